@@ -0,0 +1,292 @@
+// Package caddydiscord is a caddy log writer posting access log entries to a
+// discord webhook from inside caddy, for when rebuilding caddy is an option
+// and reading its logs through docker is not wanted.
+//
+//	log {
+//		output discord {env.DISCORD_WEBHOOK} {
+//			hosts *.example.com
+//			min_status 400
+//			ignore_paths /health
+//		}
+//		format json
+//	}
+package caddydiscord
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+
+	"simo.ng/logger/pkg/filter"
+	"simo.ng/logger/pkg/notify"
+	"simo.ng/logger/pkg/parse"
+)
+
+func init() {
+	caddy.RegisterModule(Writer{})
+}
+
+// Writer is the caddy.logging.writers.discord module. The log it's the
+// output of has to use the json format.
+type Writer struct {
+	// WebhookURL is where entries are posted, placeholders like
+	// {env.DISCORD_WEBHOOK} are replaced
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// Username overrides the name of the webhook
+	Username string `json:"username,omitempty"`
+	// Hosts limits posts to these hosts, a leading *. matches subdomains
+	Hosts []string `json:"hosts,omitempty"`
+	// MinStatus drops responses below this status
+	MinStatus int `json:"min_status,omitempty"`
+	// IgnorePaths drops requests to these paths, a trailing * matches
+	// everything below
+	IgnorePaths []string `json:"ignore_paths,omitempty"`
+	// RawUserAgent shows the full user agent instead of a summary
+	RawUserAgent bool `json:"raw_user_agent,omitempty"`
+	// Buffer is how many entries can wait to be posted before new ones are
+	// dropped, 100 by default
+	Buffer int `json:"buffer,omitempty"`
+
+	url    string
+	logger *zap.Logger
+}
+
+func (Writer) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "caddy.logging.writers.discord",
+		New: func() caddy.Module { return new(Writer) },
+	}
+}
+
+func (w *Writer) Provision(ctx caddy.Context) error {
+	w.url = caddy.NewReplacer().ReplaceAll(w.WebhookURL, "")
+	w.logger = ctx.Logger()
+	if w.Buffer <= 0 {
+		w.Buffer = 100
+	}
+	return nil
+}
+
+func (w *Writer) Validate() error {
+	if w.url == "" {
+		return fmt.Errorf("discord: webhook_url is required")
+	}
+	return nil
+}
+
+// String keeps the webhook token out of caddy's own logs
+func (w *Writer) String() string {
+	if i := strings.LastIndex(w.url, "/"); i > 0 {
+		return "discord:" + w.url[:i]
+	}
+	return "discord"
+}
+
+// WriterKey lets caddy share one writer between logs posting to the same
+// webhook
+func (w *Writer) WriterKey() string {
+	return "discord:" + w.url
+}
+
+func (w *Writer) OpenWriter() (io.WriteCloser, error) {
+	out := &writer{
+		config:  w,
+		entries: make(chan parse.Data, w.Buffer),
+		done:    make(chan struct{}),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+	go out.post()
+	return out, nil
+}
+
+// UnmarshalCaddyfile sets up the writer from
+//
+//	output discord <webhook_url> {
+//		username       <name>
+//		hosts          <hosts...>
+//		min_status     <status>
+//		ignore_paths   <paths...>
+//		raw_user_agent
+//		buffer         <entries>
+//	}
+func (w *Writer) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // module name
+	if d.NextArg() {
+		w.WebhookURL = d.Val()
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "webhook_url":
+			if !d.AllArgs(&w.WebhookURL) {
+				return d.ArgErr()
+			}
+		case "username":
+			if !d.AllArgs(&w.Username) {
+				return d.ArgErr()
+			}
+		case "hosts":
+			w.Hosts = append(w.Hosts, d.RemainingArgs()...)
+		case "ignore_paths":
+			w.IgnorePaths = append(w.IgnorePaths, d.RemainingArgs()...)
+		case "min_status", "buffer":
+			name := d.Val()
+			var raw string
+			if !d.AllArgs(&raw) {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return d.Errf("%s: %v", name, err)
+			}
+			if name == "min_status" {
+				w.MinStatus = n
+			} else {
+				w.Buffer = n
+			}
+		case "raw_user_agent":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			w.RawUserAgent = true
+		default:
+			return d.Errf("unrecognized subdirective %s", d.Val())
+		}
+	}
+	return nil
+}
+
+// writer takes log entries from caddy and posts them in the background so a
+// slow webhook never holds up a request
+type writer struct {
+	config  *Writer
+	entries chan parse.Data
+	done    chan struct{}
+	client  *http.Client
+	close   sync.Once
+}
+
+func (o *writer) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSpace(string(p)), "\n") {
+		data, err := parse.Line("caddy", line)
+		if err != nil || !parse.IsAccessLog(data) || !o.config.wants(data) {
+			continue
+		}
+		select {
+		case o.entries <- data:
+		default:
+			o.config.logger.Warn("discord webhook is behind, dropping entry",
+				zap.String("host", data.Request.Host), zap.String("uri", data.Request.URI))
+		}
+	}
+	return len(p), nil
+}
+
+func (o *writer) Close() error {
+	o.close.Do(func() { close(o.entries) })
+	<-o.done
+	return nil
+}
+
+func (w *Writer) wants(data parse.Data) bool {
+	if len(w.Hosts) > 0 && !filter.MatchHost(w.Hosts, data.Request.Host) {
+		return false
+	}
+	if data.Status < w.MinStatus {
+		return false
+	}
+	for _, pattern := range w.IgnorePaths {
+		if filter.MatchPath(pattern, data.Request.URI) {
+			return false
+		}
+	}
+	return true
+}
+
+// content is the message the logger itself sends without any extras
+func (w *Writer) content(data parse.Data) string {
+	var ua string
+	if len(data.Request.Headers.UserAgent) > 0 {
+		ua = data.Request.Headers.UserAgent[0]
+	}
+	if !w.RawUserAgent {
+		ua = parse.ParseUserAgent(ua).Summary()
+	}
+	client := data.Request.ClientIP
+	if client == "" {
+		client = data.Request.RemoteIP
+	}
+
+	content := notify.Summary("", data.Request.Host+data.Request.URI, client, ua, strconv.Itoa(data.Status)) +
+		fmt.Sprintf("<t:%d:f>", data.Ts.Time().Unix())
+	if attack, ok := filter.Attack(data, filter.Builtin); ok {
+		content += "\n⚔️ Attack signature: " + notify.EscapeMarkdown(attack)
+	}
+	return content
+}
+
+func (o *writer) post() {
+	defer close(o.done)
+	for data := range o.entries {
+		message := notify.Message{
+			Content:  notify.Truncate(o.config.content(data), notify.MaxContent),
+			Username: o.config.Username,
+		}
+		for attempt := 0; attempt < 3; attempt++ {
+			wait, err := o.execute(message)
+			if err != nil {
+				o.config.logger.Error("posting to discord failed", zap.Error(err))
+				break
+			}
+			if wait == 0 {
+				break
+			}
+			time.Sleep(wait)
+		}
+	}
+}
+
+// execute posts one message, a rate limited call returns how long to wait
+// before trying again
+func (o *writer) execute(message notify.Message) (time.Duration, error) {
+	body, contentType, err := notify.Encode(message)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := o.client.Post(o.config.url, contentType, body)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		wait, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64)
+		if err != nil || wait <= 0 {
+			wait = 1
+		}
+		return time.Duration(wait * float64(time.Second)), nil
+	case resp.StatusCode >= 300:
+		return 0, fmt.Errorf("discord answered %s", resp.Status)
+	}
+	return 0, nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*Writer)(nil)
+	_ caddy.Validator       = (*Writer)(nil)
+	_ caddy.WriterOpener    = (*Writer)(nil)
+	_ caddyfile.Unmarshaler = (*Writer)(nil)
+)
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type AbuseIPDBConfig struct {
+	APIKey    string `json:"apiKey"`
+	MaxAge    int    `json:"maxAgeInDays"`
+	CacheTTL  string `json:"cacheTtl"`
+	MaxPerDay int    `json:"maxPerDay"`
+	// only post to discord when the score is at least this, 0 posts everything
+	MinScore int `json:"minScore"`
+}
+
+type reputation struct {
+	Score   int
+	Reports int
+	ISP     string
+	fetched time.Time
+}
+
+func (r reputation) String() string {
+	return fmt.Sprintf("🛡️ AbuseIPDB: %d%% confidence, %d reports", r.Score, r.Reports)
+}
+
+// a failed or refused lookup of an address isn't tried again for this long
+const abuseRetryAfter = 5 * time.Minute
+
+var errAbuseQuota = errors.New("abuseipdb: daily quota used up")
+
+// abuseIPDB looks addresses up in the background, the first events of an
+// address are posted without its reputation rather than held up by the api
+type abuseIPDB struct {
+	mu      sync.Mutex
+	cache   map[string]reputation
+	pending map[string]bool
+	// failed holds when the lookup of an address last failed
+	failed map[string]time.Time
+	tokens float64
+	refill time.Time
+	client *http.Client
+}
+
+var abuse = &abuseIPDB{
+	cache:   map[string]reputation{},
+	pending: map[string]bool{},
+	failed:  map[string]time.Time{},
+	client:  &http.Client{Timeout: 5 * time.Second},
+}
+
+// allow is a token bucket sized to the daily quota of the api key, the free
+// plan gives 1000 checks a day. It is asked right before a request is sent.
+func (a *abuseIPDB) allow(perDay int, now time.Time) bool {
+	if perDay <= 0 {
+		perDay = 1000
+	}
+	burst := float64(perDay) / 24
+	if a.refill.IsZero() {
+		a.tokens = burst
+	} else {
+		a.tokens += now.Sub(a.refill).Seconds() * float64(perDay) / 86400
+		if a.tokens > burst {
+			a.tokens = burst
+		}
+	}
+	a.refill = now
+
+	if a.tokens < 1 {
+		return false
+	}
+	a.tokens--
+	return true
+}
+
+func (cfg AbuseIPDBConfig) ttl() time.Duration {
+	ttl, err := time.ParseDuration(cfg.CacheTTL)
+	if err != nil || ttl <= 0 {
+		return 24 * time.Hour
+	}
+	return ttl
+}
+
+// lookup returns the cached reputation of ip without waiting. On a miss it
+// starts fetching it, ok is false until that is done and while a failed
+// lookup waits to be tried again.
+func (a *abuseIPDB) lookup(cfg AbuseIPDBConfig, ip string) (reputation, bool) {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cached, ok := a.cache[ip]; ok && now.Sub(cached.fetched) < cfg.ttl() {
+		return cached, true
+	}
+	if a.pending[ip] || now.Sub(a.failed[ip]) < abuseRetryAfter {
+		return reputation{}, false
+	}
+	a.pending[ip] = true
+	go a.fetch(cfg, ip)
+	return reputation{}, false
+}
+
+func (a *abuseIPDB) fetch(cfg AbuseIPDBConfig, ip string) {
+	maxAge := cfg.MaxAge
+	if maxAge <= 0 {
+		maxAge = 90
+	}
+	query := url.Values{"ipAddress": {ip}, "maxAgeInDays": {fmt.Sprint(maxAge)}}
+	var body struct {
+		Data struct {
+			AbuseConfidenceScore int    `json:"abuseConfidenceScore"`
+			TotalReports         int    `json:"totalReports"`
+			ISP                  string `json:"isp"`
+		} `json:"data"`
+	}
+	err := retry("abuseipdb", func() error {
+		req, err := http.NewRequest(http.MethodGet, "https://api.abuseipdb.com/api/v2/check?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Key", cfg.APIKey)
+		req.Header.Set("Accept", "application/json")
+
+		a.mu.Lock()
+		allowed := a.allow(cfg.MaxPerDay, time.Now())
+		a.mu.Unlock()
+		if !allowed {
+			return errAbuseQuota
+		}
+		resp, err := a.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return newStatusError("abuseipdb", resp)
+		}
+		return json.NewDecoder(resp.Body).Decode(&body)
+	}, func(err error) (RetryPolicy, bool) {
+		if errors.Is(err, errAbuseQuota) {
+			return RetryPolicy{}, false
+		}
+		return enrichmentPolicy(err)
+	})
+
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.pending, ip)
+	if err != nil {
+		if !errors.Is(err, errAbuseQuota) {
+			slog.Warn("AbuseIPDB lookup failed", "err", err)
+		}
+		a.failed[ip] = now
+		if len(a.failed) > 10000 {
+			for k, t := range a.failed {
+				if now.Sub(t) >= abuseRetryAfter {
+					delete(a.failed, k)
+				}
+			}
+		}
+		return
+	}
+	delete(a.failed, ip)
+
+	a.cache[ip] = reputation{
+		Score:   body.Data.AbuseConfidenceScore,
+		Reports: body.Data.TotalReports,
+		ISP:     body.Data.ISP,
+		fetched: now,
+	}
+	// expired entries are only dropped once the cache gets big
+	if len(a.cache) > 10000 {
+		ttl := cfg.ttl()
+		for k, v := range a.cache {
+			if now.Sub(v.fetched) >= ttl {
+				delete(a.cache, k)
+			}
+		}
+	}
+}
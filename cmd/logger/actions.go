@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+
+	"simo.ng/logger/pkg/notify"
+
+	"simo.ng/logger/pkg/filter"
+)
+
+// ActionConfig bans a client address once it trips the trigger, like
+// fail2ban: Count matching requests within Window. The ban is lifted again
+// after Duration.
+type ActionConfig struct {
+	Name     string   `json:"name"`
+	Statuses []int    `json:"statuses"`
+	Paths    []string `json:"paths"`
+	// Count defaults to 20 within a Window of 1m
+	Count  int    `json:"count"`
+	Window string `json:"window"`
+	// Duration of the ban, defaults to 1h
+	Duration string `json:"duration"`
+	// Exempt addresses or CIDRs are never banned, private and loopback
+	// addresses never are either
+	Exempt []string `json:"exempt"`
+
+	// reactions, any number of them can be combined
+	Caddy      *CaddyBanConfig      `json:"caddy"`
+	Script     []string             `json:"script"`
+	Nftables   string               `json:"nftables"`
+	Cloudflare *CloudflareBanConfig `json:"cloudflare"`
+}
+
+// CaddyBanConfig adds banned addresses to the ranges of a remote_ip matcher
+// tagged with "@id" in the caddy config, through the admin api of caddyAdmin
+type CaddyBanConfig struct {
+	ID string `json:"id"`
+}
+
+// reaction is one way of banning an address
+type reaction interface {
+	name() string
+	ban(ip string, d time.Duration) error
+	unban(ip string) error
+}
+
+func reactionsFor(config Config, action ActionConfig) []reaction {
+	var reactions []reaction
+	if action.Caddy != nil && action.Caddy.ID != "" {
+		admin := CaddyAdminConfig{}
+		if config.CaddyAdmin != nil {
+			admin = *config.CaddyAdmin
+		}
+		reactions = append(reactions, caddyBan{admin: admin, id: action.Caddy.ID})
+	}
+	if len(action.Script) > 0 {
+		reactions = append(reactions, scriptBan{command: action.Script})
+	}
+	if action.Nftables != "" {
+		reactions = append(reactions, nftBan{set: strings.Fields(action.Nftables)})
+	}
+	if action.Cloudflare != nil && action.Cloudflare.APIToken != "" {
+		reactions = append(reactions, cloudflareBan{config: *action.Cloudflare})
+	}
+	return reactions
+}
+
+func (a ActionConfig) matches(data parse.Data) bool {
+	if len(a.Statuses) == 0 && len(a.Paths) == 0 {
+		return false
+	}
+	if len(a.Statuses) > 0 {
+		found := false
+		for _, status := range a.Statuses {
+			found = found || status == data.Status
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(a.Paths) > 0 {
+		for _, pattern := range a.Paths {
+			if filter.MatchPath(pattern, data.Request.URI) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (a ActionConfig) exempt(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return true
+	}
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+		return true
+	}
+	return trusted(parseProxies(a.Exempt), ip)
+}
+
+// ban is an address banned by an action, kept in bans.json so the ban is
+// still lifted after a restart
+type ban struct {
+	Action string    `json:"action"`
+	IP     string    `json:"ip"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+type actionState struct {
+	mu     sync.Mutex
+	loaded bool
+	hits   map[string][]time.Time
+	bans   map[string]ban
+}
+
+var actions = &actionState{hits: map[string][]time.Time{}, bans: map[string]ban{}}
+
+func init() {
+	metrics.describe("bans_active", "gauge", "Addresses currently banned per action.")
+	metrics.describe("bans_total", "counter", "Bans per action.")
+}
+
+func (s *actionState) load(config Config) {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+	if err := readStateFile(config, "bans.json", &s.bans); err != nil {
+		slog.Error("Error reading bans", "err", err)
+	}
+	if s.bans == nil {
+		s.bans = map[string]ban{}
+	}
+}
+
+func (s *actionState) save(config Config) {
+	if err := writeStateFile(config, "bans.json", s.bans); err != nil {
+		slog.Error("Error saving bans", "err", err)
+	}
+}
+
+func (s *actionState) active(action string) int {
+	n := 0
+	for _, b := range s.bans {
+		if b.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// record counts data against every action and bans the client of those it
+// trips. Reactions run in the background, they call out to other programs.
+func (s *actionState) record(config Config, data parse.Data) {
+	if len(config.Actions) == 0 {
+		return
+	}
+	ip := clientIP(data)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load(config)
+
+	for _, action := range config.Actions {
+		if !action.matches(data) || action.exempt(ip) {
+			continue
+		}
+		key := action.Name + "/" + ip
+		if _, banned := s.bans[key]; banned {
+			continue
+		}
+
+		window := parseDuration(action.Window, time.Minute)
+		count := action.Count
+		if count <= 0 {
+			count = 20
+		}
+		recent := s.hits[key][:0]
+		for _, t := range s.hits[key] {
+			if now.Sub(t) < window {
+				recent = append(recent, t)
+			}
+		}
+		recent = append(recent, now)
+		if len(recent) < count {
+			s.hits[key] = recent
+			continue
+		}
+		delete(s.hits, key)
+
+		duration := parseDuration(action.Duration, time.Hour)
+		b := ban{Action: action.Name, IP: ip, Until: now.Add(duration),
+			Reason: fmt.Sprintf("%d matching requests within %s", len(recent), window)}
+		s.bans[key] = b
+		s.save(config)
+		metrics.add("bans_total", 1, "action", action.Name)
+		metrics.set("bans_active", float64(s.active(action.Name)), "action", action.Name)
+
+		action, host := action, data.Request.Host
+		go s.apply(config, action, b, host, duration)
+	}
+}
+
+// apply runs the reactions of a new ban and reports it to the routes of host
+func (s *actionState) apply(config Config, action ActionConfig, b ban, host string, duration time.Duration) {
+	var done, failed []string
+	for _, r := range reactionsFor(config, action) {
+		if dryRun {
+			done = append(done, r.name()+" (dry run)")
+			continue
+		}
+		if err := r.ban(b.IP, duration); err != nil {
+			slog.Error("Error banning", "ip", b.IP, "reaction", r.name(), "err", err)
+			failed = append(failed, r.name())
+			continue
+		}
+		done = append(done, r.name())
+	}
+
+	message := fmt.Sprintf("🔨 **Banned** `%s` for %s by action %s: %s", notify.CodeSafe(b.IP), formatWindow(duration),
+		notify.EscapeMarkdown(action.Name), b.Reason)
+	if len(done) > 0 {
+		message += "\nReactions: " + strings.Join(done, ", ")
+	}
+	if len(failed) > 0 {
+		message += "\nFailed: " + strings.Join(failed, ", ") + ", see the logs"
+	}
+	slog.Warn(message)
+	postToRoutes(config, host, message)
+}
+
+// run lifts expired bans
+func (s *actionState) run() {
+	for now := range time.Tick(10 * time.Second) {
+		config := currentConfig()
+		expired := s.expired(config, now)
+		for _, b := range expired {
+			var action ActionConfig
+			for _, a := range config.Actions {
+				if a.Name == b.Action {
+					action = a
+				}
+			}
+			// an action removed from the config has no reactions left to
+			// lift its bans with, nftables still expires them by itself
+			for _, r := range reactionsFor(config, action) {
+				if err := r.unban(b.IP); err != nil {
+					slog.Error("Error lifting ban", "ip", b.IP, "reaction", r.name(), "err", err)
+				}
+			}
+			slog.Info("Lifted ban", "ip", b.IP, "action", b.Action)
+		}
+	}
+}
+
+func (s *actionState) expired(config Config, now time.Time) []ban {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load(config)
+
+	// forget counts that can't trip an action anymore
+	longest := time.Minute
+	for _, action := range config.Actions {
+		if window := parseDuration(action.Window, time.Minute); window > longest {
+			longest = window
+		}
+	}
+	for key, hits := range s.hits {
+		if len(hits) == 0 || now.Sub(hits[len(hits)-1]) > longest {
+			delete(s.hits, key)
+		}
+	}
+
+	var expired []ban
+	for key, b := range s.bans {
+		if now.Before(b.Until) {
+			continue
+		}
+		delete(s.bans, key)
+		expired = append(expired, b)
+		metrics.set("bans_active", float64(s.active(b.Action)), "action", b.Action)
+	}
+	if len(expired) > 0 {
+		s.save(config)
+	}
+	return expired
+}
+
+// scriptBan runs a command with BAN_ACTION set to ban or unban, {ip} and
+// {duration} in the arguments are replaced
+type scriptBan struct {
+	command []string
+}
+
+func (s scriptBan) name() string { return "script" }
+
+func (s scriptBan) run(verb, ip string, d time.Duration) error {
+	args := make([]string, len(s.command))
+	for i, arg := range s.command {
+		arg = strings.ReplaceAll(arg, "{ip}", ip)
+		args[i] = strings.ReplaceAll(arg, "{duration}", fmt.Sprint(int(d.Seconds())))
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), "BAN_ACTION="+verb, "BAN_IP="+ip)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (s scriptBan) ban(ip string, d time.Duration) error { return s.run("ban", ip, d) }
+func (s scriptBan) unban(ip string) error                { return s.run("unban", ip, 0) }
+
+// nftBan adds the address to a set, given as "family table set". The set
+// needs the timeout flag, the kernel then expires the element by itself.
+type nftBan struct {
+	set []string
+}
+
+func (n nftBan) name() string { return "nftables" }
+
+func (n nftBan) nft(verb, element string) error {
+	if len(n.set) != 3 {
+		return fmt.Errorf("nftables must be \"family table set\", got %q", strings.Join(n.set, " "))
+	}
+	args := append([]string{verb, "element"}, n.set...)
+	cmd := exec.Command("nft", append(args, element)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (n nftBan) ban(ip string, d time.Duration) error {
+	return n.nft("add", fmt.Sprintf("{ %s timeout %ds }", ip, int(d.Seconds())))
+}
+
+func (n nftBan) unban(ip string) error {
+	err := n.nft("delete", "{ "+ip+" }")
+	// the timeout usually got there first
+	if err != nil && strings.Contains(err.Error(), "No such file or directory") {
+		return nil
+	}
+	return err
+}
+
+// caddyBan edits the ranges of a remote_ip matcher through the admin api
+type caddyBan struct {
+	admin CaddyAdminConfig
+	id    string
+}
+
+func (c caddyBan) name() string { return "caddy" }
+
+func (c caddyBan) rangesURL() string {
+	return adminURL(c.admin) + "/id/" + c.id + "/remote_ip/ranges"
+}
+
+func (c caddyBan) ban(ip string, _ time.Duration) error {
+	body, _ := json.Marshal(ip)
+	return c.do(http.MethodPost, c.rangesURL(), body)
+}
+
+func (c caddyBan) unban(ip string) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(c.rangesURL())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return newStatusError("caddy admin api", resp)
+	}
+	var ranges []string
+	if err := json.NewDecoder(resp.Body).Decode(&ranges); err != nil {
+		return err
+	}
+	for i, r := range ranges {
+		if r == ip {
+			return c.do(http.MethodDelete, fmt.Sprintf("%s/%d", c.rangesURL(), i), nil)
+		}
+	}
+	return nil
+}
+
+func (c caddyBan) do(method, url string, body []byte) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return newStatusError("caddy admin api", resp)
+	}
+	return nil
+}
+
+// CloudflareBanConfig creates an IP Access Rule for banned addresses, in a
+// zone or for the whole account
+type CloudflareBanConfig struct {
+	APIToken  string `json:"apiToken"`
+	ZoneID    string `json:"zoneId"`
+	AccountID string `json:"accountId"`
+	// Mode is block, challenge, js_challenge or managed_challenge, defaults
+	// to block
+	Mode string `json:"mode"`
+}
+
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// rules made by the logger carry this note, unban only removes those
+const cloudflareNote = "caddy-discord-logger ban"
+
+type cloudflareBan struct {
+	config CloudflareBanConfig
+}
+
+func (c cloudflareBan) mode() string {
+	if c.config.Mode == "" {
+		return "block"
+	}
+	return c.config.Mode
+}
+
+func (c cloudflareBan) name() string { return "cloudflare " + c.mode() }
+
+func (c cloudflareBan) rulesURL() string {
+	if c.config.ZoneID != "" {
+		return cloudflareAPI + "/zones/" + c.config.ZoneID + "/firewall/access_rules/rules"
+	}
+	return cloudflareAPI + "/accounts/" + c.config.AccountID + "/firewall/access_rules/rules"
+}
+
+func cloudflareTarget(ip string) string {
+	if addr, err := netip.ParseAddr(ip); err == nil && !addr.Unmap().Is4() {
+		return "ip6"
+	}
+	return "ip"
+}
+
+func (c cloudflareBan) ban(ip string, d time.Duration) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"mode":          c.mode(),
+		"configuration": map[string]string{"target": cloudflareTarget(ip), "value": ip},
+		"notes":         fmt.Sprintf("%s until %s", cloudflareNote, time.Now().Add(d).UTC().Format(time.RFC3339)),
+	})
+	return c.do(http.MethodPost, c.rulesURL(), body, nil)
+}
+
+func (c cloudflareBan) unban(ip string) error {
+	var listed struct {
+		Result []struct {
+			ID    string `json:"id"`
+			Notes string `json:"notes"`
+		} `json:"result"`
+	}
+	query := "?configuration.target=" + cloudflareTarget(ip) + "&configuration.value=" + url.QueryEscape(ip)
+	if err := c.do(http.MethodGet, c.rulesURL()+query, nil, &listed); err != nil {
+		return err
+	}
+	for _, rule := range listed.Result {
+		if !strings.HasPrefix(rule.Notes, cloudflareNote) {
+			continue
+		}
+		if err := c.do(http.MethodDelete, c.rulesURL()+"/"+rule.ID, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c cloudflareBan) do(method, target string, body []byte, into interface{}) error {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.config.APIToken)
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return newStatusError("cloudflare", resp)
+	}
+	if into == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(into)
+}
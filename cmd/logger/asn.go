@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ASNConfig looks up the network a client address belongs to in an offline
+// ip2asn dataset (https://iptoasn.com, ip2asn-combined.tsv or .tsv.gz)
+type ASNConfig struct {
+	Database string `json:"database"`
+	// Suppress keeps traffic from these networks out of discord, given as
+	// "AS15169" or a case insensitive part of the organisation name
+	Suppress []string `json:"suppress"`
+	// Flag marks traffic from these networks in the message, same format
+	Flag []string `json:"flag"`
+}
+
+type asnRange struct {
+	start, end netip.Addr
+	number     int
+	country    string
+	org        string
+}
+
+type asnInfo struct {
+	Number int
+	// Country is where the network is registered, empty when unknown
+	Country string
+	Org     string
+}
+
+func (a asnInfo) String() string {
+	return fmt.Sprintf("AS%d · %s", a.Number, a.Org)
+}
+
+// matches reports whether the network is one of list
+func (a asnInfo) matches(list []string) bool {
+	org := strings.ToLower(a.Org)
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if n, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(entry), "AS")); err == nil {
+			if n == a.Number {
+				return true
+			}
+			continue
+		}
+		if entry != "" && strings.Contains(org, strings.ToLower(entry)) {
+			return true
+		}
+	}
+	return false
+}
+
+// asnDatabase holds the ranges sorted by start. They are loaded in the
+// background on first use and again whenever the configured path changes,
+// until then lookups find nothing. A failed load is tried again with a
+// growing delay.
+type asnDatabase struct {
+	mu      sync.Mutex
+	path    string
+	ranges  []asnRange
+	loading bool
+	// failures counts the loads of path that failed in a row
+	failures int
+	retryAt  time.Time
+}
+
+var asns = &asnDatabase{}
+
+var asnBackoff = RetryPolicy{Delay: "10s", MaxDelay: "10m", Jitter: 0.2}
+
+func (d *asnDatabase) lookup(cfg ASNConfig, ip string) (asnInfo, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return asnInfo{}, false
+	}
+	addr = addr.Unmap()
+
+	d.mu.Lock()
+	if d.path != cfg.Database {
+		d.path, d.ranges, d.failures, d.retryAt = cfg.Database, nil, 0, time.Time{}
+	}
+	if d.ranges == nil && !d.loading && !time.Now().Before(d.retryAt) {
+		d.loading = true
+		go d.load(cfg.Database)
+	}
+	ranges := d.ranges
+	d.mu.Unlock()
+
+	// the last range starting at or before addr is the only candidate
+	i := sort.Search(len(ranges), func(i int) bool { return addr.Less(ranges[i].start) }) - 1
+	if i < 0 || ranges[i].end.Less(addr) || ranges[i].number == 0 {
+		return asnInfo{}, false
+	}
+	return asnInfo{Number: ranges[i].number, Country: ranges[i].country, Org: ranges[i].org}, true
+}
+
+func (d *asnDatabase) load(path string) {
+	ranges, err := loadASNs(path)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.loading = false
+	if d.path != path {
+		// the config moved on while this one loaded
+		return
+	}
+	if err != nil {
+		wait := asnBackoff.backoff(d.failures)
+		d.failures++
+		d.retryAt = time.Now().Add(wait)
+		slog.Error("Error loading ASN database", "err", err, "retry in", wait.Round(time.Second).String())
+		return
+	}
+	if ranges == nil {
+		ranges = []asnRange{}
+	}
+	d.ranges, d.failures = ranges, 0
+	slog.Info("Loaded ASN database", "ranges", len(ranges), "path", path)
+}
+
+// loadASNs reads lines of "start end number country organisation", the
+// ip2asn format. Number 0 marks addresses not routed by anyone.
+func loadASNs(path string) ([]asnRange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var ranges []asnRange
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 5)
+		if len(fields) < 5 {
+			continue
+		}
+		start, err1 := netip.ParseAddr(fields[0])
+		end, err2 := netip.ParseAddr(fields[1])
+		number, err3 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		country := fields[3]
+		if country == "None" {
+			country = ""
+		}
+		ranges = append(ranges, asnRange{start: start.Unmap(), end: end.Unmap(), number: number, country: country, org: fields[4]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start.Less(ranges[j].start) })
+	return ranges, nil
+}
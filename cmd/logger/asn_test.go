@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// lookupSoon starts a lookup and repeats it once the background load is done
+func lookupSoon(t *testing.T, d *asnDatabase, cfg ASNConfig, ip string) (asnInfo, bool) {
+	t.Helper()
+	d.lookup(cfg, ip)
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		d.mu.Lock()
+		loading := d.loading
+		d.mu.Unlock()
+		if !loading {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the database didn't load within a second")
+		}
+	}
+	return d.lookup(cfg, ip)
+}
+
+func TestASNLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip2asn.tsv")
+	cfg := ASNConfig{Database: path}
+	d := &asnDatabase{}
+
+	// missing at first: nothing is found and the load waits before retrying
+	if _, ok := lookupSoon(t, d, cfg, "192.0.2.1"); ok {
+		t.Fatal("found an address without a database")
+	}
+	d.mu.Lock()
+	failures, retryAt := d.failures, d.retryAt
+	d.mu.Unlock()
+	if failures != 1 || !retryAt.After(time.Now()) {
+		t.Fatalf("after a failed load: %d failures, retry at %v", failures, retryAt)
+	}
+
+	tsv := "192.0.2.0\t192.0.2.255\t64500\tDE\tEXAMPLE-NET\n198.51.100.0\t198.51.100.255\t0\tNone\tNot routed\n"
+	if err := os.WriteFile(path, []byte(tsv), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.lookup(cfg, "192.0.2.1"); ok {
+		t.Fatal("retried before the backoff ran out")
+	}
+	d.mu.Lock()
+	d.retryAt = time.Time{}
+	d.mu.Unlock()
+
+	info, ok := lookupSoon(t, d, cfg, "192.0.2.1")
+	if !ok || info.Number != 64500 || info.Country != "DE" || info.Org != "EXAMPLE-NET" {
+		t.Errorf("192.0.2.1: got %+v, %v", info, ok)
+	}
+	if _, ok := d.lookup(cfg, "198.51.100.7"); ok {
+		t.Error("an unrouted range should not match")
+	}
+	if _, ok := d.lookup(cfg, "203.0.113.1"); ok {
+		t.Error("an address outside every range should not match")
+	}
+}
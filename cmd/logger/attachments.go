@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"simo.ng/logger/pkg/parse"
+
+	"simo.ng/logger/pkg/notify"
+
+	"simo.ng/logger/pkg/filter"
+)
+
+// AttachConfig decides which messages get the full log line attached as a
+// json file, keeping the message itself short
+type AttachConfig struct {
+	// MinStatus attaches for every response at or above this status
+	MinStatus int      `json:"minStatus"`
+	Paths     []string `json:"paths"`
+	// Escalated attaches to every message that mentions someone
+	Escalated bool `json:"escalated"`
+}
+
+func (a AttachConfig) wants(data parse.Data, escalated bool) bool {
+	if a.Escalated && escalated {
+		return true
+	}
+	if a.MinStatus > 0 && data.Status >= a.MinStatus {
+		return true
+	}
+	for _, pattern := range a.Paths {
+		if filter.MatchPath(pattern, data.Request.URI) {
+			return true
+		}
+	}
+	return false
+}
+
+func rawAttachment(data parse.Data, raw string) notify.Attachment {
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, []byte(raw), "", "  "); err != nil {
+		pretty.Reset()
+		pretty.WriteString(raw)
+	}
+	ts := data.Ts.Time().UTC()
+	return notify.Attachment{
+		Name: fmt.Sprintf("request-%s.json", ts.Format("20060102-150405.000")),
+		Data: pretty.Bytes(),
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+
+	"simo.ng/logger/pkg/ingest"
+)
+
+// BackfillConfig reads the end of the existing logs at startup, so the
+// digest, dedup, profiles and the bot have data right away. Nothing of it is
+// posted.
+type BackfillConfig struct {
+	// Lines read per file, defaults to 1000
+	Lines int `json:"lines"`
+	// Since skips lines older than this, e.g. "30m"
+	Since string `json:"since"`
+}
+
+// backfill replays the last lines of files once per pipeline start. Events
+// the previous run already counted, going by when its state was saved, are
+// skipped.
+func backfill(config Config, c container, files []string) {
+	cfg := config.Backfill
+	lines := cfg.Lines
+	if lines <= 0 {
+		lines = 1000
+	}
+	cutoff := runtimeSaved
+	if since := parseDuration(cfg.Since, 0); since > 0 && time.Now().Add(-since).After(cutoff) {
+		cutoff = time.Now().Add(-since)
+	}
+
+	var out string
+	err := withSource(c.docker, func(source ingest.Source) error {
+		var err error
+		out, err = source.Tail(c.id, files, lines)
+		return err
+	})
+	if err != nil {
+		slog.Warn("Backfill failed", "err", err)
+		return
+	}
+
+	var source string
+	if len(files) == 1 && !strings.ContainsAny(files[0], "*?[") {
+		source = path.Base(files[0])
+	}
+	replayed := 0
+	for _, line := range strings.Split(out, "\n") {
+		if name, ok := tailHeader(line); ok {
+			source = path.Base(name)
+			continue
+		}
+		if strings.TrimSpace(line) == "" || !matchesLogPattern(config, source) {
+			continue
+		}
+		if recordQuietly(config, source, line, cutoff) {
+			replayed++
+		}
+	}
+	slog.Info("Backfilled events", "events", replayed, "container", c.name)
+}
+
+// recordQuietly feeds an old line to everything that keeps statistics
+func recordQuietly(config Config, source string, line string, cutoff time.Time) bool {
+	line = redactLine(config, line)
+	data, ok := parseLine(source, line)
+	if !ok || !parse.IsAccessLog(data) {
+		return false
+	}
+	if data.Ts.Time().Before(cutoff) {
+		return false
+	}
+	events.add(config.Store.Size, data, line)
+	digest.record(config, data)
+	profiles.record(data)
+	visitors.record(config, data)
+	if config.Dedup != nil {
+		dedup.warm(*config.Dedup, data)
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// BatchConfig is how a bulk output buffers events before writing them
+type BatchConfig struct {
+	// Size writes once this many events are waiting, 500 by default
+	Size int `json:"size"`
+	// Every writes whatever is waiting at least this often, 5s by default
+	Every string `json:"every"`
+}
+
+// batch buffers the events of a sink that writes in bulk. Sends only add to
+// it, so a failed write is retried here and not by re-sending every event.
+type batch struct {
+	name   string
+	config BatchConfig
+	write  func([]event) error
+
+	mu      sync.Mutex
+	pending []event
+	since   time.Time
+}
+
+func newBatch(name string, config BatchConfig, write func([]event) error) *batch {
+	if config.Size <= 0 {
+		config.Size = 500
+	}
+	return &batch{name: name, config: config, write: write}
+}
+
+func (b *batch) add(e event) {
+	b.mu.Lock()
+	if len(b.pending) == 0 {
+		b.since = time.Now()
+	}
+	b.pending = append(b.pending, e)
+	var full []event
+	if len(b.pending) >= b.config.Size {
+		full, b.pending = b.pending, nil
+	}
+	b.mu.Unlock()
+
+	if full != nil {
+		b.send(full)
+	}
+}
+
+// Close has nothing to do, bulk sinks only hold connections while they write
+func (b *batch) Close() error {
+	return nil
+}
+
+// flush writes the waiting events once they waited long enough, or right
+// away with all set
+func (b *batch) flush(all bool) {
+	b.mu.Lock()
+	every := parseDuration(b.config.Every, 5*time.Second)
+	if len(b.pending) == 0 || (!all && time.Since(b.since) < every) {
+		b.mu.Unlock()
+		return
+	}
+	due := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	b.send(due)
+}
+
+func (b *batch) send(events []event) {
+	err := deliver(b.name, func() error {
+		return b.write(events)
+	})
+	if err != nil {
+		slog.Error("Error writing batch", "sink", b.name, "events", len(events), "err", err)
+	}
+}
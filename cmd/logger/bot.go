@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+const discordAPI = "https://discord.com/api/v10"
+
+// BotConfig enables bot mode. Discord delivers slash commands to the
+// interactions endpoint, set its url in the developer portal to
+// http(s)://<listen>/interactions.
+type BotConfig struct {
+	Token         string `json:"token"`
+	ApplicationID string `json:"applicationId"`
+	PublicKey     string `json:"publicKey"`
+	// commands are registered for this guild only when set, which applies
+	// instantly, global commands can take a while to show up
+	GuildID string `json:"guildId"`
+	Listen  string `json:"listen"`
+	// MuteFor is how long /mute silences alerts without a duration given,
+	// defaults to 1h
+	MuteFor string `json:"muteFor"`
+}
+
+type interaction struct {
+	Type          int    `json:"type"`
+	ID            string `json:"id"`
+	Token         string `json:"token"`
+	ApplicationID string `json:"application_id"`
+	ChannelID     string `json:"channel_id"`
+	Data          struct {
+		Name    string              `json:"name"`
+		Options []interactionOption `json:"options"`
+	} `json:"data"`
+}
+
+type interactionOption struct {
+	Name  string          `json:"name"`
+	Type  int             `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (i interaction) option(name string) (json.RawMessage, bool) {
+	for _, opt := range i.Data.Options {
+		if opt.Name == name {
+			return opt.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (i interaction) stringOption(name string) string {
+	var s string
+	if raw, ok := i.option(name); ok {
+		json.Unmarshal(raw, &s)
+	}
+	return s
+}
+
+func (i interaction) intOption(name string, fallback int) int {
+	n := fallback
+	if raw, ok := i.option(name); ok {
+		json.Unmarshal(raw, &n)
+	}
+	return n
+}
+
+func (i interaction) boolOption(name string) bool {
+	var b bool
+	if raw, ok := i.option(name); ok {
+		json.Unmarshal(raw, &b)
+	}
+	return b
+}
+
+type interactionResponse struct {
+	Type int                     `json:"type"`
+	Data *interactionResponseMsg `json:"data,omitempty"`
+}
+
+type interactionResponseMsg struct {
+	Content         string                 `json:"content"`
+	AllowedMentions notify.AllowedMentions `json:"allowed_mentions"`
+}
+
+// reply is the usual answer to a command, a plain message without mentions
+func reply(content string) interactionResponse {
+	return interactionResponse{Type: 4, Data: &interactionResponseMsg{Content: content, AllowedMentions: notify.AllowedMentions{Parse: []string{}}}}
+}
+
+type botCommand struct {
+	definition map[string]interface{}
+	handle     func(cfg BotConfig, i interaction) interactionResponse
+}
+
+var botCommands = map[string]botCommand{}
+
+// botRequest calls the discord api with the bot token
+func botRequest(cfg BotConfig, method string, path string, body interface{}, out interface{}) error {
+	var payload io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequest(method, discordAPI+path, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bot "+cfg.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return newStatusError("discord", resp)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func registerCommands(cfg BotConfig) error {
+	var definitions []map[string]interface{}
+	for _, cmd := range botCommands {
+		definitions = append(definitions, cmd.definition)
+	}
+
+	path := "/applications/" + cfg.ApplicationID + "/commands"
+	if cfg.GuildID != "" {
+		path = "/applications/" + cfg.ApplicationID + "/guilds/" + cfg.GuildID + "/commands"
+	}
+	return botRequest(cfg, http.MethodPut, path, definitions, nil)
+}
+
+// verifyInteraction checks the ed25519 signature discord puts on every
+// interaction, requests failing it must be rejected with a 401
+func verifyInteraction(publicKey ed25519.PublicKey, r *http.Request, body []byte) bool {
+	signature, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return false
+	}
+	message := append([]byte(r.Header.Get("X-Signature-Timestamp")), body...)
+	return ed25519.Verify(publicKey, message, signature)
+}
+
+func serveBot(cfg BotConfig) {
+	key, err := hex.DecodeString(cfg.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		slog.Warn("Bot mode disabled, publicKey is not a valid ed25519 key")
+		return
+	}
+
+	if err := registerCommands(cfg); err != nil {
+		slog.Error("Error registering slash commands", "err", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/interactions", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil || !verifyInteraction(key, r, body) {
+			http.Error(w, "invalid request signature", http.StatusUnauthorized)
+			return
+		}
+
+		var in interaction
+		if err := json.Unmarshal(body, &in); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		switch in.Type {
+		case 1:
+			// ping, sent when the endpoint is saved in the developer portal
+			writeJSON(w, interactionResponse{Type: 1})
+		case 2:
+			cmd, ok := botCommands[in.Data.Name]
+			if !ok {
+				writeJSON(w, reply("Unknown command"))
+				return
+			}
+			writeJSON(w, cmd.handle(cfg, in))
+		default:
+			http.Error(w, "unsupported interaction", http.StatusBadRequest)
+		}
+	})
+
+	server := &http.Server{Addr: cfg.Listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	slog.Info("Bot interactions endpoint listening", "listen", cfg.Listen)
+	if err := server.ListenAndServe(); err != nil {
+		slog.Error("Bot endpoint stopped", "err", err)
+	}
+}
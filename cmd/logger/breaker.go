@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+// BreakerConfig stops posting to a webhook that keeps answering 5xx or
+// timing out, so an outage isn't hammered with every log line
+type BreakerConfig struct {
+	// Failures in a row that open the circuit, defaults to 3
+	Failures int `json:"failures"`
+	// Cooldown before the webhook is tried again, defaults to 1m
+	Cooldown string `json:"cooldown"`
+}
+
+type circuit struct {
+	failures int
+	openedAt time.Time
+	until    time.Time
+	// held counts the messages that weren't posted while open
+	held int
+}
+
+type circuitBreaker struct {
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+var breakers = &circuitBreaker{circuits: map[string]*circuit{}}
+
+var errCircuitOpen = errors.New("webhook circuit is open after repeated failures")
+
+func init() {
+	metrics.describe("circuit_open", "gauge", "1 while posting to a webhook is paused after repeated failures.")
+}
+
+func breakerSettings(config Config) (int, time.Duration) {
+	failures, cooldown := 3, time.Minute
+	if config.Breaker != nil {
+		if config.Breaker.Failures > 0 {
+			failures = config.Breaker.Failures
+		}
+		cooldown = parseDuration(config.Breaker.Cooldown, cooldown)
+	}
+	return failures, cooldown
+}
+
+// allow reports whether a request may go out. Once the cooldown is over
+// requests pass again, the next outcome closes or reopens the circuit.
+func (b *circuitBreaker) allow(webhookUrl string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuits[webhookUrl]
+	if c == nil || c.until.IsZero() || !time.Now().Before(c.until) {
+		return true
+	}
+	c.held++
+	return false
+}
+
+// open reports whether posting to the webhook is held right now, without
+// counting it as a held request
+func (b *circuitBreaker) open(webhookUrl string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuits[webhookUrl]
+	return c != nil && !c.until.IsZero() && time.Now().Before(c.until)
+}
+
+// observe records the outcome of a request. Only outages count, a rejected
+// payload says nothing about whether discord is up.
+func (b *circuitBreaker) observe(webhookUrl string, err error) {
+	if err != nil && classifyError(err) != classNetwork {
+		return
+	}
+	threshold, cooldown := breakerSettings(currentConfig())
+
+	b.mu.Lock()
+	c := b.circuits[webhookUrl]
+	if c == nil {
+		if err == nil {
+			b.mu.Unlock()
+			return
+		}
+		c = &circuit{}
+		b.circuits[webhookUrl] = c
+	}
+
+	if err != nil {
+		c.failures++
+		now := time.Now()
+		switch {
+		case !c.until.IsZero():
+			// the trial after the cooldown failed as well
+			c.until = now.Add(cooldown)
+		case c.failures >= threshold:
+			c.openedAt = now
+			c.until = now.Add(cooldown)
+			metrics.set("circuit_open", 1, "webhook", webhookID(webhookUrl))
+			slog.Warn("Webhook keeps failing, pausing it", "failures", c.failures, "cooldown", cooldown.String(), "err", err)
+		}
+		b.mu.Unlock()
+		return
+	}
+
+	delete(b.circuits, webhookUrl)
+	b.mu.Unlock()
+	if c.until.IsZero() {
+		return
+	}
+	metrics.set("circuit_open", 0, "webhook", webhookID(webhookUrl))
+
+	message := fmt.Sprintf("✅ Webhook for route %s is back after %s, %d messages were held back while it was down",
+		strings.Join(routeNames(currentConfig(), webhookUrl), ", "), time.Since(c.openedAt).Round(time.Second), c.held)
+	if currentConfig().Queue != nil {
+		message += " and are delivered from the queue"
+	}
+	slog.Info(message)
+	// posted on its own, this runs in the middle of another request
+	go func() {
+		if err := postWebhook(webhookUrl, notify.Message{Content: message}); err != nil {
+			slog.Error("Error posting webhook recovery", "err", err)
+		}
+	}()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+
+	"simo.ng/logger/pkg/notify"
+
+	"simo.ng/logger/pkg/filter"
+)
+
+// BruteForceConfig watches failed logins: POSTs to the login paths that are
+// answered with one of Statuses. Count of them from one address within
+// Window raise a single alert and the attempts after it aren't posted one by
+// one.
+type BruteForceConfig struct {
+	Paths []string `json:"paths"`
+	// Statuses default to 401 and 403
+	Statuses []int `json:"statuses"`
+	// Count defaults to 10 within a Window of 5m
+	Count  int    `json:"count"`
+	Window string `json:"window"`
+}
+
+var defaultLoginPaths = []string{"/login", "/wp-login.php", "/user/login", "/admin/login", "/api/login", "/auth"}
+
+type bruteForceAttempt struct {
+	host     string
+	ip       string
+	path     string
+	hits     []time.Time
+	first    time.Time
+	last     time.Time
+	total    int
+	alerted  bool
+	reported int
+}
+
+type bruteForceDetector struct {
+	mu       sync.Mutex
+	attempts map[string]*bruteForceAttempt
+}
+
+var bruteForce = &bruteForceDetector{attempts: map[string]*bruteForceAttempt{}}
+
+func (cfg BruteForceConfig) window() time.Duration {
+	return parseDuration(cfg.Window, 5*time.Minute)
+}
+
+func (cfg BruteForceConfig) failedLogin(data parse.Data) (string, bool) {
+	if !strings.EqualFold(data.Request.Method, "POST") {
+		return "", false
+	}
+	statuses := cfg.Statuses
+	if len(statuses) == 0 {
+		statuses = []int{401, 403}
+	}
+	failed := false
+	for _, status := range statuses {
+		failed = failed || data.Status == status
+	}
+	if !failed {
+		return "", false
+	}
+
+	paths := cfg.Paths
+	if len(paths) == 0 {
+		paths = defaultLoginPaths
+	}
+	for _, pattern := range paths {
+		if filter.MatchPath(pattern, data.Request.URI) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// record counts a failed login. It returns the alert to post when the
+// address just crossed the threshold, and whether the request should stay
+// out of discord because its attack was reported already.
+func (d *bruteForceDetector) record(cfg BruteForceConfig, data parse.Data) (string, bool) {
+	path, ok := cfg.failedLogin(data)
+	if !ok {
+		return "", false
+	}
+	ip := clientIP(data)
+	now := time.Now()
+	window := cfg.window()
+	count := cfg.Count
+	if count <= 0 {
+		count = 10
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := ip + "\x00" + data.Request.Host
+	a := d.attempts[key]
+	if a == nil {
+		a = &bruteForceAttempt{host: data.Request.Host, ip: ip, path: path, first: now}
+		d.attempts[key] = a
+	}
+	a.last = now
+	a.total++
+	if a.alerted {
+		return "", true
+	}
+
+	recent := a.hits[:0]
+	for _, t := range a.hits {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	a.hits = append(recent, now)
+	if len(a.hits) < count {
+		return "", false
+	}
+
+	a.alerted = true
+	a.reported = a.total
+	a.hits = nil
+	return fmt.Sprintf("🔐 **Possible brute force** on %s from `%s`: %d failed logins to %s within %s, further attempts are counted instead of posted",
+		notify.EscapeMarkdown(a.host), notify.CodeSafe(ip), count, notify.EscapeMarkdown(path), formatWindow(window)), true
+}
+
+// ended removes attacks that went quiet for a window and returns the
+// summaries of the ones that had been alerted
+func (d *bruteForceDetector) ended(cfg BruteForceConfig, now time.Time) []rollup {
+	window := cfg.window()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var summaries []rollup
+	for key, a := range d.attempts {
+		if now.Sub(a.last) < window {
+			continue
+		}
+		delete(d.attempts, key)
+		if a.alerted {
+			summaries = append(summaries, rollup{host: a.host, message: fmt.Sprintf(
+				"🔐 Brute force from `%s` on %s stopped: %s over %s, %d after the alert",
+				notify.CodeSafe(a.ip), notify.EscapeMarkdown(a.host), formatCount(a.total, "failed login"),
+				a.last.Sub(a.first).Round(time.Second), a.total-a.reported)})
+		}
+	}
+	return summaries
+}
+
+func (d *bruteForceDetector) run() {
+	for now := range time.Tick(30 * time.Second) {
+		config := currentConfig()
+		if config.BruteForce == nil {
+			continue
+		}
+		for _, r := range d.ended(*config.BruteForce, now) {
+			postToRoutes(config, r.host, r.message)
+		}
+	}
+}
+
+// postToRoutes sends a message of the logger's own to every route of host
+func postToRoutes(config Config, host string, message string) {
+	for _, route := range routesFor(config, host) {
+		route := route
+		senders.submit(config, "route "+route.Name, route.WebhookURL, func() {
+			if err := sendRouteMessage(config, route, host, notify.Message{Content: message}); err != nil {
+				slog.Error("Error sending to route", "route", route.Name, "err", err)
+			}
+		})
+	}
+}
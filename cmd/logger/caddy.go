@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CaddyAdminConfig struct {
+	URL string `json:"url"`
+	// autoRoutes adds a route per caddy server for hosts no route covers yet
+	AutoRoutes bool `json:"autoRoutes"`
+	// siteRoutes does the same per site block, overrides are keyed by any of
+	// the hosts of the block. They are pointers so their secrets can be
+	// resolved in place.
+	SiteRoutes bool              `json:"siteRoutes"`
+	Sites      map[string]*Route `json:"sites"`
+	Refresh    string            `json:"refresh"`
+}
+
+// the parts of caddy's json config we care about
+type caddyConfig struct {
+	Logging struct {
+		Logs map[string]caddyLog `json:"logs"`
+	} `json:"logging"`
+	Apps struct {
+		HTTP struct {
+			Servers map[string]caddyServer `json:"servers"`
+		} `json:"http"`
+	} `json:"apps"`
+}
+
+type caddyLog struct {
+	Writer struct {
+		Output   string `json:"output"`
+		Filename string `json:"filename"`
+	} `json:"writer"`
+	Include []string `json:"include"`
+}
+
+type caddyServer struct {
+	Routes []caddyRoute `json:"routes"`
+	Logs   *struct {
+		// a string before caddy 2.8, a list since
+		LoggerNames       map[string]json.RawMessage `json:"logger_names"`
+		DefaultLoggerName string                     `json:"default_logger_name"`
+	} `json:"logs"`
+}
+
+type caddyRoute struct {
+	Match []struct {
+		Host []string `json:"host"`
+	} `json:"match"`
+}
+
+type discoveredServer struct {
+	Name     string
+	Hosts    []string
+	LogFiles []string
+	// Sites holds the hosts of each site block, in config order
+	Sites [][]string
+}
+
+type caddyDiscovery struct {
+	Servers  []discoveredServer
+	LogFiles []string
+}
+
+var (
+	discoveryMu sync.RWMutex
+	discovery   *caddyDiscovery
+	// filesChanged is closed and replaced when the discovered access log
+	// files change, tails inside the container start over with the new ones
+	filesChanged = make(chan struct{})
+)
+
+func currentDiscovery() *caddyDiscovery {
+	discoveryMu.RLock()
+	defer discoveryMu.RUnlock()
+	return discovery
+}
+
+func logFilesChanged() <-chan struct{} {
+	discoveryMu.RLock()
+	defer discoveryMu.RUnlock()
+	return filesChanged
+}
+
+func adminURL(cfg CaddyAdminConfig) string {
+	if cfg.URL == "" {
+		return "http://localhost:2019"
+	}
+	return strings.TrimSuffix(cfg.URL, "/")
+}
+
+func fetchCaddyConfig(cfg CaddyAdminConfig) (caddyConfig, error) {
+	var parsed caddyConfig
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(adminURL(cfg) + "/config/")
+	if err != nil {
+		return parsed, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return parsed, newStatusError("caddy admin api", resp)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&parsed)
+	return parsed, err
+}
+
+func loggerNames(raw json.RawMessage) []string {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}
+	}
+	var list []string
+	json.Unmarshal(raw, &list)
+	return list
+}
+
+// discover works out which hosts each server serves and which files its
+// access logs end up in
+func discover(parsed caddyConfig) *caddyDiscovery {
+	// caddy names access loggers "http.log.access.<name>"
+	files := map[string]string{}
+	for _, l := range parsed.Logging.Logs {
+		if l.Writer.Output != "file" || l.Writer.Filename == "" {
+			continue
+		}
+		for _, include := range l.Include {
+			if name := strings.TrimPrefix(include, "http.log.access."); name != include {
+				files[name] = l.Writer.Filename
+			}
+		}
+	}
+
+	result := &caddyDiscovery{}
+	allFiles := map[string]bool{}
+
+	names := make([]string, 0, len(parsed.Apps.HTTP.Servers))
+	for name := range parsed.Apps.HTTP.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		server := parsed.Apps.HTTP.Servers[name]
+		found := discoveredServer{Name: name}
+
+		// every top level route with a host matcher is a site block
+		for _, route := range server.Routes {
+			var site []string
+			for _, match := range route.Match {
+				site = append(site, match.Host...)
+			}
+			if len(site) > 0 {
+				found.Hosts = append(found.Hosts, site...)
+				found.Sites = append(found.Sites, site)
+			}
+		}
+
+		if server.Logs != nil {
+			loggers := map[string]bool{}
+			if server.Logs.DefaultLoggerName != "" {
+				loggers[server.Logs.DefaultLoggerName] = true
+			}
+			for _, raw := range server.Logs.LoggerNames {
+				for _, logger := range loggerNames(raw) {
+					loggers[logger] = true
+				}
+			}
+			for logger := range loggers {
+				if file, ok := files[logger]; ok {
+					found.LogFiles = append(found.LogFiles, file)
+					allFiles[file] = true
+				}
+			}
+			sort.Strings(found.LogFiles)
+		}
+
+		result.Servers = append(result.Servers, found)
+	}
+
+	for file := range allFiles {
+		result.LogFiles = append(result.LogFiles, file)
+	}
+	sort.Strings(result.LogFiles)
+	return result
+}
+
+// applyDiscovery fills in hosts for routes bound to a caddy server and adds
+// generated routes for servers nothing routes yet
+func applyDiscovery(config Config, found *caddyDiscovery) Config {
+	if found == nil {
+		return config
+	}
+
+	routes := make([]Route, 0, len(config.Routes))
+	for _, route := range config.Routes {
+		if route.CaddyServer != "" {
+			for _, server := range found.Servers {
+				if server.Name == route.CaddyServer {
+					route.Hosts = append(append([]string{}, route.Hosts...), server.Hosts...)
+				}
+			}
+		}
+		routes = append(routes, route)
+	}
+
+	if config.CaddyAdmin != nil && config.CaddyAdmin.SiteRoutes {
+		for _, server := range found.Servers {
+			for _, site := range server.Sites {
+				if hosts := uncoveredHosts(routes, site); len(hosts) > 0 {
+					routes = append(routes, siteRoute(config, hosts))
+				}
+			}
+		}
+	} else if config.CaddyAdmin != nil && config.CaddyAdmin.AutoRoutes {
+		for _, server := range found.Servers {
+			if hosts := uncoveredHosts(routes, server.Hosts); len(hosts) > 0 {
+				routes = append(routes, Route{Name: "caddy:" + server.Name, Hosts: hosts, WebhookURL: config.WebhookURL})
+			}
+		}
+	}
+
+	config.Routes = routes
+	return config
+}
+
+// uncoveredHosts filters out the hosts an explicit route already handles
+func uncoveredHosts(routes []Route, hosts []string) []string {
+	var uncovered []string
+next:
+	for _, host := range hosts {
+		for _, route := range routes {
+			if !route.Canary && len(route.Hosts) > 0 && route.matches(host) {
+				continue next
+			}
+		}
+		uncovered = append(uncovered, host)
+	}
+	return uncovered
+}
+
+// siteRoute generates the route of one site block, merged with the override
+// for any of its hosts
+func siteRoute(config Config, hosts []string) Route {
+	route := Route{Name: "site:" + hosts[0]}
+	for _, host := range hosts {
+		if override, ok := config.CaddyAdmin.Sites[host]; ok && override != nil {
+			route = *override
+			if route.Name == "" {
+				route.Name = "site:" + hosts[0]
+			}
+			break
+		}
+	}
+	if len(route.Hosts) == 0 {
+		route.Hosts = hosts
+	}
+	if route.WebhookURL == "" {
+		route.WebhookURL = config.WebhookURL
+	}
+	return route
+}
+
+func refreshDiscovery(cfg CaddyAdminConfig) error {
+	parsed, err := fetchCaddyConfig(cfg)
+	if err != nil {
+		return err
+	}
+	found := discover(parsed)
+
+	discoveryMu.Lock()
+	if discovery != nil && fmt.Sprint(discovery.LogFiles) != fmt.Sprint(found.LogFiles) {
+		close(filesChanged)
+		filesChanged = make(chan struct{})
+	}
+	discovery = found
+	discoveryMu.Unlock()
+
+	for _, server := range found.Servers {
+		slog.Info("Caddy server", "name", server.Name, "hosts", server.Hosts, "logs", server.LogFiles)
+	}
+	return nil
+}
+
+func (c *CaddyAdminConfig) interval() time.Duration {
+	if c == nil {
+		return 5 * time.Minute
+	}
+	interval, err := time.ParseDuration(c.Refresh)
+	if err != nil || interval <= 0 {
+		return 5 * time.Minute
+	}
+	return interval
+}
+
+// watchCaddy keeps the discovered servers up to date so caddyfile changes
+// show up without touching this config. The caddyAdmin settings are read on
+// every tick, so a reload changes them without a restart.
+func watchCaddy() {
+	interval := currentFileConfig().CaddyAdmin.interval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cfg := currentFileConfig().CaddyAdmin
+		if next := cfg.interval(); next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+		if cfg == nil {
+			continue
+		}
+
+		before := fmt.Sprint(currentDiscovery())
+		if err := refreshDiscovery(*cfg); err != nil {
+			slog.Warn("Caddy admin API refresh failed", "err", err)
+			continue
+		}
+		found := currentDiscovery()
+		if fmt.Sprint(found) == before {
+			continue
+		}
+
+		slog.Info("Caddy config changed, updating routes and watch targets")
+		setConfig(currentFileConfig())
+		for _, file := range found.LogFiles {
+			addWatchTarget(file)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// canaryState decides which messages get copied to canary routes. Copies are
+// only made for a while after a config reload, so new templates and rules
+// can be checked in a test channel first.
+type canaryState struct {
+	mu    sync.Mutex
+	until time.Time
+	seen  int
+}
+
+var canary = &canaryState{}
+
+func canaryRoutes(config Config) []Route {
+	var routes []Route
+	for _, route := range config.Routes {
+		if route.Canary {
+			if route.WebhookURL == "" {
+				route.WebhookURL = config.WebhookURL
+			}
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// arm starts a canary window for the longest window of the configured routes
+func (c *canaryState) arm(config Config) {
+	var longest time.Duration
+	for _, route := range canaryRoutes(config) {
+		window := 15 * time.Minute
+		if route.CanaryFor != "" {
+			if d, err := time.ParseDuration(route.CanaryFor); err == nil {
+				window = d
+			}
+		}
+		if window > longest {
+			longest = window
+		}
+	}
+	if longest == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	c.until = time.Now().Add(longest)
+	c.seen = 0
+	c.mu.Unlock()
+	slog.Info("Copying sampled messages to canary routes", "for", longest.String())
+}
+
+// tick counts a message and reports whether the canary window is still open
+func (c *canaryState) tick() (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().After(c.until) {
+		return 0, false
+	}
+	c.seen++
+	return c.seen, true
+}
+
+// sendCanaryCopies duplicates one in every canarySample messages of a route to
+// the canary routes
+func sendCanaryCopies(config Config, route Route, content string) {
+	if route.Canary {
+		return
+	}
+	seen, active := canary.tick()
+	if !active {
+		return
+	}
+
+	for _, target := range canaryRoutes(config) {
+		sample := target.CanarySample
+		if sample <= 0 {
+			sample = 10
+		}
+		if (seen-1)%sample != 0 {
+			continue
+		}
+		copied := "🐤 canary copy from route " + route.Name + "\n" + content
+		if err := sendMessageToDiscord(copied, target.WebhookURL); err != nil {
+			slog.Error("Error sending to canary route", "route", target.Name, "err", err)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/ingest"
+)
+
+// checkpoint is how far a source has been read. The inode tells a rotated
+// file apart from the one the offset belongs to, the head recognises the
+// same file under another inode, like on a new host after a state import.
+type checkpoint struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+	// Head is "<length>:<hash>" of the first headSize bytes
+	Head    string    `json:"head,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// headSize is how much of the start of a file its head covers
+const headSize = 1024
+
+type checkpointStore struct {
+	mu     sync.Mutex
+	loaded bool
+	points map[string]checkpoint
+	dirty  bool
+}
+
+var checkpoints = &checkpointStore{points: map[string]checkpoint{}}
+
+// sourceID is stable across restarts and container re-creation, the
+// container is looked up by name on every start
+func sourceID(containerName string, path string) string {
+	return containerName + ":" + path
+}
+
+func (c *checkpointStore) load(config Config) {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+
+	raw, err := os.ReadFile(statePath(config, "checkpoints.json"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("Error reading checkpoints", "err", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(raw, &c.points); err != nil || c.points == nil {
+		if err != nil {
+			slog.Error("Error reading checkpoints", "err", err)
+		}
+		c.points = map[string]checkpoint{}
+	}
+}
+
+func (c *checkpointStore) get(config Config, id string) (checkpoint, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load(config)
+	point, ok := c.points[id]
+	return point, ok
+}
+
+func (c *checkpointStore) set(id string, point checkpoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	point.Updated = time.Now()
+	c.points[id] = point
+	c.dirty = true
+}
+
+// save writes the checkpoints through a temporary file so a crash never
+// leaves a half written file behind
+func (c *checkpointStore) save(config Config) {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return
+	}
+	raw, err := json.MarshalIndent(c.points, "", "  ")
+	c.dirty = false
+	c.mu.Unlock()
+
+	path := statePath(config, "checkpoints.json")
+	if dryRun {
+		// the next real run starts where the last one stopped
+		return
+	}
+	if err == nil {
+		err = os.WriteFile(path+".tmp", raw, 0o600)
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		slog.Error("Error saving checkpoints", "err", err)
+	}
+}
+
+func (c *checkpointStore) run() {
+	for range time.Tick(2 * time.Second) {
+		c.save(currentConfig())
+	}
+}
+
+// statFile returns inode and size of a file inside the container
+func statFile(c container, path string) (inode uint64, size int64, err error) {
+	err = withSource(c.docker, func(source ingest.Source) error {
+		inode, size, err = source.Stat(c.id, path)
+		return err
+	})
+	return inode, size, err
+}
+
+// fileHead fingerprints the first length bytes of path
+func fileHead(c container, path string, length int64) (string, error) {
+	var start string
+	err := withSource(c.docker, func(source ingest.Source) error {
+		var err error
+		start, err = source.Read(c.id, path, 0, length)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(start))
+	return fmt.Sprintf("%d:%x", length, sum[:8]), nil
+}
+
+// headLength is how many bytes a head covers, 0 for none
+func headLength(head string) int64 {
+	length, _, _ := strings.Cut(head, ":")
+	n, _ := strconv.ParseInt(length, 10, 64)
+	return n
+}
+
+// moved reports whether the file at path now is the one point was saved
+// for, under another inode
+func moved(c container, path string, size int64, point checkpoint) bool {
+	length := headLength(point.Head)
+	if length == 0 || size < point.Offset || size < length {
+		return false
+	}
+	head, err := fileHead(c, path, length)
+	return err == nil && head == point.Head
+}
+
+// started holds the sources startAtEnd already ran for
+var started = struct {
+	sync.Mutex
+	keys map[string]bool
+}{keys: map[string]bool{}}
+
+// startAtEnd puts the files found when a source is first watched at their
+// current end, so a fresh install doesn't post the whole history of its
+// logs. Later watcher restarts leave files without a checkpoint alone, they
+// are new and read from the start.
+func startAtEnd(config Config, c container, files []string) {
+	started.Lock()
+	done := started.keys[c.sourceKey()]
+	started.keys[c.sourceKey()] = true
+	started.Unlock()
+	if done {
+		return
+	}
+
+	for _, path := range files {
+		id := sourceID(c.sourceKey(), path)
+		if _, ok := checkpoints.get(config, id); ok {
+			continue
+		}
+		inode, size, err := statFile(c, path)
+		if err != nil {
+			slog.Debug("Could not stat a log file", "path", path, "err", err)
+			continue
+		}
+		checkpoints.set(id, checkpoint{Inode: inode, Offset: size, Head: headFor(c, path, size, "")})
+	}
+}
+
+// readNew returns whatever was appended to path since its checkpoint. A file
+// without one, created or discovered after startup, and one that was rotated
+// or truncated are read from the beginning.
+func readNew(config Config, c container, path string) (string, error) {
+	id := sourceID(c.sourceKey(), path)
+
+	inode, size, err := statFile(c, path)
+	if err != nil {
+		return "", err
+	}
+
+	point, ok := checkpoints.get(config, id)
+	switch {
+	case !ok:
+		slog.Info("New log file, reading it from the start", "path", path)
+		point = checkpoint{Inode: inode}
+	case point.Inode != inode && moved(c, path, size, point):
+		slog.Info("Log file has a new inode but the same contents, keeping its place", "path", path)
+		point.Inode = inode
+		checkpoints.set(id, point)
+	case point.Inode != inode || size < point.Offset:
+		slog.Info("Log file was rotated, reading it from the start", "path", path)
+		point = checkpoint{Inode: inode}
+	}
+	if size == point.Offset {
+		return "", nil
+	}
+
+	// read exactly up to the size stat saw, lines written in the meantime are
+	// picked up by the next read. caddy writes whole lines so size always
+	// ends on a newline.
+	var out string
+	err = withSource(c.docker, func(source ingest.Source) error {
+		var err error
+		out, err = source.Read(c.id, path, point.Offset, size-point.Offset)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	checkpoints.set(id, checkpoint{Inode: inode, Offset: size, Head: headFor(c, path, size, point.Head)})
+	return out, nil
+}
+
+// headFor returns the head of a file of size, reading it again only while
+// it's shorter than headSize
+func headFor(c container, path string, size int64, head string) string {
+	length := min(size, headSize)
+	if headLength(head) >= length {
+		return head
+	}
+	fresh, err := fileHead(c, path, length)
+	if err != nil {
+		slog.Debug("Could not read the start of a log file", "path", path, "err", err)
+		return head
+	}
+	return fresh
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+
+	"simo.ng/logger/pkg/ingest"
+)
+
+// withFake points the sources at an in-memory runtime with one container
+// and starts with empty checkpoints in a temporary state dir
+func withFake(t *testing.T) (*ingest.Fake, Config, container) {
+	t.Helper()
+	fake := ingest.NewFake()
+	fake.AddContainer("caddy", "c1")
+	previous := openSource
+	openSource = fake.Open
+	t.Cleanup(func() { openSource = previous })
+
+	checkpoints = &checkpointStore{points: map[string]checkpoint{}}
+	started.keys = map[string]bool{}
+	return fake, Config{StateDir: t.TempDir()}, container{name: "caddy", id: "c1"}
+}
+
+func TestReadNew(t *testing.T) {
+	fake, config, c := withFake(t)
+	fake.Write("access.log", "old")
+
+	steps := []struct {
+		name  string
+		do    func()
+		want  string
+		point int64
+	}{
+		{"unknown file from the start", func() {}, "old\n", 4},
+		{"appended lines", func() { fake.Write("access.log", "one", "two") }, "one\ntwo\n", 12},
+		{"nothing new", func() {}, "", 12},
+		{"rotated file from the start", func() { fake.Rotate("access.log"); fake.Write("access.log", "new") }, "new\n", 4},
+	}
+	for _, step := range steps {
+		step.do()
+		got, err := readNew(config, c, "access.log")
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: got %q, want %q", step.name, got, step.want)
+		}
+		point, _ := checkpoints.get(config, sourceID("caddy", "access.log"))
+		if point.Offset != step.point {
+			t.Errorf("%s: offset %d, want %d", step.name, point.Offset, step.point)
+		}
+	}
+}
+
+func TestStartAtEnd(t *testing.T) {
+	fake, config, c := withFake(t)
+	fake.Write("access.log", "history")
+	startAtEnd(config, c, []string{"access.log"})
+	if got, _ := readNew(config, c, "access.log"); got != "" {
+		t.Errorf("file there at startup: got %q, want nothing", got)
+	}
+
+	// a file showing up later, even across a watcher restart, is read whole
+	fake.Write("other.log", "first")
+	startAtEnd(config, c, []string{"access.log", "other.log"})
+	if got, _ := readNew(config, c, "other.log"); got != "first\n" {
+		t.Errorf("file created after startup: got %q", got)
+	}
+}
+
+func TestReadNewMovedFile(t *testing.T) {
+	fake, config, c := withFake(t)
+	fake.Write("access.log", "one", "two")
+	if _, err := readNew(config, c, "access.log"); err != nil {
+		t.Fatal(err)
+	}
+	id := sourceID("caddy", "access.log")
+	saved, _ := checkpoints.get(config, id)
+	if saved.Head == "" {
+		t.Fatal("checkpoint has no head")
+	}
+
+	// the same file on a new host: another inode, the same start, and a
+	// line written after the export
+	moved, _, _ := withFake(t)
+	moved.Write("error.log", "takes the first inode")
+	moved.Write("access.log", "one", "two", "three")
+	checkpoints.set(id, saved)
+
+	got, err := readNew(config, c, "access.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "three\n" {
+		t.Errorf("moved file: got %q, want only the new line", got)
+	}
+
+	// a different file under the path is read from the start
+	other, _, _ := withFake(t)
+	other.Write("error.log", "takes the first inode")
+	other.Write("access.log", "else", "entirely", "different")
+	checkpoints.set(id, saved)
+	if got, _ := readNew(config, c, "access.log"); got != "else\nentirely\ndifferent\n" {
+		t.Errorf("other file: got %q", got)
+	}
+}
+
+func TestReplicaNames(t *testing.T) {
+	fake, _, _ := withFake(t)
+	fake.WriteService("caddy", "t1", 1, "line")
+	fake.WriteService("caddy", "t2", 3, "line")
+
+	p := Pipeline{Service: "caddy"}
+	replicas := &replicaNames{names: map[string]string{}}
+	for task, want := range map[string]string{"t1": "caddy.1", "t2": "caddy.3", "gone": "caddy"} {
+		if got := replicas.lookup(p, task); got != want {
+			t.Errorf("%s: got %q, want %q", task, got, want)
+		}
+	}
+
+	details, line := splitLogDetails("com.docker.swarm.task.id=t1,com.docker.swarm.node.id=n1 {\"msg\":\"x\"}")
+	if details["com.docker.swarm.task.id"] != "t1" || details["com.docker.swarm.node.id"] != "n1" || line != `{"msg":"x"}` {
+		t.Errorf("details: got %v %q", details, line)
+	}
+	if details, line := splitLogDetails(`{"msg":"no details"}`); len(details) != 0 || line != `{"msg":"no details"}` {
+		t.Errorf("without details: got %v %q", details, line)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runCommand runs a one-off subcommand instead of the logger
+func runCommand(configPath string, args []string) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		fatal("Error reading config", "err", err)
+	}
+
+	switch args[0] {
+	case "state":
+		err = stateCommand(config, args[1:])
+	case "doctor":
+		err = doctor(config)
+	case "import":
+		err = importLogs(config, args[1:])
+	case "validate":
+		err = validate(config)
+	case "simulate":
+		err = simulateCommand(config, args[1:])
+	default:
+		err = fmt.Errorf("unknown command %q", args[0])
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
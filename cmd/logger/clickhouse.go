@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+type ClickHouseConfig struct {
+	// URL is the http interface, e.g. http://clickhouse:8123
+	URL      string `json:"url"`
+	Database string `json:"database"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Table defaults to caddy_requests
+	Table string `json:"table"`
+	// Columns maps the columns of the table to event fields, by default
+	// every field goes to the column of its name
+	Columns map[string]string `json:"columns"`
+	// CreateTable creates the default table if it doesn't exist yet
+	CreateTable bool        `json:"createTable"`
+	Batch       BatchConfig `json:"batch"`
+}
+
+// eventFields are the json names of the fields of event
+var eventFields = []string{
+	"time", "host", "method", "uri", "status", "ip", "country", "userAgent",
+	"severity", "duration", "size", "proto", "source",
+}
+
+var clickhouseIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+const clickhouseSchema = `CREATE TABLE IF NOT EXISTS %s (
+    time DateTime64(3, 'UTC'),
+    host LowCardinality(String),
+    method LowCardinality(String),
+    uri String,
+    status UInt16,
+    ip String,
+    country LowCardinality(String),
+    userAgent String,
+    severity LowCardinality(String),
+    duration Float64,
+    size UInt64,
+    proto LowCardinality(String),
+    source LowCardinality(String)
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(time)
+ORDER BY (host, time)`
+
+type clickhouseSink struct {
+	*batch
+	config  ClickHouseConfig
+	client  *http.Client
+	columns []string
+
+	mu      sync.Mutex
+	created bool
+}
+
+func newClickHouseSink(config ClickHouseConfig) *clickhouseSink {
+	if config.Table == "" {
+		config.Table = "caddy_requests"
+	}
+	if len(config.Columns) == 0 {
+		config.Columns = map[string]string{}
+		for _, field := range eventFields {
+			config.Columns[field] = field
+		}
+	}
+	s := &clickhouseSink{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	for column := range config.Columns {
+		s.columns = append(s.columns, column)
+	}
+	sort.Strings(s.columns)
+	s.batch = newBatch("clickhouse", config.Batch, s.insert)
+	return s
+}
+
+func (s *clickhouseSink) Name() string {
+	return "clickhouse"
+}
+
+func (s *clickhouseSink) Send(data parse.Data, raw string) error {
+	if parse.IsAccessLog(data) {
+		s.add(newEvent(currentConfig(), data))
+	}
+	return nil
+}
+
+func (s *clickhouseSink) query(query string, body []byte) error {
+	params := url.Values{
+		"query": {query},
+		// the events carry rfc 3339 times
+		"date_time_input_format": {"best_effort"},
+	}
+	if s.config.Database != "" {
+		params.Set("database", s.config.Database)
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.config.URL, "/")+"/?"+params.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if s.config.Username != "" {
+		req.Header.Set("X-ClickHouse-User", s.config.Username)
+		req.Header.Set("X-ClickHouse-Key", s.config.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return newStatusError("clickhouse", resp)
+	}
+	return nil
+}
+
+// create makes the default table once, callers check CreateTable
+func (s *clickhouseSink) create() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.created {
+		return nil
+	}
+	if err := s.query(fmt.Sprintf(clickhouseSchema, s.config.Table), nil); err != nil {
+		return err
+	}
+	s.created = true
+	return nil
+}
+
+func (s *clickhouseSink) insert(events []event) error {
+	if s.config.CreateTable {
+		if err := s.create(); err != nil {
+			return err
+		}
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, e := range events {
+		// through a map to pick the fields by their json names
+		raw, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return err
+		}
+		row := map[string]interface{}{}
+		for column, field := range s.config.Columns {
+			if value, ok := fields[field]; ok {
+				row[column] = value
+			}
+		}
+		enc.Encode(row)
+	}
+
+	query := "INSERT INTO " + s.config.Table + " (" + strings.Join(s.columns, ", ") + ") FORMAT JSONEachRow"
+	return s.query(query, body.Bytes())
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+// trustedProxies holds the parsed trustedProxies of the current config,
+// clientIP is called far too often to parse them every time
+var trustedProxies atomic.Value
+
+// anonymized is set while privacy.ip is on. redactLine has then already
+// resolved the visitor from the raw line and put its anonymized address in
+// client_ip, the proxies can't be matched against anonymized addresses.
+var anonymized atomic.Bool
+
+func parseProxies(cidrs []string) []netip.Prefix {
+	prefixes := []netip.Prefix{}
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if addr, err := netip.ParseAddr(cidr); err == nil {
+				prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			}
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(cidr); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+		}
+	}
+	return prefixes
+}
+
+func trusted(proxies []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address of the visitor. With trustedProxies the headers
+// are only believed when the request came through one of them, and the
+// X-Forwarded-For chain is walked from the right to the first hop that isn't
+// a trusted proxy. Without it caddy's own client_ip is used, which follows
+// caddy's trusted_proxies, and then the connection address. The headers are
+// never read then, any client can send them.
+func clientIP(data parse.Data) string {
+	if anonymized.Load() && data.Request.ClientIP != "" {
+		return data.Request.ClientIP
+	}
+	return resolveClientIP(data)
+}
+
+func resolveClientIP(data parse.Data) string {
+	proxies, _ := trustedProxies.Load().([]netip.Prefix)
+	headers := data.Request.Headers
+
+	if len(proxies) == 0 {
+		if data.Request.ClientIP != "" {
+			return data.Request.ClientIP
+		}
+		return data.Request.RemoteIP
+	}
+
+	if !trusted(proxies, data.Request.RemoteIP) {
+		return data.Request.RemoteIP
+	}
+	if len(headers.CfConnectingIP) > 0 && headers.CfConnectingIP[0] != "" {
+		return headers.CfConnectingIP[0]
+	}
+
+	var chain []string
+	for _, value := range headers.XForwardedFor {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				chain = append(chain, hop)
+			}
+		}
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		if !trusted(proxies, chain[i]) {
+			return chain[i]
+		}
+	}
+	if len(chain) > 0 {
+		return chain[0]
+	}
+	return data.Request.RemoteIP
+}
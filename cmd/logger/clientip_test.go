@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+func TestClientIPIgnoresUntrustedHeaders(t *testing.T) {
+	var data parse.Data
+	data.Request.RemoteIP = "198.51.100.7"
+	data.Request.Headers.CfConnectingIP = []string{"203.0.113.9"}
+	data.Request.Headers.XForwardedFor = []string{"203.0.113.9"}
+
+	trustedProxies.Store(parseProxies(nil))
+	if got := clientIP(data); got != "198.51.100.7" {
+		t.Errorf("without trusted proxies: got %s", got)
+	}
+
+	trustedProxies.Store(parseProxies([]string{"10.0.0.0/8"}))
+	defer trustedProxies.Store(parseProxies(nil))
+	if got := clientIP(data); got != "198.51.100.7" {
+		t.Errorf("from an untrusted address: got %s", got)
+	}
+	data.Request.RemoteIP = "10.0.0.1"
+	if got := clientIP(data); got != "203.0.113.9" {
+		t.Errorf("through a trusted proxy: got %s", got)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+func init() {
+	botCommands["tail"] = botCommand{
+		definition: map[string]interface{}{
+			"name":        "tail",
+			"description": "Show the latest requests",
+			"options": []map[string]interface{}{
+				{"type": 3, "name": "host", "description": "Only requests for this host"},
+				{"type": 4, "name": "n", "description": "Number of requests", "min_value": 1, "max_value": 50},
+				{"type": 5, "name": "live", "description": "Follow new requests for 30 seconds in a thread"},
+			},
+		},
+		handle: tailCommand,
+	}
+	botCommands["recent"] = botCommand{
+		definition: map[string]interface{}{
+			"name":        "recent",
+			"description": "Show the latest requests",
+			"options": []map[string]interface{}{
+				{"type": 3, "name": "host", "description": "Only requests for this host"},
+			},
+		},
+		handle: tailCommand,
+	}
+	botCommands["ip"] = botCommand{
+		definition: map[string]interface{}{
+			"name":        "ip",
+			"description": "Show the latest requests of an IP address",
+			"options": []map[string]interface{}{
+				{"type": 3, "name": "addr", "description": "The client address", "required": true},
+			},
+		},
+		handle: ipCommand,
+	}
+	botCommands["stats"] = botCommand{
+		definition: map[string]interface{}{
+			"name":        "stats",
+			"description": "Count the stored requests",
+			"options": []map[string]interface{}{
+				{"type": 3, "name": "period", "description": "today (default), hour or a duration like 6h"},
+			},
+		},
+		handle: statsCommand,
+	}
+}
+
+func hostFilter(host string) func(parse.Data) bool {
+	if host == "" {
+		return nil
+	}
+	return func(data parse.Data) bool {
+		return strings.EqualFold(data.Request.Host, host)
+	}
+}
+
+// codeBlock joins lines into a code block that fits a discord message,
+// dropping the oldest lines first
+func codeBlock(lines []string) string {
+	for i := range lines {
+		lines[i] = notify.CodeSafe(lines[i])
+	}
+	for len(lines) > 0 {
+		block := "```\n" + strings.Join(lines, "\n") + "\n```"
+		if len(block) <= notify.MaxContent {
+			return block
+		}
+		lines = lines[1:]
+	}
+	return "```\n```"
+}
+
+func tailCommand(cfg BotConfig, i interaction) interactionResponse {
+	host := i.stringOption("host")
+	n := i.intOption("n", 20)
+	if n > 50 {
+		n = 50
+	}
+
+	if i.boolOption("live") {
+		go liveTail(cfg, i, host)
+		what := "all hosts"
+		if host != "" {
+			what = host
+		}
+		return reply("📡 Following " + what + " for 30 seconds…")
+	}
+
+	found := events.last(n, hostFilter(host))
+	if len(found) == 0 {
+		return reply("No matching requests in the store")
+	}
+	lines := make([]string, 0, len(found))
+	for _, event := range found {
+		lines = append(lines, eventLine(event.Data))
+	}
+	return reply(codeBlock(lines))
+}
+
+// liveTail opens a thread on the command's reply and streams new events into
+// it for 30 seconds, batched every couple of seconds to stay in rate limits
+func liveTail(cfg BotConfig, i interaction, host string) {
+	// the reply has to exist before a thread can be started from it
+	time.Sleep(time.Second)
+
+	var original discordMessage
+	err := botRequest(cfg, http.MethodGet, "/webhooks/"+i.ApplicationID+"/"+i.Token+"/messages/@original", nil, &original)
+	if err != nil {
+		slog.Warn("Live tail: error fetching reply", "err", err)
+		return
+	}
+
+	name := "tail " + host
+	if host == "" {
+		name = "tail"
+	}
+	var thread struct {
+		ID string `json:"id"`
+	}
+	err = botRequest(cfg, http.MethodPost, "/channels/"+i.ChannelID+"/messages/"+original.ID+"/threads",
+		map[string]interface{}{"name": name, "auto_archive_duration": 60}, &thread)
+	if err != nil {
+		slog.Warn("Live tail: error starting thread", "err", err)
+		return
+	}
+
+	lines := make(chan string, 100)
+	match := hostFilter(host)
+	cancel := events.subscribe(func(event storedEvent) {
+		if match == nil || match(event.Data) {
+			select {
+			case lines <- eventLine(event.Data):
+			default:
+			}
+		}
+	})
+	defer cancel()
+
+	post := func(content string) {
+		err := botRequest(cfg, http.MethodPost, "/channels/"+thread.ID+"/messages",
+			map[string]interface{}{"content": content, "allowed_mentions": notify.AllowedMentions{Parse: []string{}}}, nil)
+		if err != nil {
+			slog.Warn("Live tail: error posting", "err", err)
+		}
+	}
+
+	total := 0
+	var batch []string
+	end := time.After(30 * time.Second)
+	flush := time.NewTicker(2 * time.Second)
+	defer flush.Stop()
+	for {
+		select {
+		case line := <-lines:
+			batch = append(batch, line)
+			total++
+		case <-flush.C:
+			if len(batch) > 0 {
+				post(codeBlock(batch))
+				batch = nil
+			}
+		case <-end:
+			if len(batch) > 0 {
+				post(codeBlock(batch))
+			}
+			post(fmt.Sprintf("Tail finished, %d requests", total))
+			return
+		}
+	}
+}
+
+// ipCommand lists what the store has of an address, below its profile line
+func ipCommand(cfg BotConfig, i interaction) interactionResponse {
+	ip := strings.TrimSpace(i.stringOption("addr"))
+	found := events.last(20, func(data parse.Data) bool { return clientIP(data) == ip })
+
+	var summary string
+	if p, ok := profiles.get(ip); ok {
+		summary = notify.EscapeMarkdown(ip) + ": " + p.Summary() + "\n"
+	}
+	if len(found) == 0 {
+		if summary == "" {
+			return reply("No requests from " + notify.EscapeMarkdown(ip) + " in the store")
+		}
+		return reply(summary + "None of them are in the store anymore")
+	}
+	lines := make([]string, 0, len(found))
+	for _, event := range found {
+		lines = append(lines, eventLine(event.Data))
+	}
+	return reply(summary + codeBlock(lines))
+}
+
+// statsPeriod turns the period option into the time counting starts at
+func statsPeriod(period string, now time.Time) (time.Time, string, bool) {
+	switch strings.ToLower(strings.TrimSpace(period)) {
+	case "", "today":
+		local := now.In(currentConfig().Time.location())
+		return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location()), "today", true
+	case "hour":
+		return now.Add(-time.Hour), "the last hour", true
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return time.Time{}, "", false
+	}
+	return now.Add(-d), "the last " + d.String(), true
+}
+
+func statsCommand(cfg BotConfig, i interaction) interactionResponse {
+	since, label, ok := statsPeriod(i.stringOption("period"), time.Now())
+	if !ok {
+		return reply("Period must be today, hour or a duration like 6h")
+	}
+
+	var requests, clientErrors, serverErrors int
+	hosts, ips, paths := map[string]int{}, map[string]int{}, map[string]int{}
+	for _, event := range events.last(math.MaxInt, nil) {
+		if event.Data.Ts.Time().Before(since) {
+			continue
+		}
+		requests++
+		switch {
+		case event.Data.Status >= 500:
+			serverErrors++
+		case event.Data.Status >= 400:
+			clientErrors++
+		}
+		hosts[event.Data.Request.Host]++
+		ips[clientIP(event.Data)]++
+		paths[dedupPath(event.Data.Request.URI, true)]++
+	}
+	if requests == 0 {
+		return reply("No stored requests from " + label)
+	}
+
+	lines := []string{
+		fmt.Sprintf("Requests: %d in %s", requests, label),
+		fmt.Sprintf("4xx:      %d", clientErrors),
+		fmt.Sprintf("5xx:      %d", serverErrors),
+	}
+	for _, top := range []struct {
+		title  string
+		counts map[string]int
+	}{{"Hosts", hosts}, {"Addresses", ips}, {"Paths", paths}} {
+		lines = append(lines, top.title+":")
+		for _, r := range topN(top.counts, 5) {
+			lines = append(lines, fmt.Sprintf("  %5d  %s", r.count, notify.Truncate(r.key, 60)))
+		}
+	}
+	return reply(codeBlock(lines))
+}
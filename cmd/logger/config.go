@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+var (
+	configMu sync.RWMutex
+	config   Config
+	// fileConfig is the config as written, before discovered routes are added
+	fileConfig Config
+)
+
+func loadConfig(path string) (Config, error) {
+	var loaded Config
+
+	jsonData, err := os.ReadFile(path)
+	if err != nil {
+		return loaded, err
+	}
+	if err := json.Unmarshal(jsonData, &loaded); err != nil {
+		return loaded, err
+	}
+	if err := resolveSecrets(&loaded); err != nil {
+		return loaded, err
+	}
+	err = loadManagedRules(&loaded)
+	return loaded, err
+}
+
+func currentConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
+
+func currentFileConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return fileConfig
+}
+
+// setConfig swaps in a new config and rebuilds everything derived from it
+func setConfig(next Config) {
+	applied := applyDiscovery(next, currentDiscovery())
+	built := buildSinks(applied)
+	others := buildNotifiers(applied)
+	parse.SetLayouts(next.TimeLayouts)
+	if level, err := next.Log.level(); err == nil {
+		logLevel.Set(level)
+	}
+	trustedProxies.Store(parseProxies(next.TrustedProxies))
+	anonymized.Store(next.Privacy != nil && next.Privacy.IP != "")
+	compilePrograms(applied)
+
+	configMu.Lock()
+	fileConfig = next
+	config = applied
+	replaced := sinks
+	sinks = built
+	notifiers = others
+	configMu.Unlock()
+
+	// the replaced sinks would take what they buffered with them, and keep
+	// their connections open
+	go func() {
+		flushSinks(replaced, true)
+		closeSinks(replaced)
+	}()
+}
+
+// watchConfig reloads the config whenever the file changes. The directory is
+// watched rather than the file itself because most editors save by renaming
+// a temporary file over the original.
+func watchConfig(path string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Warn("Config hot reload disabled", "err", err)
+		return
+	}
+	defer watcher.Close()
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		slog.Warn("Config hot reload disabled", "err", err)
+		return
+	}
+	if err := watcher.Add(filepath.Dir(abs)); err != nil {
+		slog.Warn("Config hot reload disabled", "err", err)
+		return
+	}
+
+	// a single save often fires several events, wait for them to settle
+	var reload <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != abs {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				reload = time.After(250 * time.Millisecond)
+			}
+		case <-reload:
+			reload = nil
+			reloadConfig(path)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("Error watching config", "err", err)
+		}
+	}
+}
+
+func reloadConfig(path string) {
+	next, err := loadConfig(path)
+	if err != nil {
+		slog.Error("Config reload failed, keeping the previous config", "err", err)
+		return
+	}
+	if problems := chainProblems(next); len(problems) > 0 {
+		slog.Error("Invalid chain, keeping the previous config", "problems", problems)
+		return
+	}
+
+	previous := currentFileConfig()
+	if next.ContainerName != previous.ContainerName || next.LogDir != previous.LogDir ||
+		!reflect.DeepEqual(withoutOutputs(next.Pipelines), withoutOutputs(previous.Pipelines)) || next.Docker != previous.Docker {
+		slog.Warn("containerName, logDir, docker and pipelines changes only apply after a restart")
+		next.ContainerName = previous.ContainerName
+		next.LogDir = previous.LogDir
+		next.Docker = previous.Docker
+		// the outputs are looked up per event, so they apply right away
+		outputs := map[string][]PipelineOutput{}
+		for _, p := range next.Pipelines {
+			outputs[p.Name] = p.Outputs
+		}
+		next.Pipelines = withoutOutputs(previous.Pipelines)
+		for i := range next.Pipelines {
+			next.Pipelines[i].Outputs = outputs[next.Pipelines[i].Name]
+		}
+	}
+
+	setConfig(next)
+	slog.Info("Config reloaded", "path", path)
+	canary.arm(next)
+}
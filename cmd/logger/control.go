@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type ControlConfig struct {
+	Listen string `json:"listen"`
+	// bearer token required on every request when set
+	Token string `json:"token"`
+	// PublicURL is where the control API is reachable from a browser, when
+	// set every message links to its stored event
+	PublicURL string `json:"publicUrl"`
+}
+
+type webhookStatus struct {
+	ID     string    `json:"id"`
+	Routes []string  `json:"routes"`
+	Paused bool      `json:"paused"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since,omitempty"`
+}
+
+// webhookID is the numeric part of the url, safe to show without the token
+func webhookID(webhookUrl string) string {
+	parts := strings.Split(strings.TrimSuffix(webhookUrl, "/"), "/")
+	for i, part := range parts {
+		if part == "webhooks" && i+1 < len(parts) {
+			return parts[i+1]
+		}
+	}
+	return "unknown"
+}
+
+func webhookStatuses(config Config) []webhookStatus {
+	var statuses []webhookStatus
+	for _, url := range webhookUrls(config) {
+		status := webhookStatus{ID: webhookID(url), Routes: routeNames(config, url)}
+		webhooks.mu.Lock()
+		if state, ok := webhooks.failed[url]; ok {
+			status.Paused = true
+			status.Reason = redactSecrets(state.Reason)
+			status.Since = state.Since
+		}
+		webhooks.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func serveControl(cfg ControlConfig) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, webhookStatuses(currentConfig()))
+	})
+
+	mux.HandleFunc("/metrics", metricsHandler)
+
+	mux.HandleFunc("/events/", eventHandler)
+
+	mux.HandleFunc("/rules", rulesHandler(cfg.Token))
+	mux.HandleFunc("/rules/", rulesHandler(cfg.Token))
+
+	mux.HandleFunc("/errors", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, failures.snapshot())
+	})
+
+	// re-validate paused webhooks, optionally only those of ?route=name
+	mux.HandleFunc("/webhooks/revalidate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		config := currentConfig()
+		route := r.URL.Query().Get("route")
+
+		for _, url := range webhookUrls(config) {
+			if webhooks.failure(url) == "" {
+				continue
+			}
+			if route != "" && !contains(routeNames(config, url), route) {
+				continue
+			}
+			if err := validateWebhook(url); err != nil {
+				slog.Warn("Webhook still failing", "webhook", webhookID(url), "err", err)
+				continue
+			}
+			webhooks.restore(url)
+			slog.Info("Webhook re-validated, resuming", "webhook", webhookID(url))
+		}
+		writeJSON(w, webhookStatuses(config))
+	})
+
+	slog.Info("Control API listening", "listen", cfg.Listen)
+	if err := http.ListenAndServe(cfg.Listen, allowPermalinks(cfg.Token, requireToken(cfg.Token, mux))); err != nil {
+		slog.Error("Control API stopped", "err", err)
+	}
+}
+
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing response", "err", err)
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"simo.ng/logger/pkg/filter"
+	"simo.ng/logger/pkg/parse"
+)
+
+// CountryConfig filters on the country of the client, given as ISO 3166
+// alpha-2 codes
+type CountryConfig struct {
+	// Ignore keeps traffic from these countries out of discord, it still
+	// reaches the outputs, the store and the digest
+	Ignore []string `json:"ignore"`
+}
+
+// CountryRule escalates requests from Countries, limited to Paths when set
+type CountryRule struct {
+	Countries []string `json:"countries"`
+	Paths     []string `json:"paths"`
+}
+
+// countryOf is the country cloudflare saw the request from, or the one the
+// asn database has for the client address. The header is only taken when
+// it is a country code, a client reaching caddy directly can send anything.
+func countryOf(config Config, data parse.Data) string {
+	if headers := data.Request.Headers.CfIpcountry; len(headers) > 0 && validCountry(headers[0]) {
+		return strings.ToUpper(headers[0])
+	}
+	if config.ASN != nil && config.ASN.Database != "" {
+		if info, ok := asns.lookup(*config.ASN, clientIP(data)); ok {
+			return info.Country
+		}
+	}
+	return ""
+}
+
+func hasCountry(list []string, country string) bool {
+	for _, c := range list {
+		if strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c CountryConfig) ignores(country string) bool {
+	return country != "" && hasCountry(c.Ignore, country)
+}
+
+// matches returns what to put in the escalation reason when the rule
+// applies to the request
+func (r CountryRule) matches(data parse.Data, country string) (string, bool) {
+	if country == "" || !hasCountry(r.Countries, country) {
+		return "", false
+	}
+	if len(r.Paths) == 0 {
+		return fmt.Sprintf("request from %s", country), true
+	}
+	for _, pattern := range r.Paths {
+		if filter.MatchPath(pattern, data.Request.URI) {
+			return fmt.Sprintf("hit on %s from %s", pattern, country), true
+		}
+	}
+	return "", false
+}
+
+// validCountry accepts two letter codes, cloudflare's XX and T1 included
+func validCountry(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, c := range strings.ToUpper(code) {
+		if (c < 'A' || c > 'Z') && c != '1' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+type DedupConfig struct {
+	Window string `json:"window"`
+	// fields making up the key: ip, host, method, path, status, ua.
+	// Defaults to ip, path and status.
+	Key []string `json:"key"`
+	// similar ignores query strings and numeric path segments, so
+	// /post/1?a and /post/2?b count as the same path
+	Similar bool `json:"similar"`
+	// report posts the running count every interval while a window is still
+	// open, by default repeats are only posted when the window closes
+	Report string `json:"report"`
+}
+
+type dedupEntry struct {
+	first   time.Time
+	until   time.Time
+	host    string
+	summary string
+	repeats int
+	// reported is how many of the repeats were already posted and when
+	reported   int
+	reportedAt time.Time
+}
+
+func newDedupEntry(data parse.Data, first time.Time, window time.Duration) *dedupEntry {
+	return &dedupEntry{
+		first:      first,
+		until:      first.Add(window),
+		reportedAt: first,
+		host:       data.Request.Host,
+		summary: fmt.Sprintf("%s %s%s → %d from %s",
+			data.Request.Method, data.Request.Host, data.Request.URI, data.Status, clientIP(data)),
+	}
+}
+
+// rollup describes the repeats since the last report
+func (e *dedupEntry) rollup(now time.Time) string {
+	count := e.repeats - e.reported
+	since := now.Sub(e.reportedAt)
+	if e.until.Before(now) {
+		since = e.until.Sub(e.reportedAt)
+	}
+	e.reported = e.repeats
+	e.reportedAt = now
+	return fmt.Sprintf("🔁 last message repeated %d× in %s\n`%s`", count, since.Round(time.Second), notify.CodeSafe(e.summary))
+}
+
+type deduplicator struct {
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+var dedup = &deduplicator{entries: map[string]*dedupEntry{}}
+
+var numericSegment = regexp.MustCompile(`/[0-9]+(/|$)`)
+
+func dedupPath(uri string, similar bool) string {
+	if !similar {
+		return uri
+	}
+	path, _, _ := strings.Cut(uri, "?")
+	// run twice so back to back numeric segments are both replaced
+	path = numericSegment.ReplaceAllString(path, "/:n$1")
+	return numericSegment.ReplaceAllString(path, "/:n$1")
+}
+
+func dedupKey(cfg DedupConfig, data parse.Data) string {
+	fields := cfg.Key
+	if len(fields) == 0 {
+		fields = []string{"ip", "path", "status"}
+	}
+
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "ip":
+			parts = append(parts, clientIP(data))
+		case "host":
+			parts = append(parts, data.Request.Host)
+		case "method":
+			parts = append(parts, data.Request.Method)
+		case "path":
+			parts = append(parts, dedupPath(data.Request.URI, cfg.Similar))
+		case "status":
+			parts = append(parts, fmt.Sprint(data.Status))
+		case "ua":
+			if len(data.Request.Headers.UserAgent) > 0 {
+				parts = append(parts, data.Request.Headers.UserAgent[0])
+			}
+		}
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// suppress reports whether the event repeats one already posted within the
+// window. The first event of a key is posted, the rest are only counted.
+func (d *deduplicator) suppress(cfg DedupConfig, data parse.Data) bool {
+	window, err := time.ParseDuration(cfg.Window)
+	if err != nil || window <= 0 {
+		return false
+	}
+
+	key := dedupKey(cfg, data)
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if entry, ok := d.entries[key]; ok && now.Before(entry.until) {
+		entry.repeats++
+		return true
+	}
+	d.entries[key] = newDedupEntry(data, now, window)
+	return false
+}
+
+// warm opens the window of an event read during backfill. Live repeats of it
+// are then suppressed as if it had been posted, backfilled ones aren't counted.
+func (d *deduplicator) warm(cfg DedupConfig, data parse.Data) {
+	window, err := time.ParseDuration(cfg.Window)
+	if err != nil || window <= 0 {
+		return
+	}
+	ts := data.Ts.Time()
+	if !ts.Add(window).After(time.Now()) {
+		return
+	}
+
+	key := dedupKey(cfg, data)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.entries[key]; !ok {
+		d.entries[key] = newDedupEntry(data, ts, window)
+	}
+}
+
+type rollup struct {
+	host    string
+	message string
+}
+
+// due removes closed windows and returns a rollup for every window with
+// unreported repeats that closed or is due for a report
+func (d *deduplicator) due(now time.Time, report time.Duration) []rollup {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var due []rollup
+	for key, entry := range d.entries {
+		closed := !now.Before(entry.until)
+		if closed {
+			delete(d.entries, key)
+		} else if report <= 0 || now.Sub(entry.reportedAt) < report {
+			continue
+		}
+		if entry.repeats > entry.reported {
+			due = append(due, rollup{host: entry.host, message: entry.rollup(now)})
+		}
+	}
+	return due
+}
+
+// run posts a rollup for every window that closed with repeats in it, and
+// for open ones when a report interval is set
+func (d *deduplicator) run() {
+	for now := range time.Tick(5 * time.Second) {
+		cfg := currentConfig()
+		var report time.Duration
+		if cfg.Dedup != nil {
+			report, _ = time.ParseDuration(cfg.Dedup.Report)
+		}
+
+		for _, r := range d.due(now, report) {
+			for _, route := range routesFor(cfg, r.host) {
+				if err := sendRouteMessage(cfg, route, r.host, notify.Message{Content: r.message}); err != nil {
+					slog.Error("Error sending rollup to route", "route", route.Name, "err", err)
+				}
+			}
+		}
+	}
+}
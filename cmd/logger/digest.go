@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+
+	"simo.ng/logger/pkg/notify"
+
+	"simo.ng/logger/pkg/filter"
+)
+
+type DigestConfig struct {
+	// At is the local time of day the digest is posted, "09:00" by default
+	At         string   `json:"at"`
+	WebhookURL string   `json:"webhookUrl"`
+	Top        int      `json:"top"`
+	ProbePaths []string `json:"probePaths"`
+}
+
+var defaultProbePaths = []string{
+	"/wp-admin", "/wp-login.php", "/xmlrpc.php", "/.git", "/.env", "/.aws",
+	"/phpmyadmin", "/pma", "/cgi-bin", "/vendor/phpunit", "/actuator", "/server-status",
+}
+
+// securityDigest collects what the daily report needs since it was last posted
+type securityDigest struct {
+	mu       sync.Mutex
+	since    time.Time
+	clientIP map[string]int
+	probes   map[string]int
+	newUAs   map[string]int
+	requests int
+	// seenUAs persists across digests so only really new scanners are listed
+	seenUAs map[string]time.Time
+	loaded  bool
+}
+
+var digest = &securityDigest{
+	since:    time.Now(),
+	clientIP: map[string]int{},
+	probes:   map[string]int{},
+	newUAs:   map[string]int{},
+	seenUAs:  map[string]time.Time{},
+}
+
+func probePath(cfg DigestConfig, uri string) (string, bool) {
+	patterns := cfg.ProbePaths
+	if len(patterns) == 0 {
+		patterns = defaultProbePaths
+	}
+	for _, pattern := range patterns {
+		if filter.MatchPath(pattern, uri) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+func (d *securityDigest) load(config Config) {
+	if d.loaded {
+		return
+	}
+	d.loaded = true
+	raw, err := os.ReadFile(statePath(config, "seen-user-agents.json"))
+	if err == nil {
+		err = json.Unmarshal(raw, &d.seenUAs)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		slog.Error("Error reading seen user agents", "err", err)
+	}
+	if d.seenUAs == nil {
+		d.seenUAs = map[string]time.Time{}
+	}
+}
+
+func (d *securityDigest) record(config Config, data parse.Data) {
+	if config.Digest == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.load(config)
+
+	d.requests++
+	if data.Status >= 400 && data.Status < 500 {
+		d.clientIP[clientIP(data)]++
+	}
+	if pattern, ok := probePath(*config.Digest, data.Request.URI); ok {
+		d.probes[pattern]++
+	}
+
+	if len(data.Request.Headers.UserAgent) > 0 {
+		ua := seenUA(data.Request.Headers.UserAgent[0])
+		if parse.ParseUserAgent(ua).Device == "bot" {
+			d.remember(ua, time.Now())
+			_, counted := d.newUAs[ua]
+			if d.seenUAs[ua].After(d.since) && (counted || len(d.newUAs) < maxSeenUAs) {
+				d.newUAs[ua]++
+			}
+		}
+	}
+}
+
+// the user agents are sent by the clients, so only so many of so much are
+// kept, the oldest are forgotten first
+const (
+	maxSeenUAs  = 20000
+	maxUALength = 512
+)
+
+func seenUA(ua string) string {
+	return notify.Truncate(ua, maxUALength)
+}
+
+// remember marks a user agent as seen at, callers hold the lock
+func (d *securityDigest) remember(ua string, at time.Time) {
+	if seen, ok := d.seenUAs[ua]; ok && !at.Before(seen) {
+		return
+	}
+	if _, ok := d.seenUAs[ua]; !ok && len(d.seenUAs) >= maxSeenUAs {
+		evictOldest(d.seenUAs)
+	}
+	d.seenUAs[ua] = at
+}
+
+type ranked struct {
+	key   string
+	count int
+}
+
+func topN(counts map[string]int, n int) []ranked {
+	list := make([]ranked, 0, len(counts))
+	for key, count := range counts {
+		list = append(list, ranked{key, count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].count != list[j].count {
+			return list[i].count > list[j].count
+		}
+		return list[i].key < list[j].key
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+func rankedLines(list []ranked, limit int) []string {
+	lines := make([]string, 0, len(list))
+	for _, r := range list {
+		key := r.key
+		if len(key) > limit {
+			key = key[:limit] + "…"
+		}
+		lines = append(lines, fmt.Sprintf("`%s` — %d", strings.ReplaceAll(key, "`", "'"), r.count))
+	}
+	return lines
+}
+
+// report builds the digest embed and starts a new period
+func (d *securityDigest) report(config Config, now time.Time) notify.Embed {
+	top := config.Digest.Top
+	if top <= 0 {
+		top = 10
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	report := notify.Embed{
+		Title:       "🛡️ Security digest",
+		Description: fmt.Sprintf("%s since %s", formatCount(d.requests, "request"), d.since.Format("2006-01-02 15:04")),
+		Color:       0xE67E22,
+		Fields: []notify.EmbedField{
+			{Name: "Most 4xx responses", Value: notify.FieldValue(rankedLines(topN(d.clientIP, top), 45))},
+			{Name: "Most probed paths", Value: notify.FieldValue(rankedLines(topN(d.probes, top), 45))},
+			{Name: "New scanner user agents", Value: notify.FieldValue(rankedLines(topN(d.newUAs, top), 80))},
+		},
+		Timestamp: now.UTC().Format(time.RFC3339),
+	}
+
+	d.since = now
+	d.clientIP = map[string]int{}
+	d.probes = map[string]int{}
+	d.newUAs = map[string]int{}
+	d.requests = 0
+
+	d.saveSeen(config)
+	return report
+}
+
+// saveSeen writes the known user agents, callers hold the lock
+func (d *securityDigest) saveSeen(config Config) {
+	if err := writeStateFile(config, "seen-user-agents.json", d.seenUAs); err != nil {
+		slog.Error("Error saving seen user agents", "err", err)
+	}
+}
+
+// learn remembers a scanner seen in an old log line without counting it
+// towards the current digest
+func (d *securityDigest) learn(config Config, data parse.Data) bool {
+	if len(data.Request.Headers.UserAgent) == 0 {
+		return false
+	}
+	ua := seenUA(data.Request.Headers.UserAgent[0])
+	if parse.ParseUserAgent(ua).Device != "bot" {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.load(config)
+	at := data.Ts.Time()
+	if seen, ok := d.seenUAs[ua]; ok && !at.Before(seen) {
+		return false
+	}
+	d.remember(ua, at)
+	return true
+}
+
+func formatCount(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// nextRun returns the next time the clock reads at ("15:04")
+func nextRun(at string, now time.Time) time.Time {
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		t, _ = time.Parse("15:04", "09:00")
+	}
+	next := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func (d *securityDigest) run() {
+	for {
+		cfg := currentConfig()
+		if cfg.Digest == nil {
+			time.Sleep(time.Minute)
+			continue
+		}
+
+		// sleep in short steps so a config change to "at" is picked up
+		next := nextRun(cfg.Digest.At, time.Now())
+		for time.Now().Before(next) {
+			wait := time.Until(next)
+			if wait > time.Minute {
+				wait = time.Minute
+			}
+			time.Sleep(wait)
+			if current := currentConfig(); current.Digest == nil || current.Digest.At != cfg.Digest.At {
+				break
+			}
+		}
+		cfg = currentConfig()
+		if cfg.Digest == nil || time.Now().Before(next) {
+			continue
+		}
+
+		webhook := cfg.Digest.WebhookURL
+		if webhook == "" {
+			webhook = cfg.WebhookURL
+		}
+		report := d.report(cfg, time.Now())
+		message := notify.Message{Embeds: []notify.Embed{report}}
+		if err := sendMessage(webhook, message); err != nil {
+			slog.Error("Error posting security digest", "err", err)
+		}
+		emailDigest(report)
+	}
+}
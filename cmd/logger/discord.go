@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+var webhookClient = &http.Client{Timeout: 15 * time.Second}
+
+// discordMessage is the part of the created message we read back
+type discordMessage struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+}
+
+// discord json error codes
+const (
+	errUnknownChannel = 10003
+	errUnknownMessage = 10008
+	errUnknownWebhook = 10015
+)
+
+func discordErrorCode(err error) int {
+	var serr *statusError
+	if !errors.As(err, &serr) {
+		return 0
+	}
+	var body struct {
+		Code int `json:"code"`
+	}
+	json.Unmarshal([]byte(serr.Body), &body)
+	return body.Code
+}
+
+// a deleted webhook answers 404, a revoked token 401 or 403. All are permanent
+// until someone fixes the config so there's no point in retrying. A 404 for a
+// deleted thread or message is not the webhook's fault though.
+func isAuthFailure(err error) bool {
+	var serr *statusError
+	if !errors.As(err, &serr) || serr.Service != "discord" {
+		return false
+	}
+	return serr.Status == http.StatusUnauthorized || serr.Status == http.StatusForbidden ||
+		(serr.Status == http.StatusNotFound && discordErrorCode(err) != errUnknownChannel && discordErrorCode(err) != errUnknownMessage)
+}
+
+var errWebhookPaused = errors.New("webhook is paused after an auth failure")
+
+func postWebhook(webhookUrl string, message notify.Message) error {
+	_, err := executeWebhook(webhookUrl, nil, message)
+	return err
+}
+
+// executeWebhook posts a message, params can carry wait and thread_id. The
+// created message is only returned when wait=true. Content over discord's
+// limit is split across several messages, see notify.SplitMessage.
+func executeWebhook(webhookUrl string, params url.Values, message notify.Message) (*discordMessage, error) {
+	var first *discordMessage
+	for i, part := range notify.SplitMessage(message) {
+		created, err := executeOne(webhookUrl, params, part)
+		if err != nil {
+			return first, err
+		}
+		if i > 0 {
+			continue
+		}
+		first = created
+		if part.ThreadName != "" && created != nil && created.ChannelID != "" {
+			// the remaining parts go into the forum post the first one created
+			params = url.Values{"thread_id": {created.ChannelID}}
+		}
+	}
+	return first, nil
+}
+
+func executeOne(webhookUrl string, params url.Values, message notify.Message) (*discordMessage, error) {
+	if dryRun {
+		preview(webhookUrl, params, message)
+		return nil, nil
+	}
+	if reason := webhooks.failure(webhookUrl); reason != "" {
+		return nil, errWebhookPaused
+	}
+	if !breakers.allow(webhookUrl) {
+		return nil, errCircuitOpen
+	}
+
+	body, contentType, err := notify.Encode(message)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(message.Components) > 0 && params.Get("with_components") == "" {
+		// webhooks not owned by an application drop components otherwise
+		with := url.Values{"with_components": {"true"}}
+		for key, values := range params {
+			with[key] = values
+		}
+		params = with
+	}
+	target := webhookUrl
+	if len(params) > 0 {
+		target += "?" + params.Encode()
+	}
+	resp, err := webhookClient.Post(target, contentType, body)
+	if err != nil {
+		breakers.observe(webhookUrl, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	rateLimits.observe(webhookUrl, resp)
+
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		err := newStatusError("discord", resp)
+		if isAuthFailure(err) {
+			webhooks.fail(webhookUrl, err)
+		}
+		breakers.observe(webhookUrl, err)
+		return nil, err
+	}
+	breakers.observe(webhookUrl, nil)
+
+	if resp.StatusCode == 204 {
+		return nil, nil
+	}
+	var created discordMessage
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// editMessage replaces a message the webhook posted before
+func editMessage(webhookUrl string, id string, message notify.Message) error {
+	if dryRun {
+		preview(webhookUrl, url.Values{"edit": {id}}, message)
+		return nil
+	}
+	if reason := webhooks.failure(webhookUrl); reason != "" {
+		return errWebhookPaused
+	}
+
+	body, contentType, err := notify.Encode(message)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPatch, webhookUrl+"/messages/"+id, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	rateLimits.observe(webhookUrl, resp)
+
+	if resp.StatusCode != 200 {
+		err := newStatusError("discord", resp)
+		if isAuthFailure(err) {
+			webhooks.fail(webhookUrl, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// validateWebhook fetches the webhook object, which works without posting
+// anything to the channel
+func validateWebhook(webhookUrl string) error {
+	resp, err := webhookClient.Get(webhookUrl)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return newStatusError("discord", resp)
+	}
+	return nil
+}
+
+type webhookState struct {
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// webhookHealth remembers which webhooks failed so the routes using them are
+// paused instead of hammering discord with requests that can't succeed
+type webhookHealth struct {
+	mu     sync.Mutex
+	failed map[string]webhookState
+}
+
+var webhooks = &webhookHealth{failed: map[string]webhookState{}}
+
+func (h *webhookHealth) failure(webhookUrl string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.failed[webhookUrl].Reason
+}
+
+func (h *webhookHealth) fail(webhookUrl string, err error) {
+	h.mu.Lock()
+	_, already := h.failed[webhookUrl]
+	h.failed[webhookUrl] = webhookState{Reason: err.Error(), Since: time.Now()}
+	h.mu.Unlock()
+
+	if !already {
+		go alertWebhookFailure(webhookUrl, err)
+	}
+}
+
+func (h *webhookHealth) restore(webhookUrl string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.failed, webhookUrl)
+}
+
+// alertWebhookFailure tells every remaining healthy webhook and sink that a
+// route stopped working
+func alertWebhookFailure(failedUrl string, err error) {
+	config := currentConfig()
+
+	names := routeNames(config, failedUrl)
+	message := fmt.Sprintf("⚠️ Webhook for route %s failed and is paused: %s\nFix the config or re-validate it through the control API.",
+		strings.Join(names, ", "), redactSecrets(err.Error()))
+	slog.Error(message)
+
+	notified := map[string]bool{failedUrl: true}
+	for _, url := range webhookUrls(config) {
+		if notified[url] || webhooks.failure(url) != "" {
+			continue
+		}
+		notified[url] = true
+		if err := postWebhook(url, notify.Message{Content: message}); err != nil {
+			slog.Error("Error alerting about failed webhook", "err", err)
+		}
+	}
+	alertSinks(message)
+}
+
+// webhookUrls lists every distinct webhook in the config
+func webhookUrls(config Config) []string {
+	var urls []string
+	seen := map[string]bool{}
+	add := func(url string) {
+		if url != "" && !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	add(config.WebhookURL)
+	for _, url := range config.WebhookPool {
+		add(url)
+	}
+	for _, route := range config.Routes {
+		add(route.WebhookURL)
+		for _, url := range route.WebhookPool {
+			add(url)
+		}
+	}
+	for _, section := range sectionWebhooks(config) {
+		add(section.url)
+	}
+	return urls
+}
+
+type sectionWebhook struct {
+	name, url string
+}
+
+// sectionWebhooks are the webhooks of the features posting outside the
+// routes, quiet hours post to the routes they held back
+func sectionWebhooks(config Config) []sectionWebhook {
+	var sections []sectionWebhook
+	if config.Incidents != nil {
+		sections = append(sections, sectionWebhook{"incidents", config.Incidents.WebhookURL})
+	}
+	if config.Summary != nil {
+		sections = append(sections, sectionWebhook{"summary", config.Summary.WebhookURL})
+	}
+	if config.Digest != nil {
+		sections = append(sections, sectionWebhook{"digest", config.Digest.WebhookURL})
+	}
+	if config.Errors != nil {
+		sections = append(sections, sectionWebhook{"errors", config.Errors.WebhookURL})
+	}
+	if config.Ops != nil {
+		sections = append(sections, sectionWebhook{"ops", config.Ops.WebhookURL})
+	}
+	return sections
+}
+
+func routeNames(config Config, webhookUrl string) []string {
+	var names []string
+	if len(config.Routes) == 0 && (config.WebhookURL == webhookUrl || contains(config.WebhookPool, webhookUrl)) {
+		return []string{"default"}
+	}
+	for i, route := range config.Routes {
+		url, pool := route.WebhookURL, route.WebhookPool
+		if url == "" {
+			url, pool = config.WebhookURL, config.WebhookPool
+		}
+		if url != webhookUrl && !contains(pool, webhookUrl) {
+			continue
+		}
+		name := route.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		names = append(names, name)
+	}
+	for _, section := range sectionWebhooks(config) {
+		if section.url == webhookUrl {
+			names = append(names, section.name)
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, "default")
+	}
+	return names
+}
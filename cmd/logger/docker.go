@@ -0,0 +1,55 @@
+package main
+
+import "simo.ng/logger/pkg/ingest"
+
+// Pipeline is one caddy container to follow, on this or a remote docker
+// host. Lines of every pipeline go through the same routes.
+type Pipeline struct {
+	Name          string              `json:"name"`
+	Docker        ingest.DockerConfig `json:"docker"`
+	ContainerName string              `json:"containerName"`
+	// Service follows every replica of a swarm service instead of a
+	// single container, Docker has to point at a manager
+	Service string `json:"service"`
+	// LogDir only works for containers on this host, remote ones are
+	// always tailed through exec
+	LogDir    string   `json:"logDir"`
+	Source    string   `json:"source"`
+	ExecFiles []string `json:"execFiles"`
+	// Outputs limits where the events go, each output with its own
+	// filters. Without them every output gets everything.
+	Outputs []PipelineOutput `json:"outputs"`
+}
+
+// pipelines returns the configured pipelines, or the single one described
+// by the top level fields
+func pipelines(config Config) []Pipeline {
+	if len(config.Pipelines) > 0 {
+		return config.Pipelines
+	}
+	return []Pipeline{{
+		Name:          "default",
+		Docker:        config.Docker,
+		ContainerName: config.ContainerName,
+		LogDir:        config.LogDir,
+		Source:        config.Source,
+		ExecFiles:     config.ExecFiles,
+	}}
+}
+
+// container is a running caddy container of a pipeline
+type container struct {
+	docker   ingest.DockerConfig
+	name     string
+	id       string
+	pipeline string
+}
+
+// sourceKey identifies the container in checkpoints, remote ones include
+// the host since names only need to be unique per host
+func (c container) sourceKey() string {
+	if c.docker.Remote() {
+		return c.docker.Host + "/" + c.name
+	}
+	return c.name
+}
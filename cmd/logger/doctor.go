@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+type diagnosis struct {
+	name string
+	// skipped checks neither pass nor fail
+	skipped bool
+	err     error
+	hint    string
+}
+
+// doctor checks the environment the logger runs in. Most problems people run
+// into are a missing mount or permission rather than a bug.
+func doctor(config Config) error {
+	checks := []func(Config) diagnosis{
+		checkDocker,
+		checkLogDir,
+		checkEvents,
+		checkWebhooks,
+		checkGeoIP,
+		checkClock,
+	}
+
+	failed := 0
+	for _, check := range checks {
+		d := check(config)
+		switch {
+		case d.skipped:
+			fmt.Printf("SKIP  %s: %s\n", d.name, d.hint)
+		case d.err != nil:
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", d.name, d.err)
+			if d.hint != "" {
+				fmt.Printf("      → %s\n", d.hint)
+			}
+		default:
+			fmt.Printf("PASS  %s\n", d.name)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+func checkDocker(config Config) diagnosis {
+	d := diagnosis{name: "docker"}
+	for _, p := range pipelines(config) {
+		if d = checkPipeline(p); d.err != nil {
+			return d
+		}
+	}
+	return d
+}
+
+func checkPipeline(p Pipeline) diagnosis {
+	d := diagnosis{name: "docker"}
+	runtime, err := openSource(p.Docker)
+	if err == nil {
+		defer runtime.Close()
+		err = runtime.Ping()
+	}
+	if err != nil {
+		d.err = fmt.Errorf("pipeline %s: %w", p.Name, err)
+		d.hint = "mount /var/run/docker.sock or set DOCKER_HOST, for ssh:// hosts check that `ssh <host> docker version` works"
+		return d
+	}
+	if p.Service != "" {
+		return d
+	}
+	if _, err := runtime.FindContainer(p.ContainerName); err != nil {
+		d.err = fmt.Errorf("pipeline %s: %w", p.Name, err)
+		d.hint = "containerName has to match the name in `docker ps` exactly"
+	}
+	return d
+}
+
+func checkLogDir(config Config) diagnosis {
+	d := diagnosis{name: "logDir " + config.LogDir}
+	if config.LogDir == "" {
+		d.skipped = true
+		d.hint = "not set, the log files come from the caddy admin API"
+		return d
+	}
+	info, err := os.Stat(config.LogDir)
+	if err != nil {
+		d.err = err
+		d.hint = "mount caddy's log directory into this container at logDir"
+		return d
+	}
+	dir := config.LogDir
+	if !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	if _, err := os.ReadDir(dir); err != nil {
+		d.err = err
+		d.hint = "the logger has to be able to list the directory, check its owner and mode"
+	}
+	return d
+}
+
+// checkEvents touches a file to see whether change events actually arrive,
+// they often don't on network filesystems and some docker desktop mounts
+func checkEvents(config Config) diagnosis {
+	d := diagnosis{name: "file events"}
+	if config.LogDir == "" {
+		d.skipped = true
+		d.hint = "no logDir to test"
+		return d
+	}
+	dir := config.LogDir
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		d.err = err
+		d.hint = "raise fs.inotify.max_user_instances"
+		return d
+	}
+	defer watcher.Close()
+	if err := watcher.Add(dir); err != nil {
+		d.err = err
+		d.hint = "raise fs.inotify.max_user_watches"
+		return d
+	}
+
+	probe := filepath.Join(dir, fmt.Sprintf(".doctor-%d", os.Getpid()))
+	if err := os.WriteFile(probe, []byte("doctor\n"), 0o644); err != nil {
+		d.skipped = true
+		d.hint = "logDir is read-only, can't touch a file to test with"
+		return d
+	}
+	defer os.Remove(probe)
+
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case event := <-watcher.Events:
+			if event.Name == probe {
+				return d
+			}
+		case err := <-watcher.Errors:
+			d.err = err
+			return d
+		case <-timeout:
+			d.err = errors.New("no event within 3s of writing a file")
+			d.hint = "the mount doesn't deliver inotify events, use a bind mount on the same host"
+			return d
+		}
+	}
+}
+
+func checkWebhooks(config Config) diagnosis {
+	d := diagnosis{name: "webhooks"}
+	urls := webhookUrls(config)
+	if len(urls) == 0 {
+		d.err = errors.New("no webhook configured")
+		d.hint = "set webhookUrl or give every route one"
+		return d
+	}
+	var broken []string
+	for _, url := range urls {
+		if err := validateWebhook(url); err != nil {
+			broken = append(broken, fmt.Sprintf("%s (%s): %s", webhookID(url), routeNames(config, url)[0], err))
+		}
+	}
+	if len(broken) > 0 {
+		d.err = fmt.Errorf("%d of %d failed: %v", len(broken), len(urls), broken)
+		d.hint = "a 401, 403 or 404 means the webhook was deleted, create a new one in the channel settings"
+	}
+	return d
+}
+
+func checkGeoIP(config Config) diagnosis {
+	d := diagnosis{name: "geoip"}
+	if config.ASN == nil || config.ASN.Database == "" {
+		d.skipped = true
+		d.hint = "countries come from cloudflare's CF-IPCountry header, set asn.database for traffic that doesn't pass cloudflare"
+		return d
+	}
+	ranges, err := loadASNs(config.ASN.Database)
+	switch {
+	case err != nil:
+		d.err = err
+		d.hint = "asn.database must be a readable ip2asn tsv, plain or gzipped"
+	case len(ranges) == 0:
+		d.err = errors.New("no ranges in " + config.ASN.Database)
+		d.hint = "the file isn't in the ip2asn format of \"start end number country organisation\" lines"
+	}
+	return d
+}
+
+// checkClock compares the local clock with discord's, timestamps and
+// quiet hours are off when it drifts
+func checkClock(config Config) diagnosis {
+	d := diagnosis{name: "clock"}
+	resp, err := webhookClient.Head("https://discord.com/api/v10/gateway")
+	if err != nil {
+		d.err = err
+		d.hint = "discord is unreachable, check DNS and outbound HTTPS"
+		return d
+	}
+	resp.Body.Close()
+
+	remote, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		d.skipped = true
+		d.hint = "discord sent no usable Date header"
+		return d
+	}
+	if drift := time.Since(remote); drift > 30*time.Second || drift < -30*time.Second {
+		d.err = fmt.Errorf("local clock is %s off", drift.Round(time.Second))
+		d.hint = "run an NTP client on the host"
+	}
+	return d
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+// schemaDrift notices fields in the log lines the parser doesn't know about,
+// a sign that a caddy upgrade or encoder change altered the format
+type schemaDrift struct {
+	mu       sync.Mutex
+	known    map[string]bool
+	freeForm map[string]bool
+	reported map[string]bool
+	loaded   bool
+}
+
+var drift = newSchemaDrift(reflect.TypeOf(parse.Data{}))
+
+func newSchemaDrift(t reflect.Type) *schemaDrift {
+	d := &schemaDrift{known: map[string]bool{}, freeForm: map[string]bool{}, reported: map[string]bool{}}
+	d.learn("", t)
+	return d
+}
+
+// learn walks the json tags of the parser's structs. Header maps hold
+// whatever the client or upstream sent, their keys are not part of the schema.
+func (d *schemaDrift) learn(prefix string, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		path := prefix + name
+		d.known[path] = true
+
+		switch {
+		case field.Type == reflect.TypeOf(parse.Headers{}) || field.Type == reflect.TypeOf(parse.RespHeaders{}):
+			d.freeForm[path] = true
+		case field.Type.Kind() == reflect.Struct:
+			d.learn(path+".", field.Type)
+		}
+	}
+}
+
+func (d *schemaDrift) unknown(prefix string, raw json.RawMessage, found *[]string) {
+	var object map[string]json.RawMessage
+	if json.Unmarshal(raw, &object) != nil {
+		return
+	}
+	for key, value := range object {
+		path := prefix + key
+		if !d.known[path] {
+			*found = append(*found, path)
+			continue
+		}
+		if !d.freeForm[path] {
+			d.unknown(path+".", value, found)
+		}
+	}
+}
+
+func (d *schemaDrift) load(config Config) {
+	if d.loaded {
+		return
+	}
+	d.loaded = true
+	var reported []string
+	if err := readStateFile(config, "reported-fields.json", &reported); err != nil {
+		slog.Error("Error reading reported fields", "err", err)
+	}
+	for _, field := range reported {
+		d.reported[field] = true
+	}
+}
+
+// check returns the unknown fields of line that were never reported before
+// and remembers them, so every field is only mentioned once
+func (d *schemaDrift) check(config Config, line string) []string {
+	var found []string
+	d.unknown("", json.RawMessage(line), &found)
+	if len(found) == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.load(config)
+
+	var fresh []string
+	for _, field := range found {
+		if !d.reported[field] {
+			d.reported[field] = true
+			fresh = append(fresh, field)
+		}
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+	sort.Strings(fresh)
+
+	all := make([]string, 0, len(d.reported))
+	for field := range d.reported {
+		all = append(all, field)
+	}
+	sort.Strings(all)
+	if err := writeStateFile(config, "reported-fields.json", all); err != nil {
+		slog.Error("Error saving reported fields", "err", err)
+	}
+	return fresh
+}
+
+func reportDrift(config Config, line string) {
+	if config.IgnoreSchemaDrift {
+		return
+	}
+	fresh := drift.check(config, line)
+	if len(fresh) == 0 {
+		return
+	}
+
+	message := "🧬 The access log contains fields the logger doesn't use yet: `" + strings.Join(fresh, "`, `") +
+		"`\nIf this follows a Caddy upgrade or log encoder change, check that messages still show what you expect."
+	slog.Info(message)
+	if err := sendMessageToDiscord(message, config.WebhookURL); err != nil {
+		slog.Error("Error posting schema notice", "err", err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+// dryRun runs the whole pipeline but prints what would be posted instead of
+// posting it. Outputs, ban reactions and state files are left alone too, a
+// dry run can be pointed at the production config.
+var dryRun bool
+
+// dryRunFlag removes --dry-run from args and reports whether it was there
+func dryRunFlag(args []string) ([]string, bool) {
+	var rest []string
+	found := false
+	for _, arg := range args {
+		if arg == "--dry-run" || arg == "-dry-run" {
+			found = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, found
+}
+
+// previewMu keeps messages posted from several senders apart
+var previewMu sync.Mutex
+
+// preview prints a message the way it would reach discord, one part at a
+// time so the split of long messages shows as well
+func preview(webhookUrl string, params url.Values, message notify.Message) {
+	var b strings.Builder
+	target := strings.Join(routeNames(currentConfig(), webhookUrl), ", ")
+	if target == "" {
+		target = "webhook " + webhookID(webhookUrl)
+	}
+	fmt.Fprintf(&b, "──── %s", target)
+	if message.ThreadName != "" {
+		fmt.Fprintf(&b, ", new post %q", message.ThreadName)
+	} else if thread := params.Get("thread_id"); thread != "" {
+		fmt.Fprintf(&b, ", thread %s", thread)
+	} else if id := params.Get("edit"); id != "" {
+		fmt.Fprintf(&b, ", editing message %s", id)
+	}
+	b.WriteString("\n")
+
+	if message.Content != "" {
+		b.WriteString(message.Content + "\n")
+	}
+	for _, e := range message.Embeds {
+		fmt.Fprintf(&b, "[embed] %s\n", e.Title)
+		if e.Description != "" {
+			b.WriteString(e.Description + "\n")
+		}
+		for _, field := range e.Fields {
+			fmt.Fprintf(&b, "  %s: %s\n", field.Name, field.Value)
+		}
+		if e.Footer != nil && e.Footer.Text != "" {
+			fmt.Fprintf(&b, "  %s\n", e.Footer.Text)
+		}
+	}
+	for _, row := range message.Components {
+		for _, button := range row.Components {
+			fmt.Fprintf(&b, "[button] %s <%s>\n", button.Label, button.URL)
+		}
+	}
+	for _, file := range message.Files {
+		fmt.Fprintf(&b, "[file] %s, %d bytes\n", file.Name, len(file.Data))
+	}
+
+	previewMu.Lock()
+	defer previewMu.Unlock()
+	fmt.Fprintln(os.Stdout, redactSecrets(b.String()))
+}
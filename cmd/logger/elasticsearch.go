@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+type ElasticsearchConfig struct {
+	// URL is an elasticsearch or opensearch node, e.g. http://es:9200
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// APIKey is the base64 id:key of an elasticsearch api key
+	APIKey string `json:"apiKey"`
+	// Index is the prefix of the daily indices, <index>-2006.01.02. Defaults
+	// to caddy-requests.
+	Index string      `json:"index"`
+	Batch BatchConfig `json:"batch"`
+}
+
+// the mapping of event, installed as an index template so every daily index
+// gets it. Hashed addresses aren't valid ips and are kept out of the ip
+// field rather than failing the document.
+var elasticsearchMapping = map[string]interface{}{
+	"properties": map[string]interface{}{
+		"time":      map[string]string{"type": "date"},
+		"host":      map[string]string{"type": "keyword"},
+		"method":    map[string]string{"type": "keyword"},
+		"uri":       map[string]interface{}{"type": "keyword", "ignore_above": 2048, "fields": map[string]interface{}{"text": map[string]string{"type": "text"}}},
+		"status":    map[string]string{"type": "short"},
+		"ip":        map[string]interface{}{"type": "ip", "ignore_malformed": true},
+		"country":   map[string]string{"type": "keyword"},
+		"userAgent": map[string]interface{}{"type": "keyword", "ignore_above": 1024, "fields": map[string]interface{}{"text": map[string]string{"type": "text"}}},
+		"severity":  map[string]string{"type": "keyword"},
+		"duration":  map[string]string{"type": "float"},
+		"size":      map[string]string{"type": "long"},
+		"proto":     map[string]string{"type": "keyword"},
+		"source":    map[string]string{"type": "keyword"},
+	},
+}
+
+type elasticsearchSink struct {
+	*batch
+	config ElasticsearchConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	templated bool
+}
+
+func newElasticsearchSink(config ElasticsearchConfig) *elasticsearchSink {
+	if config.Index == "" {
+		config.Index = "caddy-requests"
+	}
+	s := &elasticsearchSink{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	s.batch = newBatch("elasticsearch", config.Batch, s.bulk)
+	return s
+}
+
+func (s *elasticsearchSink) Name() string {
+	return "elasticsearch"
+}
+
+func (s *elasticsearchSink) Send(data parse.Data, raw string) error {
+	if parse.IsAccessLog(data) {
+		s.add(newEvent(currentConfig(), data))
+	}
+	return nil
+}
+
+func (s *elasticsearchSink) do(method, path, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(s.config.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.config.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+s.config.APIKey)
+	} else if s.config.Username != "" {
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+	return s.client.Do(req)
+}
+
+// template installs the mapping for the daily indices once, before the
+// first of them is created by a write
+func (s *elasticsearchSink) template() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.templated {
+		return nil
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"index_patterns": []string{s.config.Index + "-*"},
+		"template":       map[string]interface{}{"mappings": elasticsearchMapping},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodPut, "/_index_template/"+s.config.Index, "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return newStatusError("elasticsearch", resp)
+	}
+	s.templated = true
+	return nil
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+func (s *elasticsearchSink) bulk(events []event) error {
+	if err := s.template(); err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, e := range events {
+		enc.Encode(map[string]interface{}{"index": map[string]string{"_index": s.config.Index + "-" + e.Time.Format("2006.01.02")}})
+		enc.Encode(e)
+	}
+
+	resp, err := s.do(http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return newStatusError("elasticsearch", resp)
+	}
+
+	// rejected documents would be rejected again, they're reported instead
+	// of retrying the whole batch
+	var result bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || !result.Errors {
+		return nil
+	}
+	failed := 0
+	var first string
+	for _, item := range result.Items {
+		for _, r := range item {
+			if r.Status >= 300 {
+				failed++
+				if first == "" {
+					first = string(r.Error)
+				}
+			}
+		}
+	}
+	slog.Warn("Elasticsearch rejected documents", "failed", failed, "of", len(events), "err", first)
+	return nil
+}
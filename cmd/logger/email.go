@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+// emailTimeout is how long sending one mail may take, from connecting to
+// the final QUIT
+const emailTimeout = time.Minute
+
+const (
+	emailStartTLS = "starttls"
+	emailTLS      = "tls"
+	emailPlain    = "none"
+)
+
+type EmailConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// TLS is "starttls" (default, port 587), "tls" for implicit tls (port
+	// 465) or "none"
+	TLS  string   `json:"tls"`
+	From string   `json:"from"`
+	To   []string `json:"to"`
+	// Digest mails the daily security digest as well
+	Digest bool `json:"digest"`
+	// Template is an html/template file defining "alert" and/or "digest" to
+	// replace the built in bodies
+	Template string `json:"template"`
+	// only escalations are mailed, unless severities lists more
+	NotifierFilter
+}
+
+const defaultEmailTemplates = `
+{{define "alert"}}<html><body style="font-family: sans-serif">
+{{if .Escalated}}<p style="color: #c0392b"><b>🚨 Escalated:</b> {{.Reason}}</p>{{end}}
+<h3>{{.Title}}</h3>
+<pre style="background: #f4f4f4; padding: 8px">{{range .Lines}}{{.}}
+{{end}}</pre>
+{{range .Notes}}<p>{{.}}</p>{{end}}
+</body></html>{{end}}
+{{define "digest"}}<html><body style="font-family: sans-serif">
+<h2>{{.Title}}</h2>
+<p>{{.Description}}</p>
+{{range .Fields}}<h3>{{.Name}}</h3>
+<p>{{code .Value}}</p>{{end}}
+</body></html>{{end}}
+`
+
+var codeSpan = regexp.MustCompile("`([^`]*)`")
+
+var emailFuncs = template.FuncMap{
+	// code turns the markdown of embed fields into html
+	"code": func(s string) template.HTML {
+		s = template.HTMLEscapeString(s)
+		s = codeSpan.ReplaceAllString(s, "<code>$1</code>")
+		return template.HTML(strings.ReplaceAll(s, "\n", "<br>"))
+	},
+}
+
+func parseEmailTemplates(path string) (*template.Template, error) {
+	tmpl := template.Must(template.New("email").Funcs(emailFuncs).Parse(defaultEmailTemplates))
+	if path == "" {
+		return tmpl, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return tmpl, err
+	}
+	// definitions in the file override the defaults
+	return tmpl.Parse(string(raw))
+}
+
+type emailNotifier struct {
+	config    EmailConfig
+	templates *template.Template
+}
+
+func newEmailNotifier(config EmailConfig) *emailNotifier {
+	templates, err := parseEmailTemplates(config.Template)
+	if err != nil {
+		slog.Error("Invalid email template, using the default", "err", err)
+		templates, _ = parseEmailTemplates("")
+	}
+	return &emailNotifier{config: config, templates: templates}
+}
+
+func (e *emailNotifier) Name() string {
+	return "email"
+}
+
+func (e *emailNotifier) accepts(alert Alert) bool {
+	if len(e.config.Severities) == 0 && !alert.Escalated {
+		return false
+	}
+	return e.config.accepts(alert)
+}
+
+func (e *emailNotifier) Notify(alert Alert) error {
+	subject := "[" + alert.Severity + "] " + alert.Title()
+	if alert.Escalated {
+		subject = "🚨 " + alert.Title() + ": " + alert.Reason
+	}
+	return e.render(subject, "alert", alert)
+}
+
+// Alert mails the logger's own warnings
+func (e *emailNotifier) Alert(message string) error {
+	body := "<html><body><p>⚠️ " + template.HTMLEscapeString(message) + "</p></body></html>"
+	return e.send("caddy-discord-logger warning", body)
+}
+
+func (e *emailNotifier) render(subject, name string, data interface{}) error {
+	var body bytes.Buffer
+	if err := e.templates.ExecuteTemplate(&body, name, data); err != nil {
+		return err
+	}
+	return e.send(subject, body.String())
+}
+
+func (e *emailNotifier) send(subject, body string) error {
+	var msg bytes.Buffer
+	headers := [][2]string{
+		{"From", e.config.From},
+		{"To", strings.Join(e.config.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", "<" + newEventID() + "@" + e.config.Host + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/html; charset=utf-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	if err := qp.Close(); err != nil {
+		return err
+	}
+
+	client, err := e.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if e.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.config.Username, e.config.Password, e.config.Host)); err != nil {
+			return err
+		}
+	}
+	// the envelope takes the bare address of "Name <address>"
+	from, err := mail.ParseAddress(e.config.From)
+	if err != nil {
+		return err
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range e.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (e *emailNotifier) dial() (*smtp.Client, error) {
+	port := e.config.Port
+	if port == 0 {
+		port = 587
+		if e.config.TLS == emailTLS {
+			port = 465
+		}
+	}
+	addr := net.JoinHostPort(e.config.Host, fmt.Sprint(port))
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	tlsConfig := &tls.Config{ServerName: e.config.Host}
+	// the deadline covers the whole conversation, a server stalling in the
+	// middle of it would hold up the sender for good otherwise
+	deadline := time.Now().Add(emailTimeout)
+
+	if e.config.TLS == emailTLS {
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		conn.SetDeadline(deadline)
+		return smtp.NewClient(conn, e.config.Host)
+	}
+
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(deadline)
+	client, err := smtp.NewClient(conn, e.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if e.config.TLS != emailPlain {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// emailDigest mails the security digest when the email output asks for it
+func emailDigest(report notify.Embed) {
+	if dryRun {
+		return
+	}
+	configMu.RLock()
+	current := notifiers
+	configMu.RUnlock()
+
+	for _, notifier := range current {
+		e, ok := notifier.(*emailNotifier)
+		if !ok || !e.config.Digest {
+			continue
+		}
+		err := deliver(e.Name(), func() error {
+			return e.render(report.Title, "digest", report)
+		})
+		if err != nil {
+			slog.Error("Error mailing security digest", "err", err)
+		}
+	}
+}
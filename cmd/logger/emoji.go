@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+// EmojiPack maps request attributes to emoji. Values can be plain unicode,
+// full discord syntax ("<:caddy:1234>") or the short "name:id" form for
+// custom server emoji.
+type EmojiPack struct {
+	Methods   map[string]string `json:"methods"`
+	Statuses  map[string]string `json:"statuses"`
+	Countries map[string]string `json:"countries"`
+	// Colors are the embed colors of embed routes, keyed like Statuses and
+	// given as hex ("#e67e22")
+	Colors map[string]string `json:"colors"`
+}
+
+var defaultEmoji = EmojiPack{
+	Methods: map[string]string{
+		"GET":     "📄",
+		"HEAD":    "👀",
+		"POST":    "📝",
+		"PUT":     "📦",
+		"PATCH":   "🩹",
+		"DELETE":  "🗑️",
+		"OPTIONS": "⚙️",
+	},
+	Statuses: map[string]string{
+		"1xx": "⚪",
+		"2xx": "🟢",
+		"3xx": "🔵",
+		"401": "🚨",
+		"4xx": "🟠",
+		"5xx": "🔴",
+	},
+	Colors: map[string]string{
+		"1xx": "#95a5a6",
+		"2xx": "#2ecc71",
+		"3xx": "#3498db",
+		"4xx": "#e67e22",
+		"5xx": "#e74c3c",
+	},
+}
+
+var customEmojiPattern = regexp.MustCompile(`^(a:)?[A-Za-z0-9_]+:[0-9]+$`)
+
+func formatEmoji(value string) string {
+	if customEmojiPattern.MatchString(value) {
+		if strings.HasPrefix(value, "a:") {
+			return "<" + value + ">"
+		}
+		return "<:" + value + ">"
+	}
+	return value
+}
+
+func (p EmojiPack) method(method string) string {
+	method = strings.ToUpper(method)
+	if e, ok := p.Methods[method]; ok {
+		return formatEmoji(e)
+	}
+	return defaultEmoji.Methods[method]
+}
+
+// status looks up the exact code first ("404") and then its class ("4xx")
+func (p EmojiPack) status(status int) string {
+	code := fmt.Sprint(status)
+	class := fmt.Sprintf("%dxx", status/100)
+	for _, key := range []string{code, class} {
+		if e, ok := p.Statuses[key]; ok {
+			return formatEmoji(e)
+		}
+	}
+	for _, key := range []string{code, class} {
+		if e, ok := defaultEmoji.Statuses[key]; ok {
+			return e
+		}
+	}
+	return ""
+}
+
+// color looks up the embed color like status does. Colors that don't parse
+// are reported by validate and fall back to the default.
+func (p EmojiPack) color(status int) int {
+	code := fmt.Sprint(status)
+	class := fmt.Sprintf("%dxx", status/100)
+	for _, colors := range []map[string]string{p.Colors, defaultEmoji.Colors} {
+		for _, key := range []string{code, class} {
+			if c, ok := parseColor(colors[key]); ok {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+func parseColor(value string) (int, bool) {
+	c, err := strconv.ParseUint(strings.TrimPrefix(value, "#"), 16, 32)
+	if err != nil || c > 0xFFFFFF {
+		return 0, false
+	}
+	return int(c), true
+}
+
+func (p EmojiPack) country(code string) string {
+	code = strings.ToUpper(code)
+	if e, ok := p.Countries[code]; ok {
+		return formatEmoji(e)
+	}
+	return flagEmoji(code)
+}
+
+// flagEmoji turns an ISO 3166 alpha-2 code into its regional indicator pair
+func flagEmoji(code string) string {
+	if len(code) != 2 || code == "XX" || code == "T1" {
+		return ""
+	}
+	var flag strings.Builder
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return ""
+		}
+		flag.WriteRune(0x1F1E6 + c - 'A')
+	}
+	return flag.String()
+}
+
+// header is the emoji line shown above the code block of a message, the
+// country as a flag followed by its code
+func (p EmojiPack) header(data parse.Data, country string) string {
+	var parts []string
+	for _, e := range []string{
+		p.method(data.Request.Method),
+		p.status(data.Status),
+	} {
+		if e != "" {
+			parts = append(parts, e)
+		}
+	}
+	if country != "" {
+		if e := p.country(country); e != "" {
+			parts = append(parts, e+" "+country)
+		} else {
+			parts = append(parts, country)
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+// ErrorLogConfig posts caddy's own error logs, everything that isn't an
+// access log line
+type ErrorLogConfig struct {
+	// defaults to webhookUrl
+	WebhookURL string `json:"webhookUrl"`
+	// levels posted, defaults to error, panic and fatal
+	Levels []string `json:"levels"`
+}
+
+// errorLine is the part of a caddy error log we show, the fields besides
+// level, logger and msg depend on the module that logged it
+type errorLine struct {
+	Level      string          `json:"level"`
+	Ts         parse.Timestamp `json:"ts"`
+	Logger     string          `json:"logger"`
+	Msg        string          `json:"msg"`
+	Error      string          `json:"error"`
+	Stacktrace string          `json:"stacktrace"`
+	Request    *parse.Request  `json:"request"`
+}
+
+func handleErrorLine(config Config, line string) {
+	if config.Errors == nil {
+		return
+	}
+	var entry errorLine
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		return
+	}
+	levels := config.Errors.Levels
+	if len(levels) == 0 {
+		levels = []string{"error", "panic", "fatal"}
+	}
+	if !contains(levels, strings.ToLower(entry.Level)) {
+		return
+	}
+
+	webhook := config.Errors.WebhookURL
+	if webhook == "" {
+		webhook = config.WebhookURL
+	}
+	message := notify.Message{Embeds: []notify.Embed{errorEmbed(entry)}}
+	senders.submit(config, "error logs", webhook, func() {
+		if err := sendMessage(webhook, message); err != nil {
+			slog.Error("Error posting caddy error log", "err", err)
+		}
+	})
+}
+
+func errorEmbed(entry errorLine) notify.Embed {
+	title := "Caddy " + entry.Level
+	if entry.Logger != "" {
+		title += " in " + entry.Logger
+	}
+	e := notify.Embed{
+		Title:       title,
+		Description: notify.EscapeMarkdown(entry.Msg),
+		Color:       0xE74C3C,
+		Timestamp:   entry.Ts.Time().UTC().Format(time.RFC3339),
+	}
+	if entry.Error != "" {
+		e.Fields = append(e.Fields, notify.EmbedField{Name: "Error", Value: notify.Truncate(notify.EscapeMarkdown(entry.Error), 1024)})
+	}
+	if entry.Request != nil && entry.Request.Host != "" {
+		e.Fields = append(e.Fields, notify.EmbedField{Name: "Request", Value: notify.Truncate(notify.EscapeMarkdown(fmt.Sprintf("%s %s%s from %s",
+			entry.Request.Method, entry.Request.Host, entry.Request.URI, entry.Request.RemoteIP)), 1024)})
+	}
+	if entry.Stacktrace != "" {
+		// keep the top of the trace, that's where the panic happened
+		e.Fields = append(e.Fields, notify.EmbedField{Name: "Stack trace", Value: "```\n" + notify.Truncate(notify.CodeSafe(entry.Stacktrace), 1000) + "```"})
+	}
+	return e
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+
+	"simo.ng/logger/pkg/notify"
+
+	"simo.ng/logger/pkg/filter"
+)
+
+// EscalationConfig turns selected events into messages that mention a role
+// or user, so they trigger a phone notification
+type EscalationConfig struct {
+	Roles []string `json:"roles"`
+	Users []string `json:"users"`
+	// Paths are matched against the request path, either as a glob
+	// ("/wp-*.php") or as a plain prefix ("/.env")
+	Paths    []string `json:"paths"`
+	Statuses []int    `json:"statuses"`
+	// ServerErrors escalates when a host returns Count 5xx within Window
+	ServerErrors *BurstConfig `json:"serverErrors"`
+	// Networks escalates requests from addresses on these ipLists, "tor"
+	// or "datacenter"
+	Networks []string `json:"networks"`
+	// Countries escalates requests from these countries, optionally only
+	// to some paths
+	Countries []CountryRule `json:"countries"`
+	// SlowerThan escalates responses that took longer, like "2s"
+	SlowerThan string `json:"slowerThan"`
+	// LargerThan escalates responses bigger than this, like "50MB"
+	LargerThan string `json:"largerThan"`
+	// Cooldown stops the same reason from pinging again, defaults to 5m
+	Cooldown string `json:"cooldown"`
+}
+
+type BurstConfig struct {
+	Count  int    `json:"count"`
+	Window string `json:"window"`
+}
+
+type escalationState struct {
+	mu     sync.Mutex
+	errors map[string][]time.Time
+	last   map[string]time.Time
+}
+
+func (b BurstConfig) window() time.Duration {
+	window, err := time.ParseDuration(b.Window)
+	if err != nil || window <= 0 {
+		return time.Minute
+	}
+	return window
+}
+
+func (cfg EscalationConfig) cooldown() time.Duration {
+	cooldown, err := time.ParseDuration(cfg.Cooldown)
+	if err != nil || cooldown < 0 {
+		return 5 * time.Minute
+	}
+	return cooldown
+}
+
+var escalations = &escalationState{errors: map[string][]time.Time{}, last: map[string]time.Time{}}
+
+// check returns why an event should be escalated, if at all, and the key of
+// the rule that hit, which pagers use to group repeated hits
+func (e *escalationState) check(cfg EscalationConfig, data parse.Data, country string) (string, string, bool) {
+	now := time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var reason, key string
+
+	if data.Status >= 500 && cfg.ServerErrors != nil && cfg.ServerErrors.Count > 0 {
+		window := cfg.ServerErrors.window()
+		host := data.Request.Host
+		recent := e.errors[host][:0]
+		for _, t := range e.errors[host] {
+			if now.Sub(t) < window {
+				recent = append(recent, t)
+			}
+		}
+		recent = append(recent, now)
+		e.errors[host] = recent
+		if len(recent) >= cfg.ServerErrors.Count {
+			reason = fmt.Sprintf("%d server errors on %s in the last %s", len(recent), host, window)
+			key = "5xx:" + host
+		}
+	}
+
+	if reason == "" {
+		for _, status := range cfg.Statuses {
+			if data.Status == status {
+				reason = fmt.Sprintf("status %d on %s", status, data.Request.Host)
+				key = fmt.Sprintf("status:%d:%s", status, data.Request.Host)
+				break
+			}
+		}
+	}
+
+	if reason == "" {
+		for _, pattern := range cfg.Paths {
+			if filter.MatchPath(pattern, data.Request.URI) {
+				reason = fmt.Sprintf("hit on %s from %s", pattern, clientIP(data))
+				key = "path:" + pattern + ":" + clientIP(data)
+				break
+			}
+		}
+	}
+
+	if reason == "" && len(cfg.Networks) > 0 {
+		for _, kind := range lists.kinds(clientIP(data)) {
+			if contains(cfg.Networks, kind) {
+				reason = fmt.Sprintf("%s address %s", kind, clientIP(data))
+				key = "network:" + kind + ":" + clientIP(data)
+				break
+			}
+		}
+	}
+
+	if reason == "" && cfg.SlowerThan != "" && tookLonger(data, parseDuration(cfg.SlowerThan, 0)) {
+		reason = fmt.Sprintf("%s%s took %s", data.Request.Host, data.Request.URI, formatDuration(data.Duration))
+		key = "slow:" + data.Request.Host
+	}
+
+	if reason == "" && cfg.LargerThan != "" {
+		if limit, ok := parseSize(cfg.LargerThan); ok && data.Size > limit {
+			reason = fmt.Sprintf("%s of %s%s to %s", formatSize(data.Size), data.Request.Host, data.Request.URI, clientIP(data))
+			key = "large:" + data.Request.Host + ":" + clientIP(data)
+		}
+	}
+
+	if reason == "" {
+		for _, rule := range cfg.Countries {
+			if why, ok := rule.matches(data, country); ok {
+				reason = why + " (" + clientIP(data) + ")"
+				key = "country:" + country + ":" + clientIP(data)
+				break
+			}
+		}
+	}
+
+	if reason == "" {
+		return "", "", false
+	}
+
+	if last, ok := e.last[key]; ok && now.Sub(last) < cfg.cooldown() {
+		return "", "", false
+	}
+	e.last[key] = now
+	return reason, key, true
+}
+
+// prune forgets the hosts and keys that are past every window and the
+// cooldown, both maps are keyed by what clients send
+func (e *escalationState) prune(config Config, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if config.Escalation == nil {
+		e.errors = map[string][]time.Time{}
+		e.last = map[string]time.Time{}
+		return
+	}
+	age := config.Escalation.cooldown()
+	if config.Escalation.ServerErrors != nil && config.Escalation.ServerErrors.window() > age {
+		age = config.Escalation.ServerErrors.window()
+	}
+	for _, class := range config.HostClasses {
+		if class.ServerErrors != nil && class.ServerErrors.window() > age {
+			age = class.ServerErrors.window()
+		}
+	}
+
+	for host, times := range e.errors {
+		if len(times) == 0 || now.Sub(times[len(times)-1]) >= age {
+			delete(e.errors, host)
+		}
+	}
+	for key, last := range e.last {
+		if now.Sub(last) >= age {
+			delete(e.last, key)
+		}
+	}
+}
+
+func (e *escalationState) run() {
+	for now := range time.Tick(time.Minute) {
+		e.prune(currentConfig(), now)
+	}
+}
+
+// escalate prefixes a message with the mentions and allows exactly those to
+// ping, nothing else in the message can
+func escalate(cfg EscalationConfig, mark, reason string, message notify.Message) notify.Message {
+	var mentions []string
+	for _, role := range cfg.Roles {
+		mentions = append(mentions, "<@&"+role+">")
+	}
+	for _, user := range cfg.Users {
+		mentions = append(mentions, "<@"+user+">")
+	}
+
+	message.Content = mark + " " + strings.Join(mentions, " ") + " " + reason + "\n" + message.Content
+	message.AllowedMentions = &notify.AllowedMentions{Parse: []string{}, Roles: cfg.Roles, Users: cfg.Users}
+	return message
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type errorClass string
+
+const (
+	classRateLimit errorClass = "rate_limit"
+	classAuth      errorClass = "auth"
+	classNetwork   errorClass = "network"
+	classPayload   errorClass = "payload"
+	classUnknown   errorClass = "unknown"
+)
+
+// statusError is a non 2xx answer from discord or one of the sinks
+type statusError struct {
+	Service    string
+	Status     int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s returned %d: %s", e.Service, e.Status, e.Body)
+}
+
+func newStatusError(service string, resp *http.Response) *statusError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err := &statusError{Service: service, Status: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+
+	if seconds, perr := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); perr == nil {
+		err.RetryAfter = time.Duration(seconds * float64(time.Second))
+	}
+	return err
+}
+
+func classifyError(err error) errorClass {
+	var serr *statusError
+	if errors.As(err, &serr) {
+		switch {
+		case serr.Status == http.StatusTooManyRequests:
+			return classRateLimit
+		case serr.Status == http.StatusUnauthorized || serr.Status == http.StatusForbidden || serr.Status == http.StatusNotFound:
+			return classAuth
+		case serr.Status >= 500:
+			// the service is there but struggling, retry it like a network blip
+			return classNetwork
+		case serr.Status >= 400:
+			return classPayload
+		}
+	}
+
+	if errors.Is(err, errWebhookPaused) {
+		return classAuth
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return classNetwork
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnsupportedTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return classPayload
+	}
+	return classUnknown
+}
+
+// auth failures are never retried, the webhook is paused instead
+var defaultRetryPolicies = map[errorClass]RetryPolicy{
+	classRateLimit: {Attempts: 5, Delay: "1s", MaxDelay: "1m"},
+	classNetwork:   {Attempts: 3, Delay: "1s", MaxDelay: "30s", Jitter: 0.2},
+	classPayload:   {Attempts: 0},
+	classUnknown:   {Attempts: 1, Delay: "2s", Jitter: 0.2},
+}
+
+func retryPolicy(config Config, class errorClass) RetryPolicy {
+	if config.Retry != nil {
+		var override *RetryPolicy
+		switch class {
+		case classRateLimit:
+			override = config.Retry.RateLimit
+		case classNetwork:
+			override = config.Retry.Network
+		case classPayload:
+			override = config.Retry.Payload
+		case classUnknown:
+			override = config.Retry.Unknown
+		}
+		if override != nil {
+			return *override
+		}
+	}
+	return defaultRetryPolicies[class]
+}
+
+// failureCounters counts errors per output and class, exposed on the control
+// api under /errors
+type failureCounters struct {
+	mu     sync.Mutex
+	counts map[string]map[errorClass]int
+}
+
+var failures = &failureCounters{counts: map[string]map[errorClass]int{}}
+
+func init() {
+	metrics.describe("delivery_errors_total", "counter", "Delivery errors per output and error class.")
+}
+
+func (f *failureCounters) add(output string, class errorClass) {
+	metrics.add("delivery_errors_total", 1, "output", output, "class", string(class))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.counts[output] == nil {
+		f.counts[output] = map[errorClass]int{}
+	}
+	f.counts[output][class]++
+}
+
+func (f *failureCounters) snapshot() map[string]map[errorClass]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	copied := map[string]map[errorClass]int{}
+	for output, classes := range f.counts {
+		copied[output] = map[errorClass]int{}
+		for class, n := range classes {
+			copied[output][class] = n
+		}
+	}
+	return copied
+}
+
+// deliver runs send and retries it according to the policy of whatever
+// class of error it returns
+func deliver(output string, send func() error) error {
+	err := retry(output, send, func(err error) (RetryPolicy, bool) {
+		class := classifyError(err)
+		failures.add(output, class)
+		// an open circuit is the answer to retrying, don't add to it
+		if class == classAuth || errors.Is(err, errCircuitOpen) {
+			return RetryPolicy{}, false
+		}
+		return retryPolicy(currentConfig(), class), true
+	})
+	// paused webhooks and open circuits already raised their own alert
+	if !errors.Is(err, errWebhookPaused) && !errors.Is(err, errCircuitOpen) {
+		health.observe(output, err)
+	}
+	return err
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"simo.ng/logger/pkg/notify"
+	"simo.ng/logger/pkg/parse"
+)
+
+// the fields a message can show, see Config.Fields
+const (
+	fieldDate     = "date"
+	fieldMethod   = "method"
+	fieldHost     = "host"
+	fieldURI      = "uri"
+	fieldIP       = "ip"
+	fieldUA       = "ua"
+	fieldStatus   = "status"
+	fieldDuration = "duration"
+	fieldSize     = "size"
+	fieldCountry  = "country"
+)
+
+var knownFields = []string{fieldDate, fieldMethod, fieldHost, fieldURI, fieldIP, fieldUA, fieldStatus, fieldDuration, fieldSize, fieldCountry}
+
+var defaultFields = []string{fieldDate, fieldHost, fieldURI, fieldIP, fieldUA, fieldStatus, fieldDuration, fieldSize}
+
+// messageLines returns the configured fields in their order. A leading date
+// is returned on its own to sit above the divider, noDate leaves it out. A
+// uri right after the host stays on its line, as do duration and size after
+// the status.
+func messageLines(config Config, data parse.Data, date, ua, country string, noDate bool) (string, []string) {
+	fields := config.Fields
+	if len(fields) == 0 {
+		fields = defaultFields
+	}
+
+	var top string
+	var lines []string
+	for i, field := range fields {
+		var value string
+		switch field {
+		case fieldDate:
+			if noDate {
+				continue
+			}
+			if i == 0 {
+				top = date
+				continue
+			}
+			value = date
+		case fieldMethod:
+			value = data.Request.Method
+		case fieldHost:
+			value = data.Request.Host
+		case fieldURI:
+			if i > 0 && fields[i-1] == fieldHost && len(lines) > 0 {
+				lines[len(lines)-1] += data.Request.URI
+				continue
+			}
+			value = data.Request.URI
+		case fieldIP:
+			value = clientIP(data)
+		case fieldUA:
+			value = ua
+		case fieldStatus:
+			value = fmt.Sprint(data.Status)
+		case fieldDuration, fieldSize:
+			value = formatDuration(data.Duration)
+			if field == fieldSize {
+				value = formatSize(data.Size)
+			}
+			if i > 0 && len(lines) > 0 && (fields[i-1] == fieldStatus || fields[i-1] == fieldDuration || fields[i-1] == fieldSize) {
+				lines[len(lines)-1] += " · " + value
+				continue
+			}
+		case fieldCountry:
+			value = country
+		default:
+			continue
+		}
+		lines = append(lines, value)
+	}
+	return top, lines
+}
+
+// messageBlock renders the code block of a message. With discord timestamps
+// the date moves behind the block where they render.
+func messageBlock(config Config, data parse.Data, date, ua, country string) string {
+	stamp := config.Time.discordTime(data.Ts.Time())
+	top, lines := messageLines(config, data, date, ua, country, stamp != "")
+	block := notify.Block(top, lines)
+	if stamp != "" && (len(config.Fields) == 0 || contains(config.Fields, fieldDate)) {
+		block += stamp
+	}
+	return block
+}
+
+// formatDuration shows caddy's duration in seconds as milliseconds
+func formatDuration(seconds float64) string {
+	ms := seconds * 1000
+	if ms < 10 {
+		return fmt.Sprintf("%.1f ms", ms)
+	}
+	return fmt.Sprintf("%.0f ms", ms)
+}
+
+// formatSize shows a response size in bytes, KB or MB
+func formatSize(bytes int) string {
+	switch {
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(bytes)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", bytes)
+}
+
+// parseSize reads sizes like "50MB", "512KB" or plain bytes, in the same
+// binary units formatSize shows
+func parseSize(value string) (int, bool) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	unit := 1
+	for _, suffix := range []struct {
+		name string
+		size int
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(value, suffix.name) {
+			value, unit = strings.TrimSpace(strings.TrimSuffix(value, suffix.name)), suffix.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return int(n * float64(unit)), true
+}
+
+func tookLonger(data parse.Data, limit time.Duration) bool {
+	return limit > 0 && time.Duration(data.Duration*float64(time.Second)) > limit
+}
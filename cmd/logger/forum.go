@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+// forumThreads remembers the thread created for each host in a forum
+// channel, persisted so a restart keeps posting into the same threads
+type forumThreads struct {
+	mu      sync.Mutex
+	loaded  bool
+	threads map[string]string
+}
+
+var forums = &forumThreads{threads: map[string]string{}}
+
+func statePath(config Config, name string) string {
+	dir := config.StateDir
+	if dir == "" {
+		dir = "."
+	}
+	return filepath.Join(dir, name)
+}
+
+func threadKey(webhookUrl string, host string) string {
+	return webhookID(webhookUrl) + "/" + strings.ToLower(host)
+}
+
+func (f *forumThreads) load(config Config) {
+	if f.loaded {
+		return
+	}
+	f.loaded = true
+
+	raw, err := os.ReadFile(statePath(config, "forum-threads.json"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("Error reading forum threads", "err", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(raw, &f.threads); err != nil || f.threads == nil {
+		if err != nil {
+			slog.Error("Error reading forum threads", "err", err)
+		}
+		f.threads = map[string]string{}
+	}
+}
+
+func (f *forumThreads) save(config Config) {
+	if dryRun {
+		return
+	}
+	raw, err := json.MarshalIndent(f.threads, "", "  ")
+	if err == nil {
+		err = os.WriteFile(statePath(config, "forum-threads.json"), raw, 0o600)
+	}
+	if err != nil {
+		slog.Error("Error saving forum threads", "err", err)
+	}
+}
+
+// post sends content into the thread of host, creating the thread on first
+// use or when the old one was deleted
+func (f *forumThreads) post(config Config, webhookUrl string, host string, message notify.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.load(config)
+
+	key := threadKey(webhookUrl, host)
+
+	if threadID, ok := f.threads[key]; ok {
+		err := deliver("discord", func() error {
+			_, err := executeWebhook(webhookUrl, url.Values{"thread_id": {threadID}}, message)
+			return err
+		})
+		if discordErrorCode(err) != errUnknownChannel {
+			return err
+		}
+		slog.Info("Forum thread is gone, creating a new one", "host", host)
+		delete(f.threads, key)
+	}
+
+	name := host
+	if name == "" {
+		name = "unknown host"
+	}
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	message.ThreadName = name
+
+	var created *discordMessage
+	err := deliver("discord", func() error {
+		var err error
+		created, err = executeWebhook(webhookUrl, url.Values{"wait": {"true"}}, message)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// the starter message lives in the new thread, so its channel is the thread
+	if created != nil && created.ChannelID != "" {
+		f.threads[key] = created.ChannelID
+		f.save(config)
+	}
+	return nil
+}
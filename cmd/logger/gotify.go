@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type GotifyConfig struct {
+	// URL is the gotify server, Token the token of an application on it
+	URL   string `json:"url"`
+	Token string `json:"token"`
+	// Priorities maps info, warn, critical and escalated to a gotify
+	// priority from 0 to 10
+	Priorities map[string]int `json:"priorities"`
+	NotifierFilter
+}
+
+var defaultGotifyPriorities = map[string]int{
+	severityInfo:     2,
+	severityWarn:     5,
+	severityCritical: 8,
+	"escalated":      10,
+}
+
+type gotifyNotifier struct {
+	config GotifyConfig
+	client *http.Client
+}
+
+type gotifyMessage struct {
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority"`
+}
+
+func newGotifyNotifier(config GotifyConfig) *gotifyNotifier {
+	return &gotifyNotifier{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (g *gotifyNotifier) Name() string {
+	return "gotify"
+}
+
+func (g *gotifyNotifier) accepts(alert Alert) bool {
+	return g.config.accepts(alert)
+}
+
+func (g *gotifyNotifier) Notify(alert Alert) error {
+	key := alert.Severity
+	if alert.Escalated {
+		key = "escalated"
+	}
+	priority, ok := g.config.Priorities[key]
+	if !ok {
+		priority = defaultGotifyPriorities[key]
+	}
+	return g.push(gotifyMessage{Title: alert.Title(), Message: alert.Text(), Priority: priority})
+}
+
+// Alert pushes the logger's own warnings
+func (g *gotifyNotifier) Alert(message string) error {
+	return g.push(gotifyMessage{Title: "caddy-discord-logger", Message: message, Priority: 5})
+}
+
+func (g *gotifyNotifier) push(message gotifyMessage) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(g.config.URL, "/")+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.config.Token)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return newStatusError("gotify", resp)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+// OpsConfig is where the logger reports its own trouble, separate from the
+// channels receiving traffic
+type OpsConfig struct {
+	WebhookURL string `json:"webhookUrl"`
+	// consecutive failures of a component before it counts as degraded,
+	// defaults to 5
+	Failures int `json:"failures"`
+}
+
+type componentHealth struct {
+	streak    int
+	degraded  bool
+	lastError string
+}
+
+// selfHealth tracks failure streaks of the logger's own components: docker,
+// the parser and every output
+type selfHealth struct {
+	mu         sync.Mutex
+	components map[string]*componentHealth
+}
+
+var health = &selfHealth{components: map[string]*componentHealth{}}
+
+func init() {
+	metrics.describe("logger_degraded", "gauge", "1 while a component of the logger keeps failing.")
+}
+
+// observe records the outcome of an operation, err nil meaning success
+func (h *selfHealth) observe(component string, err error) {
+	config := currentConfig()
+	threshold := 5
+	if config.Ops != nil && config.Ops.Failures > 0 {
+		threshold = config.Ops.Failures
+	}
+
+	h.mu.Lock()
+	c := h.components[component]
+	if c == nil {
+		c = &componentHealth{}
+		h.components[component] = c
+	}
+	var message string
+	if err != nil {
+		c.streak++
+		c.lastError = err.Error()
+		if !c.degraded && c.streak >= threshold {
+			c.degraded = true
+			message = fmt.Sprintf("🛠️ **Logger degraded**: %s failed %d times in a row\nLast error: %s", component, c.streak, c.lastError)
+		}
+	} else {
+		if c.degraded {
+			message = fmt.Sprintf("✅ **Logger recovered**: %s is working again after %d failures", component, c.streak)
+		}
+		c.streak = 0
+		c.degraded = false
+	}
+	degraded := c.degraded
+	h.mu.Unlock()
+
+	if message == "" {
+		return
+	}
+	value := 0.0
+	if degraded {
+		value = 1
+	}
+	metrics.set("logger_degraded", value, "component", component)
+
+	slog.Warn(message)
+	if config.Ops != nil && config.Ops.WebhookURL != "" {
+		// posted directly, going through deliver would feed back into observe
+		go func() {
+			if err := postWebhook(config.Ops.WebhookURL, notify.Message{Content: message}); err != nil {
+				slog.Error("Error posting to ops webhook", "err", err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import "simo.ng/logger/pkg/filter"
+
+// HostClass groups hosts that share alert thresholds and sampling, so a busy
+// shop and a quiet blog don't have to live with the same numbers
+type HostClass struct {
+	Name  string   `json:"name"`
+	Hosts []string `json:"hosts"`
+	// replace the top level sample and escalation.serverErrors for these hosts
+	Sample       *SampleConfig `json:"sample"`
+	ServerErrors *BurstConfig  `json:"serverErrors"`
+}
+
+// forHost returns the config with the overrides of the first class matching
+// host applied
+func forHost(config Config, host string) Config {
+	for _, class := range config.HostClasses {
+		if !filter.MatchHost(class.Hosts, host) {
+			continue
+		}
+		if class.Sample != nil {
+			config.Sample = class.Sample
+		}
+		if class.ServerErrors != nil && config.Escalation != nil {
+			escalation := *config.Escalation
+			escalation.ServerErrors = class.ServerErrors
+			config.Escalation = &escalation
+		}
+		break
+	}
+	return config
+}
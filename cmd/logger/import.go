@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+// archiveOutputs are the outputs that store events, imports are written to
+// them with their original time. The streams are left out, what listens to
+// them acts on live events.
+var archiveOutputs = []string{"loki", "elasticsearch", "clickhouse", "influx", "s3", "otlp", "ndjson"}
+
+// importLogs reads historical access logs, plain or gzipped, into the state
+// that keeps history: the scanners known to the security digest, the
+// visitors first seen routes know already and the storage outputs. Nothing
+// is posted.
+func importLogs(config Config, files []string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("usage: import <access.log|access.log.gz>...")
+	}
+
+	var archives []Sink
+	for _, sink := range buildSinks(config) {
+		if contains(archiveOutputs, sink.Name()) && !dryRun {
+			archives = append(archives, sink)
+		}
+	}
+
+	total, scanners := 0, 0
+	for _, file := range files {
+		lines, learned, err := importFile(config, file, archives)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		fmt.Printf("%s: %d lines, %d scanners\n", file, lines, learned)
+		total += lines
+		scanners += learned
+	}
+	flushSinks(archives, true)
+	closeSinks(archives)
+
+	digest.mu.Lock()
+	digest.saveSeen(config)
+	digest.mu.Unlock()
+	visitors.save(config)
+	fmt.Printf("Imported %d lines, %d scanner user agents are no longer reported as new\n", total, scanners)
+	for _, sink := range archives {
+		fmt.Printf("Wrote the lines to %s\n", sink.Name())
+	}
+	return nil
+}
+
+func importFile(config Config, file string, archives []Sink) (int, int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	// tagged like the live lines of the file, rotated ones by their base name
+	source := strings.TrimSuffix(filepath.Base(file), ".gz")
+	lines, learned := 0, 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := redactLine(config, scanner.Text())
+		data, err := parse.Line(source, line)
+		if err != nil {
+			continue
+		}
+		lines++
+		visitors.record(config, data)
+		if digest.learn(config, data) {
+			learned++
+		}
+		for _, sink := range archives {
+			if err := sink.Send(data, line); err != nil {
+				return lines, learned, fmt.Errorf("writing to %s: %w", sink.Name(), err)
+			}
+		}
+	}
+	return lines, learned, scanner.Err()
+}
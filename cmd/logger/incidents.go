@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+type IncidentConfig struct {
+	// webhook for incident and recovery posts, defaults to webhookUrl
+	WebhookURL string           `json:"webhookUrl"`
+	ErrorRate  *ErrorRateConfig `json:"errorRate"`
+	// silence opens an incident when no requests arrive for this long
+	Silence string `json:"silence"`
+}
+
+type ErrorRateConfig struct {
+	Threshold   float64 `json:"threshold"`
+	Window      string  `json:"window"`
+	MinRequests int     `json:"minRequests"`
+}
+
+// incidentUpdate is an incident opening or resolving. Key stays the same for
+// both so pagers can close what they opened.
+type incidentUpdate struct {
+	Key      string
+	Mark     string
+	Title    string
+	Detail   string
+	Resolved bool
+}
+
+// message is the update as posted to discord
+func (u incidentUpdate) message() string {
+	return u.Mark + " **" + u.Title + "**" + u.Detail
+}
+
+// Summary is the update in plain text
+func (u incidentUpdate) Summary() string {
+	return u.Title + u.Detail
+}
+
+type incident struct {
+	started  time.Time
+	ended    time.Time
+	requests int
+	errors   int
+	peak     float64
+}
+
+type hit struct {
+	at    time.Time
+	error bool
+}
+
+type incidentMonitor struct {
+	mu       sync.Mutex
+	hits     []hit
+	lastSeen time.Time
+
+	errorRate *incident
+	silence   *incident
+}
+
+var incidents = &incidentMonitor{lastSeen: time.Now()}
+
+func (m *incidentMonitor) record(data parse.Data) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	isError := data.Status >= 500
+	m.hits = append(m.hits, hit{at: now, error: isError})
+	m.lastSeen = now
+
+	if m.errorRate != nil {
+		m.errorRate.requests++
+		if isError {
+			m.errorRate.errors++
+		}
+	}
+	if m.silence != nil {
+		if m.silence.requests == 0 {
+			m.silence.ended = now
+		}
+		m.silence.requests++
+	}
+}
+
+// run evaluates the incident conditions on a fixed interval, silence can only
+// be noticed by a clock since by definition no log lines arrive
+func (m *incidentMonitor) run() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cfg := currentConfig()
+		if cfg.Incidents == nil {
+			m.mu.Lock()
+			m.hits = nil
+			m.mu.Unlock()
+			continue
+		}
+		webhook := cfg.Incidents.WebhookURL
+		if webhook == "" {
+			webhook = cfg.WebhookURL
+		}
+
+		for _, update := range m.evaluate(*cfg.Incidents, time.Now()) {
+			if err := sendMessageToDiscord(update.message(), webhook); err != nil {
+				slog.Error("Error posting incident update", "err", err)
+			}
+			pageAll(cfg, update)
+		}
+	}
+}
+
+func (m *incidentMonitor) evaluate(cfg IncidentConfig, now time.Time) []incidentUpdate {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var messages []incidentUpdate
+	if cfg.ErrorRate != nil && cfg.ErrorRate.Threshold > 0 {
+		messages = append(messages, m.evaluateErrorRate(*cfg.ErrorRate, now)...)
+	} else {
+		m.hits = nil
+	}
+	if cfg.Silence != "" {
+		messages = append(messages, m.evaluateSilence(cfg.Silence, now)...)
+	}
+	return messages
+}
+
+func (m *incidentMonitor) evaluateErrorRate(cfg ErrorRateConfig, now time.Time) []incidentUpdate {
+	window, err := time.ParseDuration(cfg.Window)
+	if err != nil || window <= 0 {
+		window = 5 * time.Minute
+	}
+
+	// drop everything that fell out of the window
+	cutoff := now.Add(-window)
+	i := 0
+	for i < len(m.hits) && m.hits[i].at.Before(cutoff) {
+		i++
+	}
+	m.hits = m.hits[i:]
+
+	errors := 0
+	for _, h := range m.hits {
+		if h.error {
+			errors++
+		}
+	}
+	total := len(m.hits)
+	rate := 0.0
+	if total > 0 {
+		rate = float64(errors) / float64(total)
+	}
+
+	if m.errorRate == nil {
+		if total >= cfg.MinRequests && total > 0 && rate >= cfg.Threshold {
+			m.errorRate = &incident{started: now, requests: total, errors: errors, peak: rate}
+			return []incidentUpdate{{
+				Key:    "incident:error-rate",
+				Mark:   "🚨",
+				Title:  "Error rate incident",
+				Detail: fmt.Sprintf(": %.0f%% of %d requests failed in the last %s", rate*100, total, window),
+			}}
+		}
+		return nil
+	}
+
+	if rate > m.errorRate.peak {
+		m.errorRate.peak = rate
+	}
+	if rate < cfg.Threshold {
+		resolved := m.errorRate
+		m.errorRate = nil
+		return []incidentUpdate{{
+			Key:   "incident:error-rate",
+			Mark:  "✅",
+			Title: "Error rate recovered",
+			Detail: fmt.Sprintf(" after %s (now %.0f%%, peak %.0f%%)\n%d requests, %d errors during the incident",
+				now.Sub(resolved.started).Round(time.Second), rate*100, resolved.peak*100, resolved.requests, resolved.errors),
+			Resolved: true,
+		}}
+	}
+	return nil
+}
+
+func (m *incidentMonitor) evaluateSilence(silence string, now time.Time) []incidentUpdate {
+	limit, err := time.ParseDuration(silence)
+	if err != nil || limit <= 0 {
+		return nil
+	}
+
+	if m.silence == nil {
+		if now.Sub(m.lastSeen) >= limit {
+			m.silence = &incident{started: m.lastSeen}
+			return []incidentUpdate{{
+				Key:    "incident:silence",
+				Mark:   "🔕",
+				Title:  "No traffic",
+				Detail: fmt.Sprintf(" for %s", limit),
+			}}
+		}
+		return nil
+	}
+
+	if m.silence.requests > 0 {
+		resolved := m.silence
+		m.silence = nil
+		return []incidentUpdate{{
+			Key:   "incident:silence",
+			Mark:  "✅",
+			Title: "Traffic resumed",
+			Detail: fmt.Sprintf(" after %s of silence, %d requests since",
+				resolved.ended.Sub(resolved.started).Round(time.Second), resolved.requests),
+			Resolved: true,
+		}}
+	}
+	return nil
+}
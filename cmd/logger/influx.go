@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"simo.ng/logger/pkg/filter"
+	"simo.ng/logger/pkg/parse"
+)
+
+type InfluxConfig struct {
+	// URL is the influxdb server, e.g. http://influxdb:8086, or
+	// udp://telegraf:8089 for plain line protocol to a socket listener
+	URL string `json:"url"`
+	// Org, Bucket and Token write through the v2 api
+	Org    string `json:"org"`
+	Bucket string `json:"bucket"`
+	Token  string `json:"token"`
+	// Database, Username and Password write through the 1.x api
+	Database string `json:"database"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Measurement defaults to caddy_request
+	Measurement string            `json:"measurement"`
+	Tags        map[string]string `json:"tags"`
+	Batch       BatchConfig       `json:"batch"`
+}
+
+type influxSink struct {
+	*batch
+	config InfluxConfig
+	client *http.Client
+}
+
+func newInfluxSink(config InfluxConfig) *influxSink {
+	if config.Measurement == "" {
+		config.Measurement = "caddy_request"
+	}
+	s := &influxSink{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	s.batch = newBatch("influx", config.Batch, s.write)
+	return s
+}
+
+func (s *influxSink) Name() string {
+	return "influx"
+}
+
+func (s *influxSink) Send(data parse.Data, raw string) error {
+	if parse.IsAccessLog(data) {
+		s.add(newEvent(currentConfig(), data))
+	}
+	return nil
+}
+
+var (
+	influxMeasurement = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTag         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// influxMethods are tagged as sent, any other method is OTHER
+var influxMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE"}
+
+// influxHost is the route host pattern host matches, so a forged Host header
+// can't add a series. Hosts no route lists are "other".
+func influxHost(config Config, host string) string {
+	for _, route := range config.Routes {
+		for _, pattern := range route.Hosts {
+			if pattern != "*" && filter.MatchHost([]string{pattern}, host) {
+				return strings.ToLower(pattern)
+			}
+		}
+	}
+	return "other"
+}
+
+// line renders an event as one point of line protocol. Only values with few
+// distinct values are tags, so the series stay bounded: the method and host
+// clients send are mapped onto known ones, the exact status is a field.
+func (s *influxSink) line(e event) string {
+	method := e.Method
+	if !contains(influxMethods, method) {
+		method = "OTHER"
+	}
+	tags := map[string]string{
+		"host":         influxHost(currentConfig(), e.Host),
+		"method":       method,
+		"status_class": fmt.Sprintf("%dxx", e.Status/100),
+		"severity":     e.Severity,
+		"country":      e.Country,
+		"source":       e.Source,
+	}
+	for k, v := range s.config.Tags {
+		tags[k] = v
+	}
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		// empty tag values aren't allowed
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(influxMeasurement.Replace(s.config.Measurement))
+	for _, k := range keys {
+		b.WriteString("," + influxTag.Replace(k) + "=" + influxTag.Replace(tags[k]))
+	}
+	fmt.Fprintf(&b, " duration=%s,size=%di,status=%di %d", strconv.FormatFloat(e.Duration, 'f', -1, 64), e.Size, e.Status, e.Time.UnixNano())
+	return b.String()
+}
+
+func (s *influxSink) write(events []event) error {
+	var body bytes.Buffer
+	for _, e := range events {
+		body.WriteString(s.line(e) + "\n")
+	}
+
+	u, err := url.Parse(s.config.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "udp" {
+		return s.writeUDP(u.Host, body.Bytes())
+	}
+
+	params := url.Values{"precision": {"ns"}}
+	endpoint := strings.TrimSuffix(s.config.URL, "/")
+	if s.config.Bucket != "" {
+		endpoint += "/api/v2/write"
+		params.Set("org", s.config.Org)
+		params.Set("bucket", s.config.Bucket)
+	} else {
+		endpoint += "/write"
+		params.Set("db", s.config.Database)
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint+"?"+params.Encode(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.config.Token)
+	} else if s.config.Username != "" {
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return newStatusError("influx", resp)
+	}
+	return nil
+}
+
+// writeUDP sends the lines in datagrams below the usual 64KB limit,
+// splitting only between lines
+func (s *influxSink) writeUDP(addr string, lines []byte) error {
+	conn, err := net.DialTimeout("udp", addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	const limit = 60000
+	for len(lines) > 0 {
+		end := len(lines)
+		if end > limit {
+			end = bytes.LastIndexByte(lines[:limit], '\n') + 1
+			if end == 0 {
+				end = limit
+			}
+		}
+		if _, err := conn.Write(lines[:end]); err != nil {
+			return err
+		}
+		lines = lines[end:]
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/netip"
+	"net/url"
+)
+
+// intelService looks up an address on an ip reputation site
+type intelService struct {
+	name  string
+	label string
+	url   string
+}
+
+var intelServices = []intelService{
+	{"abuseipdb", "AbuseIPDB", "https://www.abuseipdb.com/check/"},
+	{"shodan", "Shodan", "https://www.shodan.io/host/"},
+	{"ipinfo", "ipinfo", "https://ipinfo.io/"},
+	{"virustotal", "VirusTotal", "https://www.virustotal.com/gui/ip-address/"},
+}
+
+const intelAll = "all"
+
+func knownIntel(name string) bool {
+	if name == intelAll {
+		return true
+	}
+	for _, service := range intelServices {
+		if service.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// intelLinks returns the label and url of every intel service of the route
+// for ip, in the order of intelServices. Hashed addresses have nothing to
+// look up.
+func (r Route) intelLinks(ip string) [][2]string {
+	if len(r.Intel) == 0 {
+		return nil
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+	all := contains(r.Intel, intelAll)
+
+	var links [][2]string
+	for _, service := range intelServices {
+		if all || contains(r.Intel, service.name) {
+			links = append(links, [2]string{service.label, service.url + url.PathEscape(addr.Unmap().String())})
+		}
+	}
+	return links
+}
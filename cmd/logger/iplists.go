@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPListConfig tags requests from Tor exit nodes and datacenter or VPN
+// ranges, which on a personal site are almost always scanners
+type IPListConfig struct {
+	// Tor fetches the exit node list of the Tor project
+	Tor    bool   `json:"tor"`
+	TorURL string `json:"torUrl"`
+	// Datacenter are urls or files listing addresses or CIDR ranges, one
+	// per line
+	Datacenter []string `json:"datacenter"`
+	// Refresh is how often the lists are fetched again, defaults to 6h
+	Refresh string `json:"refresh"`
+}
+
+const (
+	listTor        = "tor"
+	listDatacenter = "datacenter"
+)
+
+const defaultTorURL = "https://check.torproject.org/torbulkexitlist"
+
+// prefixRange is a CIDR as its first and last address, sorted by start so
+// a lookup is a binary search
+type prefixRange struct {
+	start, end netip.Addr
+}
+
+type ipLists struct {
+	mu         sync.RWMutex
+	tor        map[netip.Addr]bool
+	datacenter []prefixRange
+}
+
+var lists = &ipLists{}
+
+func (l *ipLists) kinds(ip string) []string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+	addr = addr.Unmap()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var kinds []string
+	if l.tor[addr] {
+		kinds = append(kinds, listTor)
+	}
+	// ranges are merged, only the last one starting before addr can hold it
+	i := sort.Search(len(l.datacenter), func(i int) bool { return addr.Less(l.datacenter[i].start) }) - 1
+	if i >= 0 && !l.datacenter[i].end.Less(addr) {
+		kinds = append(kinds, listDatacenter)
+	}
+	return kinds
+}
+
+// describe is the tag line for the message, empty when ip is on no list
+func (l *ipLists) describe(ip string) string {
+	var tags []string
+	for _, kind := range l.kinds(ip) {
+		switch kind {
+		case listTor:
+			tags = append(tags, "🧅 Tor exit node")
+		case listDatacenter:
+			tags = append(tags, "🏭 datacenter address")
+		}
+	}
+	return strings.Join(tags, " · ")
+}
+
+// run fetches the lists now and after every refresh interval. A list that
+// fails to load keeps its previous content.
+func (l *ipLists) run() {
+	for {
+		config := currentConfig()
+		refresh := 6 * time.Hour
+		if config.IPLists != nil {
+			if d := parseDuration(config.IPLists.Refresh, refresh); d > 0 {
+				refresh = d
+			}
+			l.refresh(*config.IPLists)
+		}
+		time.Sleep(refresh)
+	}
+}
+
+func (l *ipLists) refresh(cfg IPListConfig) {
+	if cfg.Tor {
+		url := cfg.TorURL
+		if url == "" {
+			url = defaultTorURL
+		}
+		if ranges, err := fetchList(url); err != nil {
+			slog.Error("Error fetching Tor exit nodes", "err", err)
+		} else {
+			tor := make(map[netip.Addr]bool, len(ranges))
+			for _, r := range ranges {
+				tor[r.start] = true
+			}
+			l.mu.Lock()
+			l.tor = tor
+			l.mu.Unlock()
+		}
+	}
+
+	var datacenter []prefixRange
+	failed := false
+	for _, source := range cfg.Datacenter {
+		ranges, err := fetchList(source)
+		if err != nil {
+			slog.Error("Error fetching datacenter list", "source", source, "err", err)
+			failed = true
+			continue
+		}
+		datacenter = append(datacenter, ranges...)
+	}
+	if failed && len(datacenter) == 0 {
+		return
+	}
+	l.mu.Lock()
+	l.datacenter = mergeRanges(datacenter)
+	l.mu.Unlock()
+}
+
+var listClient = &http.Client{Timeout: 30 * time.Second}
+
+// fetchList reads a list from a url or a local file
+func fetchList(source string) ([]prefixRange, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return parseList(f)
+	}
+
+	var ranges []prefixRange
+	err := retry("ip lists", func() error {
+		resp, err := listClient.Get(source)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return newStatusError("ip lists", resp)
+		}
+		ranges, err = parseList(resp.Body)
+		return err
+	}, enrichmentPolicy)
+	return ranges, err
+}
+
+// parseList takes one address or CIDR per line, # starts a comment
+func parseList(r io.Reader) ([]prefixRange, error) {
+	var ranges []prefixRange
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(line); err == nil {
+			addr = addr.Unmap()
+			ranges = append(ranges, prefixRange{start: addr, end: addr})
+			continue
+		}
+		prefix, err := netip.ParsePrefix(line)
+		if err != nil {
+			continue
+		}
+		ranges = append(ranges, prefixRange{start: prefix.Masked().Addr(), end: lastAddr(prefix)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no addresses in the list")
+	}
+	return ranges, nil
+}
+
+// mergeRanges sorts ranges and joins the overlapping ones. IPv4 and IPv6
+// addresses sort apart, so ranges never span both.
+func mergeRanges(ranges []prefixRange) []prefixRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start.Less(ranges[j].start) })
+	var merged []prefixRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && !merged[n-1].end.Less(r.start) && merged[n-1].end.Is4() == r.start.Is4() {
+			if merged[n-1].end.Less(r.end) {
+				merged[n-1].end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// lastAddr is the broadcast address of prefix, all host bits set
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Masked().Addr()
+	bytes := addr.AsSlice()
+	bits := prefix.Bits()
+	for i := range bytes {
+		for b := 0; b < 8; b++ {
+			if i*8+b >= bits {
+				bytes[i] |= 1 << (7 - b)
+			}
+		}
+	}
+	last, _ := netip.AddrFromSlice(bytes)
+	return last
+}
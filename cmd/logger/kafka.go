@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+// KafkaConfig produces through a Kafka REST Proxy, the native protocol
+// needs a client library this logger doesn't ship
+type KafkaConfig struct {
+	// RESTProxy is the proxy url, e.g. http://rest-proxy:8082
+	RESTProxy string `json:"restProxy"`
+	Topic     string `json:"topic"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+}
+
+type kafkaSink struct {
+	config KafkaConfig
+	client *http.Client
+}
+
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value event  `json:"value"`
+}
+
+func newKafkaSink(config KafkaConfig) *kafkaSink {
+	return &kafkaSink{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (k *kafkaSink) Name() string {
+	return "kafka"
+}
+
+func (k *kafkaSink) Close() error {
+	return nil
+}
+
+// Send keys the record by host so a host's requests stay in order on one
+// partition
+func (k *kafkaSink) Send(data parse.Data, raw string) error {
+	if !parse.IsAccessLog(data) {
+		return nil
+	}
+	body, err := json.Marshal(kafkaRecords{Records: []kafkaRecord{{
+		Key:   data.Request.Host,
+		Value: newEvent(currentConfig(), data),
+	}}})
+	if err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimSuffix(k.config.RESTProxy, "/") + "/topics/" + url.PathEscape(k.config.Topic)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if k.config.Username != "" {
+		req.SetBasicAuth(k.config.Username, k.config.Password)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return newStatusError("kafka", resp)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+// LinkTemplate renders a url appended to the messages of the events a rule
+// matches, e.g. a grafana explore view with the host and time range of the
+// request pre-filled.
+type LinkTemplate struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Window string `json:"window"`
+}
+
+type linkData struct {
+	Host   string
+	URI    string
+	Method string
+	Status int
+	IP     string
+	Source string
+	Time   time.Time
+	// From and To are unix milliseconds, the format grafana expects
+	From int64
+	To   int64
+}
+
+var linkFuncs = template.FuncMap{
+	"query": url.QueryEscape,
+	"path":  url.PathEscape,
+	"rfc3339": func(t time.Time) string {
+		return t.UTC().Format(time.RFC3339)
+	},
+}
+
+func newLinkData(data parse.Data, window time.Duration) linkData {
+	ts := data.Ts.Time()
+	return linkData{
+		Host:   data.Request.Host,
+		URI:    data.Request.URI,
+		Method: data.Request.Method,
+		Status: data.Status,
+		IP:     clientIP(data),
+		Source: data.Source,
+		Time:   ts,
+		From:   ts.Add(-window).UnixMilli(),
+		To:     ts.Add(window).UnixMilli(),
+	}
+}
+
+func renderLinks(links []LinkTemplate, data parse.Data) string {
+	var rendered []string
+	for _, link := range links {
+		window := 15 * time.Minute
+		if link.Window != "" {
+			d, err := time.ParseDuration(link.Window)
+			if err != nil {
+				slog.Error("Invalid window for link", "link", link.Name, "err", err)
+			} else {
+				window = d
+			}
+		}
+
+		tmpl, err := template.New(link.Name).Funcs(linkFuncs).Parse(link.URL)
+		if err != nil {
+			slog.Error("Invalid link template", "link", link.Name, "err", err)
+			continue
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, newLinkData(data, window)); err != nil {
+			slog.Error("Error rendering link", "link", link.Name, "err", err)
+			continue
+		}
+
+		name := link.Name
+		if name == "" {
+			name = "link"
+		}
+		// angle brackets stop discord from unfurling a preview for every link
+		rendered = append(rendered, "["+name+"](<"+escapeURL(out.String())+">)")
+	}
+	return strings.Join(rendered, " · ")
+}
+
+// escapeURL percent-encodes what isn't allowed in a url. The request fields
+// come from the client, unescaped a ">" in the uri would end the link and
+// let the rest of it render as markdown.
+func escapeURL(raw string) string {
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		if c > ' ' && c < 0x7f && !strings.ContainsRune("<>\"{}|\\^`", rune(c)) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+func TestRenderLinks(t *testing.T) {
+	var data parse.Data
+	data.Request.Host = "example.com"
+	data.Request.URI = "/a b>)[x](https://evil.example)"
+	data.Ts = 1700000000
+
+	links := []LinkTemplate{
+		{Name: "Logs", URL: "https://grafana.example.com/explore?host={{query .Host}}&from={{.From}}&to={{.To}}", Window: "1m"},
+		{Name: "Raw", URL: "https://example.com{{.URI}}"},
+	}
+	got := renderLinks(links, data)
+	want := "[Logs](<https://grafana.example.com/explore?host=example.com&from=1699999940000&to=1700000060000>)" +
+		" · [Raw](<https://example.com/a%20b%3E)[x](https://evil.example)>)"
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	if strings.Count(got, ">") != 2 {
+		t.Errorf("the uri ended a link: %s", got)
+	}
+}
+
+func TestRuleLinks(t *testing.T) {
+	config := Config{Rules: []Rule{
+		{Name: "dashboards", Match: "status >= 500", Action: ruleLink, Links: []LinkTemplate{{Name: "Grafana", URL: "https://grafana.example.com"}}},
+		{Name: "api", Match: "request.host == 'api.example.com'", Action: ruleTag, Tags: []string{"api"}, Links: []LinkTemplate{{Name: "Runbook", URL: "https://wiki.example.com/api"}}},
+	}}
+	for _, rule := range config.Rules {
+		if problems := ruleProblems(config, rule.Name, rule); len(problems) > 0 {
+			t.Errorf("%s: %v", rule.Name, problems)
+		}
+	}
+	if problems := ruleProblems(config, "empty", Rule{Match: "true", Action: ruleLink}); len(problems) != 1 {
+		t.Errorf("a link rule without links: got %v", problems)
+	}
+
+	var data parse.Data
+	data.Request.Host = "api.example.com"
+	data.Status = 502
+	var names []string
+	for _, link := range applyRules(config, data).links {
+		names = append(names, link.Name)
+	}
+	if strings.Join(names, ",") != "Grafana,Runbook" {
+		t.Errorf("links: got %v", names)
+	}
+
+	data.Status = 200
+	if links := applyRules(config, data).links; len(links) != 1 || links[0].Name != "Runbook" {
+		t.Errorf("links of a 200: got %v", links)
+	}
+}
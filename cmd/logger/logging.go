@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// LogConfig controls the logger's own output on stderr
+type LogConfig struct {
+	// Level is debug, info, warn or error, info by default. debug also
+	// prints every log line read.
+	Level string `json:"level"`
+	// Format is "console" for key=value lines or "json" for one object per
+	// line, console by default
+	Format string `json:"format"`
+}
+
+var logLevels = map[string]slog.Level{
+	"debug":   slog.LevelDebug,
+	"info":    slog.LevelInfo,
+	"warn":    slog.LevelWarn,
+	"warning": slog.LevelWarn,
+	"error":   slog.LevelError,
+}
+
+// logLevel is shared by the handler, a reload changes the level in place
+var logLevel = new(slog.LevelVar)
+
+func (cfg *LogConfig) level() (slog.Level, error) {
+	if cfg == nil || cfg.Level == "" {
+		return slog.LevelInfo, nil
+	}
+	level, ok := logLevels[strings.ToLower(cfg.Level)]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q, use debug, info, warn or error", cfg.Level)
+	}
+	return level, nil
+}
+
+func (cfg *LogConfig) format() string {
+	if cfg == nil || cfg.Format == "" {
+		return "console"
+	}
+	return strings.ToLower(cfg.Format)
+}
+
+// setupLogging installs the default logger. A config reload only changes
+// the level, the format stays until a restart.
+func setupLogging(cfg *LogConfig) {
+	if level, err := cfg.level(); err == nil {
+		logLevel.Set(level)
+	}
+
+	// everything goes through redactingWriter, net/http errors quote the
+	// webhook urls
+	out := redactingWriter{out: os.Stderr}
+	options := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler = slog.NewTextHandler(out, options)
+	if cfg.format() == "json" {
+		handler = slog.NewJSONHandler(out, options)
+	}
+	// also picks up what the standard log package prints, e.g. net/http
+	slog.SetDefault(slog.New(handler))
+}
+
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
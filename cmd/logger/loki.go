@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+type LokiConfig struct {
+	URL      string            `json:"url"`
+	TenantID string            `json:"tenantId"`
+	Username string            `json:"username"`
+	Password string            `json:"password"`
+	Labels   map[string]string `json:"labels"`
+	Batch    BatchConfig       `json:"batch"`
+}
+
+type lokiSink struct {
+	*batch
+	config LokiConfig
+	client *http.Client
+}
+
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func newLokiSink(config LokiConfig) *lokiSink {
+	l := &lokiSink{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	l.batch = newBatch("loki", config.Batch, l.write)
+	return l
+}
+
+func (l *lokiSink) Name() string {
+	return "loki"
+}
+
+// lokiKey is the part of the labels that differs between events, the events
+// of one push are grouped into a stream per key
+type lokiKey struct {
+	host, method, statusClass, source string
+}
+
+func (k lokiKey) labels(static map[string]string) map[string]string {
+	labels := map[string]string{"job": "caddy"}
+	for name, v := range static {
+		labels[name] = v
+	}
+	labels["host"] = k.host
+	labels["method"] = k.method
+	labels["status_class"] = k.statusClass
+	if k.source != "" {
+		labels["source"] = k.source
+	}
+	return labels
+}
+
+func (l *lokiSink) Send(data parse.Data, raw string) error {
+	e := newEvent(currentConfig(), data)
+	e.Line = raw
+	l.add(e)
+	return nil
+}
+
+func (l *lokiSink) write(events []event) error {
+	var keys []lokiKey
+	values := map[lokiKey][][2]string{}
+	for _, e := range events {
+		key := lokiKey{e.Host, e.Method, fmt.Sprintf("%dxx", e.Status/100), e.Source}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		// loki wants the timestamp as a string of unix nanoseconds
+		values[key] = append(values[key], [2]string{strconv.FormatInt(e.Time.UnixNano(), 10), e.Line})
+	}
+
+	streams := make([]lokiStream, 0, len(keys))
+	for _, key := range keys {
+		streams = append(streams, lokiStream{Stream: key.labels(l.config.Labels), Values: values[key]})
+	}
+	return l.push(streams)
+}
+
+// Alert pushes the logger's own warnings into a separate stream
+func (l *lokiSink) Alert(message string) error {
+	labels := map[string]string{"job": "caddy-discord-logger", "level": "warning"}
+	for k, v := range l.config.Labels {
+		labels[k] = v
+	}
+	return l.push([]lokiStream{{
+		Stream: labels,
+		Values: [][2]string{{strconv.FormatInt(time.Now().UnixNano(), 10), message}},
+	}})
+}
+
+func (l *lokiSink) push(streams []lokiStream) error {
+	body, err := json.Marshal(lokiPush{Streams: streams})
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(l.config.URL, "/")
+	if !strings.HasSuffix(url, "/loki/api/v1/push") {
+		url += "/loki/api/v1/push"
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.config.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", l.config.TenantID)
+	}
+	if l.config.Username != "" {
+		req.SetBasicAuth(l.config.Username, l.config.Password)
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return newStatusError("loki", resp)
+	}
+	return nil
+}
@@ -0,0 +1,696 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"simo.ng/logger/pkg/parse"
+
+	"simo.ng/logger/pkg/notify"
+
+	"simo.ng/logger/pkg/ingest"
+)
+
+type Config struct {
+	ContainerName string `json:"containerName"`
+	WebhookURL    string `json:"webhookUrl"`
+	// WebhookURLFile reads webhookUrl from a file, e.g. a docker secret
+	WebhookURLFile string            `json:"webhookUrlFile"`
+	LogDir         string            `json:"logDir"`
+	Routes         []Route           `json:"routes"`
+	Loki           *LokiConfig       `json:"loki"`
+	Incidents      *IncidentConfig   `json:"incidents"`
+	Control        *ControlConfig    `json:"control"`
+	Retry          *RetryConfig      `json:"retry"`
+	AbuseIPDB      *AbuseIPDBConfig  `json:"abuseIpdb"`
+	CaddyAdmin     *CaddyAdminConfig `json:"caddyAdmin"`
+	RawUserAgent   bool              `json:"rawUserAgent"`
+	Dedup          *DedupConfig      `json:"dedup"`
+	// where state such as forum threads is kept, defaults to the working directory
+	StateDir string      `json:"stateDir"`
+	Store    StoreConfig `json:"store"`
+	Bot      *BotConfig  `json:"bot"`
+
+	// WebhookPool rotates the default route over more webhooks, see Route
+	WebhookPool []string `json:"webhookPool"`
+
+	// outputs besides discord and loki, the notifiers get the alerts and the
+	// sinks every request
+	Matrix        *MatrixConfig        `json:"matrix"`
+	Ntfy          *NtfyConfig          `json:"ntfy"`
+	Pushover      *PushoverConfig      `json:"pushover"`
+	Gotify        *GotifyConfig        `json:"gotify"`
+	Teams         *TeamsConfig         `json:"teams"`
+	Email         *EmailConfig         `json:"email"`
+	PagerDuty     *PagerDutyConfig     `json:"pagerDuty"`
+	Opsgenie      *OpsgenieConfig      `json:"opsgenie"`
+	MQTT          *MQTTConfig          `json:"mqtt"`
+	NATS          *NATSConfig          `json:"nats"`
+	Kafka         *KafkaConfig         `json:"kafka"`
+	Elasticsearch *ElasticsearchConfig `json:"elasticsearch"`
+	ClickHouse    *ClickHouseConfig    `json:"clickhouse"`
+	Influx        *InfluxConfig        `json:"influx"`
+	S3            *S3Config            `json:"s3"`
+	OTLP          *OTLPConfig          `json:"otlp"`
+	NDJSON        *NDJSONConfig        `json:"ndjson"`
+
+	Escalation *EscalationConfig `json:"escalation"`
+	Attach     *AttachConfig     `json:"attach"`
+	Digest     *DigestConfig     `json:"digest"`
+	Summary    *SummaryConfig    `json:"summary"`
+	Quiet      *QuietConfig      `json:"quiet"`
+
+	IgnoreSchemaDrift bool `json:"ignoreSchemaDrift"`
+
+	// Fields are shown in the code block of a message in this order, see
+	// fields.go. Defaults to date, host, uri, ip, ua, status, duration and
+	// size.
+	Fields []string `json:"fields"`
+
+	Severity *SeverityConfig `json:"severity"`
+	Sample   *SampleConfig   `json:"sample"`
+	Ops      *OpsConfig      `json:"ops"`
+
+	HostClasses []HostClass `json:"hostClasses"`
+
+	Docker    ingest.DockerConfig `json:"docker"`
+	Pipelines []Pipeline          `json:"pipelines"`
+
+	// Source is "files" to watch logDir or "exec" to tail the logs inside
+	// the container, by default exec is only used without a usable logDir
+	Source    string      `json:"source"`
+	ExecFiles []string    `json:"execFiles"`
+	Time      *TimeConfig `json:"time"`
+	// TrustedProxies are addresses or CIDRs whose forwarding headers are
+	// believed when working out the client address
+	TrustedProxies []string `json:"trustedProxies"`
+	// TimeLayouts parse string timestamps, tried before the usual caddy
+	// time_format layouts
+	TimeLayouts []string `json:"timeLayouts"`
+	// Poll checks logDir for changes that came without a file event, "off"
+	// disables it. Defaults to every 2s.
+	Poll string `json:"poll"`
+	// Debounce collects write events before reading, defaults to 250ms
+	Debounce string `json:"debounce"`
+	// LogPattern limits which files in logDir are read, e.g. "*.log"
+	LogPattern string `json:"logPattern"`
+
+	// MaxEventAge keeps older events out of discord, they still reach the
+	// outputs, the store and the digest
+	MaxEventAge string `json:"maxEventAge"`
+
+	Errors   *ErrorLogConfig `json:"errors"`
+	Backfill *BackfillConfig `json:"backfill"`
+	Queue    *QueueConfig    `json:"queue"`
+	Breaker  *BreakerConfig  `json:"breaker"`
+	Senders  *SenderConfig   `json:"senders"`
+	History  *HistoryConfig  `json:"history"`
+	ASN      *ASNConfig      `json:"asn"`
+	Country  *CountryConfig  `json:"country"`
+	IPLists  *IPListConfig   `json:"ipLists"`
+	Actions  []ActionConfig  `json:"actions"`
+
+	Signatures *SignatureConfig  `json:"signatures"`
+	BruteForce *BruteForceConfig `json:"bruteForce"`
+	Privacy    *PrivacyConfig    `json:"privacy"`
+
+	Log *LogConfig `json:"log"`
+
+	// Tests are run by `validate`
+	Tests []RuleTest `json:"tests"`
+	// Rules act on events matching a CEL expression, see rules.go
+	Rules []Rule       `json:"rules"`
+	Chain *ChainConfig `json:"chain"`
+	// RulesFile keeps the rules of the /rules API, added after the ones
+	// above. Defaults to rules.json in stateDir.
+	RulesFile string `json:"rulesFile"`
+}
+
+func getContainerIDByName(docker ingest.DockerConfig, containerName string) (string, error) {
+	var id string
+	err := withSource(docker, func(source ingest.Source) error {
+		var err error
+		id, err = source.FindContainer(containerName)
+		return err
+	})
+	return id, err
+}
+
+var (
+	logWatcherMu sync.Mutex
+	logWatcher   *fsnotify.Watcher
+	watched      = map[string]bool{}
+)
+
+// addWatchTarget starts watching another log file on the running watcher
+func addWatchTarget(targetPath string) {
+	logWatcherMu.Lock()
+	defer logWatcherMu.Unlock()
+
+	if logWatcher == nil || watched[targetPath] {
+		return
+	}
+	if err := logWatcher.Add(targetPath); err != nil {
+		slog.Error("Error watching", "path", targetPath, "err", err)
+		return
+	}
+	watched[targetPath] = true
+	slog.Info("Watching", "path", targetPath)
+}
+
+// readSource handles whatever was appended to a log file since the last
+// read. The file is read at its full path, log files discovered through
+// caddy can live outside the log directory; the file name is the source.
+func readSource(c container, file string) {
+	source := filepath.Base(file)
+	slog.Debug("Modified file", "path", file)
+	err := protect("handling "+file, func() error {
+		// the log directories are mounted at the same place in the
+		// container, so the watched path is the one to read
+		fileContent, err := readNew(currentConfig(), c, file)
+		if err != nil {
+			return err
+		}
+
+		handleRequest(c.pipeline, source, fileContent)
+		return nil
+	})
+	if err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// watchContainerFileChanges runs until the watcher breaks, the supervisor
+// starts it again with a fresh watcher. Only the primary pipeline's watcher
+// picks up log files discovered later through the caddy admin API.
+func watchContainerFileChanges(targetPaths []string, c container, primary bool) error {
+	// Create an fsnotify watcher to monitor the target file or directory
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// Start the fsnotify watcher on the target files or directories
+	logWatcherMu.Lock()
+	if primary {
+		logWatcher = watcher
+		watched = map[string]bool{}
+	}
+	for _, targetPath := range targetPaths {
+		if err := watcher.Add(targetPath); err != nil {
+			logWatcherMu.Unlock()
+			return fmt.Errorf("watching %s: %w", targetPath, err)
+		}
+		if primary {
+			watched[targetPath] = true
+		}
+	}
+	logWatcherMu.Unlock()
+
+	// under load caddy causes a write event for every line, they are
+	// collected for a moment so each file is read once per interval
+	pending := map[string]bool{}
+	var flush <-chan time.Time
+
+	poll := newPoller(targetPaths)
+	startAtEnd(currentConfig(), c, poll.files())
+	var pollTick <-chan time.Time
+	if interval, ok := pollInterval(currentConfig()); ok {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		pollTick = ticker.C
+	}
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return errors.New("watcher closed")
+			}
+			if event.Op&fsnotify.Write == fsnotify.Write && matchesLogPattern(currentConfig(), filepath.Base(event.Name)) {
+				pending[event.Name] = true
+				poll.evented[event.Name] = true
+				if flush == nil {
+					flush = time.After(parseDuration(currentConfig().Debounce, 250*time.Millisecond))
+				}
+			}
+		case <-pollTick:
+			for _, file := range poll.missed() {
+				readSource(c, file)
+			}
+		case <-flush:
+			flush = nil
+			for file := range pending {
+				readSource(c, file)
+			}
+			pending = map[string]bool{}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return errors.New("watcher closed")
+			}
+			// usually an overflowing event queue, losing a few events is
+			// better than restarting
+			slog.Error("Error watching files", "err", err)
+		}
+	}
+}
+
+func sendMessageToDiscord(content string, webhookUrl string) error {
+
+	message := notify.Message{
+
+		Content: content,
+	}
+
+	return sendMessage(webhookUrl, message)
+}
+
+func sendMessage(webhookUrl string, message notify.Message) error {
+
+	return queue.send(currentConfig(), queuedMessage{WebhookURL: webhookUrl, Message: message})
+
+}
+
+func handleRequest(pipeline string, source string, jsonString string) {
+
+	// split the string into an array of strings based on \n
+	var lines []string = strings.Split(jsonString, "\n")
+
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			handleLine(pipeline, source, line)
+		}
+	}
+}
+
+// parseLine parses a log line and tags it with the file it came from
+func parseLine(source string, line string) (parse.Data, bool) {
+	data, err := parse.Line(source, line)
+	health.observe("parser", err)
+	if err != nil {
+		slog.Error("JSON parse error", "err", err)
+		return data, false
+	}
+	return data, true
+}
+
+func handleLine(pipeline string, source string, line string) {
+
+	config := currentConfig()
+	line = redactLine(config, line)
+
+	slog.Debug("Log line", "source", source, "line", line)
+
+	data, ok := parseLine(source, line)
+	if ok {
+
+		ruled := applyRules(config, data)
+		if ruled.drop {
+			return
+		}
+		outputs := pipelineOutputs(config, pipeline)
+		if !parse.IsAccessLog(data) {
+			sendToSinks(config, outputs, data, line, false)
+			handleErrorLine(config, line)
+			return
+		}
+		incidents.record(data)
+		eventID := events.add(config.Store.Size, data, line)
+		digest.record(config, data)
+		profiles.record(data)
+		reportDrift(config, line)
+
+		if tooOld(config, data) {
+			sendToSinks(config, outputs, data, line, false)
+			return
+		}
+		actions.record(config, data)
+		summaries.record(config, data)
+
+		country := countryOf(config, data)
+		var date string = config.Time.formatTime(data.Ts.Time(), "2006-01-02 15:04:05")
+
+		// full user agents are ~150 characters and blow up the message width
+		var ua string
+		if len(data.Request.Headers.UserAgent) > 0 {
+			ua = data.Request.Headers.UserAgent[0]
+		}
+		if !config.RawUserAgent {
+			ua = parse.ParseUserAgent(ua).Summary()
+		}
+
+		var importantInfo []string = []string{
+			// strconv.FormatFloat(data.Ts, 'f', 4, 64),
+			date,
+			data.Request.Method,
+			data.Request.Host + data.Request.URI,
+			clientIP(data),
+			ua,
+			fmt.Sprint(data.Status),
+		}
+
+		slog.Debug("Request", "summary", importantInfo)
+
+		// send message to discord webhook
+		// [2023-05-17 13:03:52 GET imdb.simo.ng 50.230.198.1 Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/113.0.0.0 Safari/537.36 200]
+
+		var messageContent string = messageBlock(config, data, date, ua, country)
+		// the same extras without markdown, for the notifiers
+		var notes []string
+
+		if attack, ok := attackSignature(config, data); ok {
+			messageContent += "\n⚔️ Attack signature: " + notify.EscapeMarkdown(attack)
+			notes = append(notes, "⚔️ Attack signature: "+attack)
+		}
+
+		// suppressed by the network or reputation filters, which only apply
+		// to discord
+		var suppressed bool
+		if config.ASN != nil && config.ASN.Database != "" {
+			if info, ok := asns.lookup(*config.ASN, clientIP(data)); ok {
+				suppressed = info.matches(config.ASN.Suppress)
+				line := "🏢 " + notify.EscapeMarkdown(info.String())
+				note := "🏢 " + info.String()
+				if info.matches(config.ASN.Flag) {
+					line += " · ⚠️ flagged network"
+					note += " · ⚠️ flagged network"
+				}
+				messageContent += "\n" + line
+				notes = append(notes, note)
+			}
+		}
+
+		if config.IPLists != nil {
+			if tags := lists.describe(clientIP(data)); tags != "" {
+				messageContent += "\n" + tags
+				notes = append(notes, tags)
+			}
+		}
+
+		if config.AbuseIPDB != nil && config.AbuseIPDB.APIKey != "" {
+			if rep, ok := abuse.lookup(*config.AbuseIPDB, clientIP(data)); ok {
+				suppressed = suppressed || rep.Score < config.AbuseIPDB.MinScore
+				messageContent += "\n" + rep.String()
+				notes = append(notes, rep.String())
+			}
+		}
+
+		if len(ruled.tags) > 0 {
+			messageContent += "\n" + notify.EscapeMarkdown(tagLine(ruled.tags))
+			notes = append(notes, tagLine(ruled.tags))
+		}
+
+		var escalated bool
+		var reason, rule string
+		classed := forHost(config, data.Request.Host)
+		if classed.Escalation != nil {
+			reason, rule, escalated = escalations.check(*classed.Escalation, data, country)
+		}
+		if !escalated && ruled.escalation != "" {
+			reason, rule, escalated = "matched rule "+ruled.escalation, "rule:"+ruled.escalation, true
+		}
+		severity := severityOf(config, data, escalated)
+		sendToSinks(config, outputs, data, line, escalated)
+
+		// the notifiers and pagers filter for themselves, the noise controls
+		// below are discord's
+		top, lines := messageLines(config, data, date, ua, country, false)
+		if top != "" {
+			lines = append([]string{top}, lines...)
+		}
+		notifyAll(config, outputs, Alert{
+			ID:        eventID,
+			Data:      data,
+			Severity:  severity,
+			Escalated: escalated,
+			Reason:    reason,
+			Rule:      rule,
+			Country:   country,
+			Lines:     lines,
+			Notes:     notes,
+		})
+
+		if mutes.muted(config, data, time.Now()) {
+			return
+		}
+		if config.BruteForce != nil {
+			alert, withheld := bruteForce.record(*config.BruteForce, data)
+			if alert != "" {
+				postToRoutes(config, data.Request.Host, alert)
+			}
+			if withheld {
+				return
+			}
+		}
+
+		if config.Dedup != nil && dedup.suppress(*config.Dedup, data) {
+			return
+		}
+
+		if config.Country != nil && config.Country.ignores(country) {
+			return
+		}
+		if suppressed {
+			return
+		}
+		if config.Summary != nil && config.Summary.Replace && !escalated {
+			return
+		}
+		if classed.Sample != nil && sampling.skip(*classed.Sample, data, severity) {
+			return
+		}
+
+		if !sendsTo(outputs, outputDiscord, data, severity) {
+			return
+		}
+		routes := routesFor(config, data.Request.Host)
+		if ruled.routes != nil {
+			routes = namedRoutes(config, ruled.routes)
+		}
+		// modes this event reserved a first visit for, routes sharing a
+		// mode all post it
+		reserved := map[string]bool{}
+		for _, route := range routes {
+			if !route.accepts(severity) || !route.fromSource(data.Source) {
+				continue
+			}
+			if route.FirstSeen != "" && !reserved[route.FirstSeen] {
+				if !visitors.reserve(config, data, route.FirstSeen) {
+					continue
+				}
+				reserved[route.FirstSeen] = true
+			}
+			// emoji don't render inside the code block so they get their own line
+			content := messageContent
+			if header := route.header(data, severity, country); header != "" {
+				content = header + "\n" + messageContent
+			}
+			if route.FirstSeen != "" {
+				content = route.mark("🆕", "NEW VISITOR") + " first visit\n" + content
+			}
+			links := renderLinks(ruled.links, data)
+			if config.Control != nil && config.Control.PublicURL != "" {
+				event := "[event](<" + permalink(*config.Control, eventID) + ">)"
+				if links != "" {
+					links += " · "
+				}
+				links += event
+			}
+			intel := route.intelLinks(clientIP(data))
+			if !route.IntelButtons {
+				for _, link := range intel {
+					if links != "" {
+						links += " · "
+					}
+					links += "[" + link[0] + "](<" + link[1] + ">)"
+				}
+			}
+			if links != "" {
+				content += "\n" + links
+			}
+			if config.History != nil {
+				if history := config.History.line(clientIP(data), severity, data.Ts.Time()); history != "" {
+					content += "\n" + route.mark("📜", "HISTORY") + " " + history
+				}
+			}
+			message := notify.Message{Content: content}
+			if route.IntelButtons {
+				message.Components = notify.LinkButtons(intel)
+			}
+			if escalated {
+				if p, ok := profiles.get(clientIP(data)); ok {
+					message.Content += "\n" + route.mark("👤", "PROFILE") + " " + p.Summary()
+				}
+			}
+			message = route.present(message, data)
+			if escalated {
+				// rules escalate without an escalation config
+				var mentions EscalationConfig
+				if config.Escalation != nil {
+					mentions = *config.Escalation
+				}
+				message = escalate(mentions, route.mark("🚨", "ESCALATED"), reason, message)
+			}
+			if config.Attach != nil && config.Attach.wants(data, escalated) {
+				message.Files = []notify.Attachment{rawAttachment(data, line)}
+			}
+			if quiet.hold(config, route, data, severity, message) {
+				continue
+			}
+			route, host := route, data.Request.Host
+			senders.submit(config, "route "+route.Name, route.WebhookURL, func() {
+				if err := sendRouteMessage(config, route, host, message); err != nil {
+					slog.Error("Error sending to route", "route", route.Name, "err", err)
+					if route.FirstSeen != "" {
+						visitors.release(config, data, route.FirstSeen)
+					}
+				}
+				sendCanaryCopies(config, route, content)
+			})
+		}
+	}
+}
+
+func main() {
+
+	filePath := "config.json"
+	setupLogging(nil)
+
+	args, preview := dryRunFlag(os.Args[1:])
+	dryRun = preview
+	if len(args) > 0 {
+		runCommand(filePath, args)
+		return
+	}
+
+	// the config isn't printed, it holds the webhook urls
+	loaded, err := loadConfig(filePath)
+	if err != nil {
+		fatal("Error reading config", "err", err)
+	}
+	if problems := chainProblems(loaded); len(problems) > 0 {
+		fatal("Invalid chain", "problems", problems)
+	}
+	setupLogging(loaded.Log)
+	slog.Info("Config loaded", "path", filePath, "container", loaded.ContainerName)
+	if dryRun {
+		slog.Info("Dry run, messages are printed instead of posted")
+	}
+
+	if loaded.CaddyAdmin != nil {
+		if err := refreshDiscovery(*loaded.CaddyAdmin); err != nil {
+			slog.Warn("Caddy admin API discovery failed", "err", err)
+		}
+		background("caddy discovery", watchCaddy)
+	}
+	setConfig(loaded)
+
+	background("config watcher", func() { watchConfig(filePath) })
+	startServices(loaded)
+	if loaded.Control != nil && loaded.Control.Listen != "" {
+		background("control API", func() { serveControl(*loaded.Control) })
+	}
+	if loaded.Bot != nil && loaded.Bot.Listen != "" {
+		background("bot", func() { serveBot(*loaded.Bot) })
+	}
+
+	all := pipelines(loaded)
+	for i, p := range all {
+		p, primary := p, i == 0
+		run := func() error { return followPipeline(p, primary) }
+		if i == len(all)-1 {
+			// the last one keeps main running
+			supervise("pipeline "+p.Name, run)
+		} else {
+			go supervise("pipeline "+p.Name, run)
+		}
+	}
+}
+
+// startServices loads the saved state and starts the loops that run next to
+// the pipelines
+func startServices(loaded Config) {
+	background("incidents", incidents.run)
+	background("dedup", dedup.run)
+	background("checkpoints", checkpoints.run)
+	loadRuntime(loaded)
+	background("runtime state", persistRuntime)
+	background("digest", digest.run)
+	background("live summary", summaries.run)
+	background("quiet hours", quiet.run)
+	background("seen visitors", visitors.run)
+	background("ip lists", lists.run)
+	background("actions", actions.run)
+	background("brute force", bruteForce.run)
+	background("escalations", escalations.run)
+	background("delivery queue", queue.run)
+	background("batches", runBatches)
+}
+
+// backfilled remembers which pipelines already backfilled, a restart by the
+// supervisor doesn't do it again
+var backfilled sync.Map
+
+// followPipeline finds the pipeline's container and reads its logs until
+// something breaks
+func followPipeline(p Pipeline, primary bool) error {
+	if p.Service != "" {
+		return followService(p)
+	}
+	// find container id based on container name, looked up again on every
+	// restart since a recreated container gets a new id
+	if p.ContainerName == "" {
+		return unrecoverable(fmt.Errorf("pipeline %s: containerName is not set", p.Name))
+	}
+	containerID, err := getContainerIDByName(p.Docker, p.ContainerName)
+	if err != nil {
+		return err
+	}
+	slog.Info("Found container", "pipeline", p.Name, "container", containerID)
+	c := container{docker: p.Docker, name: p.ContainerName, id: containerID, pipeline: p.Name}
+
+	if config := currentConfig(); config.Backfill != nil {
+		if _, done := backfilled.LoadOrStore(p.Name, true); !done {
+			backfill(config, c, execFiles(p))
+		}
+	}
+
+	if logSource(p, primary) == sourceExec {
+		for {
+			// explicit execFiles don't follow caddy's config
+			var restart <-chan struct{}
+			if len(p.ExecFiles) == 0 {
+				restart = logFilesChanged()
+			}
+			err := streamContainerLogs(c, execFiles(p), restart)
+			if !errors.Is(err, errFilesChanged) {
+				return err
+			}
+			slog.Info("Caddy log files changed, tailing again", "pipeline", p.Name)
+		}
+	}
+
+	// an explicit logDir wins over whatever caddy says it writes to
+	targets := []string{p.LogDir}
+	if found := currentDiscovery(); p.LogDir == "" && found != nil && primary {
+		targets = found.LogFiles
+	}
+	if len(targets) == 0 || targets[0] == "" {
+		err := fmt.Errorf("pipeline %s: no logDir to watch", p.Name)
+		if !primary || currentConfig().CaddyAdmin == nil {
+			// nothing will ever turn up, a restart doesn't help
+			return unrecoverable(err)
+		}
+		return err
+	}
+	return watchContainerFileChanges(targets, c, primary)
+}
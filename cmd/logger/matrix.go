@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type MatrixConfig struct {
+	// Homeserver is the client API base, e.g. https://matrix.org
+	Homeserver  string `json:"homeserver"`
+	AccessToken string `json:"accessToken"`
+	// RoomID is the internal id like !abc:matrix.org, not an alias
+	RoomID string `json:"roomId"`
+	NotifierFilter
+}
+
+type matrixNotifier struct {
+	config MatrixConfig
+	client *http.Client
+}
+
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+func newMatrixNotifier(config MatrixConfig) *matrixNotifier {
+	return &matrixNotifier{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (m *matrixNotifier) Name() string {
+	return "matrix"
+}
+
+func (m *matrixNotifier) accepts(alert Alert) bool {
+	return m.config.accepts(alert)
+}
+
+// Notify posts the alert with the fields in a code block like on discord,
+// clients without html get the plain body
+func (m *matrixNotifier) Notify(alert Alert) error {
+	var b strings.Builder
+	if alert.Escalated {
+		b.WriteString("🚨 <b>Escalated:</b> " + html.EscapeString(alert.Reason) + "<br>")
+	}
+	b.WriteString("<b>" + html.EscapeString(alert.Title()) + "</b>")
+	b.WriteString("<pre><code>" + html.EscapeString(strings.Join(alert.Lines, "\n")) + "</code></pre>")
+	for _, note := range alert.Notes {
+		b.WriteString(html.EscapeString(note) + "<br>")
+	}
+
+	// the event id as transaction id makes retries of the same alert show once
+	return m.send(alert.ID, matrixMessage{
+		MsgType:       "m.text",
+		Body:          alert.Title() + "\n" + alert.Text(),
+		Format:        "org.matrix.custom.html",
+		FormattedBody: strings.TrimSuffix(b.String(), "<br>"),
+	})
+}
+
+// Alert posts the logger's own warnings as a notice, which bots are expected
+// to use and clients show less prominently
+func (m *matrixNotifier) Alert(message string) error {
+	return m.send(newEventID(), matrixMessage{MsgType: "m.notice", Body: message})
+}
+
+func (m *matrixNotifier) send(txn string, message matrixMessage) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimSuffix(m.config.Homeserver, "/") + "/_matrix/client/v3/rooms/" +
+		url.PathEscape(m.config.RoomID) + "/send/m.room.message/" + url.PathEscape(txn)
+
+	req, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.config.AccessToken)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return newStatusError("matrix", resp)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricSet is a tiny prometheus style registry, exposed as text on the
+// control api under /metrics
+type metricSet struct {
+	mu     sync.Mutex
+	values map[string]float64
+	kinds  map[string]string
+	help   map[string]string
+	// labels are the pairs of every series, for exporters that want them
+	// apart from the name
+	labels map[string][]string
+}
+
+var metrics = &metricSet{values: map[string]float64{}, kinds: map[string]string{}, help: map[string]string{}, labels: map[string][]string{}}
+
+func (m *metricSet) describe(name string, kind string, help string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.kinds[name] = kind
+	m.help[name] = help
+}
+
+// series formats name{k="v",...} with the labels sorted
+func series(name string, labels ...string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, labels[i]+`="`+value+`"`)
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (m *metricSet) add(name string, delta float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := series(name, labels...)
+	m.values[key] += delta
+	m.labels[key] = labels
+}
+
+func (m *metricSet) set(name string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := series(name, labels...)
+	m.values[key] = value
+	m.labels[key] = labels
+}
+
+// metricPoint is one series at the time of a snapshot
+type metricPoint struct {
+	name   string
+	kind   string
+	help   string
+	labels []string
+	value  float64
+}
+
+func (m *metricSet) snapshot() []metricPoint {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	points := make([]metricPoint, 0, len(keys))
+	for _, key := range keys {
+		name, _, _ := strings.Cut(key, "{")
+		points = append(points, metricPoint{name: name, kind: m.kinds[name], help: m.help[name], labels: m.labels[key], value: m.values[key]})
+	}
+	return points
+}
+
+func (m *metricSet) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	described := map[string]bool{}
+	for _, key := range keys {
+		name, _, _ := strings.Cut(key, "{")
+		if !described[name] {
+			described[name] = true
+			if help, ok := m.help[name]; ok {
+				fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+				fmt.Fprintf(w, "# TYPE %s %s\n", name, m.kinds[name])
+			}
+		}
+		fmt.Fprintf(w, "%s %g\n", key, m.values[key])
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.write(w)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+type MQTTConfig struct {
+	// URL is the broker, mqtt://host:1883 or mqtts://host:8883 for tls
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	ClientID string `json:"clientId"`
+	// Topic is the prefix, events of a host go to <topic>/<host>. Defaults
+	// to caddy.
+	Topic string `json:"topic"`
+	// Retain keeps the last event of every host on the broker
+	Retain bool `json:"retain"`
+}
+
+// the broker drops clients that are silent for 1.5 times the keep alive,
+// an idle connection is replaced before that instead of pinging
+const mqttKeepAlive = 60 * time.Second
+
+// mqttSink is a minimal mqtt 3.1.1 client that only publishes at qos 0,
+// which is all an event stream for automations needs
+type mqttSink struct {
+	config MQTTConfig
+	mu     sync.Mutex
+	conn   net.Conn
+	last   time.Time
+	// closed is set once the sink was replaced, a late publish mustn't
+	// open a connection nothing would close again
+	closed bool
+}
+
+func newMQTTSink(config MQTTConfig) *mqttSink {
+	if config.ClientID == "" {
+		config.ClientID = "caddy-discord-logger-" + newEventID()
+	}
+	if config.Topic == "" {
+		config.Topic = "caddy"
+	}
+	return &mqttSink{config: config}
+}
+
+func (m *mqttSink) Name() string {
+	return "mqtt"
+}
+
+// Close disconnects from the broker once the sink was replaced
+func (m *mqttSink) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.close()
+	return nil
+}
+
+// mqttTopic keeps a host from adding levels or wildcards to the topic
+func mqttTopic(prefix, host string) string {
+	if host == "" {
+		host = "unknown"
+	}
+	host = strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(host)
+	return strings.TrimSuffix(prefix, "/") + "/" + host
+}
+
+func (m *mqttSink) Send(data parse.Data, raw string) error {
+	if !parse.IsAccessLog(data) {
+		return nil
+	}
+	payload, err := json.Marshal(newEvent(currentConfig(), data))
+	if err != nil {
+		return err
+	}
+	return m.publish(mqttTopic(m.config.Topic, data.Request.Host), payload)
+}
+
+func (m *mqttSink) publish(topic string, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errors.New("mqtt: sink closed")
+	}
+	if m.conn != nil && time.Since(m.last) > mqttKeepAlive {
+		m.close()
+	}
+	if m.conn == nil {
+		if err := m.connect(); err != nil {
+			return err
+		}
+	}
+
+	var body bytes.Buffer
+	writeMQTTString(&body, topic)
+	body.Write(payload)
+	header := byte(0x30)
+	if m.config.Retain {
+		header |= 0x01
+	}
+	if err := m.write(header, body.Bytes()); err != nil {
+		// the next attempt starts over with a new connection
+		m.close()
+		return err
+	}
+	return nil
+}
+
+func (m *mqttSink) connect() error {
+	u, err := url.Parse(m.config.URL)
+	if err != nil {
+		return err
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		conn, err = dialer.Dial("tcp", hostWithPort(u, "1883"))
+	case "mqtts", "ssl", "tls":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostWithPort(u, "8883"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return fmt.Errorf("mqtt: unknown scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	writeMQTTString(&body, "MQTT")
+	flags := byte(0x02) // clean session
+	if m.config.Username != "" {
+		flags |= 0x80
+		if m.config.Password != "" {
+			flags |= 0x40
+		}
+	}
+	keepAlive := int(mqttKeepAlive.Seconds())
+	body.Write([]byte{4, flags, byte(keepAlive >> 8), byte(keepAlive)})
+	writeMQTTString(&body, m.config.ClientID)
+	if m.config.Username != "" {
+		writeMQTTString(&body, m.config.Username)
+		if m.config.Password != "" {
+			writeMQTTString(&body, m.config.Password)
+		}
+	}
+
+	m.conn = conn
+	if err := m.write(0x10, body.Bytes()); err != nil {
+		m.close()
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		m.close()
+		return err
+	}
+	conn.SetReadDeadline(time.Time{})
+	if ack[0] != 0x20 || ack[3] != 0 {
+		m.close()
+		return fmt.Errorf("mqtt: connection refused with code %d", ack[3])
+	}
+	return nil
+}
+
+func (m *mqttSink) write(header byte, body []byte) error {
+	var packet bytes.Buffer
+	packet.WriteByte(header)
+	// the remaining length takes 7 bits per byte, the high bit continues it
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet.WriteByte(b)
+		if n == 0 {
+			break
+		}
+	}
+	packet.Write(body)
+
+	m.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := m.conn.Write(packet.Bytes())
+	m.last = time.Now()
+	return err
+}
+
+// close disconnects, callers hold the lock
+func (m *mqttSink) close() {
+	if m.conn == nil {
+		return
+	}
+	m.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	m.conn.Write([]byte{0xE0, 0x00})
+	m.conn.Close()
+	m.conn = nil
+}
+
+func writeMQTTString(b *bytes.Buffer, s string) {
+	b.WriteByte(byte(len(s) >> 8))
+	b.WriteByte(byte(len(s)))
+	b.WriteString(s)
+}
+
+func hostWithPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
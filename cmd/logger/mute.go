@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/filter"
+	"simo.ng/logger/pkg/notify"
+	"simo.ng/logger/pkg/parse"
+)
+
+// muteState silences discord alerts until a point in time, for everything
+// or per host or client address. Events are still stored, profiled and sent
+// to the outputs while muted. Mutes survive restarts in mutes.json.
+type muteState struct {
+	mu     sync.Mutex
+	loaded bool
+	// until is keyed by scope: "" for everything, "host:<pattern>" with a
+	// host pattern like the ones of routes, or "ip:<address>"
+	until map[string]time.Time
+}
+
+var mutes = &muteState{until: map[string]time.Time{}}
+
+func init() {
+	botCommands["mute"] = botCommand{
+		definition: map[string]interface{}{
+			"name":        "mute",
+			"description": "Silence alerts for a while",
+			"options": []map[string]interface{}{
+				{"type": 3, "name": "for", "description": "How long, like 1h or 30m, off to resume"},
+				{"type": 3, "name": "host", "description": "Only alerts for this host"},
+				{"type": 3, "name": "ip", "description": "Only alerts about this client address"},
+			},
+		},
+		handle: muteCommand,
+	}
+	botCommands["resume"] = botCommand{
+		definition: map[string]interface{}{
+			"name":        "resume",
+			"description": "Lift mutes, all of them without options",
+			"options": []map[string]interface{}{
+				{"type": 3, "name": "host", "description": "Lift the mute of this host"},
+				{"type": 3, "name": "ip", "description": "Lift the mute of this client address"},
+			},
+		},
+		handle: resumeCommand,
+	}
+}
+
+func muteScope(host, ip string) string {
+	switch {
+	case ip != "":
+		return "ip:" + ip
+	case host != "":
+		return "host:" + hostPattern(host)
+	}
+	return ""
+}
+
+// hostPattern normalizes a host given to /mute the way filter.MatchHost
+// sees hosts: lower case and without a port
+func hostPattern(host string) string {
+	if i := strings.LastIndex(host, ":"); i != -1 && !strings.Contains(host[i:], "]") {
+		host = host[:i]
+	}
+	return strings.ToLower(host)
+}
+
+func describeScope(scope string) string {
+	if scope == "" {
+		return "all alerts"
+	}
+	kind, value, _ := strings.Cut(scope, ":")
+	if kind == "ip" {
+		return "alerts about " + notify.EscapeMarkdown(value)
+	}
+	return "alerts for " + notify.EscapeMarkdown(value)
+}
+
+func (m *muteState) load(config Config) {
+	if m.loaded {
+		return
+	}
+	m.loaded = true
+	if err := readStateFile(config, "mutes.json", &m.until); err != nil {
+		slog.Error("Error reading mutes", "err", err)
+	}
+	if m.until == nil {
+		m.until = map[string]time.Time{}
+	}
+}
+
+func (m *muteState) save(config Config) {
+	if err := writeStateFile(config, "mutes.json", m.until); err != nil {
+		slog.Error("Error saving mutes", "err", err)
+	}
+}
+
+// muted reports whether an alert about data is silenced, mutes that ran out
+// are resumed on the way
+func (m *muteState) muted(config Config, data parse.Data, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load(config)
+	if len(m.until) == 0 {
+		return false
+	}
+
+	expired := false
+	for scope, until := range m.until {
+		if !now.Before(until) {
+			delete(m.until, scope)
+			expired = true
+			slog.Info("Mute ended", "scope", scope)
+		}
+	}
+	if expired {
+		m.save(config)
+	}
+
+	for _, scope := range []string{"", muteScope("", clientIP(data))} {
+		if _, ok := m.until[scope]; ok {
+			return true
+		}
+	}
+	for scope := range m.until {
+		if pattern, ok := strings.CutPrefix(scope, "host:"); ok && filter.MatchHost([]string{pattern}, data.Request.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *muteState) set(config Config, scope string, until time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load(config)
+	m.until[scope] = until
+	m.save(config)
+}
+
+// lift removes the mute of scope, or every mute when all is set, and
+// returns the scopes that were muted
+func (m *muteState) lift(config Config, scope string, all bool) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load(config)
+
+	var lifted []string
+	for s := range m.until {
+		if all || s == scope {
+			lifted = append(lifted, s)
+			delete(m.until, s)
+		}
+	}
+	if len(lifted) > 0 {
+		m.save(config)
+	}
+	sort.Strings(lifted)
+	return lifted
+}
+
+func muteCommand(cfg BotConfig, i interaction) interactionResponse {
+	config := currentConfig()
+	scope := muteScope(strings.TrimSpace(i.stringOption("host")), strings.TrimSpace(i.stringOption("ip")))
+	value := strings.TrimSpace(i.stringOption("for"))
+	if strings.EqualFold(value, "off") {
+		if len(mutes.lift(config, scope, false)) == 0 {
+			return reply("🔔 " + describeScope(scope) + " weren't muted")
+		}
+		return reply("🔔 Resumed " + describeScope(scope))
+	}
+
+	d := parseDuration(cfg.MuteFor, time.Hour)
+	if value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return reply("Give a duration like 1h or 30m, or off to resume")
+		}
+		d = parsed
+	}
+	until := time.Now().Add(d)
+	mutes.set(config, scope, until)
+	return reply(fmt.Sprintf("🔕 Muted %s until <t:%d:t>", describeScope(scope), until.Unix()))
+}
+
+func resumeCommand(cfg BotConfig, i interaction) interactionResponse {
+	host, ip := strings.TrimSpace(i.stringOption("host")), strings.TrimSpace(i.stringOption("ip"))
+	scope := muteScope(host, ip)
+	lifted := mutes.lift(currentConfig(), scope, scope == "")
+	if len(lifted) == 0 {
+		return reply("🔔 Nothing was muted")
+	}
+	described := make([]string, len(lifted))
+	for n, s := range lifted {
+		described[n] = describeScope(s)
+	}
+	return reply("🔔 Resumed " + strings.Join(described, ", "))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+type NATSConfig struct {
+	// URL is the server, nats://nats:4222 or tls://nats:4222
+	URL      string `json:"url"`
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Subject defaults to caddy.requests
+	Subject string `json:"subject"`
+}
+
+// natsSink speaks just enough of the nats text protocol to publish: the
+// server's INFO, CONNECT and PUB, and answering its pings
+type natsSink struct {
+	config NATSConfig
+	mu     sync.Mutex
+	conn   net.Conn
+	// closed is set once the sink was replaced, a late send mustn't open
+	// a connection nothing would close again
+	closed bool
+}
+
+func newNATSSink(config NATSConfig) *natsSink {
+	if config.Subject == "" {
+		config.Subject = "caddy.requests"
+	}
+	return &natsSink{config: config}
+}
+
+func (n *natsSink) Name() string {
+	return "nats"
+}
+
+func (n *natsSink) Send(data parse.Data, raw string) error {
+	if !parse.IsAccessLog(data) {
+		return nil
+	}
+	payload, err := json.Marshal(newEvent(currentConfig(), data))
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return errors.New("nats: sink closed")
+	}
+	if n.conn == nil {
+		if err := n.connect(); err != nil {
+			return err
+		}
+	}
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\n", n.config.Subject, len(payload), payload)
+	n.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := n.conn.Write([]byte(msg)); err != nil {
+		n.conn.Close()
+		n.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Close disconnects, which also ends the goroutine answering pings
+func (n *natsSink) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closed = true
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn = nil
+	return err
+}
+
+// connect opens a connection, callers hold the lock
+func (n *natsSink) connect() error {
+	u, err := url.Parse(n.config.URL)
+	if err != nil {
+		return err
+	}
+	addr := hostWithPort(u, "4222")
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+	info, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(info, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("nats: unexpected greeting %q", strings.TrimSpace(info))
+	}
+
+	// tls starts after the plain INFO
+	if u.Scheme == "tls" {
+		secure := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := secure.Handshake(); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = secure
+		reader = bufio.NewReader(conn)
+	}
+
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "caddy-discord-logger",
+		"lang":     "go",
+		"protocol": 1,
+	}
+	if n.config.Token != "" {
+		options["auth_token"] = n.config.Token
+	}
+	if n.config.Username != "" {
+		options["user"] = n.config.Username
+		options["pass"] = n.config.Password
+	}
+	connect, _ := json.Marshal(options)
+	// the PING is answered after CONNECT was accepted, or with -ERR
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+		conn.Close()
+		return err
+	}
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(reply, "PONG") {
+		conn.Close()
+		return errors.New("nats: " + strings.TrimSpace(reply))
+	}
+	conn.SetDeadline(time.Time{})
+
+	n.conn = conn
+	go n.read(conn, reader)
+	return nil
+}
+
+// read answers the server's pings until the connection breaks, the server
+// disconnects clients that don't
+func (n *natsSink) read(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		n.mu.Lock()
+		if err != nil {
+			if n.conn == conn {
+				conn.Close()
+				n.conn = nil
+			}
+			n.mu.Unlock()
+			return
+		}
+		if strings.HasPrefix(line, "PING") && n.conn == conn {
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			conn.Write([]byte("PONG\r\n"))
+		}
+		n.mu.Unlock()
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"simo.ng/logger/pkg/filter"
+	"simo.ng/logger/pkg/parse"
+)
+
+type NDJSONConfig struct {
+	// Path is the file written to, older ones are kept as Path.1, Path.2, ...
+	Path string `json:"path"`
+	// MaxSize rotates the file once it would grow beyond it, 100MB by
+	// default
+	MaxSize string `json:"maxSize"`
+	// MaxFiles is how many rotated files are kept, 5 by default
+	MaxFiles int `json:"maxFiles"`
+	// Severities and Hosts limit what is written, empty lists let
+	// everything through
+	Severities []string `json:"severities"`
+	Hosts      []string `json:"hosts"`
+}
+
+// ndjsonSink appends the normalized events to a local file, one per line
+type ndjsonSink struct {
+	config   NDJSONConfig
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newNDJSONSink(config NDJSONConfig) *ndjsonSink {
+	maxSize, ok := parseSize(config.MaxSize)
+	if !ok || maxSize == 0 {
+		maxSize = 100 << 20
+	}
+	maxFiles := config.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = 5
+	}
+	return &ndjsonSink{config: config, maxSize: int64(maxSize), maxFiles: maxFiles}
+}
+
+func (s *ndjsonSink) Name() string {
+	return "ndjson"
+}
+
+// Close has nothing to do, flushing all already closed the file
+func (s *ndjsonSink) Close() error {
+	return nil
+}
+
+func (s *ndjsonSink) accepts(e event) bool {
+	if len(s.config.Severities) > 0 && !contains(s.config.Severities, e.Severity) {
+		return false
+	}
+	return len(s.config.Hosts) == 0 || filter.MatchHost(s.config.Hosts, e.Host)
+}
+
+func (s *ndjsonSink) Send(data parse.Data, raw string) error {
+	if !parse.IsAccessLog(data) {
+		return nil
+	}
+	e := newEvent(currentConfig(), data)
+	if !s.accepts(e) {
+		return nil
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *ndjsonSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.config.Path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+// rotate shifts Path to Path.1, Path.1 to Path.2 and so on, dropping the
+// oldest beyond MaxFiles. The next write opens a fresh Path.
+func (s *ndjsonSink) rotate() error {
+	err := s.file.Close()
+	s.file, s.size = nil, 0
+	if err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", s.config.Path, s.maxFiles))
+	for i := s.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.config.Path, i), fmt.Sprintf("%s.%d", s.config.Path, i+1))
+	}
+	return os.Rename(s.config.Path, s.config.Path+".1")
+}
+
+// flush closes the file when the sink is replaced or the logger stops,
+// the writes themselves aren't buffered
+func (s *ndjsonSink) flush(all bool) {
+	if !all {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil {
+		s.file.Close()
+		s.file, s.size = nil, 0
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"simo.ng/logger/pkg/filter"
+	"simo.ng/logger/pkg/parse"
+)
+
+// Notifier is an output that gets the same alerts as the discord routes, for
+// chat and push services other than discord. Unlike a Sink it only sees the
+// requests that made it through the rules, and filters the rest itself: the
+// noise controls of discord like mutes, dedup and sampling don't apply.
+type Notifier interface {
+	Name() string
+	Notify(alert Alert) error
+	accepts(alert Alert) bool
+}
+
+// Alert is one event as the notifiers get it, in plain text without discord
+// markdown
+type Alert struct {
+	// ID is the id of the event in the store
+	ID        string
+	Data      parse.Data
+	Severity  string
+	Escalated bool
+	Reason    string
+	// Rule is the escalation rule that hit, e.g. 5xx:example.com
+	Rule    string
+	Country string
+	// Lines are the configured message fields, the date first
+	Lines []string
+	// Notes are the extra lines below them, e.g. the attack signature
+	Notes []string
+}
+
+// incidentKey groups the escalations of one rule into one incident, those
+// without a rule by host and reason
+func (a Alert) incidentKey() string {
+	if a.Rule != "" {
+		return a.Rule
+	}
+	return a.Data.Request.Host + ":" + a.Reason
+}
+
+// Title is a one line summary of the request
+func (a Alert) Title() string {
+	return fmt.Sprintf("%d %s %s%s", a.Data.Status, a.Data.Request.Method, a.Data.Request.Host, a.Data.Request.URI)
+}
+
+// Text is the alert as plain lines
+func (a Alert) Text() string {
+	var lines []string
+	if a.Escalated {
+		lines = append(lines, "🚨 Escalated: "+a.Reason)
+	}
+	lines = append(lines, a.Lines...)
+	lines = append(lines, a.Notes...)
+	return strings.Join(lines, "\n")
+}
+
+// NotifierFilter limits which alerts a notifier gets, empty lists let
+// everything through
+type NotifierFilter struct {
+	Severities []string `json:"severities"`
+	Hosts      []string `json:"hosts"`
+}
+
+func (f NotifierFilter) accepts(alert Alert) bool {
+	if len(f.Severities) > 0 && !contains(f.Severities, alert.Severity) {
+		return false
+	}
+	return len(f.Hosts) == 0 || filter.MatchHost(f.Hosts, alert.Data.Request.Host)
+}
+
+var notifiers []Notifier
+
+func buildNotifiers(config Config) []Notifier {
+	var built []Notifier
+	if config.Matrix != nil && config.Matrix.Homeserver != "" {
+		built = append(built, newMatrixNotifier(*config.Matrix))
+	}
+	if config.Ntfy != nil && config.Ntfy.URL != "" {
+		built = append(built, newNtfyNotifier(*config.Ntfy))
+	}
+	if config.Pushover != nil && config.Pushover.Token != "" {
+		built = append(built, newPushoverNotifier(*config.Pushover))
+	}
+	if config.Gotify != nil && config.Gotify.URL != "" {
+		built = append(built, newGotifyNotifier(*config.Gotify))
+	}
+	if config.Teams != nil && config.Teams.WebhookURL != "" {
+		built = append(built, newTeamsNotifier(*config.Teams))
+	}
+	if config.Email != nil && config.Email.Host != "" {
+		built = append(built, newEmailNotifier(*config.Email))
+	}
+	if config.PagerDuty != nil && config.PagerDuty.RoutingKey != "" {
+		built = append(built, newPagerDutyNotifier(*config.PagerDuty))
+	}
+	if config.Opsgenie != nil && config.Opsgenie.APIKey != "" {
+		built = append(built, newOpsgenieNotifier(*config.Opsgenie))
+	}
+	return built
+}
+
+func notifyAll(config Config, outputs []PipelineOutput, alert Alert) {
+	if dryRun {
+		return
+	}
+	configMu.RLock()
+	current := notifiers
+	configMu.RUnlock()
+
+	for _, notifier := range current {
+		notifier := notifier
+		if !notifier.accepts(alert) || !sendsTo(outputs, notifier.Name(), alert.Data, alert.Severity) {
+			continue
+		}
+		outputSenders.submit(config, notifier.Name(), notifier.Name(), func() {
+			err := deliver(notifier.Name(), func() error {
+				return notifier.Notify(alert)
+			})
+			if err != nil {
+				slog.Error("Error sending to notifier", "notifier", notifier.Name(), "err", err)
+			}
+		})
+	}
+}
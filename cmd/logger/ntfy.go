@@ -0,0 +1,102 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type NtfyConfig struct {
+	// URL is the topic URL, e.g. https://ntfy.sh/my-alerts
+	URL string `json:"url"`
+	// Token is an access token, or use username and password
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Priorities maps info, warn, critical and escalated to an ntfy priority,
+	// min, low, default, high or urgent
+	Priorities map[string]string `json:"priorities"`
+	NotifierFilter
+}
+
+var defaultNtfyPriorities = map[string]string{
+	severityInfo:     "low",
+	severityWarn:     "default",
+	severityCritical: "high",
+	"escalated":      "urgent",
+}
+
+var ntfyPriorities = []string{"min", "low", "default", "high", "urgent", "1", "2", "3", "4", "5"}
+
+type ntfyNotifier struct {
+	config NtfyConfig
+	client *http.Client
+}
+
+func newNtfyNotifier(config NtfyConfig) *ntfyNotifier {
+	return &ntfyNotifier{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *ntfyNotifier) Name() string {
+	return "ntfy"
+}
+
+func (n *ntfyNotifier) accepts(alert Alert) bool {
+	return n.config.accepts(alert)
+}
+
+func (n *ntfyNotifier) priority(key string) string {
+	if p, ok := n.config.Priorities[key]; ok {
+		return p
+	}
+	return defaultNtfyPriorities[key]
+}
+
+func (n *ntfyNotifier) Notify(alert Alert) error {
+	key, tag := alert.Severity, "mag"
+	switch {
+	case alert.Escalated:
+		key, tag = "escalated", "rotating_light"
+	case alert.Severity == severityCritical:
+		tag = "red_circle"
+	case alert.Severity == severityWarn:
+		tag = "warning"
+	}
+	return n.publish(alert.Title(), alert.Text(), n.priority(key), tag)
+}
+
+// Alert publishes the logger's own warnings
+func (n *ntfyNotifier) Alert(message string) error {
+	return n.publish("caddy-discord-logger", message, "high", "warning")
+}
+
+func (n *ntfyNotifier) publish(title, message, priority, tag string) error {
+	req, err := http.NewRequest(http.MethodPost, n.config.URL, strings.NewReader(message))
+	if err != nil {
+		return err
+	}
+	// headers can't carry raw utf-8, ntfy decodes the rfc 2047 form
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", title))
+	req.Header.Set("Priority", priority)
+	req.Header.Set("Tags", tag)
+	if n.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.config.Token)
+	} else if n.config.Username != "" {
+		req.SetBasicAuth(n.config.Username, n.config.Password)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return newStatusError("ntfy", resp)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+type OTLPConfig struct {
+	// Endpoint is the OTLP/HTTP base of a collector, e.g.
+	// http://otel-collector:4318, logs go to /v1/logs and metrics to
+	// /v1/metrics
+	Endpoint string `json:"endpoint"`
+	// Headers are sent with every export, e.g. a vendor's api key
+	Headers map[string]string `json:"headers"`
+	// ServiceName defaults to caddy-discord-logger
+	ServiceName string `json:"serviceName"`
+	// Metrics exports the metrics of /metrics every MetricsInterval
+	// (default 60s) besides the requests as logs
+	Metrics         bool        `json:"metrics"`
+	MetricsInterval string      `json:"metricsInterval"`
+	Batch           BatchConfig `json:"batch"`
+}
+
+// processStart is the start of the cumulative metrics
+var processStart = time.Now()
+
+type otlpSink struct {
+	*batch
+	config OTLPConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	exported time.Time
+}
+
+// the OTLP json encoding of the protobuf messages, 64 bit integers are
+// strings there
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string          `json:"timeUnixNano"`
+	ObservedTimeUnixNano string          `json:"observedTimeUnixNano"`
+	SeverityNumber       int             `json:"severityNumber"`
+	SeverityText         string          `json:"severityText"`
+	Body                 otlpValue       `json:"body"`
+	Attributes           []otlpAttribute `json:"attributes"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpMetric struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Sum         *struct {
+		DataPoints             []otlpDataPoint `json:"dataPoints"`
+		AggregationTemporality int             `json:"aggregationTemporality"`
+		IsMonotonic            bool            `json:"isMonotonic"`
+	} `json:"sum,omitempty"`
+	Gauge *struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	} `json:"gauge,omitempty"`
+}
+
+func otlpString(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int) otlpAttribute {
+	s := strconv.Itoa(value)
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: &s}}
+}
+
+func otlpDouble(key string, value float64) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{DoubleValue: &value}}
+}
+
+func otlpNanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func newOTLPSink(config OTLPConfig) *otlpSink {
+	if config.ServiceName == "" {
+		config.ServiceName = "caddy-discord-logger"
+	}
+	s := &otlpSink{
+		config:   config,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		exported: time.Now(),
+	}
+	s.batch = newBatch("otlp", config.Batch, s.exportLogs)
+	return s
+}
+
+func (s *otlpSink) Name() string {
+	return "otlp"
+}
+
+func (s *otlpSink) Send(data parse.Data, raw string) error {
+	if parse.IsAccessLog(data) {
+		s.add(newEvent(currentConfig(), data))
+	}
+	return nil
+}
+
+// flush exports the waiting requests and, once the interval passed, the
+// metrics
+func (s *otlpSink) flush(all bool) {
+	s.batch.flush(all)
+	if !s.config.Metrics {
+		return
+	}
+	s.mu.Lock()
+	due := all || time.Since(s.exported) >= parseDuration(s.config.MetricsInterval, time.Minute)
+	if due {
+		s.exported = time.Now()
+	}
+	s.mu.Unlock()
+	if due {
+		s.exportMetrics(time.Now())
+	}
+}
+
+func (s *otlpSink) resource() otlpResource {
+	return otlpResource{Attributes: []otlpAttribute{otlpString("service.name", s.config.ServiceName)}}
+}
+
+// the otel severity numbers of INFO, WARN and ERROR
+var otlpSeverities = map[string]int{severityInfo: 9, severityWarn: 13, severityCritical: 17}
+
+// logRecord puts a request into the http semantic conventions
+func logRecord(e event) otlpLogRecord {
+	path, query, _ := strings.Cut(e.URI, "?")
+	attributes := []otlpAttribute{
+		otlpString("http.request.method", e.Method),
+		otlpString("server.address", e.Host),
+		otlpString("url.path", path),
+		otlpInt("http.response.status_code", e.Status),
+		otlpString("client.address", e.IP),
+		otlpInt("http.response.body.size", e.Size),
+		otlpDouble("http.server.request.duration", e.Duration),
+	}
+	if query != "" {
+		attributes = append(attributes, otlpString("url.query", query))
+	}
+	if e.UserAgent != "" {
+		attributes = append(attributes, otlpString("user_agent.original", e.UserAgent))
+	}
+	if e.Country != "" {
+		attributes = append(attributes, otlpString("geo.country.iso_code", e.Country))
+	}
+	if e.Proto != "" {
+		attributes = append(attributes, otlpString("network.protocol.version", strings.TrimPrefix(e.Proto, "HTTP/")))
+	}
+	if e.Source != "" {
+		attributes = append(attributes, otlpString("log.file.name", e.Source))
+	}
+	body := e.Method + " " + e.Host + e.URI + " " + strconv.Itoa(e.Status)
+	return otlpLogRecord{
+		TimeUnixNano:         otlpNanos(e.Time),
+		ObservedTimeUnixNano: otlpNanos(time.Now()),
+		SeverityNumber:       otlpSeverities[e.Severity],
+		SeverityText:         strings.ToUpper(e.Severity),
+		Body:                 otlpValue{StringValue: &body},
+		Attributes:           attributes,
+	}
+}
+
+func (s *otlpSink) exportLogs(events []event) error {
+	records := make([]otlpLogRecord, 0, len(events))
+	for _, e := range events {
+		records = append(records, logRecord(e))
+	}
+	body := map[string]interface{}{
+		"resourceLogs": []interface{}{map[string]interface{}{
+			"resource": s.resource(),
+			"scopeLogs": []interface{}{map[string]interface{}{
+				"scope":      otlpScope{Name: "caddy-discord-logger"},
+				"logRecords": records,
+			}},
+		}},
+	}
+	return s.post("/v1/logs", body)
+}
+
+// metricsBody turns the registry into otlp metrics, counters as cumulative
+// sums since the start and everything else as gauges
+func (s *otlpSink) metricsBody(now time.Time) interface{} {
+	var list []otlpMetric
+	byName := map[string]int{}
+	for _, point := range metrics.snapshot() {
+		dp := otlpDataPoint{TimeUnixNano: otlpNanos(now), AsDouble: point.value}
+		for i := 0; i+1 < len(point.labels); i += 2 {
+			dp.Attributes = append(dp.Attributes, otlpString(point.labels[i], point.labels[i+1]))
+		}
+		i, ok := byName[point.name]
+		if !ok {
+			m := otlpMetric{Name: point.name, Description: point.help}
+			if point.kind == "counter" {
+				m.Sum = &struct {
+					DataPoints             []otlpDataPoint `json:"dataPoints"`
+					AggregationTemporality int             `json:"aggregationTemporality"`
+					IsMonotonic            bool            `json:"isMonotonic"`
+				}{AggregationTemporality: 2, IsMonotonic: true}
+			} else {
+				m.Gauge = &struct {
+					DataPoints []otlpDataPoint `json:"dataPoints"`
+				}{}
+			}
+			list = append(list, m)
+			i = len(list) - 1
+			byName[point.name] = i
+		}
+		if list[i].Sum != nil {
+			dp.StartTimeUnixNano = otlpNanos(processStart)
+			list[i].Sum.DataPoints = append(list[i].Sum.DataPoints, dp)
+		} else {
+			list[i].Gauge.DataPoints = append(list[i].Gauge.DataPoints, dp)
+		}
+	}
+	return map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource": s.resource(),
+			"scopeMetrics": []interface{}{map[string]interface{}{
+				"scope":   otlpScope{Name: "caddy-discord-logger"},
+				"metrics": list,
+			}},
+		}},
+	}
+}
+
+// exportMetrics isn't retried, a missed export is covered by the next one
+// since the counters are cumulative
+func (s *otlpSink) exportMetrics(now time.Time) {
+	if err := s.post("/v1/metrics", s.metricsBody(now)); err != nil {
+		slog.Warn("Error exporting metrics", "sink", "otlp", "err", err)
+	}
+}
+
+func (s *otlpSink) post(path string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.config.Endpoint, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return newStatusError("otlp", resp)
+	}
+	return nil
+}
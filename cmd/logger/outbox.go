@@ -0,0 +1,136 @@
+package main
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SenderConfig sizes the workers posting messages, so a slow webhook or
+// output never holds up reading the logs. Applies at startup.
+type SenderConfig struct {
+	// Workers posting in parallel, defaults to 4
+	Workers int `json:"workers"`
+	// Buffer is how many messages can wait per worker before new ones are
+	// dropped, defaults to 256
+	Buffer int `json:"buffer"`
+}
+
+type sendJob struct {
+	name string
+	run  func()
+}
+
+// outbox hands sends to a fixed set of workers. Jobs with the same key
+// always go to the same worker, which keeps the messages of one webhook
+// in order.
+type outbox struct {
+	once    sync.Once
+	lanes   []chan sendJob
+	pending atomic.Int64
+
+	// dedicated outboxes start a worker for every key instead
+	dedicated bool
+	buffer    int
+	mu        sync.Mutex
+	named     map[string]chan sendJob
+}
+
+var senders = &outbox{}
+
+// outputSenders posts to the notifiers and sinks, a worker each, so one that
+// keeps failing and retrying only holds up itself and never a webhook
+var outputSenders = &outbox{dedicated: true}
+
+func init() {
+	metrics.describe("outbox_pending", "gauge", "Messages waiting for a sender worker.")
+	metrics.describe("outbox_dropped_total", "counter", "Messages dropped because the sender workers fell behind.")
+}
+
+func (o *outbox) start(config Config) {
+	workers, buffer := 4, 256
+	if config.Senders != nil {
+		if config.Senders.Workers > 0 {
+			workers = config.Senders.Workers
+		}
+		if config.Senders.Buffer > 0 {
+			buffer = config.Senders.Buffer
+		}
+	}
+
+	o.buffer = buffer
+	if o.dedicated {
+		o.named = map[string]chan sendJob{}
+		return
+	}
+	o.lanes = make([]chan sendJob, workers)
+	for i := range o.lanes {
+		o.lanes[i] = o.work(buffer)
+	}
+}
+
+// work starts a worker and returns its queue
+func (o *outbox) work(buffer int) chan sendJob {
+	lane := make(chan sendJob, buffer)
+	background("sender", func() {
+		for job := range lane {
+			protect(job.name, func() error {
+				job.run()
+				return nil
+			})
+			o.pending.Add(-1)
+			metrics.add("outbox_pending", -1)
+		}
+	})
+	return lane
+}
+
+func (o *outbox) lane(key string) chan sendJob {
+	if o.dedicated {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		lane, ok := o.named[key]
+		if !ok {
+			lane = o.work(o.buffer)
+			o.named[key] = lane
+		}
+		return lane
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return o.lanes[h.Sum32()%uint32(len(o.lanes))]
+}
+
+// submit queues run on the worker for key, dropping it when that worker is
+// too far behind rather than blocking the caller
+func (o *outbox) submit(config Config, name string, key string, run func()) {
+	o.once.Do(func() { o.start(config) })
+
+	lane := o.lane(key)
+
+	// counted up front, the worker may finish it before the send returns
+	o.pending.Add(1)
+	select {
+	case lane <- sendJob{name: name, run: run}:
+		metrics.add("outbox_pending", 1)
+	default:
+		o.pending.Add(-1)
+		metrics.add("outbox_dropped_total", 1, "output", name)
+		slog.Warn("Sender is behind, dropping a message", "sender", name)
+	}
+}
+
+// drain waits up to timeout for the workers to finish what was submitted,
+// for commands that exit once they're done
+func (o *outbox) drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for o.pending.Load() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+
+	"simo.ng/logger/pkg/filter"
+	"simo.ng/logger/pkg/parse"
+)
+
+// outputDiscord stands for the routes, every other output goes by the name
+// of its sink or notifier
+const outputDiscord = "discord"
+
+var outputNames = []string{
+	outputDiscord, "loki", "mqtt", "nats", "kafka", "elasticsearch", "clickhouse", "influx", "s3", "otlp", "ndjson",
+	"matrix", "ntfy", "pushover", "gotify", "teams", "email", "pagerduty", "opsgenie",
+}
+
+// PipelineOutput sends a pipeline's events to one output, limited by its own
+// filters. Empty lists let everything through.
+type PipelineOutput struct {
+	Output     string   `json:"output"`
+	Severities []string `json:"severities"`
+	// statuses as exact codes ("404") or classes ("5xx")
+	Statuses []string `json:"statuses"`
+	Hosts    []string `json:"hosts"`
+}
+
+func (o PipelineOutput) accepts(data parse.Data, severity string) bool {
+	if len(o.Severities) > 0 && !contains(o.Severities, severity) {
+		return false
+	}
+	if len(o.Statuses) > 0 && !contains(o.Statuses, fmt.Sprint(data.Status)) && !contains(o.Statuses, fmt.Sprintf("%dxx", data.Status/100)) {
+		return false
+	}
+	return len(o.Hosts) == 0 || filter.MatchHost(o.Hosts, data.Request.Host)
+}
+
+// pipelineOutputs are the outputs a pipeline picked, nil when it didn't and
+// everything gets its events
+func pipelineOutputs(config Config, pipeline string) []PipelineOutput {
+	for _, p := range config.Pipelines {
+		if p.Name == pipeline {
+			return p.Outputs
+		}
+	}
+	return nil
+}
+
+// withoutOutputs copies the pipelines without their outputs, the part of
+// them that needs a restart
+func withoutOutputs(list []Pipeline) []Pipeline {
+	stripped := make([]Pipeline, len(list))
+	for i, p := range list {
+		p.Outputs = nil
+		stripped[i] = p
+	}
+	return stripped
+}
+
+// sendsTo tells whether an event goes to the output, any entry for it that
+// accepts the event will do
+func sendsTo(outputs []PipelineOutput, output string, data parse.Data, severity string) bool {
+	if outputs == nil {
+		return true
+	}
+	for _, o := range outputs {
+		if o.Output == output && o.accepts(data, severity) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+// pager is implemented by notifiers that open incidents on an on-call
+// service. They get the escalations as alerts, and the incidents of the
+// incident monitor opening and resolving.
+type pager interface {
+	Page(update incidentUpdate) error
+}
+
+func pageAll(config Config, update incidentUpdate) {
+	if dryRun {
+		return
+	}
+	configMu.RLock()
+	current := notifiers
+	configMu.RUnlock()
+
+	for _, notifier := range current {
+		p, ok := notifier.(pager)
+		if !ok {
+			continue
+		}
+		name := notifier.Name()
+		outputSenders.submit(config, name, name, func() {
+			err := deliver(name, func() error {
+				return p.Page(update)
+			})
+			if err != nil {
+				slog.Error("Error paging", "notifier", name, "err", err)
+			}
+		})
+	}
+}
+
+// postJSON is the request every pager makes
+func postJSON(client *http.Client, service, endpoint string, header http.Header, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return newStatusError(service, resp)
+	}
+	return nil
+}
+
+const pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+type PagerDutyConfig struct {
+	// RoutingKey is the integration key of an Events API v2 integration
+	RoutingKey string `json:"routingKey"`
+	NotifierFilter
+}
+
+type pagerDutyNotifier struct {
+	config PagerDutyConfig
+	client *http.Client
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     string            `json:"timestamp,omitempty"`
+	Component     string            `json:"component,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+func newPagerDutyNotifier(config PagerDutyConfig) *pagerDutyNotifier {
+	return &pagerDutyNotifier{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (p *pagerDutyNotifier) Name() string {
+	return "pagerduty"
+}
+
+// accepts only escalations, everything else would page for single requests
+func (p *pagerDutyNotifier) accepts(alert Alert) bool {
+	return alert.Escalated && p.config.accepts(alert)
+}
+
+// Notify triggers an incident for the escalation rule, further hits of the
+// same rule are grouped into it until it's resolved on pagerduty
+func (p *pagerDutyNotifier) Notify(alert Alert) error {
+	return p.send(pagerDutyEvent{
+		EventAction: "trigger",
+		DedupKey:    alert.incidentKey(),
+		Payload: &pagerDutyPayload{
+			Summary:   notify.Truncate(alert.Reason+": "+alert.Title(), 1024),
+			Source:    alert.Data.Request.Host,
+			Severity:  "critical",
+			Timestamp: alert.Data.Ts.Time().UTC().Format(time.RFC3339),
+			Component: alert.Data.Request.URI,
+			CustomDetails: map[string]string{
+				"request": alert.Text(),
+				"ip":      clientIP(alert.Data),
+			},
+		},
+	})
+}
+
+func (p *pagerDutyNotifier) Page(update incidentUpdate) error {
+	event := pagerDutyEvent{EventAction: "resolve", DedupKey: update.Key}
+	if !update.Resolved {
+		event.EventAction = "trigger"
+		event.Payload = &pagerDutyPayload{
+			Summary:  notify.Truncate(update.Summary(), 1024),
+			Source:   "caddy-discord-logger",
+			Severity: "critical",
+		}
+	}
+	return p.send(event)
+}
+
+func (p *pagerDutyNotifier) send(event pagerDutyEvent) error {
+	event.RoutingKey = p.config.RoutingKey
+	return postJSON(p.client, "pagerduty", pagerDutyURL, nil, event)
+}
+
+type OpsgenieConfig struct {
+	APIKey string `json:"apiKey"`
+	// APIURL defaults to https://api.opsgenie.com, EU accounts use
+	// https://api.eu.opsgenie.com
+	APIURL string `json:"apiUrl"`
+	// Priorities maps the severity of escalations to P1 to P5, critical is
+	// P1 unless set. Incidents are always P1.
+	Priorities map[string]string `json:"priorities"`
+	NotifierFilter
+}
+
+var opsgeniePriorities = []string{"P1", "P2", "P3", "P4", "P5"}
+
+type opsgenieNotifier struct {
+	config OpsgenieConfig
+	client *http.Client
+}
+
+type opsgenieAlert struct {
+	Message     string            `json:"message"`
+	Alias       string            `json:"alias"`
+	Description string            `json:"description,omitempty"`
+	Priority    string            `json:"priority"`
+	Source      string            `json:"source"`
+	Tags        []string          `json:"tags,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+}
+
+func newOpsgenieNotifier(config OpsgenieConfig) *opsgenieNotifier {
+	if config.APIURL == "" {
+		config.APIURL = "https://api.opsgenie.com"
+	}
+	return &opsgenieNotifier{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (o *opsgenieNotifier) Name() string {
+	return "opsgenie"
+}
+
+func (o *opsgenieNotifier) accepts(alert Alert) bool {
+	return alert.Escalated && o.config.accepts(alert)
+}
+
+func (o *opsgenieNotifier) header() http.Header {
+	return http.Header{"Authorization": {"GenieKey " + o.config.APIKey}}
+}
+
+// Notify creates an alert for the escalation rule, opsgenie counts further
+// hits with the same alias on the open alert
+func (o *opsgenieNotifier) Notify(alert Alert) error {
+	priority := o.config.Priorities[alert.Severity]
+	if priority == "" {
+		priority = "P1"
+	}
+	return postJSON(o.client, "opsgenie", strings.TrimSuffix(o.config.APIURL, "/")+"/v2/alerts", o.header(), opsgenieAlert{
+		Message:     notify.Truncate(alert.Reason, 130),
+		Alias:       notify.Truncate(alert.incidentKey(), 512),
+		Description: notify.Truncate(alert.Title()+"\n"+alert.Text(), 15000),
+		Priority:    priority,
+		Source:      "caddy-discord-logger",
+		Tags:        []string{"caddy", alert.Data.Request.Host},
+		Details:     map[string]string{"host": alert.Data.Request.Host, "ip": clientIP(alert.Data)},
+	})
+}
+
+func (o *opsgenieNotifier) Page(update incidentUpdate) error {
+	base := strings.TrimSuffix(o.config.APIURL, "/") + "/v2/alerts"
+	if update.Resolved {
+		endpoint := base + "/" + url.PathEscape(update.Key) + "/close?identifierType=alias"
+		return postJSON(o.client, "opsgenie", endpoint, o.header(), map[string]string{
+			"source": "caddy-discord-logger",
+			"note":   update.Summary(),
+		})
+	}
+	return postJSON(o.client, "opsgenie", base, o.header(), opsgenieAlert{
+		Message:     notify.Truncate(update.Title, 130),
+		Alias:       update.Key,
+		Description: update.Summary(),
+		Priority:    "P1",
+		Source:      "caddy-discord-logger",
+		Tags:        []string{"caddy"},
+	})
+}
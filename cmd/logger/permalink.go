@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// permalinks are opened from discord where no bearer token can be sent, so
+// they carry a signature made with the control token instead
+func eventSignature(token, id string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:12])
+}
+
+func permalink(cfg ControlConfig, id string) string {
+	link := strings.TrimSuffix(cfg.PublicURL, "/") + "/events/" + url.PathEscape(id)
+	if cfg.Token != "" {
+		link += "?sig=" + eventSignature(cfg.Token, id)
+	}
+	return link
+}
+
+// allowPermalinks lets correctly signed event links past the token check
+func allowPermalinks(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := strings.CutPrefix(r.URL.Path, "/events/"); ok && token != "" {
+			sig := r.URL.Query().Get("sig")
+			if sig != "" && hmac.Equal([]byte(sig), []byte(eventSignature(token, id))) {
+				eventHandler(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+var eventPage = template.Must(template.New("event").Parse(`<!doctype html>
+<html>
+<head><meta charset="utf-8"><title>{{.Line}}</title>
+<style>body{font-family:sans-serif;margin:2em}pre{background:#f4f4f4;padding:1em;overflow-x:auto}</style>
+</head>
+<body>
+<h1>{{.Line}}</h1>
+<p>Received {{.At}}</p>
+<pre>{{.JSON}}</pre>
+</body>
+</html>
+`))
+
+// eventHandler shows a stored event, as json with ?format=json
+func eventHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/events/")
+	event, ok := events.get(id)
+	if !ok {
+		http.Error(w, "event not found, it may have been evicted from the store", http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(event.Raw))
+		return
+	}
+
+	// re-indent the raw line so every header is readable
+	pretty := event.Raw
+	var v interface{}
+	if json.Unmarshal([]byte(event.Raw), &v) == nil {
+		if b, err := json.MarshalIndent(v, "", "  "); err == nil {
+			pretty = string(b)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := eventPage.Execute(w, map[string]string{
+		"Line": eventLine(event.Data),
+		"At":   event.At.Format("2006-01-02 15:04:05 MST"),
+		"JSON": pretty,
+	})
+	if err != nil {
+		slog.Error("Error rendering event", "err", err)
+	}
+}
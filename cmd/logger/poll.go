@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// poller notices log files that grew without a write event, which is what
+// happens on NFS, SSHFS and some docker desktop mounts where inotify events
+// never fire. With working events it finds nothing to do.
+type poller struct {
+	targets []string
+	sizes   map[string]int64
+	evented map[string]bool
+	warned  bool
+}
+
+func newPoller(targets []string) *poller {
+	p := &poller{targets: targets, sizes: map[string]int64{}, evented: map[string]bool{}}
+	p.changed()
+	return p
+}
+
+// pollInterval is 2s by default, "off" disables polling
+func pollInterval(config Config) (time.Duration, bool) {
+	if config.Poll == "off" {
+		return 0, false
+	}
+	return parseDuration(config.Poll, 2*time.Second), true
+}
+
+// changed returns the paths of the files whose size changed since the last
+// poll
+func (p *poller) changed() []string {
+	var found []string
+	check := func(file string, info os.FileInfo) {
+		if info.IsDir() || !matchesLogPattern(currentConfig(), filepath.Base(file)) {
+			return
+		}
+		if last, ok := p.sizes[file]; ok && last != info.Size() {
+			found = append(found, file)
+		}
+		p.sizes[file] = info.Size()
+	}
+	for _, target := range p.targets {
+		info, err := os.Stat(target)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			check(target, info)
+			continue
+		}
+		entries, err := os.ReadDir(target)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil {
+				check(filepath.Join(target, entry.Name()), info)
+			}
+		}
+	}
+	return found
+}
+
+// files returns the log files the targets held at the last poll
+func (p *poller) files() []string {
+	files := make([]string, 0, len(p.sizes))
+	for file := range p.sizes {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// missed returns files that changed without an event since the last poll
+func (p *poller) missed() []string {
+	var missed []string
+	for _, file := range p.changed() {
+		if !p.evented[file] {
+			missed = append(missed, file)
+		}
+	}
+	p.evented = map[string]bool{}
+	if len(missed) > 0 && !p.warned {
+		p.warned = true
+		slog.Warn("Log files change without file events, falling back to polling")
+	}
+	return missed
+}
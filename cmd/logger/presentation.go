@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"simo.ng/logger/pkg/parse"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+const presentationAccessible = "accessible"
+
+// the most characters discord takes in an embed description
+const maxEmbedDescription = 4096
+
+// accessible routes never convey meaning through emoji or color alone, every
+// message starts with the same bracketed text labels instead
+func (r Route) accessible() bool {
+	return r.Presentation == presentationAccessible
+}
+
+// header is the line shown above the code block of a message
+func (r Route) header(data parse.Data, severity, country string) string {
+	if !r.accessible() {
+		return r.Emoji.header(data, country)
+	}
+	parts := []string{
+		"[" + strings.ToUpper(severity) + "]",
+		notify.EscapeMarkdown(strings.ToUpper(data.Request.Method)),
+		fmt.Sprint(data.Status),
+	}
+	if text := http.StatusText(data.Status); text != "" {
+		parts = append(parts, text)
+	}
+	if country != "" {
+		parts = append(parts, "country "+country)
+	}
+	return strings.Join(parts, " · ")
+}
+
+// mark picks between an emoji and its text label for the route
+func (r Route) mark(emoji, label string) string {
+	if r.accessible() {
+		return "[" + label + "]"
+	}
+	return emoji
+}
+
+// present moves the content of an embed route into an embed colored by the
+// status. Whatever is added to the content later, like the mentions of an
+// escalation, stays above it.
+func (r Route) present(message notify.Message, data parse.Data) notify.Message {
+	if !r.Embed {
+		return message
+	}
+	message.Embeds = append([]notify.Embed{{
+		Description: notify.Truncate(message.Content, maxEmbedDescription),
+		Color:       r.Emoji.color(data.Status),
+	}}, message.Embeds...)
+	message.Content = ""
+	return message
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+// PrivacyConfig anonymizes client addresses and drops headers from every
+// line before it is stored, posted or sent to an output
+type PrivacyConfig struct {
+	// IP is "truncate" to zero the host part (/24 for IPv4, /48 for IPv6)
+	// or "hash" to replace the address with a keyed hash
+	IP string `json:"ip"`
+	// Salt keys the hash, so addresses can't be recovered by hashing all
+	// of them
+	Salt string `json:"salt"`
+	// Headers are removed from the request and response headers
+	Headers []string `json:"headers"`
+}
+
+const (
+	privacyTruncate = "truncate"
+	privacyHash     = "hash"
+)
+
+// headers that carry client addresses, anonymized along with remote_ip
+var addressHeaders = []string{"Cf-Connecting-Ip", "X-Forwarded-For", "X-Real-Ip", "True-Client-Ip"}
+
+func (p PrivacyConfig) anonymize(ip string) string {
+	ip = strings.TrimSpace(ip)
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+
+	switch p.IP {
+	case privacyTruncate:
+		bits := 24
+		if addr.Is6() {
+			bits = 48
+		}
+		prefix, _ := addr.Prefix(bits)
+		return prefix.Addr().String()
+	case privacyHash:
+		mac := hmac.New(sha256.New, []byte(p.Salt))
+		mac.Write([]byte(addr.String()))
+		return "anon-" + hex.EncodeToString(mac.Sum(nil))[:12]
+	}
+	return ip
+}
+
+func (p PrivacyConfig) stripped(name string) bool {
+	for _, header := range p.Headers {
+		if strings.EqualFold(header, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactLine applies the privacy settings to a raw log line. Lines that
+// aren't json objects are returned as they are, the parser reports those.
+func redactLine(config Config, line string) string {
+	p := config.Privacy
+	if p == nil || (p.IP == "" && len(p.Headers) == 0) {
+		return line
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(line))
+	// numbers stay as written, a float64 round trip would change ts
+	decoder.UseNumber()
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return line
+	}
+
+	if request, ok := doc["request"].(map[string]interface{}); ok {
+		if p.IP != "" {
+			// the visitor is resolved before anything is anonymized, the
+			// trusted proxies only match the real addresses
+			if data, err := parse.Line("", line); err == nil {
+				if ip := resolveClientIP(data); ip != "" {
+					request["client_ip"] = ip
+				}
+			}
+			for _, field := range []string{"remote_ip", "client_ip"} {
+				if ip, ok := request[field].(string); ok {
+					request[field] = p.anonymize(ip)
+				}
+			}
+		}
+		if headers, ok := request["headers"].(map[string]interface{}); ok {
+			p.redactHeaders(headers)
+		}
+	}
+	if headers, ok := doc["resp_headers"].(map[string]interface{}); ok {
+		p.redactHeaders(headers)
+	}
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(doc); err != nil {
+		return line
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+func (p PrivacyConfig) redactHeaders(headers map[string]interface{}) {
+	for name, values := range headers {
+		if p.stripped(name) {
+			delete(headers, name)
+			continue
+		}
+		if p.IP == "" || !contains(addressHeaders, http.CanonicalHeaderKey(name)) {
+			continue
+		}
+		list, ok := values.([]interface{})
+		if !ok {
+			continue
+		}
+		for i, value := range list {
+			s, ok := value.(string)
+			if !ok {
+				continue
+			}
+			// X-Forwarded-For is a comma separated chain
+			hops := strings.Split(s, ",")
+			for j, hop := range hops {
+				hops[j] = p.anonymize(hop)
+			}
+			list[i] = strings.Join(hops, ", ")
+		}
+	}
+}
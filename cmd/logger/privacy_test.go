@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+func TestRedactBehindProxy(t *testing.T) {
+	trustedProxies.Store(parseProxies([]string{"10.0.0.1"}))
+	anonymized.Store(true)
+	defer func() {
+		trustedProxies.Store(parseProxies(nil))
+		anonymized.Store(false)
+	}()
+
+	line := `{"request":{"remote_ip":"10.0.0.1","client_ip":"10.0.0.1","headers":{"X-Forwarded-For":["203.0.113.9, 10.0.0.1"]}},"status":200}`
+	for _, p := range []PrivacyConfig{{IP: privacyTruncate}, {IP: privacyHash, Salt: "pepper"}} {
+		config := Config{Privacy: &p}
+		data, err := parse.Line("", redactLine(config, line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := clientIP(data), p.anonymize("203.0.113.9"); got != want {
+			t.Errorf("%s: client is %s, want %s", p.IP, got, want)
+		}
+		if got, want := data.Request.RemoteIP, p.anonymize("10.0.0.1"); got != want {
+			t.Errorf("%s: remote_ip is %s, want %s", p.IP, got, want)
+		}
+		if got, want := data.Request.Headers.XForwardedFor[0], p.anonymize("203.0.113.9")+", "+p.anonymize("10.0.0.1"); got != want {
+			t.Errorf("%s: X-Forwarded-For is %s, want %s", p.IP, got, want)
+		}
+	}
+}
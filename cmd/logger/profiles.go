@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+const (
+	maxProfiles          = 10000
+	maxProfilePaths      = 50
+	maxProfileUserAgents = 10
+	// recent hits cover the history context shown with alerts
+	maxRecentHits = 500
+	recentWindow  = 24 * time.Hour
+	// profiles of addresses not seen for this long are dropped
+	profileRetention = 7 * 24 * time.Hour
+)
+
+// ipProfile sums up how one client behaved over time, the context a single
+// log line lacks
+type ipProfile struct {
+	First      time.Time      `json:"first"`
+	Last       time.Time      `json:"last"`
+	Requests   int            `json:"requests"`
+	Errors     int            `json:"errors"`
+	Paths      map[string]int `json:"paths"`
+	UserAgents map[string]int `json:"userAgents"`
+	Recent     []profileHit   `json:"recent"`
+}
+
+type profileHit struct {
+	At   time.Time `json:"at"`
+	Path string    `json:"path"`
+}
+
+// HistoryConfig adds what the client did recently to alerts
+type HistoryConfig struct {
+	// Window looked back on, defaults to 1h and can't exceed 24h
+	Window string `json:"window"`
+	// Paths is how many of the most requested paths are listed, default 3
+	Paths int `json:"paths"`
+	// Severities that get the history line, defaults to warn and critical
+	Severities []string `json:"severities"`
+}
+
+// line renders the history of ip for an alert of severity, empty when the
+// severity doesn't call for it
+func (h HistoryConfig) line(ip string, severity string, now time.Time) string {
+	severities := h.Severities
+	if len(severities) == 0 {
+		severities = []string{severityWarn, severityCritical}
+	}
+	if !contains(severities, severity) {
+		return ""
+	}
+	p, ok := profiles.get(ip)
+	if !ok {
+		return ""
+	}
+	window := parseDuration(h.Window, time.Hour)
+	if window <= 0 || window > recentWindow {
+		window = time.Hour
+	}
+	paths := h.Paths
+	if paths <= 0 {
+		paths = 3
+	}
+	return p.History(window, paths, now)
+}
+
+type profileStore struct {
+	mu       sync.Mutex
+	profiles map[string]*ipProfile
+}
+
+var profiles = &profileStore{profiles: map[string]*ipProfile{}}
+
+func init() {
+	botCommands["profile"] = botCommand{
+		definition: map[string]interface{}{
+			"name":        "profile",
+			"description": "Show how an IP address behaved so far",
+			"options": []map[string]interface{}{
+				{"type": 3, "name": "ip", "description": "The client address", "required": true},
+			},
+		},
+		handle: profileCommand,
+	}
+}
+
+func (s *profileStore) record(data parse.Data) {
+	ip := clientIP(data)
+	if ip == "" {
+		return
+	}
+	at := data.Ts.Time()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.profiles[ip]
+	if p == nil {
+		if len(s.profiles) >= maxProfiles {
+			s.evict()
+		}
+		p = &ipProfile{First: at, Paths: map[string]int{}, UserAgents: map[string]int{}}
+		s.profiles[ip] = p
+	}
+	if at.After(p.Last) {
+		p.Last = at
+	}
+	p.Requests++
+	if data.Status >= 400 {
+		p.Errors++
+	}
+	// similar paths count as one so /post/1 and /post/2 aren't diverse
+	path := dedupPath(data.Request.URI, true)
+	if _, ok := p.Paths[path]; ok || len(p.Paths) < maxProfilePaths {
+		p.Paths[path]++
+	}
+	if len(data.Request.Headers.UserAgent) > 0 {
+		ua := data.Request.Headers.UserAgent[0]
+		if _, ok := p.UserAgents[ua]; ok || len(p.UserAgents) < maxProfileUserAgents {
+			p.UserAgents[ua]++
+		}
+	}
+
+	p.Recent = append(p.Recent, profileHit{At: at, Path: path})
+	drop := 0
+	for drop < len(p.Recent) && (len(p.Recent)-drop > maxRecentHits || at.Sub(p.Recent[drop].At) > recentWindow) {
+		drop++
+	}
+	p.Recent = p.Recent[drop:]
+}
+
+// evict drops the tenth of the profiles seen least recently, callers hold
+// the lock
+func (s *profileStore) evict() {
+	ips := make([]string, 0, len(s.profiles))
+	for ip := range s.profiles {
+		ips = append(ips, ip)
+	}
+	sort.Slice(ips, func(i, j int) bool {
+		return s.profiles[ips[i]].Last.Before(s.profiles[ips[j]].Last)
+	})
+	for _, ip := range ips[:len(ips)/10+1] {
+		delete(s.profiles, ip)
+	}
+}
+
+func (s *profileStore) get(ip string) (ipProfile, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.profiles[ip]
+	if !ok {
+		return ipProfile{}, false
+	}
+	c := *p
+	c.Recent = append([]profileHit(nil), p.Recent...)
+	return c, true
+}
+
+// snapshot copies the profiles for the runtime state, dropping stale ones
+func (s *profileStore) snapshot(now time.Time) map[string]ipProfile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := make(map[string]ipProfile, len(s.profiles))
+	for ip, p := range s.profiles {
+		if now.Sub(p.Last) > profileRetention {
+			delete(s.profiles, ip)
+			continue
+		}
+		c := *p
+		c.Paths = copyCounts(p.Paths)
+		c.UserAgents = copyCounts(p.UserAgents)
+		c.Recent = append([]profileHit(nil), p.Recent...)
+		copied[ip] = c
+	}
+	return copied
+}
+
+func (s *profileStore) restore(saved map[string]ipProfile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ip, p := range saved {
+		p := p
+		p.Paths = nonNil(p.Paths)
+		p.UserAgents = nonNil(p.UserAgents)
+		s.profiles[ip] = &p
+	}
+}
+
+// rate is requests per minute over the time the address was active
+func (p ipProfile) rate() float64 {
+	active := p.Last.Sub(p.First)
+	if active < time.Minute {
+		active = time.Minute
+	}
+	return float64(p.Requests) / active.Minutes()
+}
+
+func (p ipProfile) paths() string {
+	if len(p.Paths) >= maxProfilePaths {
+		return fmt.Sprintf("%d+ distinct paths", maxProfilePaths)
+	}
+	return formatCount(len(p.Paths), "distinct path")
+}
+
+func (p ipProfile) userAgents() string {
+	switch n := len(p.UserAgents); {
+	case n == 1:
+		return "1 user agent (stable)"
+	case n >= maxProfileUserAgents:
+		return fmt.Sprintf("%d+ user agents (rotating)", maxProfileUserAgents)
+	default:
+		return formatCount(n, "user agent")
+	}
+}
+
+// Summary is the one line shown with security alerts
+func (p ipProfile) Summary() string {
+	return fmt.Sprintf("%s since %s (%.1f/min), %s, %.0f%% errors, %s",
+		formatCount(p.Requests, "request"), p.First.Format("2006-01-02 15:04"), p.rate(),
+		p.paths(), 100*float64(p.Errors)/float64(p.Requests), p.userAgents())
+}
+
+// History is the context line added to alerts: what the address did within
+// window before this request
+func (p ipProfile) History(window time.Duration, paths int, now time.Time) string {
+	counts := map[string]int{}
+	requests := 0
+	for _, hit := range p.Recent {
+		if now.Sub(hit.At) <= window {
+			requests++
+			counts[hit.Path]++
+		}
+	}
+
+	var top []string
+	for _, path := range topN(counts, paths) {
+		entry := notify.EscapeMarkdown(notify.Truncate(path.key, 60))
+		if path.count > 1 {
+			entry += fmt.Sprintf(" ×%d", path.count)
+		}
+		top = append(top, entry)
+	}
+	line := formatCount(requests, "request") + " in the last " + formatWindow(window)
+	if len(top) > 0 {
+		line += " (" + strings.Join(top, ", ") + ")"
+	}
+	return line + ", first seen " + p.First.Format("2006-01-02 15:04")
+}
+
+// formatWindow drops the zero units time.Duration prints, 1h instead of 1h0m0s
+func formatWindow(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+func profileCommand(cfg BotConfig, i interaction) interactionResponse {
+	ip := strings.TrimSpace(i.stringOption("ip"))
+	p, ok := profiles.get(ip)
+	if !ok {
+		return reply("No requests from " + ip + " seen in the last 7 days")
+	}
+
+	lines := []string{
+		"Address:     " + ip,
+		"First seen:  " + p.First.Format("2006-01-02 15:04:05"),
+		"Last seen:   " + p.Last.Format("2006-01-02 15:04:05"),
+		fmt.Sprintf("Requests:    %d (%.1f/min while active)", p.Requests, p.rate()),
+		fmt.Sprintf("Errors:      %d (%.0f%%)", p.Errors, 100*float64(p.Errors)/float64(p.Requests)),
+		"Paths:       " + p.paths(),
+		"User agents: " + p.userAgents(),
+	}
+	for _, path := range topN(p.Paths, 5) {
+		lines = append(lines, fmt.Sprintf("  %5d  %s", path.count, notify.Truncate(path.key, 60)))
+	}
+	return reply(codeBlock(lines))
+}
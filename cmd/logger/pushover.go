@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+const pushoverURL = "https://api.pushover.net/1/messages.json"
+
+type PushoverConfig struct {
+	// Token is the application token, User the user or group key
+	Token string `json:"token"`
+	User  string `json:"user"`
+	// Device limits the alerts to some of the user's devices
+	Device string `json:"device"`
+	// Priorities maps info, warn, critical and escalated to a pushover
+	// priority from -2 to 2. 2 is an emergency that bypasses quiet hours
+	// and repeats every retry until acknowledged or expired.
+	Priorities map[string]int    `json:"priorities"`
+	Sounds     map[string]string `json:"sounds"`
+	Retry      string            `json:"retry"`
+	Expire     string            `json:"expire"`
+	NotifierFilter
+}
+
+var defaultPushoverPriorities = map[string]int{
+	severityInfo:     -1,
+	severityWarn:     0,
+	severityCritical: 1,
+	"escalated":      1,
+}
+
+type pushoverNotifier struct {
+	config PushoverConfig
+	client *http.Client
+}
+
+func newPushoverNotifier(config PushoverConfig) *pushoverNotifier {
+	return &pushoverNotifier{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *pushoverNotifier) Name() string {
+	return "pushover"
+}
+
+func (p *pushoverNotifier) accepts(alert Alert) bool {
+	return p.config.accepts(alert)
+}
+
+func (p *pushoverNotifier) Notify(alert Alert) error {
+	key := alert.Severity
+	if alert.Escalated {
+		key = "escalated"
+	}
+	priority, ok := p.config.Priorities[key]
+	if !ok {
+		priority = defaultPushoverPriorities[key]
+	}
+	return p.push(alert.Title(), alert.Text(), priority, p.config.Sounds[key])
+}
+
+// Alert pushes the logger's own warnings
+func (p *pushoverNotifier) Alert(message string) error {
+	return p.push("caddy-discord-logger", message, 0, "")
+}
+
+func (p *pushoverNotifier) push(title, message string, priority int, sound string) error {
+	form := url.Values{
+		"token":    {p.config.Token},
+		"user":     {p.config.User},
+		"title":    {notify.Truncate(title, 250)},
+		"message":  {notify.Truncate(message, 1024)},
+		"priority": {strconv.Itoa(priority)},
+	}
+	if p.config.Device != "" {
+		form.Set("device", p.config.Device)
+	}
+	if sound != "" {
+		form.Set("sound", sound)
+	}
+	// emergency alerts must say how often to repeat and for how long
+	if priority == 2 {
+		retry := parseDuration(p.config.Retry, time.Minute)
+		expire := parseDuration(p.config.Expire, time.Hour)
+		form.Set("retry", strconv.Itoa(int(retry.Seconds())))
+		form.Set("expire", strconv.Itoa(int(expire.Seconds())))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, pushoverURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return newStatusError("pushover", resp)
+	}
+	return nil
+}
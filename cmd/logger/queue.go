@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+// QueueConfig keeps messages discord couldn't take on disk and delivers them
+// once it is reachable again
+type QueueConfig struct {
+	// MaxSize is the most messages kept, the oldest are dropped beyond it.
+	// Defaults to 1000.
+	MaxSize int `json:"maxSize"`
+	// MaxAge drops messages that waited longer than this, defaults to 24h
+	MaxAge string `json:"maxAge"`
+	// Interval between delivery attempts, defaults to 30s
+	Interval string `json:"interval"`
+}
+
+// queuedMessage is one line of the queue file
+type queuedMessage struct {
+	Seq        uint64         `json:"seq"`
+	Queued     time.Time      `json:"queued"`
+	WebhookURL string         `json:"webhookUrl"`
+	Forum      bool           `json:"forum,omitempty"`
+	Host       string         `json:"host,omitempty"`
+	Message    notify.Message `json:"message"`
+	// Files are kept apart since notify.Message doesn't marshal them
+	Files []notify.Attachment `json:"files,omitempty"`
+}
+
+// post delivers the message once without queueing it again
+func (m queuedMessage) post() error {
+	message := m.Message
+	message.Files = m.Files
+	if m.Forum {
+		return forums.post(currentConfig(), m.WebhookURL, m.Host, message)
+	}
+	return deliver("discord", func() error {
+		return postWebhook(m.WebhookURL, message)
+	})
+}
+
+// deliveryQueue is an append only file of pending messages, rewritten
+// whenever messages leave it
+type deliveryQueue struct {
+	mu      sync.Mutex
+	loaded  bool
+	pending []queuedMessage
+	seq     uint64
+}
+
+var queue = &deliveryQueue{}
+
+func init() {
+	metrics.describe("queue_depth", "gauge", "Messages waiting in the delivery queue.")
+	metrics.describe("queue_dropped_total", "counter", "Queued messages dropped for age or size.")
+}
+
+// queueable errors are the ones that might go away by waiting
+func queueable(err error) bool {
+	if errors.Is(err, errWebhookPaused) {
+		return false
+	}
+	switch classifyError(err) {
+	case classNetwork, classRateLimit, classUnknown:
+		return true
+	}
+	return false
+}
+
+// send posts the message, or queues it when discord can't take it. Messages
+// for a webhook that still has a backlog queue up behind it, so they arrive
+// in order.
+func (q *deliveryQueue) send(config Config, item queuedMessage) error {
+	// a dry run leaves the backlog of the real one alone
+	if config.Queue == nil || dryRun {
+		return item.post()
+	}
+
+	if q.waiting(config, item.WebhookURL) {
+		return q.push(config, item)
+	}
+	err := item.post()
+	if err == nil || !queueable(err) {
+		return err
+	}
+	slog.Warn("Discord unavailable, queueing message", "err", err)
+	return q.push(config, item)
+}
+
+func (q *deliveryQueue) load(config Config) {
+	if q.loaded {
+		return
+	}
+	q.loaded = true
+
+	file, err := os.Open(statePath(config, "queue.jsonl"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("Error reading delivery queue", "err", err)
+		}
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 32*1024*1024)
+	for scanner.Scan() {
+		var item queuedMessage
+		// a line cut short by a crash is the only one that can be broken
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			slog.Warn("Skipping broken queue entry", "err", err)
+			continue
+		}
+		q.pending = append(q.pending, item)
+		if item.Seq > q.seq {
+			q.seq = item.Seq
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		slog.Error("Error reading delivery queue", "err", err)
+	}
+	metrics.set("queue_depth", float64(len(q.pending)))
+}
+
+func (q *deliveryQueue) waiting(config Config, webhookUrl string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.load(config)
+	for _, item := range q.pending {
+		if item.WebhookURL == webhookUrl {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *deliveryQueue) push(config Config, item queuedMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.load(config)
+
+	q.seq++
+	item.Seq = q.seq
+	item.Queued = time.Now()
+	item.Files = item.Message.Files
+	q.pending = append(q.pending, item)
+
+	maxSize := config.Queue.MaxSize
+	if maxSize <= 0 {
+		maxSize = 1000
+	}
+	if len(q.pending) > maxSize {
+		dropped := len(q.pending) - maxSize
+		q.pending = q.pending[dropped:]
+		metrics.add("queue_dropped_total", float64(dropped))
+		slog.Warn("Delivery queue full, dropped the oldest messages", "dropped", dropped)
+		metrics.set("queue_depth", float64(len(q.pending)))
+		return q.rewrite(config)
+	}
+	metrics.set("queue_depth", float64(len(q.pending)))
+	return q.append(config, item)
+}
+
+func (q *deliveryQueue) append(config Config, item queuedMessage) error {
+	line, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(statePath(config, "queue.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// rewrite replaces the file with what is still pending
+func (q *deliveryQueue) rewrite(config Config) error {
+	path := statePath(config, "queue.jsonl")
+	if len(q.pending) == 0 {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, item := range q.pending {
+		if err := encoder.Encode(item); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// flush delivers queued messages oldest first. A webhook that fails again
+// keeps the rest of its messages for the next round. Posting happens outside
+// the lock so new messages can still be queued meanwhile.
+func (q *deliveryQueue) flush(config Config, now time.Time) {
+	q.mu.Lock()
+	q.load(config)
+	batch := append([]queuedMessage(nil), q.pending...)
+	q.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	maxAge := parseDuration(config.Queue.MaxAge, 24*time.Hour)
+	failed := map[string]bool{}
+	done := map[uint64]bool{}
+	delivered, expired := 0, 0
+	for _, item := range batch {
+		switch {
+		case now.Sub(item.Queued) > maxAge:
+			expired++
+			done[item.Seq] = true
+		case failed[item.WebhookURL]:
+		default:
+			err := item.post()
+			if err != nil && queueable(err) {
+				failed[item.WebhookURL] = true
+				continue
+			}
+			if err != nil {
+				slog.Warn("Dropping queued message", "err", err)
+			} else {
+				delivered++
+			}
+			done[item.Seq] = true
+		}
+	}
+	if len(done) == 0 {
+		return
+	}
+
+	if expired > 0 {
+		metrics.add("queue_dropped_total", float64(expired))
+		slog.Warn("Dropped expired queued messages", "dropped", expired, "maxAge", maxAge.String())
+	}
+	if delivered > 0 {
+		slog.Info("Delivered queued messages", "delivered", delivered)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var kept []queuedMessage
+	for _, item := range q.pending {
+		if !done[item.Seq] {
+			kept = append(kept, item)
+		}
+	}
+	q.pending = kept
+	metrics.set("queue_depth", float64(len(q.pending)))
+	if err := q.rewrite(config); err != nil {
+		slog.Error("Error saving delivery queue", "err", err)
+	}
+}
+
+func (q *deliveryQueue) run() {
+	for {
+		config := currentConfig()
+		interval := 30 * time.Second
+		if config.Queue != nil && !dryRun {
+			if d := parseDuration(config.Queue.Interval, interval); d > 0 {
+				interval = d
+			}
+			q.flush(config, time.Now())
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+type QuietConfig struct {
+	Schedules []QuietWindow `json:"schedules"`
+	// IANA zone the schedules are in, the server's zone by default
+	Timezone string `json:"timezone"`
+	// Mode "summary" (default) posts one summary per route when quiet hours
+	// end, "queue" delivers the held messages instead
+	Mode string `json:"mode"`
+	// these still go out immediately, critical events by default
+	AllowStatuses   []int    `json:"allowStatuses"`
+	AllowSeverities []string `json:"allowSeverities"`
+}
+
+type QuietWindow struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// queued messages beyond this are only counted, nobody reads 500 messages
+const maxQueuedPerRoute = 50
+
+func minuteOfDay(clock string) (int, bool) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+func (q QuietConfig) location() *time.Location {
+	if q.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		slog.Error("Unknown quiet hours timezone", "timezone", q.Timezone, "err", err)
+		return time.Local
+	}
+	return loc
+}
+
+// active reports whether now falls in one of the windows, a window whose
+// end is before its start runs past midnight
+func (q QuietConfig) active(now time.Time) bool {
+	local := now.In(q.location())
+	minute := local.Hour()*60 + local.Minute()
+	for _, w := range q.Schedules {
+		from, ok1 := minuteOfDay(w.From)
+		to, ok2 := minuteOfDay(w.To)
+		if !ok1 || !ok2 {
+			continue
+		}
+		if from <= to && minute >= from && minute < to {
+			return true
+		}
+		if from > to && (minute >= from || minute < to) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q QuietConfig) allows(data parse.Data, severity string) bool {
+	allowed := q.AllowSeverities
+	if allowed == nil {
+		allowed = []string{severityCritical}
+	}
+	if contains(allowed, severity) {
+		return true
+	}
+	for _, status := range q.AllowStatuses {
+		if data.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+type quietHold struct {
+	route    Route
+	host     string
+	queued   []notify.Message
+	count    int
+	statuses map[string]int
+	hosts    map[string]int
+}
+
+type quietHours struct {
+	mu      sync.Mutex
+	started time.Time
+	held    map[string]*quietHold
+}
+
+var quiet = &quietHours{held: map[string]*quietHold{}}
+
+// hold keeps a message back instead of sending it, reporting false when
+// quiet hours don't apply to it
+func (q *quietHours) hold(config Config, route Route, data parse.Data, severity string, message notify.Message) bool {
+	if config.Quiet == nil || !config.Quiet.active(time.Now()) || config.Quiet.allows(data, severity) {
+		return false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.started.IsZero() {
+		q.started = time.Now()
+	}
+
+	key := route.Name + "\x00" + route.WebhookURL
+	h, ok := q.held[key]
+	if !ok {
+		h = &quietHold{route: route, host: data.Request.Host, statuses: map[string]int{}, hosts: map[string]int{}}
+		q.held[key] = h
+	}
+	h.count++
+	h.statuses[fmt.Sprintf("%dxx", data.Status/100)]++
+	h.hosts[data.Request.Host]++
+	if len(h.queued) < maxQueuedPerRoute {
+		h.queued = append(h.queued, message)
+	}
+	return true
+}
+
+func (h *quietHold) summary(since time.Time) string {
+	classes := make([]string, 0, len(h.statuses))
+	for class, n := range h.statuses {
+		classes = append(classes, fmt.Sprintf("%s: %d", class, n))
+	}
+	sort.Strings(classes)
+
+	var hosts []string
+	for _, r := range topN(h.hosts, 5) {
+		hosts = append(hosts, fmt.Sprintf("%s (%d)", notify.EscapeMarkdown(r.key), r.count))
+	}
+	return fmt.Sprintf("🌙 Quiet hours since %s: %s held back\n%s\nTop hosts: %s",
+		since.Format("15:04"), formatCount(h.count, "message"), strings.Join(classes, ", "), strings.Join(hosts, ", "))
+}
+
+// run flushes everything that was held once the quiet window is over
+func (q *quietHours) run() {
+	for range time.Tick(30 * time.Second) {
+		config := currentConfig()
+		if config.Quiet != nil && config.Quiet.active(time.Now()) {
+			continue
+		}
+
+		q.mu.Lock()
+		held, started := q.held, q.started
+		q.held = map[string]*quietHold{}
+		q.started = time.Time{}
+		q.mu.Unlock()
+
+		queue := config.Quiet != nil && config.Quiet.Mode == "queue"
+		for _, h := range held {
+			var messages []notify.Message
+			if queue {
+				messages = h.queued
+			}
+			if !queue || h.count > len(h.queued) {
+				messages = append(messages, notify.Message{Content: h.summary(started)})
+			}
+			for _, message := range messages {
+				if err := sendRouteMessage(config, h.route, h.host, message); err != nil {
+					slog.Error("Error flushing quiet hours", "route", h.route.Name, "err", err)
+				}
+			}
+		}
+	}
+}
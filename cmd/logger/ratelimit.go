@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+func init() {
+	metrics.describe("discord_ratelimit_remaining", "gauge", "Requests left in the current rate limit bucket of a webhook.")
+	metrics.describe("discord_ratelimit_limit", "gauge", "Size of the rate limit bucket of a webhook.")
+	metrics.describe("discord_ratelimit_utilization", "gauge", "Share of the bucket used, smoothed over recent requests.")
+	metrics.describe("discord_ratelimited_total", "counter", "Requests discord answered with 429.")
+}
+
+// rateBudget follows how much of a webhook's rate limit is being used. One
+// high reading is normal for a burst, a high average means messages are
+// about to be delayed.
+type rateBudget struct {
+	utilization float64
+	highSince   time.Time
+	warned      time.Time
+}
+
+type rateTracker struct {
+	mu      sync.Mutex
+	budgets map[string]*rateBudget
+}
+
+var rateLimits = &rateTracker{budgets: map[string]*rateBudget{}}
+
+const (
+	highUtilization = 0.8
+	sustainedFor    = 5 * time.Minute
+	warnEvery       = time.Hour
+)
+
+// observe reads the rate limit headers of a discord response
+func (t *rateTracker) observe(webhookUrl string, resp *http.Response) {
+	id := webhookID(webhookUrl)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		metrics.add("discord_ratelimited_total", 1, "webhook", id)
+	}
+
+	limit, err1 := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Limit"), 64)
+	remaining, err2 := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Remaining"), 64)
+	if err1 != nil || err2 != nil || limit <= 0 {
+		return
+	}
+	metrics.set("discord_ratelimit_limit", limit, "webhook", id)
+	metrics.set("discord_ratelimit_remaining", remaining, "webhook", id)
+
+	now := time.Now()
+	t.mu.Lock()
+	budget, ok := t.budgets[id]
+	if !ok {
+		budget = &rateBudget{}
+		t.budgets[id] = budget
+	}
+	// exponential moving average over roughly the last ten requests
+	used := 1 - remaining/limit
+	budget.utilization = budget.utilization*0.9 + used*0.1
+	metrics.set("discord_ratelimit_utilization", budget.utilization, "webhook", id)
+
+	warn := false
+	if budget.utilization < highUtilization {
+		budget.highSince = time.Time{}
+	} else if budget.highSince.IsZero() {
+		budget.highSince = now
+	} else if now.Sub(budget.highSince) >= sustainedFor && now.Sub(budget.warned) >= warnEvery {
+		budget.warned = now
+		warn = true
+	}
+	utilization := budget.utilization
+	t.mu.Unlock()
+
+	if warn {
+		message := fmt.Sprintf("⏳ Webhook %s has used %.0f%% of its Discord rate limit for over %s. Messages will start getting delayed, consider dedup, sampling or batching.",
+			id, utilization*100, sustainedFor)
+		slog.Warn(message)
+		alertSinks(message)
+		go postWebhook(webhookUrl, notify.Message{Content: message})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy is shared by everything that retries: discord, the outputs,
+// docker and enrichment lookups
+type RetryPolicy struct {
+	// Attempts is the number of retries after the first try
+	Attempts int `json:"attempts"`
+	// Delay is the first backoff, doubled on every further retry
+	Delay    string `json:"delay"`
+	MaxDelay string `json:"maxDelay"`
+	// Jitter spreads each delay by up to this fraction, 0.2 means ±20%
+	Jitter float64 `json:"jitter"`
+	// MaxElapsed gives up once retrying would take longer than this in total
+	MaxElapsed string `json:"maxElapsed"`
+}
+
+type RetryConfig struct {
+	RateLimit  *RetryPolicy `json:"rateLimit"`
+	Network    *RetryPolicy `json:"network"`
+	Payload    *RetryPolicy `json:"payload"`
+	Unknown    *RetryPolicy `json:"unknown"`
+	Docker     *RetryPolicy `json:"docker"`
+	Enrichment *RetryPolicy `json:"enrichment"`
+}
+
+var (
+	defaultDockerRetry     = RetryPolicy{Attempts: 3, Delay: "500ms", MaxDelay: "5s", Jitter: 0.2}
+	defaultEnrichmentRetry = RetryPolicy{Attempts: 1, Delay: "500ms", Jitter: 0.2}
+)
+
+func init() {
+	metrics.describe("retries_total", "counter", "Retries per operation.")
+	metrics.describe("retries_exhausted_total", "counter", "Operations that failed after using up their retries.")
+}
+
+func parseDuration(value string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return fallback
+	}
+	return d
+}
+
+// backoff is the delay before retry number attempt (counting from 0)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := parseDuration(p.Delay, time.Second)
+	for i := 0; i < attempt && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if max := parseDuration(p.MaxDelay, 0); max > 0 && delay > max {
+		delay = max
+	}
+	if p.Jitter > 0 {
+		spread := float64(delay) * p.Jitter
+		delay += time.Duration(spread * (2*rand.Float64() - 1))
+	}
+	return delay
+}
+
+// retry runs fn until it succeeds or the policy returned for its error says
+// to stop. policy returning false means the error is not worth retrying.
+func retry(op string, fn func() error, policy func(error) (RetryPolicy, bool)) error {
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		p, ok := policy(err)
+		if !ok {
+			return err
+		}
+
+		delay := p.backoff(attempt)
+		// the server knows best how long to wait
+		var serr *statusError
+		if errors.As(err, &serr) && serr.RetryAfter > 0 {
+			delay = serr.RetryAfter
+		}
+
+		maxElapsed := parseDuration(p.MaxElapsed, 0)
+		if attempt >= p.Attempts || (maxElapsed > 0 && time.Since(start)+delay > maxElapsed) {
+			if p.Attempts > 0 {
+				metrics.add("retries_exhausted_total", 1, "op", op)
+			}
+			return fmt.Errorf("%s failed after %d attempts: %w", op, attempt+1, err)
+		}
+
+		metrics.add("retries_total", 1, "op", op)
+		slog.Warn(op+" failed, retrying", "attempt", attempt+1, "attempts", p.Attempts, "in", delay.Round(time.Millisecond).String(), "err", err)
+		time.Sleep(delay)
+	}
+}
+
+// fixedPolicy retries every error with the same policy
+func fixedPolicy(p RetryPolicy) func(error) (RetryPolicy, bool) {
+	return func(error) (RetryPolicy, bool) {
+		return p, true
+	}
+}
+
+func dockerRetry(config Config) RetryPolicy {
+	if config.Retry != nil && config.Retry.Docker != nil {
+		return *config.Retry.Docker
+	}
+	return defaultDockerRetry
+}
+
+// enrichmentPolicy only retries errors that might go away, a bad api key or
+// an exhausted quota won't
+func enrichmentPolicy(err error) (RetryPolicy, bool) {
+	switch classifyError(err) {
+	case classNetwork, classUnknown:
+		return enrichmentRetry(currentConfig()), true
+	}
+	return RetryPolicy{}, false
+}
+
+func enrichmentRetry(config Config) RetryPolicy {
+	if config.Retry != nil && config.Retry.Enrichment != nil {
+		return *config.Retry.Enrichment
+	}
+	return defaultEnrichmentRetry
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"path"
+	"sync"
+
+	"simo.ng/logger/pkg/filter"
+
+	"simo.ng/logger/pkg/notify"
+)
+
+type Route struct {
+	Name       string   `json:"name"`
+	Hosts      []string `json:"hosts"`
+	WebhookURL string   `json:"webhookUrl"`
+	// WebhookURLFile reads webhookUrl from a file, e.g. a docker secret
+	WebhookURLFile string    `json:"webhookUrlFile"`
+	Emoji          EmojiPack `json:"emoji"`
+	// Intel links the client address on these services, "all" for every
+	// one of them, see intel.go
+	Intel []string `json:"intel"`
+	// IntelButtons shows the intel links as buttons instead of inline links
+	IntelButtons bool `json:"intelButtons"`
+	// "accessible" replaces emoji with text labels, see presentation.go
+	Presentation string `json:"presentation"`
+	// Embed posts messages as an embed in the color of the status
+	Embed bool `json:"embed"`
+	// WebhookPool are more webhooks of the same channel, messages rotate
+	// over them and webhookUrl to spread discord's rate limits
+	WebhookPool []string `json:"webhookPool"`
+	// canary routes only receive sampled copies of the other routes for a
+	// while after each config reload
+	Canary       bool   `json:"canary"`
+	CanarySample int    `json:"canarySample"`
+	CanaryFor    string `json:"canaryFor"`
+	// Severities limits the route to events of these severities, e.g. only
+	// "critical" for an #alerts channel. Empty means all.
+	Severities []string `json:"severities"`
+	// Sources limits the route to lines from these log files, matched as
+	// globs against the file name ("shop-*.log")
+	Sources []string `json:"sources"`
+	// FirstSeen only posts visitors never seen before: "ip" for a new
+	// client address, "fingerprint" for a new address and user agent pair
+	FirstSeen string `json:"firstSeen"`
+	// forum posts every host into its own thread of a forum channel
+	Forum bool `json:"forum"`
+	// hosts of this caddy server are added to the route, see caddyAdmin
+	CaddyServer string `json:"caddyServer"`
+}
+
+// routesFor returns every route whose hosts match the request host. When no
+// routes are configured, or only canaries, the top level webhookUrl acts as a
+// catch-all route.
+func routesFor(config Config, host string) []Route {
+	if onlyCanaries(config) {
+		return []Route{{Name: "default", WebhookURL: config.WebhookURL, WebhookPool: config.WebhookPool}}
+	}
+
+	var matched []Route
+	for _, route := range config.Routes {
+		if !route.Canary && route.matches(host) {
+			matched = append(matched, route.withDefaults(config))
+		}
+	}
+	return matched
+}
+
+// namedRoutes returns the routes with these names whatever their hosts, for
+// events a rule routed
+func namedRoutes(config Config, names []string) []Route {
+	if onlyCanaries(config) {
+		if contains(names, "default") {
+			return routesFor(config, "")
+		}
+		return nil
+	}
+	var named []Route
+	for _, route := range config.Routes {
+		if !route.Canary && contains(names, route.Name) {
+			named = append(named, route.withDefaults(config))
+		}
+	}
+	return named
+}
+
+// onlyCanaries reports whether no route takes traffic of its own
+func onlyCanaries(config Config) bool {
+	for _, route := range config.Routes {
+		if !route.Canary {
+			return false
+		}
+	}
+	return true
+}
+
+// withDefaults fills in the top level webhook for routes without their own
+func (r Route) withDefaults(config Config) Route {
+	if r.WebhookURL == "" {
+		r.WebhookURL = config.WebhookURL
+		r.WebhookPool = config.WebhookPool
+	}
+	return r
+}
+
+func (r Route) accepts(severity string) bool {
+	return len(r.Severities) == 0 || contains(r.Severities, severity)
+}
+
+func (r Route) fromSource(source string) bool {
+	if len(r.Sources) == 0 {
+		return true
+	}
+	for _, pattern := range r.Sources {
+		if ok, _ := path.Match(pattern, source); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Route) matches(host string) bool {
+	return len(r.Hosts) == 0 || filter.MatchHost(r.Hosts, host)
+}
+
+// sendRouteMessage posts content to the route's channel, or to the thread of
+// host when the route's webhook belongs to a forum
+func sendRouteMessage(config Config, route Route, host string, message notify.Message) error {
+	return queue.send(config, queuedMessage{WebhookURL: route.pickWebhook(), Forum: route.Forum, Host: host, Message: message})
+}
+
+// rotation is where each pool continues, keyed by the route's webhookUrl
+var rotation = struct {
+	sync.Mutex
+	next map[string]int
+}{next: map[string]int{}}
+
+// pickWebhook takes the next webhook of the route's pool, skipping paused
+// ones and those with an open circuit. Forum routes keep to webhookUrl, the
+// threads they post to are remembered per webhook.
+func (r Route) pickWebhook() string {
+	if len(r.WebhookPool) == 0 || r.Forum {
+		return r.WebhookURL
+	}
+	pool := append([]string{r.WebhookURL}, r.WebhookPool...)
+
+	rotation.Lock()
+	defer rotation.Unlock()
+	start := rotation.next[r.WebhookURL]
+	for i := range pool {
+		n := (start + i) % len(pool)
+		if url := pool[n]; url != "" && webhooks.failure(url) == "" && !breakers.open(url) {
+			rotation.next[r.WebhookURL] = n + 1
+			return url
+		}
+	}
+	return r.WebhookURL
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// the rules of the /rules API live in their own file so config.json is never
+// rewritten, it may be read only and holds secret references
+
+func rulesPath(config Config) string {
+	if config.RulesFile != "" {
+		return config.RulesFile
+	}
+	return statePath(config, "rules.json")
+}
+
+// loadManagedRules adds the rules of the rules file to the config's own
+func loadManagedRules(config *Config) error {
+	raw, err := os.ReadFile(rulesPath(*config))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var managed []Rule
+	if err := json.Unmarshal(raw, &managed); err != nil {
+		return fmt.Errorf("%s: %w", rulesPath(*config), err)
+	}
+	for i := range managed {
+		managed[i].managed = true
+		// rules written by hand get an id to be deleted by
+		if managed[i].ID == "" {
+			managed[i].ID = newEventID()
+		}
+	}
+	config.Rules = append(config.Rules, managed...)
+	return nil
+}
+
+// writeRulesFile replaces the rules file in one go
+func writeRulesFile(config Config, rules []Rule) error {
+	if dryRun {
+		return nil
+	}
+	raw, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := rulesPath(config)
+	if err := os.WriteFile(path+".tmp", raw, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// rulesMu keeps changes through the api from overwriting each other
+var rulesMu sync.Mutex
+
+// changeManagedRules writes the managed rules change returns and swaps them
+// into the running config, the rest of the config is left as it is
+func changeManagedRules(change func([]Rule) ([]Rule, error)) error {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	next := currentFileConfig()
+	var own, managed []Rule
+	for _, rule := range next.Rules {
+		if rule.managed {
+			managed = append(managed, rule)
+		} else {
+			own = append(own, rule)
+		}
+	}
+	managed, err := change(managed)
+	if err != nil {
+		return err
+	}
+
+	if err := writeRulesFile(next, managed); err != nil {
+		return err
+	}
+	next.Rules = append(own, managed...)
+	setConfig(next)
+	return nil
+}
+
+// managedRule is a rule as the api lists it
+type managedRule struct {
+	Rule
+	// Managed rules can be deleted, the others are in config.json
+	Managed bool `json:"managed"`
+}
+
+var errRuleNotFound = errors.New("no such rule")
+
+// rulesHandler lists the rules on GET /rules, adds one on POST /rules and
+// deletes one on DELETE /rules/<id>. Changes need control.token, they
+// would be open to anyone reaching the port otherwise.
+func rulesHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/rules"), "/")
+		if r.Method != http.MethodGet && token == "" {
+			http.Error(w, "set control.token to change rules", http.StatusForbidden)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && id == "":
+			list := []managedRule{}
+			for _, rule := range currentFileConfig().Rules {
+				list = append(list, managedRule{Rule: rule, Managed: rule.managed})
+			}
+			writeJSON(w, list)
+
+		case r.Method == http.MethodPost && id == "":
+			var rule Rule
+			if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+				http.Error(w, "invalid rule: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			rule.ID = newEventID()
+			rule.managed = true
+			if problems := ruleProblems(currentConfig(), "rule "+rule.Name, rule); len(problems) > 0 {
+				http.Error(w, strings.Join(problems, "\n"), http.StatusBadRequest)
+				return
+			}
+			err := changeManagedRules(func(managed []Rule) ([]Rule, error) {
+				return append(managed, rule), nil
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			// the header has to be set before the status is written
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			writeJSON(w, managedRule{Rule: rule, Managed: true})
+
+		case r.Method == http.MethodDelete && id != "":
+			err := changeManagedRules(func(managed []Rule) ([]Rule, error) {
+				for i, rule := range managed {
+					if rule.ID == id {
+						return append(managed[:i:i], managed[i+1:]...), nil
+					}
+				}
+				return nil, errRuleNotFound
+			})
+			switch {
+			case errors.Is(err, errRuleNotFound):
+				http.Error(w, "no rule "+id+" in "+rulesPath(currentConfig()), http.StatusNotFound)
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			default:
+				w.WriteHeader(http.StatusNoContent)
+			}
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"simo.ng/logger/pkg/expr"
+	"simo.ng/logger/pkg/parse"
+)
+
+const (
+	ruleDrop     = "drop"
+	ruleRoute    = "route"
+	ruleEscalate = "escalate"
+	ruleTag      = "tag"
+	ruleLink     = "link"
+	// the other actions of the chain
+	ruleAllow = "allow"
+	ruleDeny  = "deny"
+)
+
+// Rule acts on the events its CEL expression matches. Every matching rule
+// applies, in order.
+type Rule struct {
+	// ID is set on the rules added through the /rules API
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	// Match is a CEL expression over the event, see ruleVariables, e.g.
+	// status >= 500 && request.host == 'api.example.com'
+	Match string `json:"match"`
+	// Action is "drop" to ignore the event, "route" to post it to Routes
+	// instead of the routes of its host, "escalate", "tag" to add Tags or
+	// "link" for only its Links
+	Action string   `json:"action"`
+	Routes []string `json:"routes"`
+	Tags   []string `json:"tags"`
+	// Links are appended to the messages of the events the rule matches,
+	// whatever its action
+	Links []LinkTemplate `json:"links,omitempty"`
+
+	// managed rules come from the rules file, see rulefile.go
+	managed bool
+}
+
+// ChainConfig is an ordered list of rules where the first match decides,
+// like a firewall: "allow" lets the event through, "deny" drops it and
+// "route" posts it to its routes only. Without a match Default applies.
+// The chain runs before the rules.
+type ChainConfig struct {
+	Rules []Rule `json:"rules"`
+	// Default is "allow" (the default), "deny" or "route" to Routes
+	Default string   `json:"default"`
+	Routes  []string `json:"routes"`
+}
+
+// decide returns the first rule that matches, or the default as a rule
+// named "default". The chain fails closed, a rule that doesn't compile
+// denies everything that reaches it.
+func (c ChainConfig) decide(vars map[string]interface{}) Rule {
+	for _, rule := range c.Rules {
+		if program(rule) == nil {
+			return Rule{Name: rule.Name, Action: ruleDeny}
+		}
+		if matches(rule, vars) {
+			return rule
+		}
+	}
+	action := c.Default
+	if action == "" {
+		action = ruleAllow
+	}
+	return Rule{Name: "default", Action: action, Routes: c.Routes}
+}
+
+// ruleOutcome is what the chain and the matching rules made of an event
+type ruleOutcome struct {
+	// decision is the chain rule that decided, empty without a chain
+	decision string
+	drop     bool
+	// routes replace the routes of the host when set
+	routes []string
+	// escalation is the rule that escalated the event
+	escalation string
+	tags       []string
+	links      []LinkTemplate
+}
+
+// ruleNames are the variables an expression can use
+var ruleNames = []string{"status", "duration", "size", "level", "logger", "msg", "user_id", "ts", "request", "resp_headers", "source", "country", "ip", "severity"}
+
+// programs holds the compiled expressions of the current rules and chain,
+// rebuilt by compilePrograms on every config swap. A broken one is kept as
+// nil so it's only logged once.
+var programs = struct {
+	sync.Mutex
+	compiled map[string]*expr.Program
+}{compiled: map[string]*expr.Program{}}
+
+func compileRule(match string) (*expr.Program, error) {
+	return expr.Compile(match, ruleNames...)
+}
+
+// compilePrograms replaces the cache with the expressions of config, the
+// ones of rules that were removed or edited are dropped
+func compilePrograms(config Config) {
+	rules := config.Rules
+	if config.Chain != nil {
+		rules = append(append([]Rule{}, rules...), config.Chain.Rules...)
+	}
+
+	programs.Lock()
+	defer programs.Unlock()
+	compiled := make(map[string]*expr.Program, len(rules))
+	for _, rule := range rules {
+		if _, ok := compiled[rule.Match]; ok {
+			continue
+		}
+		if p, ok := programs.compiled[rule.Match]; ok {
+			compiled[rule.Match] = p
+			continue
+		}
+		p, err := compileRule(rule.Match)
+		if err != nil {
+			slog.Error("Invalid rule expression", "rule", rule.Name, "err", err)
+		}
+		compiled[rule.Match] = p
+	}
+	programs.compiled = compiled
+}
+
+// program returns the compiled expression of rule. Events still running on
+// the config before a swap can miss the cache, their rules are compiled
+// without keeping them.
+func program(rule Rule) *expr.Program {
+	programs.Lock()
+	p, ok := programs.compiled[rule.Match]
+	programs.Unlock()
+	if ok {
+		return p
+	}
+	p, _ = compileRule(rule.Match)
+	return p
+}
+
+// headerValues turns a header struct of parse into a map of the headers
+// that were sent
+func headerValues(headers interface{}) map[string]interface{} {
+	values := map[string]interface{}{}
+	v := reflect.ValueOf(headers)
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		if list, ok := v.Field(i).Interface().([]string); ok && len(list) > 0 {
+			values[name] = list
+		}
+	}
+	return values
+}
+
+// ruleVariables is the event as the expressions see it, with caddy's names
+// for the log fields
+func ruleVariables(config Config, data parse.Data) map[string]interface{} {
+	path, query, _ := strings.Cut(data.Request.URI, "?")
+	return map[string]interface{}{
+		"status":   data.Status,
+		"duration": data.Duration,
+		"size":     data.Size,
+		"level":    data.Level,
+		"logger":   data.Logger,
+		"msg":      data.Msg,
+		"user_id":  data.UserID,
+		"ts":       float64(data.Ts.Time().UnixNano()) / 1e9,
+		"request": map[string]interface{}{
+			"remote_ip":   data.Request.RemoteIP,
+			"remote_port": data.Request.RemotePort,
+			"client_ip":   data.Request.ClientIP,
+			"proto":       data.Request.Proto,
+			"method":      data.Request.Method,
+			"host":        data.Request.Host,
+			"uri":         data.Request.URI,
+			"path":        path,
+			"query":       query,
+			"headers":     headerValues(data.Request.Headers),
+		},
+		"resp_headers": headerValues(data.RespHeaders),
+		"source":       data.Source,
+		"country":      countryOf(config, data),
+		"ip":           clientIP(data),
+		"severity":     severityOf(config, data, false),
+	}
+}
+
+func matches(rule Rule, vars map[string]interface{}) bool {
+	p := program(rule)
+	if p == nil {
+		return false
+	}
+	matched, err := p.Match(vars)
+	if err != nil {
+		// a field the event doesn't have, like a header that wasn't sent
+		slog.Debug("Rule did not evaluate", "rule", rule.Name, "err", err)
+		return false
+	}
+	return matched
+}
+
+// applyRules runs the chain and the rules over an access log event
+func applyRules(config Config, data parse.Data) ruleOutcome {
+	var outcome ruleOutcome
+	var chained bool
+	if (len(config.Rules) == 0 && config.Chain == nil) || !parse.IsAccessLog(data) {
+		return outcome
+	}
+	vars := ruleVariables(config, data)
+	if config.Chain != nil {
+		decided := config.Chain.decide(vars)
+		outcome.decision = decided.Name
+		switch decided.Action {
+		case ruleAllow:
+		case ruleRoute:
+			// final, the rules below don't add routes to it
+			outcome.routes = decided.Routes
+			chained = true
+		default:
+			// deny, and whatever isn't a known action
+			outcome.drop = true
+			return outcome
+		}
+	}
+	for _, rule := range config.Rules {
+		if !matches(rule, vars) {
+			continue
+		}
+		outcome.links = append(outcome.links, rule.Links...)
+		switch rule.Action {
+		case ruleDrop:
+			outcome.drop = true
+			return outcome
+		case ruleRoute:
+			if !chained {
+				outcome.routes = appendMissing(outcome.routes, rule.Routes...)
+			}
+		case ruleEscalate:
+			if outcome.escalation == "" {
+				outcome.escalation = rule.Name
+			}
+		case ruleTag:
+			outcome.tags = appendMissing(outcome.tags, rule.Tags...)
+		}
+	}
+	return outcome
+}
+
+func appendMissing(list []string, items ...string) []string {
+	for _, item := range items {
+		if !contains(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// tagLine shows the tags of an event below its message
+func tagLine(tags []string) string {
+	sorted := append([]string{}, tags...)
+	sort.Strings(sorted)
+	return "🏷️ " + strings.Join(sorted, ", ")
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+type S3Config struct {
+	// Endpoint is the s3 api of the provider, e.g. https://s3.eu-central-1.amazonaws.com,
+	// http://minio:9000 or https://<account>.r2.cloudflarestorage.com
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	// Prefix comes before the dated part of the keys, caddy by default
+	Prefix string `json:"prefix"`
+	Gzip   bool   `json:"gzip"`
+	// Batch defaults to uploading every 5m, or once 10000 events are waiting
+	Batch BatchConfig `json:"batch"`
+}
+
+type s3Sink struct {
+	*batch
+	config S3Config
+	client *http.Client
+}
+
+func newS3Sink(config S3Config) *s3Sink {
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Prefix == "" {
+		config.Prefix = "caddy"
+	}
+	if config.Batch.Size <= 0 {
+		config.Batch.Size = 10000
+	}
+	if config.Batch.Every == "" {
+		config.Batch.Every = "5m"
+	}
+	s := &s3Sink{
+		config: config,
+		client: &http.Client{Timeout: time.Minute},
+	}
+	s.batch = newBatch("s3", config.Batch, s.upload)
+	return s
+}
+
+func (s *s3Sink) Name() string {
+	return "s3"
+}
+
+func (s *s3Sink) Send(data parse.Data, raw string) error {
+	if parse.IsAccessLog(data) {
+		s.add(newEvent(currentConfig(), data))
+	}
+	return nil
+}
+
+// key partitions the objects by the date of their first event, e.g.
+// caddy/2026/05/17/130352-x1y2z3.ndjson.gz. The random part keeps two
+// logger instances from overwriting each other.
+func (s *s3Sink) key(events []event) string {
+	first := events[0].Time.UTC()
+	key := strings.Trim(s.config.Prefix, "/") + "/" + first.Format("2006/01/02/150405") + "-" + newEventID() + ".ndjson"
+	if s.config.Gzip {
+		key += ".gz"
+	}
+	return key
+}
+
+func (s *s3Sink) upload(events []event) error {
+	var body bytes.Buffer
+	var enc *json.Encoder
+	var zw *gzip.Writer
+	if s.config.Gzip {
+		zw = gzip.NewWriter(&body)
+		enc = json.NewEncoder(zw)
+	} else {
+		enc = json.NewEncoder(&body)
+	}
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	contentType := "application/x-ndjson"
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		contentType = "application/gzip"
+	}
+
+	// path style addressing works with every provider
+	path := "/" + s.config.Bucket + "/" + s.key(events)
+	req, err := http.NewRequest(http.MethodPut, strings.TrimSuffix(s.config.Endpoint, "/")+escapePath(path), bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	signS3(req, body.Bytes(), s.config, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return newStatusError("s3", resp)
+	}
+	return nil
+}
+
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signS3 adds an aws signature version 4 to a request without a query
+func signS3(req *http.Request, body []byte, config S3Config, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	hashed := sha256.Sum256([]byte(canonical))
+	scope := day + "/" + config.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+config.SecretKey), day)
+	key = hmacSHA256(key, config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+config.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
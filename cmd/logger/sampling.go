@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"simo.ng/logger/pkg/filter"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+// SampleConfig thins out routine traffic on busy sites, only one in every
+// `every` matching requests per host is posted. Server errors and critical
+// events are never sampled.
+type SampleConfig struct {
+	Every int `json:"every"`
+	// statuses as exact codes or classes, defaults to 2xx
+	Statuses []string `json:"statuses"`
+	// hosts to sample, empty samples all of them
+	Hosts []string `json:"hosts"`
+}
+
+func (c SampleConfig) applies(data parse.Data) bool {
+	statuses := c.Statuses
+	if len(statuses) == 0 {
+		statuses = []string{"2xx"}
+	}
+	if !contains(statuses, fmt.Sprint(data.Status)) && !contains(statuses, fmt.Sprintf("%dxx", data.Status/100)) {
+		return false
+	}
+	return len(c.Hosts) == 0 || filter.MatchHost(c.Hosts, data.Request.Host)
+}
+
+type sampler struct {
+	mu   sync.Mutex
+	seen map[string]int
+}
+
+var sampling = &sampler{seen: map[string]int{}}
+
+// the host header is picked by the client, past this many hosts the counters
+// start over
+const maxSampledHosts = 10000
+
+func init() {
+	metrics.describe("sampled_out_total", "counter", "Requests not posted because of sampling.")
+}
+
+// skip reports whether the event falls outside the sample
+func (s *sampler) skip(cfg SampleConfig, data parse.Data, severity string) bool {
+	if cfg.Every <= 1 || severity == severityCritical || data.Status >= 500 || !cfg.applies(data) {
+		return false
+	}
+
+	host := strings.ToLower(data.Request.Host)
+	s.mu.Lock()
+	n, ok := s.seen[host]
+	if !ok && len(s.seen) >= maxSampledHosts {
+		s.seen = map[string]int{}
+	}
+	s.seen[host] = (n + 1) % cfg.Every
+	s.mu.Unlock()
+
+	if n == 0 {
+		return false
+	}
+	metrics.add("sampled_out_total", 1)
+	return true
+}
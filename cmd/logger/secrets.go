@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// secret values can point elsewhere instead of sitting in the config:
+// "env:NAME" reads an environment variable, "file:/path" a file and
+// "secret:name" the docker secret /run/secrets/name
+const dockerSecrets = "/run/secrets"
+
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		resolved, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return strings.TrimSpace(resolved), nil
+	case strings.HasPrefix(value, "file:"):
+		return readSecretFile(strings.TrimPrefix(value, "file:"))
+	case strings.HasPrefix(value, "secret:"):
+		return readSecretFile(filepath.Join(dockerSecrets, strings.TrimPrefix(value, "secret:")))
+	}
+	return value, nil
+}
+
+func readSecretFile(path string) (string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
+
+// secretFields are the settings that may hold a secret reference
+func secretFields(config *Config) map[string]*string {
+	fields := map[string]*string{"webhookUrl": &config.WebhookURL}
+	for i := range config.WebhookPool {
+		fields[fmt.Sprintf("webhookPool[%d]", i)] = &config.WebhookPool[i]
+	}
+	for i := range config.Routes {
+		fields[fmt.Sprintf("routes[%d].webhookUrl", i)] = &config.Routes[i].WebhookURL
+		for j := range config.Routes[i].WebhookPool {
+			fields[fmt.Sprintf("routes[%d].webhookPool[%d]", i, j)] = &config.Routes[i].WebhookPool[j]
+		}
+	}
+	if config.CaddyAdmin != nil {
+		for host, site := range config.CaddyAdmin.Sites {
+			if site != nil {
+				fields[fmt.Sprintf("caddyAdmin.sites[%q].webhookUrl", host)] = &site.WebhookURL
+			}
+		}
+	}
+	if config.Incidents != nil {
+		fields["incidents.webhookUrl"] = &config.Incidents.WebhookURL
+	}
+	if config.Digest != nil {
+		fields["digest.webhookUrl"] = &config.Digest.WebhookURL
+	}
+	if config.Errors != nil {
+		fields["errors.webhookUrl"] = &config.Errors.WebhookURL
+	}
+	if config.Ops != nil {
+		fields["ops.webhookUrl"] = &config.Ops.WebhookURL
+	}
+	if config.Summary != nil {
+		fields["summary.webhookUrl"] = &config.Summary.WebhookURL
+	}
+	if config.Loki != nil {
+		fields["loki.password"] = &config.Loki.Password
+	}
+	if config.Matrix != nil {
+		fields["matrix.accessToken"] = &config.Matrix.AccessToken
+	}
+	if config.Ntfy != nil {
+		fields["ntfy.token"] = &config.Ntfy.Token
+		fields["ntfy.password"] = &config.Ntfy.Password
+	}
+	if config.Pushover != nil {
+		fields["pushover.token"] = &config.Pushover.Token
+		fields["pushover.user"] = &config.Pushover.User
+	}
+	if config.Gotify != nil {
+		fields["gotify.token"] = &config.Gotify.Token
+	}
+	if config.Teams != nil {
+		fields["teams.webhookUrl"] = &config.Teams.WebhookURL
+	}
+	if config.Email != nil {
+		fields["email.password"] = &config.Email.Password
+	}
+	if config.PagerDuty != nil {
+		fields["pagerDuty.routingKey"] = &config.PagerDuty.RoutingKey
+	}
+	if config.Opsgenie != nil {
+		fields["opsgenie.apiKey"] = &config.Opsgenie.APIKey
+	}
+	if config.MQTT != nil {
+		fields["mqtt.password"] = &config.MQTT.Password
+	}
+	if config.NATS != nil {
+		fields["nats.token"] = &config.NATS.Token
+		fields["nats.password"] = &config.NATS.Password
+	}
+	if config.Kafka != nil {
+		fields["kafka.password"] = &config.Kafka.Password
+	}
+	if config.Elasticsearch != nil {
+		fields["elasticsearch.password"] = &config.Elasticsearch.Password
+		fields["elasticsearch.apiKey"] = &config.Elasticsearch.APIKey
+	}
+	if config.ClickHouse != nil {
+		fields["clickhouse.password"] = &config.ClickHouse.Password
+	}
+	if config.Influx != nil {
+		fields["influx.token"] = &config.Influx.Token
+		fields["influx.password"] = &config.Influx.Password
+	}
+	if config.S3 != nil {
+		fields["s3.accessKey"] = &config.S3.AccessKey
+		fields["s3.secretKey"] = &config.S3.SecretKey
+	}
+	if config.AbuseIPDB != nil {
+		fields["abuseIpdb.apiKey"] = &config.AbuseIPDB.APIKey
+	}
+	if config.Control != nil {
+		fields["control.token"] = &config.Control.Token
+	}
+	if config.Bot != nil {
+		fields["bot.token"] = &config.Bot.Token
+	}
+	for i := range config.Actions {
+		if config.Actions[i].Cloudflare != nil {
+			fields[fmt.Sprintf("actions[%d].cloudflare.apiToken", i)] = &config.Actions[i].Cloudflare.APIToken
+		}
+	}
+	return fields
+}
+
+// resolveSecrets replaces secret references with what they point to, and
+// reads webhookUrlFile where webhookUrl is empty
+func resolveSecrets(config *Config) error {
+	if config.WebhookURL == "" && config.WebhookURLFile != "" {
+		config.WebhookURL = "file:" + config.WebhookURLFile
+	}
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		if route.WebhookURL == "" && route.WebhookURLFile != "" {
+			route.WebhookURL = "file:" + route.WebhookURLFile
+		}
+	}
+	if config.CaddyAdmin != nil {
+		for _, site := range config.CaddyAdmin.Sites {
+			if site != nil && site.WebhookURL == "" && site.WebhookURLFile != "" {
+				site.WebhookURL = "file:" + site.WebhookURLFile
+			}
+		}
+	}
+
+	for name, field := range secretFields(config) {
+		resolved, err := resolveSecret(*field)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*field = resolved
+	}
+	// collector headers usually carry the vendor's api key
+	if config.OTLP != nil {
+		for header, value := range config.OTLP.Headers {
+			resolved, err := resolveSecret(value)
+			if err != nil {
+				return fmt.Errorf("otlp.headers.%s: %w", header, err)
+			}
+			config.OTLP.Headers[header] = resolved
+		}
+	}
+	return nil
+}
+
+// webhookToken matches the secret part of a discord webhook url
+var webhookToken = regexp.MustCompile(`(/api(?:/v\d+)?/webhooks/\d+/)[\w-]+`)
+
+func redactSecrets(s string) string {
+	return webhookToken.ReplaceAllString(s, "${1}***")
+}
+
+// redactingWriter keeps webhook tokens out of the logs, net/http errors
+// quote the full url
+type redactingWriter struct {
+	out io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := w.out.Write([]byte(redactSecrets(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+
+	"simo.ng/logger/pkg/filter"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+const (
+	severityInfo     = "info"
+	severityWarn     = "warn"
+	severityCritical = "critical"
+)
+
+func severityRank(severity string) int {
+	switch severity {
+	case severityWarn:
+		return 1
+	case severityCritical:
+		return 2
+	}
+	return 0
+}
+
+// SeverityConfig derives a severity for every event. Rules are tried in
+// order and the first match wins, without a match 5xx is critical, 4xx is
+// warn and everything else info. Escalated events are always critical.
+type SeverityConfig struct {
+	Rules []SeverityRule `json:"rules"`
+}
+
+type SeverityRule struct {
+	Severity string `json:"severity"`
+	// statuses as exact codes ("401") or classes ("5xx")
+	Statuses []string `json:"statuses"`
+	Paths    []string `json:"paths"`
+	Hosts    []string `json:"hosts"`
+	// SlowerThan matches responses that took longer, like "2s"
+	SlowerThan string `json:"slowerThan"`
+	// LargerThan matches responses bigger than this, like "50MB"
+	LargerThan string `json:"largerThan"`
+}
+
+func (r SeverityRule) matches(data parse.Data) bool {
+	if len(r.Statuses) > 0 {
+		code := fmt.Sprint(data.Status)
+		class := fmt.Sprintf("%dxx", data.Status/100)
+		if !contains(r.Statuses, code) && !contains(r.Statuses, class) {
+			return false
+		}
+	}
+	if len(r.Paths) > 0 {
+		found := false
+		for _, pattern := range r.Paths {
+			if filter.MatchPath(pattern, data.Request.URI) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.Hosts) > 0 && !filter.MatchHost(r.Hosts, data.Request.Host) {
+		return false
+	}
+	if r.SlowerThan != "" && !tookLonger(data, parseDuration(r.SlowerThan, 0)) {
+		return false
+	}
+	if r.LargerThan != "" {
+		if limit, ok := parseSize(r.LargerThan); !ok || data.Size <= limit {
+			return false
+		}
+	}
+	return len(r.Statuses) > 0 || len(r.Paths) > 0 || len(r.Hosts) > 0 || r.SlowerThan != "" || r.LargerThan != ""
+}
+
+func severityOf(config Config, data parse.Data, escalated bool) string {
+	if escalated {
+		return severityCritical
+	}
+	if _, attack := attackSignature(config, data); attack {
+		return severityCritical
+	}
+	if config.Severity != nil {
+		for _, rule := range config.Severity.Rules {
+			if rule.matches(data) {
+				return rule.Severity
+			}
+		}
+	}
+	switch {
+	case data.Status >= 500:
+		return severityCritical
+	case data.Status >= 400:
+		return severityWarn
+	}
+	return severityInfo
+}
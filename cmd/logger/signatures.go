@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+	"sync"
+
+	"simo.ng/logger/pkg/filter"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+// SignatureConfig tunes the built-in attack signatures. Requests matching
+// one are critical and carry the name of the attack.
+type SignatureConfig struct {
+	// Disable turns off built-in signatures by name, "*" turns off all
+	Disable []string `json:"disable"`
+	// Extra signatures, regular expressions matched against the decoded
+	// request uri and the user agent
+	Extra []SignatureRule `json:"extra"`
+}
+
+type SignatureRule struct {
+	Name      string `json:"name"`
+	URI       string `json:"uri"`
+	UserAgent string `json:"userAgent"`
+	// Method limits the signature to one method, e.g. POST
+	Method string `json:"method"`
+}
+
+// compiledExtra caches the extra signatures of the current config. They
+// only change on reload, so they're compiled once per distinct set.
+var compiledExtra struct {
+	mu    sync.Mutex
+	rules []SignatureRule
+	built []filter.Signature
+}
+
+func extraSignatures(rules []SignatureRule) []filter.Signature {
+	compiledExtra.mu.Lock()
+	defer compiledExtra.mu.Unlock()
+	if sameRules(compiledExtra.rules, rules) {
+		return compiledExtra.built
+	}
+
+	var built []filter.Signature
+	for _, rule := range rules {
+		s := filter.Signature{Name: rule.Name, Method: rule.Method}
+		var err error
+		if rule.URI != "" {
+			if s.URI, err = regexp.Compile(rule.URI); err != nil {
+				slog.Error("Invalid uri in signature", "signature", rule.Name, "err", err)
+				continue
+			}
+		}
+		if rule.UserAgent != "" {
+			if s.UserAgent, err = regexp.Compile(rule.UserAgent); err != nil {
+				slog.Error("Invalid userAgent in signature", "signature", rule.Name, "err", err)
+				continue
+			}
+		}
+		built = append(built, s)
+	}
+	compiledExtra.rules = rules
+	compiledExtra.built = built
+	return built
+}
+
+func sameRules(a, b []SignatureRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// attackSignature returns the name of the first signature data matches
+func attackSignature(config Config, data parse.Data) (string, bool) {
+	var disabled []string
+	var extra []filter.Signature
+	if config.Signatures != nil {
+		disabled = config.Signatures.Disable
+		extra = extraSignatures(config.Signatures.Extra)
+	}
+
+	var signatures []filter.Signature
+	if !contains(disabled, "*") {
+		for _, s := range filter.Builtin {
+			if !contains(disabled, s.Name) {
+				signatures = append(signatures, s)
+			}
+		}
+	}
+	return filter.Attack(data, append(signatures, extra...))
+}
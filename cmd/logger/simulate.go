@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"simo.ng/logger/pkg/ingest"
+)
+
+// simulateCommand writes generated access log lines into a fake caddy
+// container at a steady rate, to try batching, rate limits and templates
+// without real traffic. They're read the same way as from a real container.
+// Messages are posted unless --dry-run is given as well.
+func simulateCommand(config Config, args []string) error {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	rate := flags.Float64("rate", 2, "log lines per second")
+	duration := flags.Duration("duration", time.Minute, "how long to run, 0 runs until interrupted")
+	hostList := flags.String("hosts", "", "comma separated hosts, defaults to the hosts of the routes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *rate <= 0 {
+		return fmt.Errorf("-rate must be above 0")
+	}
+
+	hosts := simulatedHosts(config, *hostList)
+
+	// fake visitors must not end up in the real state, and nobody should be
+	// banned or looked up for them
+	dir, err := os.MkdirTemp("", "logger-simulate-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	config.StateDir = dir
+	config.Actions = nil
+	config.AbuseIPDB = nil
+
+	fake := ingest.NewFake()
+	fake.AddContainer("caddy", "simulated")
+	openSource = fake.Open
+	// the outputs of the first pipeline apply, to preview them
+	p := Pipeline{Name: "simulate", ContainerName: "caddy", Source: sourceExec, ExecFiles: []string{simulatedLog}, Outputs: pipelines(config)[0].Outputs}
+	config.Pipelines = []Pipeline{p}
+	config.Backfill = nil
+
+	setConfig(config)
+	startServices(config)
+	go supervise("pipeline simulate", func() error { return followPipeline(p, true) })
+
+	gen := newTrafficGenerator(hosts)
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+	defer ticker.Stop()
+	var deadline <-chan time.Time
+	if *duration > 0 {
+		deadline = time.After(*duration)
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	fmt.Printf("Simulating %g requests/s to %s\n", *rate, strings.Join(hosts, ", "))
+	generated := 0
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			fake.Write(simulatedLog, gen.line(time.Now()))
+			generated++
+		case <-deadline:
+			break loop
+		case <-interrupt:
+			break loop
+		}
+	}
+
+	fmt.Printf("Generated %d log lines, waiting for the senders\n", generated)
+	if !senders.drain(30*time.Second) || !outputSenders.drain(30*time.Second) {
+		fmt.Println("Gave up waiting, some messages were not sent")
+	}
+	configMu.RLock()
+	current := sinks
+	configMu.RUnlock()
+	flushSinks(current, true)
+	return nil
+}
+
+// simulatedHosts are the ones given, or the literal hosts of the routes
+func simulatedHosts(config Config, list string) []string {
+	var hosts []string
+	for _, host := range strings.Split(list, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) > 0 {
+		return hosts
+	}
+	for _, route := range config.Routes {
+		for _, host := range route.Hosts {
+			if !strings.ContainsAny(host, "*?[") && !contains(hosts, host) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		hosts = []string{"example.com"}
+	}
+	return hosts
+}
+
+const simulatedLog = ingest.LogDir + "simulate.log"
+
+var (
+	simulatedAgents = []string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+	}
+	simulatedScanners = []string{
+		"curl/8.4.0",
+		"python-requests/2.31.0",
+		"Mozilla/5.0 zgrab/0.x",
+		"Go-http-client/1.1",
+	}
+	simulatedPaths = []string{"/", "/", "/", "/about", "/blog", "/blog/hello-world", "/contact", "/favicon.ico",
+		"/static/app.js", "/static/style.css", "/images/logo.png", "/api/items", "/api/items/42", "/search?q=caddy"}
+	simulatedProbes = []string{"/.env", "/.git/config", "/wp-login.php", "/phpmyadmin/", "/admin", "/config.php",
+		"/../../etc/passwd", "/?id=1%27%20or%20%271%27=%271"}
+)
+
+// trafficGenerator makes up requests: mostly returning visitors browsing
+// pages, some new ones, a few scanners probing for well known files and the
+// occasional server error
+type trafficGenerator struct {
+	hosts    []string
+	visitors []simulatedVisitor
+}
+
+type simulatedVisitor struct {
+	ip    string
+	agent string
+}
+
+func newTrafficGenerator(hosts []string) *trafficGenerator {
+	g := &trafficGenerator{hosts: hosts}
+	for i := 0; i < 50; i++ {
+		g.visitors = append(g.visitors, simulatedVisitor{ip: randomAddress(), agent: pick(simulatedAgents)})
+	}
+	return g
+}
+
+func pick(list []string) string {
+	return list[rand.Intn(len(list))]
+}
+
+// randomAddress picks from the documentation ranges, which never belong to
+// real clients
+func randomAddress() string {
+	if rand.Intn(10) == 0 {
+		var b [16]byte
+		copy(b[:], []byte{0x20, 0x01, 0x0d, 0xb8})
+		rand.Read(b[4:])
+		return netip.AddrFrom16(b).String()
+	}
+	prefixes := [][3]byte{{192, 0, 2}, {198, 51, 100}, {203, 0, 113}}
+	p := prefixes[rand.Intn(len(prefixes))]
+	return netip.AddrFrom4([4]byte{p[0], p[1], p[2], byte(1 + rand.Intn(254))}).String()
+}
+
+func (g *trafficGenerator) line(now time.Time) string {
+	visitor := g.visitors[rand.Intn(len(g.visitors))]
+	method, path, status := "GET", pick(simulatedPaths), 200
+
+	switch roll := rand.Intn(100); {
+	case roll < 8:
+		// a scanner looking for something to exploit
+		visitor = simulatedVisitor{ip: randomAddress(), agent: pick(simulatedScanners)}
+		path, status = pick(simulatedProbes), 404
+	case roll < 13:
+		visitor = simulatedVisitor{ip: randomAddress(), agent: pick(simulatedAgents)}
+	case roll < 16:
+		method, path, status = "POST", "/login", 401
+	case roll < 20:
+		status = []int{500, 502, 503}[rand.Intn(3)]
+	case roll < 28:
+		status = 304
+	case roll < 32:
+		path, status = "/old-page", 301
+	case roll < 40:
+		status = 404
+		path += "-missing"
+	}
+
+	size := 0
+	if status == 200 {
+		size = 500 + rand.Intn(50000)
+	}
+	line, _ := json.Marshal(map[string]interface{}{
+		"level":  "info",
+		"ts":     float64(now.UnixNano()) / 1e9,
+		"logger": "http.log.access.log0",
+		"msg":    "handled request",
+		"request": map[string]interface{}{
+			"remote_ip":   visitor.ip,
+			"remote_port": fmt.Sprint(1024 + rand.Intn(64000)),
+			"client_ip":   visitor.ip,
+			"proto":       "HTTP/2.0",
+			"method":      method,
+			"host":        pick(g.hosts),
+			"uri":         path,
+			"headers": map[string][]string{
+				"User-Agent":      {visitor.agent},
+				"Accept":          {"*/*"},
+				"Accept-Encoding": {"gzip, deflate, br"},
+			},
+		},
+		"user_id":      "",
+		"duration":     rand.ExpFloat64() * 0.05,
+		"size":         size,
+		"status":       status,
+		"resp_headers": map[string][]string{"Server": {"Caddy"}},
+	})
+	return string(line)
+}
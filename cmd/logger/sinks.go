@@ -0,0 +1,193 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+// Sink is an output that receives every parsed log line, next to the discord
+// routes which only get the formatted message.
+type Sink interface {
+	Name() string
+	Send(data parse.Data, raw string) error
+	// Close lets go of connections once the sink was replaced, after it
+	// was flushed
+	Close() error
+}
+
+// alerter is implemented by sinks and notifiers that can also carry the
+// logger's own warnings, used when discord itself is the thing that broke
+type alerter interface {
+	Alert(message string) error
+}
+
+// flusher is implemented by sinks that buffer events and write them in bulk
+type flusher interface {
+	flush(all bool)
+}
+
+var sinks []Sink
+
+// event is a request in the normalized form sinks publish as json, the same
+// for every output so consumers can switch between them
+type event struct {
+	Time      time.Time `json:"time"`
+	Host      string    `json:"host"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Status    int       `json:"status"`
+	IP        string    `json:"ip"`
+	Country   string    `json:"country,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	Severity  string    `json:"severity"`
+	// Duration is in seconds, Size in bytes
+	Duration float64 `json:"duration"`
+	Size     int     `json:"size"`
+	Proto    string  `json:"proto,omitempty"`
+	Source   string  `json:"source,omitempty"`
+	// Line is the raw log line, only kept for loki
+	Line string `json:"-"`
+}
+
+func newEvent(config Config, data parse.Data) event {
+	e := event{
+		Time:     data.Ts.Time().UTC(),
+		Host:     data.Request.Host,
+		Method:   data.Request.Method,
+		URI:      data.Request.URI,
+		Status:   data.Status,
+		IP:       clientIP(data),
+		Country:  countryOf(config, data),
+		Severity: severityOf(config, data, false),
+		Duration: data.Duration,
+		Size:     data.Size,
+		Proto:    data.Request.Proto,
+		Source:   data.Source,
+	}
+	if len(data.Request.Headers.UserAgent) > 0 {
+		e.UserAgent = data.Request.Headers.UserAgent[0]
+	}
+	return e
+}
+
+func buildSinks(config Config) []Sink {
+	var built []Sink
+	if config.Loki != nil && config.Loki.URL != "" {
+		built = append(built, newLokiSink(*config.Loki))
+	}
+	if config.MQTT != nil && config.MQTT.URL != "" {
+		built = append(built, newMQTTSink(*config.MQTT))
+	}
+	if config.NATS != nil && config.NATS.URL != "" {
+		built = append(built, newNATSSink(*config.NATS))
+	}
+	if config.Kafka != nil && config.Kafka.RESTProxy != "" {
+		built = append(built, newKafkaSink(*config.Kafka))
+	}
+	if config.Elasticsearch != nil && config.Elasticsearch.URL != "" {
+		built = append(built, newElasticsearchSink(*config.Elasticsearch))
+	}
+	if config.ClickHouse != nil && config.ClickHouse.URL != "" {
+		built = append(built, newClickHouseSink(*config.ClickHouse))
+	}
+	if config.Influx != nil && config.Influx.URL != "" {
+		built = append(built, newInfluxSink(*config.Influx))
+	}
+	if config.S3 != nil && config.S3.Bucket != "" {
+		built = append(built, newS3Sink(*config.S3))
+	}
+	if config.OTLP != nil && config.OTLP.Endpoint != "" {
+		built = append(built, newOTLPSink(*config.OTLP))
+	}
+	if config.NDJSON != nil && config.NDJSON.Path != "" {
+		built = append(built, newNDJSONSink(*config.NDJSON))
+	}
+	return built
+}
+
+// sendToSinks hands an event to every sink its pipeline's outputs let it
+// reach, escalated events count as critical for their severity filters
+func sendToSinks(config Config, outputs []PipelineOutput, data parse.Data, raw string, escalated bool) {
+	if dryRun {
+		return
+	}
+	configMu.RLock()
+	current := sinks
+	configMu.RUnlock()
+
+	var severity string
+	if outputs != nil {
+		severity = severityOf(config, data, escalated)
+	}
+	for _, sink := range current {
+		sink := sink
+		if !sendsTo(outputs, sink.Name(), data, severity) {
+			continue
+		}
+		outputSenders.submit(config, sink.Name(), sink.Name(), func() {
+			err := deliver(sink.Name(), func() error {
+				return sink.Send(data, raw)
+			})
+			if err != nil {
+				slog.Error("Error sending to sink", "sink", sink.Name(), "err", err)
+			}
+		})
+	}
+}
+
+// flushSinks writes out what bulk sinks have buffered for long enough, with
+// all set everything they have
+func flushSinks(list []Sink, all bool) {
+	for _, sink := range list {
+		if f, ok := sink.(flusher); ok {
+			f.flush(all)
+		}
+	}
+}
+
+// closeSinks closes sinks that were replaced or are done with
+func closeSinks(list []Sink) {
+	for _, sink := range list {
+		if err := sink.Close(); err != nil {
+			slog.Warn("Error closing sink", "sink", sink.Name(), "err", err)
+		}
+	}
+}
+
+// runBatches flushes the bulk sinks on their interval
+func runBatches() {
+	for {
+		time.Sleep(time.Second)
+		configMu.RLock()
+		current := sinks
+		configMu.RUnlock()
+		flushSinks(current, false)
+	}
+}
+
+func alertSinks(message string) {
+	if dryRun {
+		return
+	}
+	configMu.RLock()
+	current := sinks
+	others := notifiers
+	configMu.RUnlock()
+
+	for _, sink := range current {
+		if a, ok := sink.(alerter); ok {
+			if err := a.Alert(message); err != nil {
+				slog.Error("Error alerting via sink", "sink", sink.Name(), "err", err)
+			}
+		}
+	}
+	for _, notifier := range others {
+		if a, ok := notifier.(alerter); ok {
+			if err := a.Alert(message); err != nil {
+				slog.Error("Error alerting via notifier", "notifier", notifier.Name(), "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+
+	"simo.ng/logger/pkg/ingest"
+)
+
+// openSource connects to the runtime a pipeline points at
+var openSource = ingest.NewDockerSource
+
+// withSource runs fn against a runtime, retrying like every docker call. A
+// command failing inside the container isn't retried, it fails the same way
+// again.
+func withSource(cfg ingest.DockerConfig, fn func(ingest.Source) error) error {
+	err := retry("docker", func() error {
+		source, err := openSource(cfg)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		return fn(source)
+	}, func(err error) (RetryPolicy, bool) {
+		var exit *ingest.ExitError
+		return dockerRetry(currentConfig()), !errors.As(err, &exit)
+	})
+	var exit *ingest.ExitError
+	if !errors.As(err, &exit) {
+		health.observe("docker", err)
+	}
+	return err
+}
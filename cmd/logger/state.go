@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshot is the portable form of everything the logger remembers, used to
+// move it to another host without double posting or losing its place
+type snapshot struct {
+	Version        int                   `json:"version"`
+	Created        time.Time             `json:"created"`
+	Checkpoints    map[string]checkpoint `json:"checkpoints"`
+	ForumThreads   map[string]string     `json:"forumThreads"`
+	SeenUserAgents map[string]time.Time  `json:"seenUserAgents"`
+	SeenVisitors   map[string]time.Time  `json:"seenVisitors"`
+	ReportedFields []string              `json:"reportedFields"`
+	Runtime        runtimeState          `json:"runtime"`
+	// since version 2
+	Mutes map[string]time.Time `json:"mutes"`
+	Bans  map[string]ban       `json:"bans"`
+	Rules []Rule               `json:"rules"`
+}
+
+// snapshotVersion is written by exports, imports also read older versions
+// which just lack the newer parts
+const snapshotVersion = 2
+
+// runtimeState is the in-memory state the running logger saves periodically
+type runtimeState struct {
+	Saved    time.Time                `json:"saved"`
+	Dedup    map[string]dedupSnapshot `json:"dedup"`
+	Digest   digestSnapshot           `json:"digest"`
+	Profiles map[string]ipProfile     `json:"profiles"`
+}
+
+type dedupSnapshot struct {
+	First      time.Time `json:"first"`
+	Until      time.Time `json:"until"`
+	Host       string    `json:"host"`
+	Summary    string    `json:"summary"`
+	Repeats    int       `json:"repeats"`
+	Reported   int       `json:"reported"`
+	ReportedAt time.Time `json:"reportedAt"`
+}
+
+type digestSnapshot struct {
+	Since    time.Time      `json:"since"`
+	ClientIP map[string]int `json:"clientIp"`
+	Probes   map[string]int `json:"probes"`
+	NewUAs   map[string]int `json:"newUserAgents"`
+	Requests int            `json:"requests"`
+}
+
+func captureRuntime() runtimeState {
+	state := runtimeState{Saved: time.Now(), Dedup: map[string]dedupSnapshot{}}
+
+	dedup.mu.Lock()
+	for key, entry := range dedup.entries {
+		state.Dedup[key] = dedupSnapshot{First: entry.first, Until: entry.until, Host: entry.host, Summary: entry.summary,
+			Repeats: entry.repeats, Reported: entry.reported, ReportedAt: entry.reportedAt}
+	}
+	dedup.mu.Unlock()
+
+	digest.mu.Lock()
+	state.Digest = digestSnapshot{
+		Since:    digest.since,
+		ClientIP: copyCounts(digest.clientIP),
+		Probes:   copyCounts(digest.probes),
+		NewUAs:   copyCounts(digest.newUAs),
+		Requests: digest.requests,
+	}
+	digest.mu.Unlock()
+
+	state.Profiles = profiles.snapshot(time.Now())
+	return state
+}
+
+func restoreRuntime(state runtimeState) {
+	runtimeSaved = state.Saved
+
+	dedup.mu.Lock()
+	for key, entry := range state.Dedup {
+		restored := &dedupEntry{first: entry.First, until: entry.Until, host: entry.Host, summary: entry.Summary,
+			repeats: entry.Repeats, reported: entry.Reported, reportedAt: entry.ReportedAt}
+		if restored.reportedAt.IsZero() {
+			// saved by a version without reports
+			restored.first = time.Now()
+			restored.reportedAt = restored.first
+		}
+		dedup.entries[key] = restored
+	}
+	dedup.mu.Unlock()
+
+	digest.mu.Lock()
+	if !state.Digest.Since.IsZero() {
+		digest.since = state.Digest.Since
+		digest.clientIP = nonNil(state.Digest.ClientIP)
+		digest.probes = nonNil(state.Digest.Probes)
+		digest.newUAs = nonNil(state.Digest.NewUAs)
+		digest.requests = state.Digest.Requests
+	}
+	digest.mu.Unlock()
+
+	profiles.restore(state.Profiles)
+}
+
+func copyCounts(counts map[string]int) map[string]int {
+	copied := make(map[string]int, len(counts))
+	for k, v := range counts {
+		copied[k] = v
+	}
+	return copied
+}
+
+func nonNil(counts map[string]int) map[string]int {
+	if counts == nil {
+		return map[string]int{}
+	}
+	return counts
+}
+
+func readStateFile(config Config, name string, into interface{}) error {
+	raw, err := os.ReadFile(statePath(config, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, into)
+}
+
+func writeStateFile(config Config, name string, value interface{}) error {
+	if dryRun {
+		return nil
+	}
+	raw, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := statePath(config, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", raw, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// runtimeSaved is when the state loaded at startup was saved, events before
+// it were already counted by the previous run
+var runtimeSaved time.Time
+
+// persistRuntime saves the in-memory state so restarts and exports keep it
+func persistRuntime() {
+	for range time.Tick(10 * time.Second) {
+		if err := writeStateFile(currentConfig(), "runtime-state.json", captureRuntime()); err != nil {
+			slog.Error("Error saving runtime state", "err", err)
+		}
+	}
+}
+
+func loadRuntime(config Config) {
+	var state runtimeState
+	if err := readStateFile(config, "runtime-state.json", &state); err != nil {
+		slog.Error("Error reading runtime state", "err", err)
+		return
+	}
+	restoreRuntime(state)
+}
+
+func exportState(config Config, w io.Writer) error {
+	snap := snapshot{Version: snapshotVersion, Created: time.Now()}
+	for name, into := range map[string]interface{}{
+		"checkpoints.json":      &snap.Checkpoints,
+		"forum-threads.json":    &snap.ForumThreads,
+		"seen-user-agents.json": &snap.SeenUserAgents,
+		"seen-visitors.json":    &snap.SeenVisitors,
+		"runtime-state.json":    &snap.Runtime,
+		"reported-fields.json":  &snap.ReportedFields,
+		"mutes.json":            &snap.Mutes,
+		"bans.json":             &snap.Bans,
+	} {
+		if err := readStateFile(config, name, into); err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+	}
+	// the rules added through /rules, which may live outside stateDir
+	raw, err := os.ReadFile(rulesPath(config))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(raw, &snap.Rules); err != nil {
+			return fmt.Errorf("reading %s: %w", rulesPath(config), err)
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(snap)
+}
+
+func importState(config Config, r io.Reader) error {
+	var snap snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return err
+	}
+	if snap.Version < 1 || snap.Version > snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+
+	if err := os.MkdirAll(statePath(config, ""), 0o700); err != nil {
+		return err
+	}
+	files := map[string]interface{}{"runtime-state.json": snap.Runtime}
+	// parts the old host never had are left alone
+	if snap.Checkpoints != nil {
+		files["checkpoints.json"] = snap.Checkpoints
+	}
+	if snap.ForumThreads != nil {
+		files["forum-threads.json"] = snap.ForumThreads
+	}
+	if snap.SeenUserAgents != nil {
+		files["seen-user-agents.json"] = snap.SeenUserAgents
+	}
+	if snap.SeenVisitors != nil {
+		files["seen-visitors.json"] = snap.SeenVisitors
+	}
+	if snap.ReportedFields != nil {
+		files["reported-fields.json"] = snap.ReportedFields
+	}
+	if snap.Mutes != nil {
+		files["mutes.json"] = snap.Mutes
+	}
+	if snap.Bans != nil {
+		files["bans.json"] = snap.Bans
+	}
+	for name, value := range files {
+		if err := writeStateFile(config, name, value); err != nil {
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+	}
+	if snap.Rules != nil {
+		if err := writeRulesFile(config, snap.Rules); err != nil {
+			return fmt.Errorf("writing %s: %w", rulesPath(config), err)
+		}
+	}
+	return nil
+}
+
+// stateCommand handles "state export [file]" and "state import <file>". The
+// logger should be stopped for an import, it would overwrite the files again.
+func stateCommand(config Config, args []string) error {
+	usage := fmt.Errorf("usage: state export [file] | state import <file>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "export":
+		if len(args) < 2 || args[1] == "-" {
+			return exportState(config, os.Stdout)
+		}
+		f, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		if err := exportState(config, f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	case "import":
+		if len(args) < 2 {
+			return usage
+		}
+		in := io.Reader(os.Stdin)
+		if args[1] != "-" {
+			f, err := os.Open(args[1])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		return importState(config, in)
+	}
+	return usage
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+type StoreConfig struct {
+	// number of events kept in memory, defaults to 1000
+	Size int `json:"size"`
+}
+
+type storedEvent struct {
+	// ID is random so permalinks from before a restart don't point at a
+	// different event
+	ID   string
+	Data parse.Data
+	Raw  string
+	At   time.Time
+}
+
+// eventStore keeps the most recent events for bot queries and lets them be
+// followed live
+type eventStore struct {
+	mu     sync.Mutex
+	events []storedEvent
+	next   int
+	full   bool
+	subs   map[int]func(storedEvent)
+	lastID int
+}
+
+var events = &eventStore{subs: map[int]func(storedEvent){}}
+
+func newEventID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// add stores an event and returns its id
+func (s *eventStore) add(size int, data parse.Data, raw string) string {
+	if size <= 0 {
+		size = 1000
+	}
+	event := storedEvent{ID: newEventID(), Data: data, Raw: raw, At: time.Now()}
+
+	s.mu.Lock()
+	if len(s.events) != size {
+		s.resize(size)
+	}
+	s.events[s.next] = event
+	s.next = (s.next + 1) % size
+	if s.next == 0 {
+		s.full = true
+	}
+	subs := make([]func(storedEvent), 0, len(s.subs))
+	for _, sub := range s.subs {
+		subs = append(subs, sub)
+	}
+	s.mu.Unlock()
+
+	for _, sub := range subs {
+		sub(event)
+	}
+	return event.ID
+}
+
+// get finds a stored event by id, evicted events are gone
+func (s *eventStore) get(id string) (storedEvent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, event := range s.events {
+		if event.ID == id && id != "" {
+			return event, true
+		}
+	}
+	return storedEvent{}, false
+}
+
+// resize keeps the newest events when the configured size changes
+func (s *eventStore) resize(size int) {
+	kept := s.ordered()
+	if len(kept) > size {
+		kept = kept[len(kept)-size:]
+	}
+	s.events = make([]storedEvent, size)
+	copy(s.events, kept)
+	s.next = len(kept) % size
+	s.full = len(kept) == size
+}
+
+// ordered returns the events oldest first, callers hold the lock
+func (s *eventStore) ordered() []storedEvent {
+	if !s.full {
+		return append([]storedEvent{}, s.events[:s.next]...)
+	}
+	return append(append([]storedEvent{}, s.events[s.next:]...), s.events[:s.next]...)
+}
+
+// last returns up to n of the newest events that match, oldest first
+func (s *eventStore) last(n int, match func(parse.Data) bool) []storedEvent {
+	s.mu.Lock()
+	all := s.ordered()
+	s.mu.Unlock()
+
+	var found []storedEvent
+	for i := len(all) - 1; i >= 0 && len(found) < n; i-- {
+		if match == nil || match(all[i].Data) {
+			found = append(found, all[i])
+		}
+	}
+	for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+		found[i], found[j] = found[j], found[i]
+	}
+	return found
+}
+
+// subscribe calls fn for every new event until the returned cancel is called
+func (s *eventStore) subscribe(fn func(storedEvent)) func() {
+	s.mu.Lock()
+	s.lastID++
+	id := s.lastID
+	s.subs[id] = fn
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		delete(s.subs, id)
+		s.mu.Unlock()
+	}
+}
+
+// eventLine is the one line form used when listing events
+func eventLine(data parse.Data) string {
+	date := currentConfig().Time.formatTime(data.Ts.Time(), "01-02 15:04:05")
+	return fmt.Sprintf("%s %s %s%s → %d %s", date, data.Request.Method, data.Request.Host, data.Request.URI, data.Status, clientIP(data))
+}
+
+func init() {
+	metrics.describe("stale_events_total", "counter", "Events not posted because they were older than maxEventAge when read.")
+}
+
+// tooOld reports events that were read too late to be worth a message, e.g.
+// after catching up on a backlog
+func tooOld(config Config, data parse.Data) bool {
+	limit := parseDuration(config.MaxEventAge, 0)
+	if limit <= 0 {
+		return false
+	}
+	ts := data.Ts.Time()
+	if time.Since(ts) <= limit {
+		return false
+	}
+	metrics.add("stale_events_total", 1)
+	return true
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"simo.ng/logger/pkg/ingest"
+)
+
+const (
+	sourceFiles = "files"
+	sourceExec  = "exec"
+)
+
+// logSource picks how log lines are read. Watching the mounted log directory
+// is cheaper, without a usable mount the logs are tailed inside the
+// container instead. The files caddy reports only go to the primary pipeline.
+func logSource(p Pipeline, primary bool) string {
+	if p.Source != "" {
+		return p.Source
+	}
+	if p.Docker.Remote() {
+		return sourceExec
+	}
+	if p.LogDir != "" {
+		if _, err := os.Stat(p.LogDir); err == nil {
+			return sourceFiles
+		}
+	}
+	if found := currentDiscovery(); p.LogDir == "" && primary && found != nil && len(found.LogFiles) > 0 {
+		return sourceFiles
+	}
+	return sourceExec
+}
+
+// execFiles are the paths tailed inside the container, globs are expanded by
+// the container's shell
+func execFiles(p Pipeline) []string {
+	if len(p.ExecFiles) > 0 {
+		return p.ExecFiles
+	}
+	if found := currentDiscovery(); found != nil && len(found.LogFiles) > 0 {
+		return found.LogFiles
+	}
+	return []string{ingest.LogDir + "*.log"}
+}
+
+// errFilesChanged ends a tail whose files caddy no longer logs to
+var errFilesChanged = errors.New("caddy log files changed")
+
+// streamContainerLogs follows the files inside the container and handles
+// their lines as they arrive. Lines written while the logger is down are not
+// replayed. Closing restart stops the tail with errFilesChanged.
+func streamContainerLogs(c container, files []string, restart <-chan struct{}) error {
+	runtime, err := openSource(c.docker)
+	if err != nil {
+		return err
+	}
+	defer runtime.Close()
+
+	stdout, err := runtime.Follow(c.id, files)
+	if err != nil {
+		return err
+	}
+	defer stdout.Close()
+	slog.Info("Tailing inside the container", "files", files)
+
+	done := make(chan struct{})
+	defer close(done)
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-restart:
+			close(stopped)
+			stdout.Close()
+		case <-done:
+		}
+	}()
+
+	// with several files tail announces which one the next lines are from
+	var source string
+	if len(files) == 1 && !strings.ContainsAny(files[0], "*?[") {
+		source = path.Base(files[0])
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if name, ok := tailHeader(line); ok {
+			source = path.Base(name)
+			continue
+		}
+		if !matchesLogPattern(currentConfig(), source) {
+			continue
+		}
+		if err := protect("handling a log line", func() error {
+			handleLine(c.pipeline, source, line)
+			return nil
+		}); err != nil {
+			slog.Error(err.Error())
+		}
+	}
+	select {
+	case <-stopped:
+		return errFilesChanged
+	default:
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("tail exited")
+}
+
+// tailHeader recognises the "==> file <==" lines tail prints between files
+func tailHeader(line string) (string, bool) {
+	if strings.HasPrefix(line, "==> ") && strings.HasSuffix(line, " <==") {
+		return strings.TrimSuffix(strings.TrimPrefix(line, "==> "), " <=="), true
+	}
+	return "", false
+}
+
+// matchesLogPattern filters the files of a log directory by logPattern
+func matchesLogPattern(config Config, source string) bool {
+	if config.LogPattern == "" || source == "" {
+		return true
+	}
+	ok, _ := filepath.Match(config.LogPattern, source)
+	return ok
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/notify"
+	"simo.ng/logger/pkg/parse"
+)
+
+// SummaryConfig keeps one message per period, and per host if wanted, with
+// running counters. It is edited in place instead of posting every request.
+type SummaryConfig struct {
+	WebhookURL string `json:"webhookUrl"`
+	// Period is "hour" (default) or "day"
+	Period  string `json:"period"`
+	PerHost bool   `json:"perHost"`
+	// Every is how often changed summaries are edited, defaults to 30s
+	Every string `json:"every"`
+	Top   int    `json:"top"`
+	// Replace stops the message per request, escalated requests are still
+	// posted
+	Replace bool `json:"replace"`
+}
+
+const (
+	summaryHour = "hour"
+	summaryDay  = "day"
+)
+
+type liveSummary struct {
+	host         string
+	start, end   time.Time
+	requests     int
+	clientErrors int
+	serverErrors int
+	clientIP     map[string]int
+	// dirty is set by new events until the message is edited
+	dirty     bool
+	messageID string
+	// failed counts the final edits that didn't go through
+	failed int
+}
+
+// a final edit is tried again on the next flushes, this many times
+const summaryRetries = 10
+
+type summaryState struct {
+	mu      sync.Mutex
+	current map[string]*liveSummary
+	// finished summaries get one last edit
+	finished []*liveSummary
+}
+
+var summaries = &summaryState{current: map[string]*liveSummary{}}
+
+// bounds returns the period now falls into
+func (c SummaryConfig) bounds(now time.Time, loc *time.Location) (time.Time, time.Time) {
+	now = now.In(loc)
+	if c.Period == summaryDay {
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, 1)
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, loc)
+	return start, start.Add(time.Hour)
+}
+
+func (s *summaryState) record(config Config, data parse.Data) {
+	if config.Summary == nil {
+		return
+	}
+	var host string
+	if config.Summary.PerHost {
+		host = strings.ToLower(data.Request.Host)
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sum := s.current[host]
+	if sum == nil || !now.Before(sum.end) {
+		if sum != nil {
+			s.finished = append(s.finished, sum)
+		}
+		start, end := config.Summary.bounds(now, config.Time.location())
+		sum = &liveSummary{host: host, start: start, end: end, clientIP: map[string]int{}}
+		s.current[host] = sum
+	}
+	sum.requests++
+	switch {
+	case data.Status >= 500:
+		sum.serverErrors++
+	case data.Status >= 400:
+		sum.clientErrors++
+	}
+	sum.clientIP[clientIP(data)]++
+	sum.dirty = true
+}
+
+// message renders the summary, callers hold the lock
+func (l *liveSummary) message(cfg SummaryConfig, now time.Time, final bool) notify.Message {
+	top := cfg.Top
+	if top <= 0 {
+		top = 5
+	}
+	what := "All hosts"
+	if l.host != "" {
+		what = l.host
+	}
+	period := l.start.Format("2006-01-02 15:04") + "–" + l.end.Format("15:04")
+	if cfg.Period == summaryDay {
+		period = l.start.Format("2006-01-02")
+	}
+	footer := "Live, updated every " + parseDuration(cfg.Every, 30*time.Second).String()
+	if final {
+		footer = "Final"
+	}
+
+	return notify.Message{Embeds: []notify.Embed{{
+		Title: "📊 " + notify.Truncate(what, 200) + " · " + period,
+		Description: fmt.Sprintf("%s · %d × 4xx · %d × 5xx",
+			formatCount(l.requests, "request"), l.clientErrors, l.serverErrors),
+		Color: 0x3498DB,
+		Fields: []notify.EmbedField{
+			{Name: "Top addresses", Value: notify.FieldValue(rankedLines(topN(l.clientIP, top), 45))},
+		},
+		Footer:    &notify.EmbedFooter{Text: footer},
+		Timestamp: now.UTC().Format(time.RFC3339),
+	}}}
+}
+
+type summaryEdit struct {
+	summary *liveSummary
+	id      string
+	message notify.Message
+	final   bool
+}
+
+// flush edits every summary that changed since the last flush and closes
+// the ones whose period is over
+func (s *summaryState) flush(config Config, now time.Time) {
+	cfg := *config.Summary
+
+	s.mu.Lock()
+	for host, sum := range s.current {
+		if !now.Before(sum.end) {
+			s.finished = append(s.finished, sum)
+			delete(s.current, host)
+		}
+	}
+	var edits []summaryEdit
+	for _, sum := range s.finished {
+		edits = append(edits, summaryEdit{sum, sum.messageID, sum.message(cfg, now, true), true})
+	}
+	s.finished = nil
+	for _, sum := range s.current {
+		if sum.dirty {
+			sum.dirty = false
+			edits = append(edits, summaryEdit{sum, sum.messageID, sum.message(cfg, now, false), false})
+		}
+	}
+	s.mu.Unlock()
+
+	webhook := cfg.WebhookURL
+	if webhook == "" {
+		webhook = config.WebhookURL
+	}
+	for _, edit := range edits {
+		id, err := publishSummary(webhook, edit.id, edit.message)
+		s.mu.Lock()
+		switch {
+		case err != nil && edit.final:
+			// no longer tracked, keep it for the next flush
+			edit.summary.failed++
+			if edit.summary.failed < summaryRetries {
+				s.finished = append(s.finished, edit.summary)
+				slog.Error("Error closing live summary, trying again", "host", edit.summary.host, "err", err)
+			} else {
+				slog.Error("Giving up on closing live summary", "host", edit.summary.host, "err", err)
+			}
+		case err != nil:
+			slog.Error("Error updating live summary", "host", edit.summary.host, "err", err)
+			edit.summary.dirty = true
+		default:
+			edit.summary.messageID = id
+		}
+		s.mu.Unlock()
+	}
+}
+
+// publishSummary posts a summary the first time and edits that message
+// after, a message deleted from the channel is posted again
+func publishSummary(webhook, id string, message notify.Message) (string, error) {
+	if id != "" {
+		err := editMessage(webhook, id, message)
+		if discordErrorCode(err) != errUnknownMessage {
+			return id, err
+		}
+	}
+	created, err := executeWebhook(webhook, url.Values{"wait": {"true"}}, message)
+	if err != nil || created == nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (s *summaryState) run() {
+	for {
+		cfg := currentConfig()
+		if cfg.Summary == nil {
+			time.Sleep(time.Minute)
+			continue
+		}
+		time.Sleep(parseDuration(cfg.Summary.Every, 30*time.Second))
+		if cfg = currentConfig(); cfg.Summary != nil {
+			s.flush(cfg, time.Now())
+		}
+	}
+}
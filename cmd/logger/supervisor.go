@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+)
+
+// unrecoverableError marks failures a restart can't fix, like a config that
+// is missing something required. These are the only ones that end the
+// process.
+type unrecoverableError struct {
+	err error
+}
+
+func (e *unrecoverableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *unrecoverableError) Unwrap() error {
+	return e.err
+}
+
+func unrecoverable(err error) error {
+	return &unrecoverableError{err: err}
+}
+
+var supervisorBackoff = RetryPolicy{Delay: "1s", MaxDelay: "1m", Jitter: 0.2}
+
+// supervise keeps fn running. Errors and panics restart it with a growing
+// delay, which starts over once it ran fine for a while.
+func supervise(name string, fn func() error) {
+	for attempt := 0; ; attempt++ {
+		started := time.Now()
+		err := protect(name, fn)
+
+		var stop *unrecoverableError
+		if errors.As(err, &stop) {
+			fatal(name+" failed", "err", err)
+		}
+		if err == nil {
+			err = fmt.Errorf("%s stopped", name)
+		}
+		if time.Since(started) > 5*time.Minute {
+			// it was healthy up to now, this is a new streak
+			health.observe(name, nil)
+			attempt = 0
+		}
+		health.observe(name, err)
+
+		wait := supervisorBackoff.backoff(attempt)
+		slog.Warn(name+" failed, restarting", "in", wait.Round(time.Millisecond).String(), "err", err)
+		time.Sleep(wait)
+	}
+}
+
+// background supervises a loop that is only expected to return when it broke
+func background(name string, fn func()) {
+	go supervise(name, func() error {
+		fn()
+		return nil
+	})
+}
+
+// protect turns a panic in fn into an error so one bad log line or a bug in
+// a side feature doesn't end all monitoring
+func protect(name string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Panic in "+name, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
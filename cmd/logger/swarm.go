@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"simo.ng/logger/pkg/ingest"
+)
+
+// replicaNames maps swarm task ids to "service.slot", the name docker shows
+// for a replica
+type replicaNames struct {
+	mu    sync.Mutex
+	names map[string]string
+}
+
+func (r *replicaNames) lookup(p Pipeline, taskID string) string {
+	r.mu.Lock()
+	name, ok := r.names[taskID]
+	r.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	// a task we don't know yet, most likely a replica that was just started
+	var slots map[string]int
+	err := withSource(p.Docker, func(source ingest.Source) error {
+		var err error
+		slots, err = source.ServiceTasks(p.Service)
+		return err
+	})
+	if err != nil {
+		slog.Error("Error listing tasks", "service", p.Service, "err", err)
+		return p.Service
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, slot := range slots {
+		r.names[id] = fmt.Sprintf("%s.%d", p.Service, slot)
+	}
+	if name, ok := r.names[taskID]; ok {
+		return name
+	}
+	return p.Service
+}
+
+// followService streams the logs of every replica of a swarm service through
+// the manager the pipeline points at. This only sees what caddy writes to
+// stdout or stderr, so its access log has to be configured with
+// `output stdout` instead of a file.
+func followService(p Pipeline) error {
+	runtime, err := openSource(p.Docker)
+	if err != nil {
+		return err
+	}
+	defer runtime.Close()
+
+	stdout, err := runtime.ServiceLogs(p.Service)
+	if err != nil {
+		return err
+	}
+	defer stdout.Close()
+	slog.Info("Following the replicas of service", "service", p.Service)
+
+	replicas := &replicaNames{names: map[string]string{}}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		details, line := splitLogDetails(scanner.Text())
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		source := replicas.lookup(p, details["com.docker.swarm.task.id"])
+		if err := protect("handling a log line", func() error {
+			handleLine(p.Name, source, line)
+			return nil
+		}); err != nil {
+			slog.Error(err.Error())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("service log stream ended")
+}
+
+// splitLogDetails separates the "key=value,key=value " prefix docker adds to
+// every line when details are requested
+func splitLogDetails(line string) (map[string]string, string) {
+	details := map[string]string{}
+	prefix, rest, ok := strings.Cut(line, " ")
+	if !ok || !strings.Contains(prefix, "=") || strings.HasPrefix(prefix, "{") {
+		return details, line
+	}
+	for _, pair := range strings.Split(prefix, ",") {
+		key, value, _ := strings.Cut(pair, "=")
+		details[key] = value
+	}
+	return details, rest
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type TeamsConfig struct {
+	// WebhookURL is an incoming webhook of a channel, or the url of a
+	// workflow started by a webhook request
+	WebhookURL string `json:"webhookUrl"`
+	NotifierFilter
+}
+
+type teamsNotifier struct {
+	config TeamsConfig
+	client *http.Client
+}
+
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string    `json:"contentType"`
+	Content     teamsCard `json:"content"`
+}
+
+type teamsCard struct {
+	Schema  string            `json:"$schema"`
+	Type    string            `json:"type"`
+	Version string            `json:"version"`
+	Body    []teamsElement    `json:"body"`
+	MSTeams map[string]string `json:"msteams,omitempty"`
+}
+
+// teamsElement is the part of the adaptive card schema used here, a text
+// block or a container of them
+type teamsElement struct {
+	Type     string         `json:"type"`
+	Text     string         `json:"text,omitempty"`
+	Weight   string         `json:"weight,omitempty"`
+	Color    string         `json:"color,omitempty"`
+	FontType string         `json:"fontType,omitempty"`
+	Spacing  string         `json:"spacing,omitempty"`
+	Wrap     bool           `json:"wrap,omitempty"`
+	IsSubtle bool           `json:"isSubtle,omitempty"`
+	Style    string         `json:"style,omitempty"`
+	Bleed    bool           `json:"bleed,omitempty"`
+	Items    []teamsElement `json:"items,omitempty"`
+}
+
+func newTeamsNotifier(config TeamsConfig) *teamsNotifier {
+	return &teamsNotifier{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (t *teamsNotifier) Name() string {
+	return "teams"
+}
+
+func (t *teamsNotifier) accepts(alert Alert) bool {
+	return t.config.accepts(alert)
+}
+
+// teamsStyle stands in for the embed color, cards only know a few named
+// styles
+func teamsStyle(status int) string {
+	switch status / 100 {
+	case 5:
+		return "attention"
+	case 4:
+		return "warning"
+	case 2:
+		return "good"
+	}
+	return "emphasis"
+}
+
+// Notify posts the alert laid out like a discord embed: the emoji header and
+// the request on a colored band, the fields in monospace and the extras
+// below
+func (t *teamsNotifier) Notify(alert Alert) error {
+	head := []teamsElement{
+		{Type: "TextBlock", Text: defaultEmoji.header(alert.Data, alert.Country), Wrap: true},
+		{Type: "TextBlock", Text: alert.Title(), Weight: "Bolder", Spacing: "None", Wrap: true},
+	}
+	if alert.Escalated {
+		head = append(head, teamsElement{Type: "TextBlock", Text: "🚨 Escalated: " + alert.Reason, Color: "Attention", Weight: "Bolder", Wrap: true})
+	}
+	body := []teamsElement{{Type: "Container", Style: teamsStyle(alert.Data.Status), Bleed: true, Items: head}}
+
+	// one block per line, line breaks inside a block render differently
+	// between the desktop and mobile clients
+	for i, line := range alert.Lines {
+		block := teamsElement{Type: "TextBlock", Text: line, FontType: "Monospace", Spacing: "None", Wrap: true}
+		if i == 0 {
+			block.Spacing = "Medium"
+		}
+		body = append(body, block)
+	}
+	for _, note := range alert.Notes {
+		body = append(body, teamsElement{Type: "TextBlock", Text: note, IsSubtle: true, Wrap: true})
+	}
+	return t.post(body)
+}
+
+// Alert posts the logger's own warnings
+func (t *teamsNotifier) Alert(message string) error {
+	return t.post([]teamsElement{{Type: "TextBlock", Text: "⚠️ " + message, Color: "Warning", Wrap: true}})
+}
+
+func (t *teamsNotifier) post(body []teamsElement) error {
+	payload, err := json.Marshal(teamsMessage{
+		Type: "message",
+		Attachments: []teamsAttachment{{
+			ContentType: "application/vnd.microsoft.card.adaptive",
+			Content: teamsCard{
+				Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+				Type:    "AdaptiveCard",
+				Version: "1.4",
+				Body:    body,
+				MSTeams: map[string]string{"width": "Full"},
+			},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.config.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return newStatusError("teams", resp)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeConfig controls how times appear in messages
+type TimeConfig struct {
+	// IANA zone like "Europe/Amsterdam", defaults to the server's zone
+	Timezone string `json:"timezone"`
+	// Go layout, defaults to "2006-01-02 15:04:05"
+	Format string `json:"format"`
+	// Discord shows <t:unix:style> in each reader's own zone, "R" reads
+	// "3 minutes ago". Set a style to use it instead of a fixed time.
+	Discord string `json:"discord"`
+}
+
+func (c *TimeConfig) location() *time.Location {
+	if c == nil || c.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// formatTime renders t for plain text, which includes code blocks where
+// discord timestamps don't render
+func (c *TimeConfig) formatTime(t time.Time, fallback string) string {
+	layout := fallback
+	if c != nil && c.Format != "" {
+		layout = c.Format
+	}
+	return t.In(c.location()).Format(layout)
+}
+
+// discordTime is the <t:unix:style> markup, empty when not configured
+func (c *TimeConfig) discordTime(t time.Time) string {
+	if c == nil || c.Discord == "" {
+		return ""
+	}
+	return fmt.Sprintf("<t:%d:%s>", t.Unix(), c.Discord)
+}
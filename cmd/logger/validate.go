@@ -0,0 +1,714 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+// RuleTest is a sample log line and what the config is expected to do with
+// it, run by `validate`
+type RuleTest struct {
+	Name string `json:"name"`
+	// Event is a caddy log line as json
+	Event json.RawMessage `json:"event"`
+	// Source is the log file the line pretends to come from
+	Source string     `json:"source"`
+	Expect RuleExpect `json:"expect"`
+}
+
+// RuleExpect only checks what is set
+type RuleExpect struct {
+	Severity string `json:"severity"`
+	// Routes is the exact set of route names that get the message
+	Routes    []string `json:"routes"`
+	Escalated *bool    `json:"escalated"`
+	Attached  *bool    `json:"attached"`
+	// Dropped is whether a rule drops the event, Tags the exact set of
+	// tags the rules add
+	Dropped *bool    `json:"dropped"`
+	Tags    []string `json:"tags"`
+	// Chain is the name of the chain rule that decides, or "default"
+	Chain string `json:"chain"`
+}
+
+// validateConfig lists mistakes that would otherwise only show up as log
+// lines at runtime
+func validateConfig(config Config) []string {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	duration := func(name, value string) {
+		if value == "" {
+			return
+		}
+		if _, err := time.ParseDuration(value); err != nil {
+			problem("%s: %v", name, err)
+		}
+	}
+	size := func(name, value string) {
+		if _, ok := parseSize(value); value != "" && !ok {
+			problem("%s: %q is not a size like 50MB", name, value)
+		}
+	}
+	severity := func(name, value string) {
+		if value != severityInfo && value != severityWarn && value != severityCritical {
+			problem("%s: unknown severity %q, use info, warn or critical", name, value)
+		}
+	}
+
+	names := map[string]bool{}
+	for i, p := range pipelines(config) {
+		if names[p.Name] {
+			problem("pipeline %s: the name is used twice", p.Name)
+		}
+		names[p.Name] = true
+		if p.ContainerName == "" && p.Service == "" {
+			problem("pipeline %s: neither containerName nor service is set", p.Name)
+		}
+		if p.Source != "" && p.Source != sourceFiles && p.Source != sourceExec {
+			problem("pipeline %s: unknown source %q, use files or exec", p.Name, p.Source)
+		}
+		if r := p.Docker.Runtime; r != "" && r != "docker" && r != "podman" {
+			problem("pipeline %s: unknown runtime %q, use docker or podman", p.Name, r)
+		}
+		if p.Docker.Remote() && p.Source == sourceFiles {
+			problem("pipeline %s: a remote docker host can only be tailed through exec", p.Name)
+		}
+		// only the first pipeline gets the log files of the caddy admin api
+		if p.Source == sourceFiles && p.LogDir == "" && (i > 0 || config.CaddyAdmin == nil) {
+			problem("pipeline %s: source files needs a logDir", p.Name)
+		}
+		for i, o := range p.Outputs {
+			if !contains(outputNames, o.Output) {
+				problem("pipeline %s: output #%d: unknown output %q, use %s", p.Name, i+1, o.Output, strings.Join(outputNames, ", "))
+			}
+			for _, s := range o.Severities {
+				severity(fmt.Sprintf("pipeline %s: output #%d", p.Name, i+1), s)
+			}
+		}
+	}
+	if len(webhookUrls(config)) == 0 {
+		problem("no webhook configured")
+	}
+	duration("maxEventAge", config.MaxEventAge)
+	if n := len(parseProxies(config.TrustedProxies)); n != len(config.TrustedProxies) {
+		problem("trustedProxies: %d of %d entries are not an address or CIDR", len(config.TrustedProxies)-n, len(config.TrustedProxies))
+	}
+	if config.Time != nil && config.Time.Timezone != "" {
+		if _, err := time.LoadLocation(config.Time.Timezone); err != nil {
+			problem("time.timezone: %v", err)
+		}
+	}
+
+	if len(config.Routes) > 0 && onlyCanaries(config) && config.WebhookURL == "" {
+		problem("every route is a canary and there is no webhookUrl for the traffic they copy")
+	}
+	for i, route := range config.Routes {
+		name := route.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		if route.WebhookURL == "" && config.WebhookURL == "" {
+			problem("route %s has no webhookUrl and there is no default", name)
+		}
+		if route.FirstSeen != "" && route.FirstSeen != firstSeenIP && route.FirstSeen != firstSeenFingerprint {
+			problem("route %s: firstSeen must be %q or %q", name, firstSeenIP, firstSeenFingerprint)
+		}
+		if route.Presentation != "" && route.Presentation != presentationAccessible {
+			problem("route %s: unknown presentation %q", name, route.Presentation)
+		}
+		for _, service := range route.Intel {
+			if !knownIntel(service) {
+				problem("route %s: unknown intel service %q", name, service)
+			}
+		}
+		for code := range route.Emoji.Countries {
+			if !validCountry(code) {
+				problem("route %s: %q is not a country code", name, code)
+			}
+		}
+		for key, value := range route.Emoji.Colors {
+			if _, ok := parseColor(value); !ok {
+				problem("route %s: color %q for %s is not a hex color", name, value, key)
+			}
+		}
+		for _, s := range route.Severities {
+			severity("route "+name, s)
+		}
+		duration("route "+name+" canaryFor", route.CanaryFor)
+	}
+	if config.Severity != nil {
+		for i, rule := range config.Severity.Rules {
+			severity(fmt.Sprintf("severity rule #%d", i), rule.Severity)
+			duration(fmt.Sprintf("severity rule #%d slowerThan", i), rule.SlowerThan)
+			size(fmt.Sprintf("severity rule #%d largerThan", i), rule.LargerThan)
+		}
+	}
+	if p := config.Privacy; p != nil {
+		switch p.IP {
+		case "", privacyTruncate, privacyHash:
+		default:
+			problem("privacy.ip must be %q or %q", privacyTruncate, privacyHash)
+		}
+		if p.IP == privacyHash && p.Salt == "" {
+			problem("privacy.salt is required with ip hash, unsalted address hashes are easy to reverse")
+		}
+		if p.IP != "" && len(config.Actions) > 0 {
+			problem("actions can't ban anonymized addresses, remove them or privacy.ip")
+		}
+		// tor exit nodes and reputations are per address, a truncated one
+		// is somebody else's network address
+		if p.IP != "" && config.AbuseIPDB != nil && config.AbuseIPDB.APIKey != "" {
+			problem("abuseIpdb can't look up anonymized addresses, remove it or privacy.ip")
+		}
+		if p.IP != "" && config.IPLists != nil && config.IPLists.Tor {
+			problem("ipLists.tor can't match anonymized addresses, remove it or privacy.ip")
+		}
+		if p.IP == privacyTruncate && config.Escalation != nil && contains(config.Escalation.Networks, listTor) {
+			problem("escalation.networks: tor can't match truncated addresses, remove it or privacy.ip")
+		}
+		if p.IP == privacyHash {
+			if config.ASN != nil && config.ASN.Database != "" {
+				problem("asn can't look up hashed addresses, remove it or use ip truncate")
+			}
+			if config.IPLists != nil && len(config.IPLists.Datacenter) > 0 {
+				problem("ipLists.datacenter can't match hashed addresses, remove it or use ip truncate")
+			}
+			if config.Escalation != nil && len(config.Escalation.Networks) > 0 {
+				problem("escalation.networks can't match hashed addresses, remove them or use ip truncate")
+			}
+		}
+	}
+	if config.Log != nil {
+		if _, err := config.Log.level(); err != nil {
+			problem("log.level: %v", err)
+		}
+		if format := config.Log.format(); format != "console" && format != "json" {
+			problem("log.format must be \"console\" or \"json\"")
+		}
+	}
+	if config.BruteForce != nil {
+		duration("bruteForce.window", config.BruteForce.Window)
+	}
+	if config.Signatures != nil {
+		for _, rule := range config.Signatures.Extra {
+			if rule.URI == "" && rule.UserAgent == "" {
+				problem("signature %s: needs uri or userAgent", rule.Name)
+			}
+			for field, pattern := range map[string]string{"uri": rule.URI, "userAgent": rule.UserAgent} {
+				if _, err := regexp.Compile(pattern); err != nil {
+					problem("signature %s: invalid %s: %v", rule.Name, field, err)
+				}
+			}
+		}
+	}
+	actionNames := map[string]bool{}
+	for i, action := range config.Actions {
+		if action.Name == "" {
+			problem("action #%d: name is required, it keys the bans", i)
+		} else if actionNames[action.Name] {
+			problem("action %s: name used twice", action.Name)
+		}
+		actionNames[action.Name] = true
+		if len(action.Statuses) == 0 && len(action.Paths) == 0 {
+			problem("action %s: needs statuses or paths to trigger on", action.Name)
+		}
+		duration("action "+action.Name+" window", action.Window)
+		duration("action "+action.Name+" duration", action.Duration)
+		if action.Nftables != "" && len(strings.Fields(action.Nftables)) != 3 {
+			problem("action %s: nftables must be \"family table set\"", action.Name)
+		}
+		if cf := action.Cloudflare; cf != nil {
+			if cf.APIToken == "" || (cf.ZoneID == "" && cf.AccountID == "") {
+				problem("action %s: cloudflare needs apiToken and zoneId or accountId", action.Name)
+			}
+			switch cf.Mode {
+			case "", "block", "challenge", "js_challenge", "managed_challenge":
+			default:
+				problem("action %s: unknown cloudflare mode %q", action.Name, cf.Mode)
+			}
+		}
+		if action.Caddy != nil && action.Caddy.ID == "" {
+			problem("action %s: caddy needs the @id of a remote_ip matcher", action.Name)
+		}
+	}
+	if config.Country != nil {
+		for _, code := range config.Country.Ignore {
+			if !validCountry(code) {
+				problem("country.ignore: %q is not a country code", code)
+			}
+		}
+	}
+	for _, field := range config.Fields {
+		if !contains(knownFields, field) {
+			problem("fields: unknown field %q, use one of %s", field, strings.Join(knownFields, ", "))
+		}
+	}
+	if config.Summary != nil {
+		if p := config.Summary.Period; p != "" && p != summaryHour && p != summaryDay {
+			problem("summary.period must be %q or %q", summaryHour, summaryDay)
+		}
+		duration("summary.every", config.Summary.Every)
+	}
+	if config.Bot != nil {
+		duration("bot.muteFor", config.Bot.MuteFor)
+	}
+	if config.IPLists != nil {
+		duration("ipLists.refresh", config.IPLists.Refresh)
+	}
+	if config.Escalation != nil {
+		for _, kind := range config.Escalation.Networks {
+			if kind != listTor && kind != listDatacenter {
+				problem("escalation.networks: unknown list %q, use %q or %q", kind, listTor, listDatacenter)
+			}
+		}
+		for _, rule := range config.Escalation.Countries {
+			for _, code := range rule.Countries {
+				if !validCountry(code) {
+					problem("escalation.countries: %q is not a country code", code)
+				}
+			}
+		}
+	}
+	if config.Matrix != nil {
+		if config.Matrix.Homeserver == "" || config.Matrix.RoomID == "" {
+			problem("matrix: homeserver and roomId are required")
+		}
+		if config.Matrix.RoomID != "" && !strings.HasPrefix(config.Matrix.RoomID, "!") {
+			problem("matrix.roomId: %q is not a room id like !abc:matrix.org", config.Matrix.RoomID)
+		}
+		for _, s := range config.Matrix.Severities {
+			severity("matrix", s)
+		}
+	}
+	if config.Ntfy != nil {
+		if config.Ntfy.URL == "" {
+			problem("ntfy: url is required")
+		}
+		for key, p := range config.Ntfy.Priorities {
+			if key != "escalated" {
+				severity("ntfy.priorities", key)
+			}
+			if !contains(ntfyPriorities, p) {
+				problem("ntfy.priorities: unknown priority %q, use min, low, default, high or urgent", p)
+			}
+		}
+		for _, s := range config.Ntfy.Severities {
+			severity("ntfy", s)
+		}
+	}
+	if config.Pushover != nil {
+		if config.Pushover.Token == "" || config.Pushover.User == "" {
+			problem("pushover: token and user are required")
+		}
+		for key, p := range config.Pushover.Priorities {
+			if key != "escalated" {
+				severity("pushover.priorities", key)
+			}
+			if p < -2 || p > 2 {
+				problem("pushover.priorities: %d is not a priority from -2 to 2", p)
+			}
+		}
+		for key := range config.Pushover.Sounds {
+			if key != "escalated" {
+				severity("pushover.sounds", key)
+			}
+		}
+		duration("pushover.retry", config.Pushover.Retry)
+		duration("pushover.expire", config.Pushover.Expire)
+		if retry := parseDuration(config.Pushover.Retry, time.Minute); retry < 30*time.Second {
+			problem("pushover.retry: must be at least 30s")
+		}
+		if expire := parseDuration(config.Pushover.Expire, time.Hour); expire > 3*time.Hour {
+			problem("pushover.expire: must be at most 3h")
+		}
+		for _, s := range config.Pushover.Severities {
+			severity("pushover", s)
+		}
+	}
+	if config.Gotify != nil {
+		if config.Gotify.URL == "" || config.Gotify.Token == "" {
+			problem("gotify: url and token are required")
+		}
+		for key, p := range config.Gotify.Priorities {
+			if key != "escalated" {
+				severity("gotify.priorities", key)
+			}
+			if p < 0 || p > 10 {
+				problem("gotify.priorities: %d is not a priority from 0 to 10", p)
+			}
+		}
+		for _, s := range config.Gotify.Severities {
+			severity("gotify", s)
+		}
+	}
+	if config.Teams != nil {
+		if config.Teams.WebhookURL == "" {
+			problem("teams: webhookUrl is required")
+		}
+		for _, s := range config.Teams.Severities {
+			severity("teams", s)
+		}
+	}
+	if config.Email != nil {
+		if config.Email.Host == "" || config.Email.From == "" || len(config.Email.To) == 0 {
+			problem("email: host, from and to are required")
+		}
+		if _, err := mail.ParseAddress(config.Email.From); config.Email.From != "" && err != nil {
+			problem("email.from: %v", err)
+		}
+		if tls := config.Email.TLS; tls != "" && tls != emailStartTLS && tls != emailTLS && tls != emailPlain {
+			problem("email.tls: unknown mode %q, use starttls, tls or none", tls)
+		}
+		if _, err := parseEmailTemplates(config.Email.Template); err != nil {
+			problem("email.template: %v", err)
+		}
+		for _, s := range config.Email.Severities {
+			severity("email", s)
+		}
+	}
+	if config.PagerDuty != nil && config.PagerDuty.RoutingKey == "" {
+		problem("pagerDuty: routingKey is required")
+	}
+	if config.Opsgenie != nil {
+		if config.Opsgenie.APIKey == "" {
+			problem("opsgenie: apiKey is required")
+		}
+		for key, p := range config.Opsgenie.Priorities {
+			severity("opsgenie.priorities", key)
+			if !contains(opsgeniePriorities, p) {
+				problem("opsgenie.priorities: unknown priority %q, use P1 to P5", p)
+			}
+		}
+	}
+	if config.MQTT != nil {
+		if u, err := url.Parse(config.MQTT.URL); err != nil || u.Host == "" {
+			problem("mqtt.url: %q is not a broker url like mqtt://broker:1883", config.MQTT.URL)
+		} else if !contains([]string{"mqtt", "tcp", "mqtts", "ssl", "tls"}, u.Scheme) {
+			problem("mqtt.url: unknown scheme %q, use mqtt or mqtts", u.Scheme)
+		}
+		if strings.ContainsAny(config.MQTT.Topic, "+#") {
+			problem("mqtt.topic: wildcards can't be published to")
+		}
+	}
+	if config.NATS != nil {
+		if u, err := url.Parse(config.NATS.URL); err != nil || u.Host == "" || (u.Scheme != "nats" && u.Scheme != "tls") {
+			problem("nats.url: %q is not a server url like nats://nats:4222", config.NATS.URL)
+		}
+		if strings.ContainsAny(config.NATS.Subject, " *>\t") {
+			problem("nats.subject: %q can't have spaces or wildcards", config.NATS.Subject)
+		}
+	}
+	if config.Kafka != nil && (config.Kafka.RESTProxy == "" || config.Kafka.Topic == "") {
+		problem("kafka: restProxy and topic are required")
+	}
+	if config.Elasticsearch != nil {
+		if config.Elasticsearch.URL == "" {
+			problem("elasticsearch: url is required")
+		}
+		if index := config.Elasticsearch.Index; index != strings.ToLower(index) || strings.ContainsAny(index, ` "*\\/<>|,#?`) {
+			problem("elasticsearch.index: %q is not a valid index name", index)
+		}
+		duration("elasticsearch.batch.every", config.Elasticsearch.Batch.Every)
+	}
+	if config.ClickHouse != nil {
+		if config.ClickHouse.URL == "" {
+			problem("clickhouse: url is required")
+		}
+		if table := config.ClickHouse.Table; table != "" && !clickhouseIdentifier.MatchString(table) {
+			problem("clickhouse.table: %q is not a plain table name", table)
+		}
+		for column, field := range config.ClickHouse.Columns {
+			if !clickhouseIdentifier.MatchString(column) {
+				problem("clickhouse.columns: %q is not a plain column name", column)
+			}
+			if !contains(eventFields, field) {
+				problem("clickhouse.columns: unknown field %q, use one of %s", field, strings.Join(eventFields, ", "))
+			}
+		}
+		if config.ClickHouse.CreateTable && len(config.ClickHouse.Columns) > 0 {
+			problem("clickhouse: createTable only creates the default table, leave out columns or create it yourself")
+		}
+		duration("clickhouse.batch.every", config.ClickHouse.Batch.Every)
+	}
+	if config.Influx != nil {
+		u, err := url.Parse(config.Influx.URL)
+		switch {
+		case err != nil || u.Host == "":
+			problem("influx.url: %q is not a url like http://influxdb:8086", config.Influx.URL)
+		case u.Scheme == "udp":
+		case config.Influx.Bucket == "" && config.Influx.Database == "":
+			problem("influx: bucket (v2) or database (1.x) is required")
+		}
+		duration("influx.batch.every", config.Influx.Batch.Every)
+	}
+	if config.S3 != nil {
+		if config.S3.Endpoint == "" || config.S3.Bucket == "" || config.S3.AccessKey == "" || config.S3.SecretKey == "" {
+			problem("s3: endpoint, bucket, accessKey and secretKey are required")
+		}
+		duration("s3.batch.every", config.S3.Batch.Every)
+	}
+	if config.OTLP != nil {
+		if u, err := url.Parse(config.OTLP.Endpoint); err != nil || u.Host == "" {
+			problem("otlp.endpoint: %q is not a url like http://otel-collector:4318", config.OTLP.Endpoint)
+		}
+		duration("otlp.metricsInterval", config.OTLP.MetricsInterval)
+		duration("otlp.batch.every", config.OTLP.Batch.Every)
+	}
+	if config.NDJSON != nil {
+		if config.NDJSON.Path == "" {
+			problem("ndjson.path is required")
+		}
+		size("ndjson.maxSize", config.NDJSON.MaxSize)
+		for _, s := range config.NDJSON.Severities {
+			severity("ndjson", s)
+		}
+	}
+	for i, rule := range config.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		problems = append(problems, ruleProblems(config, "rule "+name, rule)...)
+	}
+	problems = append(problems, chainProblems(config)...)
+	if config.History != nil {
+		duration("history.window", config.History.Window)
+		for _, s := range config.History.Severities {
+			severity("history", s)
+		}
+	}
+	if config.Breaker != nil {
+		duration("breaker.cooldown", config.Breaker.Cooldown)
+	}
+	if config.Queue != nil {
+		duration("queue.maxAge", config.Queue.MaxAge)
+		duration("queue.interval", config.Queue.Interval)
+	}
+	if config.Dedup != nil {
+		duration("dedup.window", config.Dedup.Window)
+		duration("dedup.report", config.Dedup.Report)
+	}
+	if config.Escalation != nil {
+		duration("escalation.cooldown", config.Escalation.Cooldown)
+		duration("escalation.slowerThan", config.Escalation.SlowerThan)
+		size("escalation.largerThan", config.Escalation.LargerThan)
+		if config.Escalation.ServerErrors != nil {
+			duration("escalation.serverErrors.window", config.Escalation.ServerErrors.Window)
+		}
+	}
+	if config.Incidents != nil {
+		duration("incidents.silence", config.Incidents.Silence)
+		if config.Incidents.ErrorRate != nil {
+			duration("incidents.errorRate.window", config.Incidents.ErrorRate.Window)
+		}
+	}
+	if config.Quiet != nil {
+		for i, window := range config.Quiet.Schedules {
+			for _, at := range []string{window.From, window.To} {
+				if _, err := time.Parse("15:04", at); err != nil {
+					problem("quiet.schedules #%d: %q is not a time like 23:00", i, at)
+				}
+			}
+		}
+		if _, err := time.LoadLocation(config.Quiet.Timezone); err != nil {
+			problem("quiet.timezone: %v", err)
+		}
+	}
+	return problems
+}
+
+// ruleProblems checks a rule of rules, the /rules API runs it on the rules
+// it gets
+func ruleProblems(config Config, name string, rule Rule) []string {
+	var problems []string
+	if _, err := compileRule(rule.Match); err != nil {
+		problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+	}
+	for _, link := range rule.Links {
+		if _, err := time.ParseDuration(link.Window); link.Window != "" && err != nil {
+			problems = append(problems, fmt.Sprintf("%s: link %s window: %v", name, link.Name, err))
+		}
+		if _, err := template.New(link.Name).Funcs(linkFuncs).Parse(link.URL); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: link %s: %v", name, link.Name, err))
+		}
+	}
+	switch rule.Action {
+	case ruleDrop, ruleEscalate:
+	case ruleLink:
+		if len(rule.Links) == 0 {
+			problems = append(problems, name+": link needs links")
+		}
+	case ruleRoute:
+		problems = append(problems, routeProblems(config, name, rule.Routes)...)
+	case ruleTag:
+		if len(rule.Tags) == 0 {
+			problems = append(problems, name+": tag needs tags")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("%s: unknown action %q, use drop, route, escalate, tag or link", name, rule.Action))
+	}
+	return problems
+}
+
+// chainProblems checks the chain. A broken chain would let through what it
+// should stop, so it's also checked on every (re)load and refused.
+func chainProblems(config Config) []string {
+	if config.Chain == nil {
+		return nil
+	}
+	var problems []string
+	for i, rule := range config.Chain.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		if _, err := compileRule(rule.Match); err != nil {
+			problems = append(problems, fmt.Sprintf("chain rule %s: %v", name, err))
+		}
+		switch rule.Action {
+		case ruleAllow, ruleDeny:
+		case ruleRoute:
+			problems = append(problems, routeProblems(config, "chain rule "+name, rule.Routes)...)
+		default:
+			problems = append(problems, fmt.Sprintf("chain rule %s: unknown action %q, use allow, deny or route", name, rule.Action))
+		}
+	}
+	switch config.Chain.Default {
+	case "", ruleAllow, ruleDeny:
+	case ruleRoute:
+		problems = append(problems, routeProblems(config, "chain.default", config.Chain.Routes)...)
+	default:
+		problems = append(problems, fmt.Sprintf("chain.default: unknown action %q, use allow, deny or route", config.Chain.Default))
+	}
+	return problems
+}
+
+func routeProblems(config Config, name string, routes []string) []string {
+	var problems []string
+	if len(routes) == 0 {
+		problems = append(problems, name+": route needs routes")
+	}
+	for _, route := range routes {
+		if len(namedRoutes(config, []string{route})) == 0 {
+			problems = append(problems, fmt.Sprintf("%s: there is no route %q", name, route))
+		}
+	}
+	return problems
+}
+
+// runRuleTest returns why the test failed, nothing when it passed
+func runRuleTest(config Config, test RuleTest) []string {
+	var data parse.Data
+	if err := json.Unmarshal(test.Event, &data); err != nil {
+		return []string{"event is not a valid log line: " + err.Error()}
+	}
+	data.Source = test.Source
+
+	var failed []string
+	ruled := applyRules(config, data)
+	if test.Expect.Dropped != nil && *test.Expect.Dropped != ruled.drop {
+		failed = append(failed, fmt.Sprintf("dropped: got %v, want %v", ruled.drop, *test.Expect.Dropped))
+	}
+	if test.Expect.Chain != "" && test.Expect.Chain != ruled.decision {
+		failed = append(failed, fmt.Sprintf("chain: got %q, want %q", ruled.decision, test.Expect.Chain))
+	}
+	if test.Expect.Tags != nil {
+		got := append([]string{}, ruled.tags...)
+		want := append([]string{}, test.Expect.Tags...)
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			failed = append(failed, fmt.Sprintf("tags: got [%s], want [%s]", strings.Join(got, ", "), strings.Join(want, ", ")))
+		}
+	}
+
+	classed := forHost(config, data.Request.Host)
+	var escalated bool
+	if classed.Escalation != nil {
+		// a fresh state so earlier tests don't count towards bursts or cooldowns
+		state := &escalationState{errors: map[string][]time.Time{}, last: map[string]time.Time{}}
+		_, _, escalated = state.check(*classed.Escalation, data, countryOf(config, data))
+	}
+	escalated = escalated || ruled.escalation != ""
+	if test.Expect.Escalated != nil && *test.Expect.Escalated != escalated {
+		failed = append(failed, fmt.Sprintf("escalated: got %v, want %v", escalated, *test.Expect.Escalated))
+	}
+
+	severity := severityOf(config, data, escalated)
+	if test.Expect.Severity != "" && test.Expect.Severity != severity {
+		failed = append(failed, fmt.Sprintf("severity: got %s, want %s", severity, test.Expect.Severity))
+	}
+
+	if test.Expect.Routes != nil {
+		var got []string
+		routes := routesFor(config, data.Request.Host)
+		if ruled.routes != nil {
+			routes = namedRoutes(config, ruled.routes)
+		}
+		if ruled.drop {
+			routes = nil
+		}
+		for _, route := range routes {
+			if route.accepts(severity) && route.fromSource(data.Source) {
+				got = append(got, route.Name)
+			}
+		}
+		want := append([]string{}, test.Expect.Routes...)
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			failed = append(failed, fmt.Sprintf("routes: got [%s], want [%s]", strings.Join(got, ", "), strings.Join(want, ", ")))
+		}
+	}
+
+	if test.Expect.Attached != nil {
+		attached := config.Attach != nil && config.Attach.wants(data, escalated)
+		if attached != *test.Expect.Attached {
+			failed = append(failed, fmt.Sprintf("attached: got %v, want %v", attached, *test.Expect.Attached))
+		}
+	}
+	return failed
+}
+
+// validate checks the config and runs its tests
+func validate(config Config) error {
+	problems := validateConfig(config)
+	for _, p := range problems {
+		fmt.Println("ERROR", p)
+	}
+
+	failed := 0
+	for i, test := range config.Tests {
+		name := test.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		reasons := runRuleTest(config, test)
+		if len(reasons) == 0 {
+			fmt.Println("PASS ", name)
+			continue
+		}
+		failed++
+		fmt.Println("FAIL ", name)
+		for _, reason := range reasons {
+			fmt.Println("      " + reason)
+		}
+	}
+
+	if len(problems) > 0 || failed > 0 {
+		return fmt.Errorf("%d problems, %d of %d tests failed", len(problems), failed, len(config.Tests))
+	}
+	fmt.Printf("Config is valid, %d tests passed\n", len(config.Tests))
+	return nil
+}
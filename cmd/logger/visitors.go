@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+// first seen modes of a route: a new client address, or a new combination
+// of address and user agent
+const (
+	firstSeenIP          = "ip"
+	firstSeenFingerprint = "fingerprint"
+)
+
+// the oldest visitors are forgotten beyond this, they'd count as new again
+const maxVisitors = 200000
+
+// visitorLog remembers every visitor ever seen, kept across restarts in
+// seen-visitors.json
+type visitorLog struct {
+	mu     sync.Mutex
+	loaded bool
+	dirty  bool
+	seen   map[string]time.Time
+}
+
+var visitors = &visitorLog{seen: map[string]time.Time{}}
+
+func usesFirstSeen(config Config) bool {
+	for _, route := range config.Routes {
+		if route.FirstSeen != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// visitorKeys are the keys of data for both modes. The fingerprint is
+// hashed, user agents are long and the file only needs to recognise them.
+func visitorKeys(data parse.Data) map[string]string {
+	ip := clientIP(data)
+	if ip == "" {
+		return nil
+	}
+	var ua string
+	if len(data.Request.Headers.UserAgent) > 0 {
+		ua = data.Request.Headers.UserAgent[0]
+	}
+	sum := sha256.Sum256([]byte(ip + "\x00" + ua))
+	return map[string]string{
+		firstSeenIP:          "ip:" + ip,
+		firstSeenFingerprint: "fp:" + base64.RawURLEncoding.EncodeToString(sum[:12]),
+	}
+}
+
+func (v *visitorLog) load(config Config) {
+	if v.loaded {
+		return
+	}
+	v.loaded = true
+	if err := readStateFile(config, "seen-visitors.json", &v.seen); err != nil {
+		slog.Error("Error reading seen visitors", "err", err)
+	}
+	if v.seen == nil {
+		v.seen = map[string]time.Time{}
+	}
+}
+
+// reserve marks the visitor of data as seen in mode and reports whether it
+// was new. It is called when the first visit is queued, so the rest of a
+// visitor's first burst isn't posted as new too; release undoes it when the
+// post fails.
+func (v *visitorLog) reserve(config Config, data parse.Data, mode string) bool {
+	key, ok := visitorKeys(data)[mode]
+	if !ok {
+		return false
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.load(config)
+
+	if _, ok := v.seen[key]; ok {
+		return false
+	}
+	v.add(key, data.Ts.Time())
+	return true
+}
+
+// release forgets a visitor reserved for mode, its first visit wasn't posted
+func (v *visitorLog) release(config Config, data parse.Data, mode string) {
+	key, ok := visitorKeys(data)[mode]
+	if !ok {
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.load(config)
+
+	delete(v.seen, key)
+	v.dirty = true
+}
+
+// mark remembers the visitor of data for the given modes
+func (v *visitorLog) mark(config Config, data parse.Data, modes ...string) {
+	keys := visitorKeys(data)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.load(config)
+
+	for _, mode := range modes {
+		key, ok := keys[mode]
+		if !ok {
+			continue
+		}
+		if _, ok := v.seen[key]; ok {
+			continue
+		}
+		v.add(key, data.Ts.Time())
+	}
+}
+
+// add puts key in the seen map, v.mu held
+func (v *visitorLog) add(key string, seen time.Time) {
+	if len(v.seen) >= maxVisitors {
+		evictOldest(v.seen)
+	}
+	v.seen[key] = seen
+	v.dirty = true
+}
+
+// record marks the visitor of data as seen in every mode, for events that
+// are only read to learn from, like a backfill or an import
+func (v *visitorLog) record(config Config, data parse.Data) {
+	if !usesFirstSeen(config) {
+		return
+	}
+	v.mark(config, data, firstSeenIP, firstSeenFingerprint)
+}
+
+// evictOldest forgets the tenth of a seen map that was seen first
+func evictOldest(seen map[string]time.Time) {
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return seen[keys[i]].Before(seen[keys[j]])
+	})
+	for _, key := range keys[:len(keys)/10+1] {
+		delete(seen, key)
+	}
+}
+
+func (v *visitorLog) save(config Config) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.dirty {
+		return
+	}
+	if err := writeStateFile(config, "seen-visitors.json", v.seen); err != nil {
+		slog.Error("Error saving seen visitors", "err", err)
+		return
+	}
+	v.dirty = false
+}
+
+func (v *visitorLog) run() {
+	for range time.Tick(time.Minute) {
+		v.save(currentConfig())
+	}
+}
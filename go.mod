@@ -1,11 +1,10 @@
 module simo.ng/logger
 
-go 1.20
+go 1.21
 
 require (
 	github.com/docker/docker v23.0.6+incompatible
 	github.com/fsnotify/fsnotify v1.6.0
-	github.com/gtuk/discordwebhook v1.1.0
 )
 
 require (
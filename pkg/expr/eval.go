@@ -0,0 +1,584 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Eval evaluates the program with the variables in vars. Values are go
+// ints, floats, strings, bools, nil, slices and maps with string keys.
+func (p *Program) Eval(vars map[string]interface{}) (interface{}, error) {
+	return eval(p.root, vars)
+}
+
+// Match evaluates a condition, anything but a bool is an error
+func (p *Program) Match(vars map[string]interface{}) (bool, error) {
+	v, err := p.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition is of type %s, not bool", typeName(v))
+	}
+	return b, nil
+}
+
+// normalize brings go values down to int64, float64, string, bool, nil,
+// []interface{} and map[string]interface{}
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, bool, int64, float64, string, []interface{}, map[string]interface{}:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case []string:
+		list := make([]interface{}, len(v))
+		for i, s := range v {
+			list[i] = s
+		}
+		return list
+	}
+	r := reflect.ValueOf(v)
+	switch r.Kind() {
+	case reflect.Slice, reflect.Array:
+		list := make([]interface{}, r.Len())
+		for i := range list {
+			list[i] = r.Index(i).Interface()
+		}
+		return list
+	case reflect.Map:
+		if r.Type().Key().Kind() == reflect.String {
+			m := make(map[string]interface{}, r.Len())
+			iter := r.MapRange()
+			for iter.Next() {
+				m[iter.Key().String()] = iter.Value().Interface()
+			}
+			return m
+		}
+	}
+	return v
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func noOverload(op string, values ...interface{}) error {
+	types := make([]string, len(values))
+	for i, v := range values {
+		types[i] = typeName(v)
+	}
+	return fmt.Errorf("no such overload: %s(%s)", op, strings.Join(types, ", "))
+}
+
+func eval(n node, vars map[string]interface{}) (interface{}, error) {
+	switch n := n.(type) {
+	case literal:
+		return n.value, nil
+	case ident:
+		v, ok := vars[n.name]
+		if !ok {
+			return nil, fmt.Errorf("no such variable: %s", n.name)
+		}
+		return normalize(v), nil
+	case selection:
+		operand, err := eval(n.operand, vars)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := operand.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("no field %s on a %s", n.field, typeName(operand))
+		}
+		v, ok := m[n.field]
+		if !ok {
+			return nil, fmt.Errorf("no such key: %s", n.field)
+		}
+		return normalize(v), nil
+	case index:
+		return evalIndex(n, vars)
+	case call:
+		return evalCall(n, vars)
+	case unary:
+		operand, err := eval(n.operand, vars)
+		if err != nil {
+			return nil, err
+		}
+		switch v := operand.(type) {
+		case bool:
+			if n.op == "!" {
+				return !v, nil
+			}
+		case int64:
+			if n.op == "-" {
+				return -v, nil
+			}
+		case float64:
+			if n.op == "-" {
+				return -v, nil
+			}
+		}
+		return nil, noOverload(n.op, operand)
+	case binary:
+		return evalBinary(n, vars)
+	case conditional:
+		cond, err := eval(n.cond, vars)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := cond.(bool)
+		if !ok {
+			return nil, noOverload("_?_:_", cond)
+		}
+		if b {
+			return eval(n.then, vars)
+		}
+		return eval(n.otherwise, vars)
+	case list:
+		values := make([]interface{}, len(n.elements))
+		for i, e := range n.elements {
+			v, err := eval(e, vars)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return values, nil
+	case hasMacro:
+		operand, err := eval(n.selection.operand, vars)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := operand.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("has: no field %s on a %s", n.selection.field, typeName(operand))
+		}
+		_, ok = m[n.selection.field]
+		return ok, nil
+	case comprehension:
+		return evalComprehension(n, vars)
+	}
+	return nil, fmt.Errorf("unknown node %T", n)
+}
+
+func evalIndex(n index, vars map[string]interface{}) (interface{}, error) {
+	operand, err := eval(n.operand, vars)
+	if err != nil {
+		return nil, err
+	}
+	i, err := eval(n.index, vars)
+	if err != nil {
+		return nil, err
+	}
+	switch operand := operand.(type) {
+	case []interface{}:
+		k, ok := i.(int64)
+		if !ok {
+			return nil, noOverload("_[_]", operand, i)
+		}
+		if k < 0 || k >= int64(len(operand)) {
+			return nil, fmt.Errorf("index %d out of range of a list of %d", k, len(operand))
+		}
+		return normalize(operand[k]), nil
+	case map[string]interface{}:
+		k, ok := i.(string)
+		if !ok {
+			return nil, noOverload("_[_]", operand, i)
+		}
+		v, ok := operand[k]
+		if !ok {
+			return nil, fmt.Errorf("no such key: %s", k)
+		}
+		return normalize(v), nil
+	}
+	return nil, noOverload("_[_]", operand, i)
+}
+
+// evalBinary short circuits && and || the way CEL does: an error on one
+// side doesn't matter when the other decides the result
+func evalBinary(n binary, vars map[string]interface{}) (interface{}, error) {
+	if n.op == "&&" || n.op == "||" {
+		decides := n.op == "||"
+		left, leftErr := eval(n.left, vars)
+		if b, ok := left.(bool); ok && leftErr == nil && b == decides {
+			return b, nil
+		}
+		right, rightErr := eval(n.right, vars)
+		if b, ok := right.(bool); ok && rightErr == nil && b == decides {
+			return b, nil
+		}
+		if leftErr != nil {
+			return nil, leftErr
+		}
+		if rightErr != nil {
+			return nil, rightErr
+		}
+		if _, ok := left.(bool); !ok {
+			return nil, noOverload(n.op, left, right)
+		}
+		if _, ok := right.(bool); !ok {
+			return nil, noOverload(n.op, left, right)
+		}
+		return !decides, nil
+	}
+
+	left, err := eval(n.left, vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := eval(n.right, vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "<", "<=", ">", ">=":
+		c, err := compare(n.op, left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "in":
+		switch right := right.(type) {
+		case []interface{}:
+			for _, v := range right {
+				if equal(left, normalize(v)) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			k, ok := left.(string)
+			if !ok {
+				return nil, noOverload("in", left, right)
+			}
+			_, ok = right[k]
+			return ok, nil
+		}
+		return nil, noOverload("in", left, right)
+	}
+	return arithmetic(n.op, left, right)
+}
+
+func arithmetic(op string, left, right interface{}) (interface{}, error) {
+	switch l := left.(type) {
+	case int64:
+		r, ok := right.(int64)
+		if !ok {
+			break
+		}
+		switch op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "/", "%":
+			if r == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if op == "/" {
+				return l / r, nil
+			}
+			return l % r, nil
+		}
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			break
+		}
+		switch op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "/":
+			return l / r, nil
+		}
+	case string:
+		if r, ok := right.(string); ok && op == "+" {
+			return l + r, nil
+		}
+	case []interface{}:
+		if r, ok := right.([]interface{}); ok && op == "+" {
+			return append(append([]interface{}{}, l...), r...), nil
+		}
+	}
+	return nil, noOverload(op, left, right)
+}
+
+// number reads ints and doubles alike, CEL compares them by value
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func equal(left, right interface{}) bool {
+	if l, ok := number(left); ok {
+		r, ok := number(right)
+		return ok && l == r
+	}
+	switch l := left.(type) {
+	case []interface{}:
+		r, ok := right.([]interface{})
+		if !ok || len(l) != len(r) {
+			return false
+		}
+		for i := range l {
+			if !equal(normalize(l[i]), normalize(r[i])) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		r, ok := right.(map[string]interface{})
+		if !ok || len(l) != len(r) {
+			return false
+		}
+		for k, v := range l {
+			w, ok := r[k]
+			if !ok || !equal(normalize(v), normalize(w)) {
+				return false
+			}
+		}
+		return true
+	}
+	return left == right
+}
+
+func compare(op string, left, right interface{}) (int, error) {
+	if l, ok := number(left); ok {
+		if r, ok := number(right); ok {
+			switch {
+			case l < r:
+				return -1, nil
+			case l > r:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok {
+			return strings.Compare(l, r), nil
+		}
+	}
+	if l, ok := left.(bool); ok {
+		if r, ok := right.(bool); ok {
+			switch {
+			case l == r:
+				return 0, nil
+			case r:
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, noOverload(op, left, right)
+}
+
+// patterns caches the expressions of matches that aren't literals
+var patterns sync.Map
+
+func pattern(s string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(s); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(s, re)
+	return re, nil
+}
+
+func evalCall(n call, vars map[string]interface{}) (interface{}, error) {
+	var args []interface{}
+	if n.target != nil {
+		target, err := eval(n.target, vars)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, target)
+	}
+	for _, a := range n.args {
+		v, err := eval(a, vars)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	switch n.fn {
+	case "size":
+		switch v := args[0].(type) {
+		case string:
+			return int64(len([]rune(v))), nil
+		case []interface{}:
+			return int64(len(v)), nil
+		case map[string]interface{}:
+			return int64(len(v)), nil
+		}
+	case "int":
+		switch v := args[0].(type) {
+		case int64:
+			return v, nil
+		case float64:
+			return int64(v), nil
+		case string:
+			i, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("int: %q is not a number", v)
+			}
+			return i, nil
+		}
+	case "double":
+		switch v := args[0].(type) {
+		case int64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("double: %q is not a number", v)
+			}
+			return f, nil
+		}
+	case "string":
+		switch v := args[0].(type) {
+		case string:
+			return v, nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case float64:
+			return strconv.FormatFloat(v, 'g', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	case "lowerAscii", "upperAscii":
+		if s, ok := args[0].(string); ok {
+			if n.fn == "lowerAscii" {
+				return strings.ToLower(s), nil
+			}
+			return strings.ToUpper(s), nil
+		}
+	case "startsWith", "endsWith", "contains", "matches":
+		s, ok := args[0].(string)
+		arg, ok2 := args[1].(string)
+		if !ok || !ok2 {
+			break
+		}
+		switch n.fn {
+		case "startsWith":
+			return strings.HasPrefix(s, arg), nil
+		case "endsWith":
+			return strings.HasSuffix(s, arg), nil
+		case "contains":
+			return strings.Contains(s, arg), nil
+		}
+		re := n.re
+		if re == nil {
+			var err error
+			if re, err = pattern(arg); err != nil {
+				return nil, fmt.Errorf("matches: %v", err)
+			}
+		}
+		return re.MatchString(s), nil
+	}
+	return nil, noOverload(n.fn, args...)
+}
+
+func evalComprehension(n comprehension, vars map[string]interface{}) (interface{}, error) {
+	target, err := eval(n.target, vars)
+	if err != nil {
+		return nil, err
+	}
+	var items []interface{}
+	switch t := target.(type) {
+	case []interface{}:
+		items = t
+	case map[string]interface{}:
+		// like CEL, a map is ranged over its keys
+		for k := range t {
+			items = append(items, k)
+		}
+	default:
+		return nil, noOverload(n.fn, target)
+	}
+
+	scope := make(map[string]interface{}, len(vars)+1)
+	for k, v := range vars {
+		scope[k] = v
+	}
+	want := n.fn == "exists"
+	var firstErr error
+	for _, item := range items {
+		scope[n.variable] = item
+		v, err := eval(n.predicate, scope)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return nil, noOverload(n.fn, v)
+		}
+		if b == want {
+			return want, nil
+		}
+	}
+	// an error only counts when no other item decided the result
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return !want, nil
+}
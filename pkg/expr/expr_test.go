@@ -0,0 +1,233 @@
+package expr
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// event looks like the variables the rules evaluate against
+var event = map[string]interface{}{
+	"status":   503,
+	"duration": 1.5,
+	"size":     2048,
+	"level":    "info",
+	"country":  "DE",
+	"request": map[string]interface{}{
+		"method": "GET",
+		"host":   "api.example.com",
+		"uri":    "/wp-login.php?x=1",
+		"path":   "/wp-login.php",
+		"headers": map[string]interface{}{
+			"User-Agent": []string{"curl/8.0"},
+		},
+	},
+	"resp_headers": map[string]interface{}{},
+	"tags":         []string{"scanner", "tor"},
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		source string
+		want   interface{}
+	}{
+		// the examples of the rules
+		{"status >= 500 && request.host == 'api.example.com'", true},
+		{"request.path.startsWith('/wp-') && status != 404", true},
+		{"country in ['CN', 'RU'] || duration > 2.0", false},
+
+		// precedence
+		{"1 + 2 * 3", int64(7)},
+		{"(1 + 2) * 3", int64(9)},
+		{"10 - 4 - 3", int64(3)},
+		{"7 / 2 % 2", int64(1)},
+		{"-2 * 3", int64(-6)},
+		{"!false && false", false},
+		{"true || false && false", true},
+		{"1 + 1 == 2 && 3 < 4", true},
+		{"status > 500 ? 'down' : 'up'", "down"},
+		{"false ? 1 : true ? 2 : 3", int64(2)},
+
+		// numbers
+		{"1.5 + 1.0", 2.5},
+		{"1 == 1.0", true},
+		{"2 > 1.5", true},
+		{"size / 1024", int64(2)},
+		{"int('42') + 1", int64(43)},
+		{"double(3) / 2.0", 1.5},
+		{"string(status)", "503"},
+
+		// strings
+		{"'a' + 'b'", "ab"},
+		{"'abc' < 'abd'", true},
+		{"size('héllo')", int64(5)},
+		{"request.uri.contains('?')", true},
+		{"request.host.endsWith('.example.com')", true},
+		{"request.method.lowerAscii()", "get"},
+		{"level.upperAscii() == 'INFO'", true},
+		{"request.path.matches('^/wp-[a-z]+\\\\.php$')", true},
+		{"matches(request.host, r'^api\\.')", true},
+		{`"it's" == 'it\'s'`, true},
+
+		// lists and maps
+		{"[1, 2] + [3]", []interface{}{int64(1), int64(2), int64(3)}},
+		{"size(tags)", int64(2)},
+		{"tags[1]", "tor"},
+		{"[1, [2]] == [1, [2]]", true},
+		{"request['method']", "GET"},
+		{"request.headers['User-Agent'][0]", "curl/8.0"},
+		{"size(resp_headers)", int64(0)},
+
+		// in
+		{"'tor' in tags", true},
+		{"'vpn' in tags", false},
+		{"2 in [1, 2.0]", true},
+		{"'host' in request", true},
+		{"'Referer' in request.headers", false},
+
+		// has
+		{"has(request.host)", true},
+		{"has(request.headers.Referer)", false},
+		{"has(request.headers.Referer) && request.headers.Referer[0] == 'x'", false},
+
+		// macros
+		{"tags.exists(t, t == 'tor')", true},
+		{"tags.all(t, t.size() > 2)", true},
+		{"tags.all(t, t.startsWith('s'))", false},
+		{"[].exists(x, x == 1)", false},
+		{"[].all(x, x == 1)", true},
+		{"request.headers.exists(h, h.lowerAscii() == 'user-agent')", true},
+		{"[0, 1].exists(x, 1 / x == 1)", true},
+
+		// short circuits around errors
+		{"false && missing.field", false},
+		{"true || 1 / 0 == 1", true},
+		{"request.headers.Referer[0] == 'x' || status == 503", true},
+	}
+	for _, test := range tests {
+		p, err := Compile(test.source)
+		if err != nil {
+			t.Errorf("%s: %v", test.source, err)
+			continue
+		}
+		got, err := p.Eval(event)
+		if err != nil {
+			t.Errorf("%s: %v", test.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %#v, want %#v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		// type errors
+		{"status + 'x'", "no such overload: +(int, string)"},
+		{"1 + 1.0", "no such overload: +(int, double)"},
+		{"'a' < 1", "no such overload: <(string, int)"},
+		{"!status", "no such overload: !(int)"},
+		{"-'a'", "no such overload: -(string)"},
+		{"status && true", "no such overload: &&(int, bool)"},
+		{"status ? 1 : 2", "no such overload: _?_:_(int)"},
+		{"1 in 'abc'", "no such overload: in(int, string)"},
+		{"size(1)", "no such overload: size(int)"},
+		{"status.startsWith('5')", "no such overload: startsWith(int, string)"},
+		{"tags['a']", "no such overload: _[_](list, string)"},
+		{"tags.exists(t, 1)", "no such overload: exists(int)"},
+		{"int('x')", `int: "x" is not a number`},
+		{"1 / 0", "division by zero"},
+
+		// missing fields
+		{"missing == 1", "no such variable: missing"},
+		{"request.port == 80", "no such key: port"},
+		{"request.headers.Referer[0] == 'x'", "no such key: Referer"},
+		{"request['port']", "no such key: port"},
+		{"status.code", "no field code on a int"},
+		{"has(status.code)", "has: no field code on a int"},
+		{"tags[2]", "index 2 out of range of a list of 2"},
+		{"request.headers.Referer[0] == 'x' && status == 503", "no such key: Referer"},
+	}
+	for _, test := range tests {
+		p, err := Compile(test.source)
+		if err != nil {
+			t.Errorf("%s: %v", test.source, err)
+			continue
+		}
+		got, err := p.Eval(event)
+		if err == nil {
+			t.Errorf("%s = %#v, want an error", test.source, got)
+			continue
+		}
+		if err.Error() != test.err {
+			t.Errorf("%s: got error %q, want %q", test.source, err, test.err)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{"status >=", "unexpected end of expression at 9"},
+		{"(status", `expected ")", got end of expression at 7`},
+		{"status 500", `unexpected "500" at 7`},
+		{"status # 1", "unexpected '#' at 7"},
+		{"'open", "unterminated string at 0"},
+		{`'\q'`, `unknown escape \q at 1`},
+		{"unknown(1)", "unknown function unknown at 0"},
+		{"tags.first()", "unknown method first at 5"},
+		{"size(1, 2)", "size takes 1 argument(s) at 0"},
+		{"has(status)", "has takes a field selection like has(request.host) at 0"},
+		{"tags.exists('t', true)", "the first argument of exists is a variable name at 5"},
+		{"request.host.matches('[')", "invalid pattern: error parsing regexp: missing closing ]: `[` at 13"},
+		{"status.1", `expected a field name, got "1" at 7`},
+	}
+	for _, test := range tests {
+		_, err := Compile(test.source)
+		if err == nil {
+			t.Errorf("%s compiled", test.source)
+			continue
+		}
+		if err.Error() != test.err {
+			t.Errorf("%s: got error %q, want %q", test.source, err, test.err)
+		}
+	}
+}
+
+func TestCompileNames(t *testing.T) {
+	names := []string{"status", "request"}
+	if _, err := Compile("status == 500 && [1].exists(x, x == status)", names...); err != nil {
+		t.Errorf("known names: %v", err)
+	}
+	_, err := Compile("stauts == 500", names...)
+	if err == nil || !strings.Contains(err.Error(), "unknown variable stauts") {
+		t.Errorf("a typo: got %v", err)
+	}
+	_, err = Compile("[1].exists(x, x == 1) && x == 1", names...)
+	if err == nil || !strings.Contains(err.Error(), "unknown variable x") {
+		t.Errorf("a variable outside its macro: got %v", err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	p, err := Compile("status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Match(event); err == nil || err.Error() != "condition is of type int, not bool" {
+		t.Errorf("a condition that isn't a bool: got %v", err)
+	}
+	p, err = Compile("status == 503")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := p.Match(event); !ok || err != nil {
+		t.Errorf("got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,619 @@
+// Package expr evaluates a subset of CEL, the Common Expression Language,
+// over plain go values: literals, lists, field selection and indexing, the
+// usual operators, the string functions and the has, exists and all macros.
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Program is a compiled expression, safe for concurrent use
+type Program struct {
+	source string
+	root   node
+}
+
+func (p *Program) String() string {
+	return p.source
+}
+
+// Compile parses source. With names given every free variable has to be one
+// of them, so typos show up here instead of as evaluation errors.
+func Compile(source string, names ...string) (*Program, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	if len(names) > 0 {
+		if err := checkNames(root, names, nil); err != nil {
+			return nil, err
+		}
+	}
+	return &Program{source: source, root: root}, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenInt
+	tokenDouble
+	tokenString
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// value is the decoded literal
+	value interface{}
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// operators, longest first so "<=" isn't read as "<"
+var operators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "!", "?", ":", ".", ",", "(", ")", "[", "]"}
+
+func lex(source string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(source) {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isLetter(c):
+			start := i
+			for i < len(source) && (isLetter(source[i]) || isDigit(source[i])) {
+				i++
+			}
+			// r'...' is a raw string
+			if word := source[start:i]; (word == "r" || word == "R") && i < len(source) && (source[i] == '\'' || source[i] == '"') {
+				t, end, err := lexString(source, i, true)
+				if err != nil {
+					return nil, err
+				}
+				t.pos = start
+				tokens = append(tokens, t)
+				i = end
+				continue
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: source[start:i], pos: start})
+		case isDigit(c):
+			start := i
+			double := false
+			for i < len(source) && isDigit(source[i]) {
+				i++
+			}
+			if i+1 < len(source) && source[i] == '.' && isDigit(source[i+1]) {
+				double = true
+				i++
+				for i < len(source) && isDigit(source[i]) {
+					i++
+				}
+			}
+			if i < len(source) && (source[i] == 'e' || source[i] == 'E') {
+				double = true
+				i++
+				if i < len(source) && (source[i] == '+' || source[i] == '-') {
+					i++
+				}
+				for i < len(source) && isDigit(source[i]) {
+					i++
+				}
+			}
+			text := source[start:i]
+			if double {
+				f, err := strconv.ParseFloat(text, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid number %q at %d", text, start)
+				}
+				tokens = append(tokens, token{kind: tokenDouble, text: text, value: f, pos: start})
+				continue
+			}
+			n, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at %d", text, start)
+			}
+			tokens = append(tokens, token{kind: tokenInt, text: text, value: n, pos: start})
+		case c == '\'' || c == '"':
+			t, end, err := lexString(source, i, false)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, t)
+			i = end
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(source[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(source)}), nil
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// lexString reads the quoted string starting at source[start]
+func lexString(source string, start int, raw bool) (token, int, error) {
+	quote := source[start]
+	var b strings.Builder
+	i := start + 1
+	for i < len(source) {
+		c := source[i]
+		switch {
+		case c == quote:
+			return token{kind: tokenString, text: source[start : i+1], value: b.String(), pos: start}, i + 1, nil
+		case c == '\\' && !raw && i+1 < len(source):
+			i++
+			switch e := source[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '\\', '\'', '"':
+				b.WriteByte(e)
+			default:
+				return token{}, 0, fmt.Errorf("unknown escape \\%c at %d", e, i-1)
+			}
+			i++
+		case c == '\n':
+			return token{}, 0, fmt.Errorf("unterminated string at %d", start)
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return token{}, 0, fmt.Errorf("unterminated string at %d", start)
+}
+
+// the syntax tree
+type node interface{}
+
+type (
+	literal struct{ value interface{} }
+	ident   struct{ name string }
+	// selection is operand.field
+	selection struct {
+		operand node
+		field   string
+	}
+	index struct{ operand, index node }
+	// call is fn(args) or target.fn(args)
+	call struct {
+		target node
+		fn     string
+		args   []node
+		// re is the pattern of matches when it's a literal
+		re *regexp.Regexp
+	}
+	unary struct {
+		op      string
+		operand node
+	}
+	binary struct {
+		op          string
+		left, right node
+	}
+	conditional struct{ cond, then, otherwise node }
+	list        struct{ elements []node }
+	// has(operand.field)
+	hasMacro struct{ selection selection }
+	// target.exists(name, predicate) and target.all(name, predicate)
+	comprehension struct {
+		target    node
+		fn        string
+		variable  string
+		predicate node
+	}
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the operator op if it comes next
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokenOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return p.errorf(t, "expected %q, got %s", op, t)
+	}
+	return nil
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return fmt.Errorf("%s at %d", fmt.Sprintf(format, args...), t.pos)
+}
+
+func (p *parser) expr() (node, error) {
+	cond, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("?") {
+		return cond, nil
+	}
+	then, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return conditional{cond, then, otherwise}, nil
+}
+
+func (p *parser) or() (node, error) {
+	return p.binary(p.and, "||")
+}
+
+func (p *parser) and() (node, error) {
+	return p.binary(p.relation, "&&")
+}
+
+func (p *parser) relation() (node, error) {
+	left, err := p.addition()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		op := ""
+		switch {
+		case t.kind == tokenOp && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+			op = t.text
+		case t.kind == tokenIdent && t.text == "in":
+			op = "in"
+		default:
+			return left, nil
+		}
+		p.next()
+		right, err := p.addition()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op, left, right}
+	}
+}
+
+func (p *parser) addition() (node, error) {
+	return p.binary(p.multiplication, "+", "-")
+}
+
+func (p *parser) multiplication() (node, error) {
+	return p.binary(p.unary, "*", "/", "%")
+}
+
+// binary parses a left associative chain of the operators ops
+func (p *parser) binary(operand func() (node, error), ops ...string) (node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokenOp || !contains(ops, t.text) {
+			return left, nil
+		}
+		p.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{t.text, left, right}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	for _, op := range []string{"!", "-"} {
+		if p.accept(op) {
+			operand, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			// -5 is a literal, so the smallest int can be written
+			if lit, ok := operand.(literal); ok && op == "-" {
+				switch v := lit.value.(type) {
+				case int64:
+					return literal{-v}, nil
+				case float64:
+					return literal{-v}, nil
+				}
+			}
+			return unary{op, operand}, nil
+		}
+	}
+	return p.member()
+}
+
+func (p *parser) member() (node, error) {
+	operand, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokenIdent {
+				return nil, p.errorf(t, "expected a field name, got %s", t)
+			}
+			if !p.accept("(") {
+				operand = selection{operand, t.text}
+				continue
+			}
+			args, err := p.args(")")
+			if err != nil {
+				return nil, err
+			}
+			operand, err = p.method(t, operand, args)
+			if err != nil {
+				return nil, err
+			}
+		case p.accept("["):
+			i, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			operand = index{operand, i}
+		default:
+			return operand, nil
+		}
+	}
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenInt, tokenDouble, tokenString:
+		return literal{t.value}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null":
+			return literal{nil}, nil
+		}
+		if !p.accept("(") {
+			return ident{t.text}, nil
+		}
+		args, err := p.args(")")
+		if err != nil {
+			return nil, err
+		}
+		return p.function(t, args)
+	case tokenOp:
+		switch t.text {
+		case "(":
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		case "[":
+			elements, err := p.args("]")
+			if err != nil {
+				return nil, err
+			}
+			return list{elements}, nil
+		}
+	}
+	return nil, p.errorf(t, "unexpected %s", t)
+}
+
+// args reads a comma separated list up to closing
+func (p *parser) args(closing string) ([]node, error) {
+	var args []node
+	if p.accept(closing) {
+		return args, nil
+	}
+	for {
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.accept(closing) {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// functions and methods with the number of arguments they take, the target
+// of a method not counted
+var (
+	functions = map[string]int{"size": 1, "int": 1, "double": 1, "string": 1, "matches": 2}
+	methods   = map[string]int{"size": 0, "startsWith": 1, "endsWith": 1, "contains": 1, "matches": 1, "lowerAscii": 0, "upperAscii": 0}
+)
+
+func (p *parser) function(t token, args []node) (node, error) {
+	if t.text == "has" {
+		if len(args) != 1 {
+			return nil, p.errorf(t, "has takes a single field selection")
+		}
+		s, ok := args[0].(selection)
+		if !ok {
+			return nil, p.errorf(t, "has takes a field selection like has(request.host)")
+		}
+		return hasMacro{s}, nil
+	}
+	n, ok := functions[t.text]
+	if !ok {
+		return nil, p.errorf(t, "unknown function %s", t.text)
+	}
+	if len(args) != n {
+		return nil, p.errorf(t, "%s takes %d argument(s)", t.text, n)
+	}
+	c := call{fn: t.text, args: args}
+	if t.text == "matches" {
+		return p.pattern(t, c, args[1])
+	}
+	return c, nil
+}
+
+func (p *parser) method(t token, target node, args []node) (node, error) {
+	if t.text == "exists" || t.text == "all" {
+		if len(args) != 2 {
+			return nil, p.errorf(t, "%s takes a variable and a predicate", t.text)
+		}
+		v, ok := args[0].(ident)
+		if !ok {
+			return nil, p.errorf(t, "the first argument of %s is a variable name", t.text)
+		}
+		return comprehension{target: target, fn: t.text, variable: v.name, predicate: args[1]}, nil
+	}
+	n, ok := methods[t.text]
+	if !ok {
+		return nil, p.errorf(t, "unknown method %s", t.text)
+	}
+	if len(args) != n {
+		return nil, p.errorf(t, "%s takes %d argument(s)", t.text, n)
+	}
+	c := call{target: target, fn: t.text, args: args}
+	if t.text == "matches" {
+		return p.pattern(t, c, args[0])
+	}
+	return c, nil
+}
+
+// pattern compiles a literal regular expression once
+func (p *parser) pattern(t token, c call, pattern node) (node, error) {
+	lit, ok := pattern.(literal)
+	if !ok {
+		return c, nil
+	}
+	s, ok := lit.value.(string)
+	if !ok {
+		return nil, p.errorf(t, "matches takes a string pattern")
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, p.errorf(t, "invalid pattern: %v", err)
+	}
+	c.re = re
+	return c, nil
+}
+
+// checkNames fails on free variables that aren't in names, bound are the
+// variables of the comprehensions around n
+func checkNames(n node, names []string, bound []string) error {
+	switch n := n.(type) {
+	case ident:
+		if !contains(names, n.name) && !contains(bound, n.name) {
+			return fmt.Errorf("unknown variable %s, use %s", n.name, strings.Join(names, ", "))
+		}
+	case selection:
+		return checkNames(n.operand, names, bound)
+	case index:
+		return checkAll(names, bound, n.operand, n.index)
+	case call:
+		if n.target != nil {
+			if err := checkNames(n.target, names, bound); err != nil {
+				return err
+			}
+		}
+		return checkAll(names, bound, n.args...)
+	case unary:
+		return checkNames(n.operand, names, bound)
+	case binary:
+		return checkAll(names, bound, n.left, n.right)
+	case conditional:
+		return checkAll(names, bound, n.cond, n.then, n.otherwise)
+	case list:
+		return checkAll(names, bound, n.elements...)
+	case hasMacro:
+		return checkNames(n.selection.operand, names, bound)
+	case comprehension:
+		if err := checkNames(n.target, names, bound); err != nil {
+			return err
+		}
+		return checkNames(n.predicate, names, append(append([]string{}, bound...), n.variable))
+	}
+	return nil
+}
+
+func checkAll(names []string, bound []string, nodes ...node) error {
+	for _, n := range nodes {
+		if err := checkNames(n, names, bound); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+// Package filter holds the matching rules applied to access log entries:
+// request paths, hosts and attack signatures.
+package filter
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchPath matches the path of uri against a glob, or a prefix for
+// patterns without wildcards. The query string is ignored.
+func MatchPath(pattern string, uri string) bool {
+	p, _, _ := strings.Cut(uri, "?")
+	if ok, err := path.Match(pattern, p); err == nil && ok {
+		return true
+	}
+	return strings.HasPrefix(strings.ToLower(p), strings.ToLower(pattern))
+}
+
+// MatchHost reports whether host matches one of patterns: an exact host,
+// "*" or "*.example.com" for any subdomain of example.com
+func MatchHost(patterns []string, host string) bool {
+	// strip the port, caddy logs the host header as sent by the client
+	if i := strings.LastIndex(host, ":"); i != -1 && !strings.Contains(host[i:], "]") {
+		host = host[:i]
+	}
+	host = strings.ToLower(host)
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*" || pattern == host {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package filter
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"simo.ng/logger/pkg/parse"
+)
+
+// Signature recognises an attack by the request uri or user agent, either
+// pattern matching is enough when both are set. Method limits it to
+// requests with that method.
+type Signature struct {
+	Name      string
+	URI       *regexp.Regexp
+	UserAgent *regexp.Regexp
+	Method    string
+}
+
+// Builtin signatures catch the attacks every public site sees daily. They
+// are matched against the uri after url decoding it twice.
+var Builtin = []Signature{
+	{Name: "sql injection", URI: regexp.MustCompile(`(?i)(\bunion\b[\s(]+(all\s+)?select\b|\bselect\b.+\bfrom\b.+\bwhere\b|'\s*(or|and)\s*'?\d+'?\s*=\s*'?\d|\bsleep\s*\(\s*\d|\bbenchmark\s*\(|\binformation_schema\b|;\s*(drop|insert|update|delete)\s+)`)},
+	{Name: "path traversal", URI: regexp.MustCompile(`(\.\.[/\\]){2,}|/etc/passwd|/proc/self/|\\windows\\win\.ini|c:\\`)},
+	{Name: "env file", URI: regexp.MustCompile(`(?i)/\.env(\.|$|\?|/)`)},
+	{Name: "git config", URI: regexp.MustCompile(`(?i)/\.git/(config|head|index)`)},
+	{Name: "wordpress login", Method: "POST", URI: regexp.MustCompile(`(?i)/(wp-login|xmlrpc)\.php`)},
+	{Name: "shellshock", UserAgent: regexp.MustCompile(`\(\)\s*\{\s*:?\s*;\s*\}`)},
+	{Name: "log4shell", URI: regexp.MustCompile(`(?i)\$\{jndi:`), UserAgent: regexp.MustCompile(`(?i)\$\{jndi:`)},
+	{Name: "php injection", URI: regexp.MustCompile(`(?i)(allow_url_include|auto_prepend_file|php://input|base64_decode\()`)},
+	{Name: "cross-site scripting", URI: regexp.MustCompile(`(?i)(<script|javascript:|onerror\s*=|onload\s*=)`)},
+}
+
+// DecodeURI undoes url encoding twice, scanners double encode to get past
+// filters that only decode once
+func DecodeURI(uri string) string {
+	decoded := uri
+	for i := 0; i < 2; i++ {
+		next, err := url.QueryUnescape(decoded)
+		if err != nil || next == decoded {
+			break
+		}
+		decoded = next
+	}
+	return decoded
+}
+
+func (s Signature) matches(data parse.Data, uri string, ua string) bool {
+	if s.Method != "" && !strings.EqualFold(s.Method, data.Request.Method) {
+		return false
+	}
+	// either pattern matching is enough when both are set
+	if s.URI != nil && s.URI.MatchString(uri) {
+		return true
+	}
+	return s.UserAgent != nil && s.UserAgent.MatchString(ua)
+}
+
+// Attack returns the name of the first signature data matches
+func Attack(data parse.Data, signatures []Signature) (string, bool) {
+	uri := DecodeURI(data.Request.URI)
+	var ua string
+	if len(data.Request.Headers.UserAgent) > 0 {
+		ua = data.Request.Headers.UserAgent[0]
+	}
+	for _, s := range signatures {
+		if s.matches(data, uri, ua) {
+			return s.Name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,195 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// DockerConfig points at a docker daemon, without a host the usual
+// DOCKER_HOST environment is used, and without that whichever of the docker
+// or podman sockets exists
+type DockerConfig struct {
+	// Host is unix:///var/run/docker.sock, tcp://host:2376 or ssh://user@host
+	Host string `json:"host"`
+	// TLS is used for tcp:// when set or when any of the certificates is
+	// given. CACert alone verifies the daemon, Cert and Key authenticate
+	// the logger to it.
+	TLS    bool   `json:"tls"`
+	CACert string `json:"caCert"`
+	Cert   string `json:"cert"`
+	Key    string `json:"key"`
+	// Runtime picks the socket to look for when no host is set, "docker" or
+	// "podman". By default docker's is tried first.
+	Runtime string `json:"runtime"`
+}
+
+// Remote is true for daemons on another host, their files can only be
+// read through exec
+func (d DockerConfig) Remote() bool {
+	return d.Host != "" && !strings.HasPrefix(d.Host, "unix://")
+}
+
+// runtimeSockets are the default api sockets per runtime. Podman serves a
+// docker compatible api, rootless under the user's runtime directory.
+func runtimeSockets(runtime string) []string {
+	podman := []string{"/run/podman/podman.sock"}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		podman = append(podman, filepath.Join(dir, "podman", "podman.sock"))
+	}
+	podman = append(podman, fmt.Sprintf("/run/user/%d/podman/podman.sock", os.Getuid()))
+
+	switch runtime {
+	case "docker":
+		return []string{"/var/run/docker.sock"}
+	case "podman":
+		return podman
+	}
+	return append([]string{"/var/run/docker.sock"}, podman...)
+}
+
+var detectOnce sync.Map
+
+// detectSocket finds the socket of an available runtime, logged once per
+// runtime setting so it's clear which one is used
+func detectSocket(runtime string) string {
+	for _, socket := range runtimeSockets(runtime) {
+		if info, err := os.Stat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if _, logged := detectOnce.LoadOrStore(runtime, true); !logged {
+				slog.Info("Using container runtime socket", "socket", socket)
+			}
+			return "unix://" + socket
+		}
+	}
+	return ""
+}
+
+// NewDockerClient connects to the daemon cfg points at
+func NewDockerClient(cfg DockerConfig) (*client.Client, error) {
+	// podman and older docker daemons speak older api versions
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if cfg.Host == "" && os.Getenv("DOCKER_HOST") == "" {
+		cfg.Host = detectSocket(cfg.Runtime)
+	}
+	switch {
+	case strings.HasPrefix(cfg.Host, "ssh://"):
+		dial, err := sshDialer(cfg.Host)
+		if err != nil {
+			return nil, err
+		}
+		// the host only ends up in the Host header, the dialer does the work
+		opts = append(opts, client.WithHost("http://docker.example.com"), client.WithDialContext(dial))
+	case cfg.Host != "":
+		opts = append(opts, client.WithHost(cfg.Host))
+		// an empty CACert trusts the system roots, without Cert and Key no
+		// client certificate is sent
+		if cfg.TLS || cfg.CACert != "" || cfg.Cert != "" || cfg.Key != "" {
+			opts = append(opts, client.WithTLSClientConfig(cfg.CACert, cfg.Cert, cfg.Key))
+		}
+	}
+	return client.NewClientWithOpts(opts...)
+}
+
+// sshDialer connects through `docker system dial-stdio` on the remote host,
+// the same way the docker cli does. It needs the ssh binary and a key that
+// logs in without a password. Every connection runs its own ssh, they share
+// one master connection so the host is only logged in to once.
+func sshDialer(host string) (func(context.Context, string, string) (net.Conn, error), error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{
+		"-o", "ControlMaster=auto",
+		"-o", "ControlPath=" + filepath.Join(os.TempDir(), "caddy-logger-ssh-%C"),
+		"-o", "ControlPersist=5m",
+	}
+	if u.Port() != "" {
+		args = append(args, "-p", u.Port())
+	}
+	target := u.Hostname()
+	if u.User != nil {
+		target = u.User.Username() + "@" + target
+	}
+	args = append(args, "--", target, "docker", "system", "dial-stdio")
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		cmd := exec.Command("ssh", args...)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("starting ssh: %w", err)
+		}
+		conn := &commandConn{cmd: cmd, stdin: stdin, stdout: stdout, dialed: make(chan struct{})}
+		// the login only shows once the daemon answers, until then the
+		// dial's deadline or cancellation ends ssh. Tying ssh to ctx for
+		// good would kill pooled connections along with their request.
+		go func() {
+			select {
+			case <-ctx.Done():
+				cmd.Process.Kill()
+			case <-conn.dialed:
+			}
+		}()
+		return conn, nil
+	}, nil
+}
+
+// commandConn is a connection over the stdin and stdout of a command
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	// dialed is closed on the first answer or on Close
+	dialed chan struct{}
+	once   sync.Once
+}
+
+func (c *commandConn) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if n > 0 {
+		c.once.Do(func() { close(c.dialed) })
+	}
+	return n, err
+}
+
+func (c *commandConn) Write(p []byte) (int, error) { return c.stdin.Write(p) }
+
+func (c *commandConn) Close() error {
+	c.once.Do(func() { close(c.dialed) })
+	c.stdin.Close()
+	c.stdout.Close()
+	c.cmd.Process.Kill()
+	return c.cmd.Wait()
+}
+
+func (c *commandConn) LocalAddr() net.Addr                { return dummyAddr{} }
+func (c *commandConn) RemoteAddr() net.Addr               { return dummyAddr{} }
+func (c *commandConn) SetDeadline(t time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type dummyAddr struct{}
+
+func (dummyAddr) Network() string { return "ssh" }
+func (dummyAddr) String() string  { return "ssh" }
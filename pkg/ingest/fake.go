@@ -0,0 +1,329 @@
+package ingest
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Fake is a runtime kept in memory: containers by name, log files that grow
+// with Write and start over with Rotate, and swarm services. It stands in
+// for docker where there is no daemon.
+type Fake struct {
+	mu         sync.Mutex
+	containers map[string]string
+	files      map[string]*fakeFile
+	inodes     uint64
+	followers  []*fakeFollower
+	// tasks holds the slot of every task per service
+	tasks map[string]map[string]int
+}
+
+type fakeFile struct {
+	inode uint64
+	data  []byte
+}
+
+// fakeFollower is one Follow or ServiceLogs call, lines queue up for it so
+// writers never wait for the reader
+type fakeFollower struct {
+	patterns []string
+	service  string
+	last     string
+
+	mu     sync.Mutex
+	queue  []string
+	wake   chan struct{}
+	done   chan struct{}
+	reader *io.PipeReader
+	writer *io.PipeWriter
+}
+
+// NewFake returns an empty runtime
+func NewFake() *Fake {
+	return &Fake{
+		containers: map[string]string{},
+		files:      map[string]*fakeFile{},
+		tasks:      map[string]map[string]int{},
+	}
+}
+
+// Open returns the runtime itself for any config, in place of
+// NewDockerSource
+func (f *Fake) Open(DockerConfig) (Source, error) {
+	return f, nil
+}
+
+// AddContainer adds a running container
+func (f *Fake) AddContainer(name string, id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.containers[name] = id
+}
+
+// Write appends lines to a file in the container, creating it if needed
+func (f *Fake) Write(file string, lines ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file = f.resolve(file)
+	target := f.files[file]
+	if target == nil {
+		f.inodes++
+		target = &fakeFile{inode: f.inodes}
+		f.files[file] = target
+	}
+	for _, line := range lines {
+		target.data = append(target.data, line+"\n"...)
+		for _, follower := range f.followers {
+			if follower.service == "" && follower.follows(file) {
+				follower.send(file, line)
+			}
+		}
+	}
+}
+
+// Rotate replaces a file with an empty one, the way logrotate does
+func (f *Fake) Rotate(file string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inodes++
+	f.files[f.resolve(file)] = &fakeFile{inode: f.inodes}
+}
+
+// WriteService logs lines from one replica of a swarm service
+func (f *Fake) WriteService(service string, taskID string, slot int, lines ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.tasks[service] == nil {
+		f.tasks[service] = map[string]int{}
+	}
+	f.tasks[service][taskID] = slot
+	for _, line := range lines {
+		for _, follower := range f.followers {
+			if follower.service == service {
+				follower.push("com.docker.swarm.task.id=" + taskID + " " + line)
+			}
+		}
+	}
+}
+
+// resolve makes names relative to the log directory, where exec runs
+func (f *Fake) resolve(file string) string {
+	if path.IsAbs(file) {
+		return path.Clean(file)
+	}
+	return path.Join(LogDir, file)
+}
+
+// matching returns the files matching the patterns in order, like the
+// shell expanding the globs
+func (f *Fake) matching(patterns []string) []string {
+	var matched []string
+	for _, pattern := range patterns {
+		pattern = f.resolve(pattern)
+		for name := range f.files {
+			if ok, _ := path.Match(pattern, name); ok && !slices.Contains(matched, name) {
+				matched = append(matched, name)
+			}
+		}
+	}
+	return matched
+}
+
+func (f *Fake) container(id string) error {
+	for _, known := range f.containers {
+		if known == id {
+			return nil
+		}
+	}
+	return fmt.Errorf("no such container: %s", id)
+}
+
+func (f *Fake) Ping() error  { return nil }
+func (f *Fake) Close() error { return nil }
+
+func (f *Fake) FindContainer(name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, ok := f.containers[name]; ok {
+		return id, nil
+	}
+	return "", fmt.Errorf("container with name %s not found", name)
+}
+
+func (f *Fake) Stat(id string, file string) (uint64, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.container(id); err != nil {
+		return 0, 0, err
+	}
+	target := f.files[f.resolve(file)]
+	if target == nil {
+		return 0, 0, &ExitError{Code: 1, Stderr: "stat: can't stat '" + file + "': No such file or directory"}
+	}
+	return target.inode, int64(len(target.data)), nil
+}
+
+func (f *Fake) Read(id string, file string, offset int64, length int64) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.container(id); err != nil {
+		return "", err
+	}
+	target := f.files[f.resolve(file)]
+	if target == nil {
+		return "", &ExitError{Code: 1, Stderr: "tail: can't open '" + file + "': No such file or directory"}
+	}
+	data := target.data
+	if offset > int64(len(data)) {
+		return "", nil
+	}
+	data = data[offset:]
+	if length < int64(len(data)) {
+		data = data[:length]
+	}
+	return string(data), nil
+}
+
+func (f *Fake) Tail(id string, files []string, lines int) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.container(id); err != nil {
+		return "", err
+	}
+	matched := f.matching(files)
+	var out strings.Builder
+	for i, name := range matched {
+		if len(matched) > 1 {
+			if i > 0 {
+				out.WriteString("\n")
+			}
+			fmt.Fprintf(&out, "==> %s <==\n", name)
+		}
+		all := strings.SplitAfter(string(f.files[name].data), "\n")
+		if all[len(all)-1] == "" {
+			all = all[:len(all)-1]
+		}
+		if len(all) > lines {
+			all = all[len(all)-lines:]
+		}
+		out.WriteString(strings.Join(all, ""))
+	}
+	return out.String(), nil
+}
+
+func (f *Fake) Follow(id string, files []string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.container(id); err != nil {
+		return nil, err
+	}
+	return f.follow(&fakeFollower{patterns: files}), nil
+}
+
+func (f *Fake) ServiceLogs(service string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.follow(&fakeFollower{service: service}), nil
+}
+
+func (f *Fake) ServiceTasks(service string) (map[string]int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	slots := map[string]int{}
+	for id, slot := range f.tasks[service] {
+		slots[id] = slot
+	}
+	return slots, nil
+}
+
+// follow registers a follower, callers hold the lock
+func (f *Fake) follow(follower *fakeFollower) io.ReadCloser {
+	follower.wake = make(chan struct{}, 1)
+	follower.done = make(chan struct{})
+	follower.reader, follower.writer = io.Pipe()
+	f.followers = append(f.followers, follower)
+	go follower.pump()
+	return fakeStream{source: f, follower: follower}
+}
+
+// push queues a line without waiting for the reader
+func (follower *fakeFollower) push(line string) {
+	follower.mu.Lock()
+	follower.queue = append(follower.queue, line)
+	follower.mu.Unlock()
+	select {
+	case follower.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pump writes the queued lines to the stream until it's closed
+func (follower *fakeFollower) pump() {
+	for {
+		select {
+		case <-follower.wake:
+		case <-follower.done:
+			return
+		}
+		follower.mu.Lock()
+		lines := follower.queue
+		follower.queue = nil
+		follower.mu.Unlock()
+		for _, line := range lines {
+			if _, err := io.WriteString(follower.writer, line+"\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (follower *fakeFollower) follows(file string) bool {
+	for _, pattern := range follower.patterns {
+		if !path.IsAbs(pattern) {
+			pattern = path.Join(LogDir, pattern)
+		}
+		if ok, _ := path.Match(pattern, file); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// send passes a line on, with a tail header when it comes from another
+// file than the previous one and several files are followed
+func (follower *fakeFollower) send(file string, line string) {
+	several := len(follower.patterns) > 1 || strings.ContainsAny(follower.patterns[0], "*?[")
+	if several && follower.last != file {
+		follower.push("==> " + file + " <==")
+	}
+	follower.last = file
+	follower.push(line)
+}
+
+// fakeStream ends its follower when closed, a line the reader never took
+// is dropped
+type fakeStream struct {
+	source   *Fake
+	follower *fakeFollower
+}
+
+func (s fakeStream) Read(p []byte) (int, error) {
+	return s.follower.reader.Read(p)
+}
+
+func (s fakeStream) Close() error {
+	s.source.mu.Lock()
+	defer s.source.mu.Unlock()
+	for i, follower := range s.source.followers {
+		if follower == s.follower {
+			s.source.followers = append(s.source.followers[:i], s.source.followers[i+1:]...)
+			close(follower.done)
+			break
+		}
+	}
+	return s.follower.reader.Close()
+}
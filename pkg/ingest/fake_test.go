@@ -0,0 +1,161 @@
+package ingest
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readLines reads n lines from a stream or fails the test after a second
+func readLines(t *testing.T, stream io.Reader, n int) []string {
+	t.Helper()
+	got := make(chan []string, 1)
+	go func() {
+		var lines []string
+		scanner := bufio.NewScanner(stream)
+		for len(lines) < n && scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		got <- lines
+	}()
+	select {
+	case lines := <-got:
+		return lines
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %d lines", n)
+		return nil
+	}
+}
+
+func TestFakeFollow(t *testing.T) {
+	fake := NewFake()
+	fake.AddContainer("caddy", "c1")
+
+	single, err := fake.Follow("c1", []string{"access.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer single.Close()
+	several, err := fake.Follow("c1", []string{"*.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer several.Close()
+
+	fake.Write("access.log", "one", "two")
+	fake.Write("shop.log", "three")
+	fake.Write("other.txt", "ignored")
+
+	if got := readLines(t, single, 2); strings.Join(got, "|") != "one|two" {
+		t.Errorf("single file: got %q", got)
+	}
+	want := "==> /var/log/caddy/access.log <==|one|two|==> /var/log/caddy/shop.log <==|three"
+	if got := readLines(t, several, 5); strings.Join(got, "|") != want {
+		t.Errorf("glob: got %q, want %q", strings.Join(got, "|"), want)
+	}
+
+	if _, err := fake.Follow("nope", []string{"access.log"}); err == nil {
+		t.Error("following an unknown container should fail")
+	}
+}
+
+func TestFakeSlowReader(t *testing.T) {
+	fake := NewFake()
+	fake.AddContainer("caddy", "c1")
+	stream, err := fake.Follow("c1", []string{"access.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nobody reads, neither the writes nor Close may wait for a reader
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5000; i++ {
+			fake.Write("access.log", "line")
+		}
+		stream.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("writer or Close blocked on a follower that doesn't read")
+	}
+
+	if _, err := stream.Read(make([]byte, 1)); err == nil {
+		t.Error("a closed stream should not read")
+	}
+	fake.Write("access.log", "after close")
+}
+
+func TestFakeRotate(t *testing.T) {
+	fake := NewFake()
+	fake.AddContainer("caddy", "c1")
+	fake.Write("access.log", "first", "second")
+
+	inode, size, err := fake.Stat("c1", "access.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len("first\nsecond\n")) {
+		t.Errorf("size: got %d", size)
+	}
+	if out, _ := fake.Read("c1", "access.log", 6, 7); out != "second\n" {
+		t.Errorf("read: got %q", out)
+	}
+
+	fake.Rotate("access.log")
+	rotated, size, err := fake.Stat("c1", "/var/log/caddy/access.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated == inode || size != 0 {
+		t.Errorf("after rotate: inode %d (was %d), size %d", rotated, inode, size)
+	}
+
+	var exit *ExitError
+	if _, _, err := fake.Stat("c1", "missing.log"); !errors.As(err, &exit) || exit.Code != 1 {
+		t.Errorf("missing file: got %v", err)
+	}
+}
+
+func TestFakeTail(t *testing.T) {
+	fake := NewFake()
+	fake.AddContainer("caddy", "c1")
+	fake.Write("a.log", "1", "2", "3")
+	fake.Write("b.log", "4")
+
+	if out, _ := fake.Tail("c1", []string{"a.log"}, 2); out != "2\n3\n" {
+		t.Errorf("one file: got %q", out)
+	}
+	out, _ := fake.Tail("c1", []string{"a.log", "b.log"}, 1)
+	if want := "==> /var/log/caddy/a.log <==\n3\n\n==> /var/log/caddy/b.log <==\n4\n"; out != want {
+		t.Errorf("several files: got %q, want %q", out, want)
+	}
+}
+
+func TestFakeService(t *testing.T) {
+	fake := NewFake()
+	stream, err := fake.ServiceLogs("caddy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	fake.WriteService("caddy", "task1", 1, "from one")
+	fake.WriteService("caddy", "task2", 2, "from two")
+	fake.WriteService("other", "task3", 1, "not followed")
+
+	want := "com.docker.swarm.task.id=task1 from one|com.docker.swarm.task.id=task2 from two"
+	if got := readLines(t, stream, 2); strings.Join(got, "|") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, "|"), want)
+	}
+
+	tasks, _ := fake.ServiceTasks("caddy")
+	if len(tasks) != 2 || tasks["task1"] != 1 || tasks["task2"] != 2 {
+		t.Errorf("tasks: got %v", tasks)
+	}
+}
@@ -0,0 +1,269 @@
+// Package ingest reads caddy's logs out of containers. Source is what the
+// logger needs from a container runtime, Docker and podman are reached
+// through the docker api and Fake keeps everything in memory.
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// LogDir is where caddy writes its logs inside the container
+const LogDir = "/var/log/caddy/"
+
+// Source is everything the logger needs from a container runtime: finding
+// caddy, reading its log files and following its output.
+type Source interface {
+	Ping() error
+	// FindContainer returns the id of the running container with this name
+	FindContainer(name string) (string, error)
+	// Stat returns inode and size of a file inside the container
+	Stat(id string, path string) (uint64, int64, error)
+	// Read returns length bytes of a file starting at offset
+	Read(id string, path string, offset int64, length int64) (string, error)
+	// Tail returns the last lines of files, globs are expanded. With several
+	// files tail's "==> file <==" headers say where the next lines are from.
+	Tail(id string, files []string, lines int) (string, error)
+	// Follow streams what is written to files from now on, across rotation
+	Follow(id string, files []string) (io.ReadCloser, error)
+	// ServiceLogs follows every replica of a swarm service, lines carry the
+	// docker log details, see splitLogDetails
+	ServiceLogs(service string) (io.ReadCloser, error)
+	// ServiceTasks maps the task ids of a service to their slot
+	ServiceTasks(service string) (map[string]int, error)
+	Close() error
+}
+
+// ExitError means the command ran but failed, retrying won't help
+type ExitError struct {
+	Code   int
+	Stderr string
+}
+
+func (e *ExitError) Error() string {
+	if e.Stderr != "" {
+		return fmt.Sprintf("Command execution failed with exit code %d: %s", e.Code, e.Stderr)
+	}
+	return fmt.Sprintf("Command execution failed with exit code %d", e.Code)
+}
+
+// dockerSource reads the logs through the docker api, running stat and tail
+// inside the container
+type dockerSource struct {
+	cli *client.Client
+}
+
+// NewDockerSource connects to the daemon cfg points at
+func NewDockerSource(cfg DockerConfig) (Source, error) {
+	cli, err := NewDockerClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return dockerSource{cli: cli}, nil
+}
+
+func (d dockerSource) Close() error { return d.cli.Close() }
+
+func (d dockerSource) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := d.cli.Ping(ctx)
+	return err
+}
+
+func (d dockerSource) FindContainer(containerName string) (string, error) {
+	containers, err := d.cli.ContainerList(context.Background(), types.ContainerListOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	for _, container := range containers {
+		for _, name := range container.Names {
+			if name == "/"+containerName {
+				return container.ID, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("container with name %s not found", containerName)
+}
+
+func (d dockerSource) Stat(id string, path string) (uint64, int64, error) {
+	out, err := d.exec(id, []string{"stat", "-c", "%i %s", path})
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected stat output %q", out)
+	}
+	inode, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	return inode, size, err
+}
+
+func (d dockerSource) Read(id string, path string, offset int64, length int64) (string, error) {
+	return d.exec(id, []string{"sh", "-c", `tail -c +"$1" "$3" | head -c "$2"`,
+		"sh", strconv.FormatInt(offset+1, 10), strconv.FormatInt(length, 10), path})
+}
+
+func (d dockerSource) Tail(id string, files []string, lines int) (string, error) {
+	return d.exec(id, tailCommand([]string{"-n", strconv.Itoa(lines)}, files))
+}
+
+// tailCommand runs tail with its options args on files. The paths are
+// arguments, never part of a script, so names from the config or caddy can't
+// run anything. Only when one of them is a glob a shell expands it: $@
+// unquoted with IFS empty is expanded as a pattern but not split or
+// evaluated. args are the logger's own flags and numbers.
+func tailCommand(args []string, files []string) []string {
+	for _, file := range files {
+		if strings.ContainsAny(file, "*?[") {
+			script := "IFS=; exec tail " + strings.Join(args, " ") + " -- $@"
+			return append([]string{"sh", "-c", script, "sh"}, files...)
+		}
+	}
+	return append(append(append([]string{"tail"}, args...), "--"), files...)
+}
+
+// exec runs cmd in the log directory of the container and returns its
+// stdout
+func (d dockerSource) exec(id string, cmd []string) (string, error) {
+	ctx := context.Background()
+
+	// Create a command to be executed within the container
+	execResp, err := d.cli.ContainerExecCreate(ctx, id, types.ExecConfig{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+		WorkingDir:   LogDir,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Start the command execution
+	execStartResp, err := d.cli.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{})
+	if err != nil {
+		return "", err
+	}
+	defer execStartResp.Close()
+
+	// Read the output of the command. Without a tty docker multiplexes
+	// stdout and stderr into one stream of framed chunks.
+	var output, stderr strings.Builder
+	_, err = stdcopy.StdCopy(&output, &stderr, execStartResp.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	// Get the exit status of the command
+	execInspectResp, err := d.cli.ContainerExecInspect(ctx, execResp.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if execInspectResp.ExitCode != 0 {
+		err := &ExitError{Code: execInspectResp.ExitCode, Stderr: strings.TrimSpace(stderr.String())}
+		slog.Error(err.Error())
+		return "", err
+	}
+
+	return output.String(), nil
+}
+
+// Follow runs a single `tail -F` inside the container, -F keeps following
+// across rotation. What tail says about rotated or missing files is logged.
+func (d dockerSource) Follow(id string, files []string) (io.ReadCloser, error) {
+	ctx := context.Background()
+	cmd := tailCommand([]string{"-n", "0", "-F"}, files)
+	execResp, err := d.cli.ContainerExecCreate(ctx, id, types.ExecConfig{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return nil, err
+	}
+	attach, err := d.cli.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, err
+	}
+	return demux(attach.Reader, stderrLog{}, attach.Close), nil
+}
+
+func (d dockerSource) ServiceLogs(service string) (io.ReadCloser, error) {
+	logs, err := d.cli.ServiceLogs(context.Background(), service, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Tail:       "0",
+		Details:    true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// caddy logs to stderr by default, both carry log lines
+	return demux(logs, nil, func() { logs.Close() }), nil
+}
+
+func (d dockerSource) ServiceTasks(service string) (map[string]int, error) {
+	tasks, err := d.cli.TaskList(context.Background(), types.TaskListOptions{
+		Filters: filters.NewArgs(filters.Arg("service", service)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	slots := map[string]int{}
+	for _, task := range tasks {
+		slots[task.ID] = task.Slot
+	}
+	return slots, nil
+}
+
+// demuxedStream is the stdout of a multiplexed docker stream
+type demuxedStream struct {
+	*io.PipeReader
+	close func()
+}
+
+func (s demuxedStream) Close() error {
+	s.close()
+	return s.PipeReader.Close()
+}
+
+// demux splits a multiplexed stream, stderr nil sends both to stdout
+func demux(stream io.Reader, stderr io.Writer, close func()) io.ReadCloser {
+	stdout, writer := io.Pipe()
+	if stderr == nil {
+		stderr = writer
+	}
+	go func() {
+		_, err := stdcopy.StdCopy(writer, stderr, stream)
+		writer.CloseWithError(err)
+	}()
+	return demuxedStream{PipeReader: stdout, close: close}
+}
+
+// stderrLog forwards what tail says about rotated or missing files
+type stderrLog struct{}
+
+func (stderrLog) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSpace(string(p)), "\n") {
+		slog.Warn("tail: " + line)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,42 @@
+package ingest
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTailCommandQuoting(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a b.log": "spaced\n", "c.log": "plain\n", "x;touch pwned.log": "odd\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// tail fails on the missing file but still prints the others
+	run := func(files ...string) string {
+		cmd := tailCommand([]string{"-q", "-n", "1"}, files)
+		c := exec.Command(cmd[0], cmd[1:]...)
+		c.Dir = dir
+		out, _ := c.Output()
+		return string(out)
+	}
+
+	if got := run(filepath.Join(dir, "a b.log"), filepath.Join(dir, "x;touch pwned.log")); got != "spaced\nodd\n" {
+		t.Errorf("literal paths: got %q", got)
+	}
+	got := run(filepath.Join(dir, "*.log"), filepath.Join(dir, "$(touch pwned2.log)"))
+	for _, want := range []string{"spaced", "plain", "odd"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("glob: %q is missing %s", got, want)
+		}
+	}
+	for _, name := range []string{"pwned.log", "pwned2.log"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("a path ran a command: %s exists", name)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package notify
+
+// discord's limits on the components of one message
+const (
+	maxButtonsPerRow = 5
+	maxRows          = 5
+)
+
+// ActionRow is a row of buttons below a message
+type ActionRow struct {
+	Type       int      `json:"type"`
+	Components []Button `json:"components"`
+}
+
+// Button is a link button, the only component a webhook that doesn't belong
+// to an application can send
+type Button struct {
+	Type  int    `json:"type"`
+	Style int    `json:"style"`
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// LinkButtons lays out link buttons with these labels and urls in rows.
+// Buttons past discord's limit of 25 are dropped.
+func LinkButtons(links [][2]string) []ActionRow {
+	var rows []ActionRow
+	for i, link := range links {
+		if i%maxButtonsPerRow == 0 {
+			if len(rows) == maxRows {
+				break
+			}
+			rows = append(rows, ActionRow{Type: 1})
+		}
+		row := &rows[len(rows)-1]
+		row.Components = append(row.Components, Button{Type: 2, Style: 5, Label: Truncate(link[0], 80), URL: link[1]})
+	}
+	return rows
+}
@@ -0,0 +1,46 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+)
+
+// Encode builds the request body of an execute webhook call and returns it
+// with its content type. Nothing pings unless the message explicitly
+// allows it.
+func Encode(message Message) (*bytes.Buffer, string, error) {
+	if message.AllowedMentions == nil {
+		message.AllowedMentions = &AllowedMentions{Parse: []string{}}
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return nil, "", err
+	}
+
+	body := &bytes.Buffer{}
+	if len(message.Files) == 0 {
+		body.Write(payload)
+		return body, "application/json", nil
+	}
+
+	// files need a multipart upload with the message as payload_json
+	form := multipart.NewWriter(body)
+	if err := form.WriteField("payload_json", string(payload)); err != nil {
+		return nil, "", err
+	}
+	for i, file := range message.Files {
+		part, err := form.CreateFormFile(fmt.Sprintf("files[%d]", i), file.Name)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(file.Data); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return nil, "", err
+	}
+	return body, form.FormDataContentType(), nil
+}
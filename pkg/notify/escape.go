@@ -0,0 +1,34 @@
+package notify
+
+import "strings"
+
+// The request line, headers and host are chosen by whoever sends the
+// request. They must neither break out of the code block they're shown in
+// nor ping anyone or render as markdown.
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`", "|", `\|`,
+	">", `\>`, "#", `\#`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	// a zero width space keeps @everyone from being a mention
+	"@", "@\u200b",
+)
+
+// EscapeMarkdown makes untrusted text show up literally outside code blocks
+func EscapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// CodeSafe keeps untrusted text from closing the code block it's in, a
+// backtick is swapped for a look-alike that isn't markdown
+func CodeSafe(s string) string {
+	return strings.ReplaceAll(s, "`", "\u02cb")
+}
+
+// Truncate cuts s to limit bytes, marking the cut with an ellipsis
+func Truncate(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	// cutting may split a multi-byte character, drop what's left of it
+	return strings.ToValidUTF8(s[:limit-1], "") + "…"
+}
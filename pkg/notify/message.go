@@ -0,0 +1,67 @@
+// Package notify builds discord webhook messages: the payload types, escaping
+// of untrusted text, splitting content over discord's limits and encoding
+// the request body.
+package notify
+
+// Message is the body of an execute webhook call
+type Message struct {
+	Content   string `json:"content,omitempty"`
+	Username  string `json:"username,omitempty"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+	// ThreadName creates a new post when the webhook belongs to a forum
+	ThreadName      string           `json:"thread_name,omitempty"`
+	AllowedMentions *AllowedMentions `json:"allowed_mentions,omitempty"`
+	Embeds          []Embed          `json:"embeds,omitempty"`
+	// Components are sent with with_components=true, see LinkButtons
+	Components []ActionRow `json:"components,omitempty"`
+	// Files are uploaded next to the message as attachments
+	Files []Attachment `json:"-"`
+}
+
+// Embed is a rich block below the content
+type Embed struct {
+	Title       string       `json:"title,omitempty"`
+	Description string       `json:"description,omitempty"`
+	URL         string       `json:"url,omitempty"`
+	Color       int          `json:"color,omitempty"`
+	Fields      []EmbedField `json:"fields,omitempty"`
+	Footer      *EmbedFooter `json:"footer,omitempty"`
+	Timestamp   string       `json:"timestamp,omitempty"`
+}
+type EmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+type EmbedFooter struct {
+	Text string `json:"text"`
+}
+
+// FieldValue fits lines into the 1024 characters discord allows per field
+func FieldValue(lines []string) string {
+	if len(lines) == 0 {
+		return "none"
+	}
+	value := ""
+	for _, line := range lines {
+		if len(value)+len(line)+1 > 1024 {
+			break
+		}
+		value += line + "\n"
+	}
+	return value
+}
+
+// Attachment is a file uploaded with the message
+type Attachment struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// AllowedMentions limits who a message may ping, an empty Parse pings no
+// one
+type AllowedMentions struct {
+	Parse []string `json:"parse"`
+	Roles []string `json:"roles,omitempty"`
+	Users []string `json:"users,omitempty"`
+}
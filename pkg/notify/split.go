@@ -0,0 +1,137 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxContent is the most characters discord takes in one message
+	MaxContent = 2000
+	// longer messages are attached as a file instead of flooding the channel
+	maxParts = 3
+)
+
+// SplitContent cuts content into parts that fit a message. Cuts happen at
+// line ends where possible and a code block cut in half is closed and
+// reopened, every part ends with a [n/total] marker.
+func SplitContent(content string) []string {
+	if utf8.RuneCountInString(content) <= MaxContent {
+		return []string{content}
+	}
+
+	// the marker grows with the number of parts, cut again until it fits
+	reserve := len(marker(1, 1))
+	for {
+		parts := cutContent(content, MaxContent-reserve)
+		fits := true
+		for i := range parts {
+			parts[i] = strings.TrimRight(parts[i], "\n") + marker(i+1, len(parts))
+			if n := utf8.RuneCountInString(parts[i]); n > MaxContent {
+				reserve += n - MaxContent
+				fits = false
+			}
+		}
+		if fits {
+			return parts
+		}
+	}
+}
+
+func marker(n, total int) string {
+	return fmt.Sprintf("\n`[%d/%d]`", n, total)
+}
+
+// cutContent cuts content into parts of at most room characters, the fence
+// closing a cut code block included
+func cutContent(content string, room int) []string {
+	limit := room - len("\n```")
+	var parts []string
+	var current strings.Builder
+	inCode := false
+	// start is the length of a fresh part, the reopened fence
+	start := 0
+	flush := func() {
+		part := current.String()
+		if inCode {
+			part += "\n```"
+		}
+		parts = append(parts, part)
+		current.Reset()
+		if inCode {
+			// on its own line, text right after a fence is its language
+			current.WriteString("```\n")
+		}
+		start = current.Len()
+	}
+
+	for _, line := range strings.SplitAfter(content, "\n") {
+		for utf8.RuneCountInString(line) > limit-start {
+			// a single line longer than a message, cut it anywhere
+			if current.Len() > start {
+				flush()
+			}
+			room := limit - utf8.RuneCountInString(current.String())
+			cut := runePrefix(line, room)
+			current.WriteString(cut)
+			inCode = inCode != (strings.Count(cut, "```")%2 == 1)
+			line = line[len(cut):]
+			flush()
+		}
+		if utf8.RuneCountInString(current.String())+utf8.RuneCountInString(line) > limit {
+			flush()
+		}
+		current.WriteString(line)
+		if strings.Count(line, "```")%2 == 1 {
+			inCode = !inCode
+		}
+	}
+	if current.Len() > start {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+func runePrefix(s string, n int) string {
+	i := 0
+	for n > 0 && i < len(s) {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		n--
+	}
+	return s[:i]
+}
+
+// SplitMessage turns an oversized message into several. Embeds and files go
+// with the last part. Beyond maxParts the full text is attached as a file
+// and only the start of it is posted.
+func SplitMessage(message Message) []Message {
+	parts := SplitContent(message.Content)
+	if len(parts) == 1 {
+		return []Message{message}
+	}
+
+	if len(parts) > maxParts {
+		message.Files = append(message.Files, Attachment{Name: "message.txt", Data: []byte(message.Content)})
+		truncated := "\n`[truncated, full text in message.txt]`"
+		first := cutContent(message.Content, MaxContent-utf8.RuneCountInString(truncated))[0]
+		message.Content = strings.TrimRight(first, "\n") + truncated
+		return []Message{message}
+	}
+
+	messages := make([]Message, len(parts))
+	for i, part := range parts {
+		m := Message{Content: part, Username: message.Username, AvatarURL: message.AvatarURL, AllowedMentions: message.AllowedMentions}
+		if i == 0 {
+			m.ThreadName = message.ThreadName
+		}
+		if i == len(parts)-1 {
+			m.Embeds = message.Embeds
+			m.Components = message.Components
+			m.Files = message.Files
+		}
+		messages[i] = m
+	}
+	return messages
+}
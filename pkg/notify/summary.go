@@ -0,0 +1,23 @@
+package notify
+
+import "strings"
+
+// Summary is the code block an access log message starts with: the time
+// above a divider, then host and uri, client address, user agent and
+// status. Without a time the block starts at the host, for when the time is
+// shown as a discord timestamp after it.
+func Summary(date, target, client, ua, status string) string {
+	return Block(date, []string{target, client, ua, status})
+}
+
+// Block is a Summary of any lines
+func Block(date string, lines []string) string {
+	safe := make([]string, 0, len(lines)+2)
+	if date != "" {
+		safe = append(safe, CodeSafe(date), "---------------------------------------- ")
+	}
+	for _, line := range lines {
+		safe = append(safe, CodeSafe(line))
+	}
+	return "```\n" + strings.Join(safe, "\n") + "```"
+}
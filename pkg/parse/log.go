@@ -0,0 +1,77 @@
+// Package parse reads caddy's json logs: access log entries, the timestamp
+// formats caddy can write and the user agents of the requests.
+package parse
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Data is one line of caddy's json log, the access log fields filled in
+type Data struct {
+	Level       string      `json:"level"`
+	Ts          Timestamp   `json:"ts"`
+	Logger      string      `json:"logger"`
+	Msg         string      `json:"msg"`
+	Request     Request     `json:"request"`
+	UserID      string      `json:"user_id"`
+	Duration    float64     `json:"duration"`
+	Size        int         `json:"size"`
+	Status      int         `json:"status"`
+	RespHeaders RespHeaders `json:"resp_headers"`
+	// Source is the name of the log file the line was read from
+	Source string `json:"-"`
+}
+
+type Request struct {
+	RemoteIP   string `json:"remote_ip"`
+	RemotePort string `json:"remote_port"`
+	// ClientIP is set by caddy 2.7+ according to its own trusted_proxies
+	ClientIP string  `json:"client_ip"`
+	Proto    string  `json:"proto"`
+	Method   string  `json:"method"`
+	Host     string  `json:"host"`
+	URI      string  `json:"uri"`
+	Headers  Headers `json:"headers"`
+}
+
+type Headers struct {
+	AcceptEncoding  []string `json:"Accept-Encoding"`
+	XForwardedFor   []string `json:"X-Forwarded-For"`
+	CfRay           []string `json:"Cf-Ray"`
+	XForwardedProto []string `json:"X-Forwarded-Proto"`
+	CfVisitor       []string `json:"Cf-Visitor"`
+	Accept          []string `json:"Accept"`
+	Referer         []string `json:"Referer"`
+	CfIpcountry     []string `json:"Cf-Ipcountry"`
+	CdnLoop         []string `json:"Cdn-Loop"`
+	UserAgent       []string `json:"User-Agent"`
+	CfConnectingIP  []string `json:"Cf-Connecting-Ip"`
+}
+
+type RespHeaders struct {
+	ContentLength []string `json:"Content-Length"`
+	Server        []string `json:"Server"`
+	AltSvc        []string `json:"Alt-Svc"`
+	Etag          []string `json:"Etag"`
+	ContentType   []string `json:"Content-Type"`
+	LastModified  []string `json:"Last-Modified"`
+	AcceptRanges  []string `json:"Accept-Ranges"`
+}
+
+// Line parses one log line, source is the file it was read from
+func Line(source string, line string) (Data, error) {
+	var data Data
+	err := json.Unmarshal([]byte(line), &data)
+	data.Source = source
+	return data, err
+}
+
+// IsAccessLog tells access log lines apart from everything else caddy logs.
+// Older configs log access lines without a logger name.
+func IsAccessLog(data Data) bool {
+	if data.Logger == "" {
+		return data.Status != 0
+	}
+	return strings.HasPrefix(data.Logger, "http.log.access")
+}
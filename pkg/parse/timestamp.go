@@ -0,0 +1,88 @@
+package parse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Timestamp is caddy's ts field, unix seconds as a float by default. With a
+// time_format in the encoder config it's a string or a number in another unit.
+type Timestamp float64
+
+var defaultTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.000Z0700", // iso8601
+	"2006/01/02 15:04:05.000",      // wall_milli
+	"2006/01/02 15:04:05",          // wall
+	"02/Jan/2006:15:04:05 -0700",   // common_log
+}
+
+// timeLayouts are the configured layouts followed by the defaults, see
+// SetLayouts. The slice is never changed once stored, lines are parsed on
+// several goroutines.
+var timeLayouts atomic.Value
+
+// SetLayouts adds Go time layouts for string timestamps, for a time_format
+// that isn't one of caddy's named ones
+func SetLayouts(layouts []string) {
+	combined := make([]string, 0, len(layouts)+len(defaultTimeLayouts))
+	combined = append(combined, layouts...)
+	timeLayouts.Store(append(combined, defaultTimeLayouts...))
+}
+
+func (t *Timestamp) UnmarshalJSON(raw []byte) error {
+	if bytes.Equal(raw, []byte("null")) {
+		return nil
+	}
+	if raw[0] != '"' {
+		var n float64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return err
+		}
+		*t = fromUnix(n)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return err
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		*t = fromUnix(n)
+		return nil
+	}
+	layouts, _ := timeLayouts.Load().([]string)
+	if layouts == nil {
+		layouts = defaultTimeLayouts
+	}
+	for _, layout := range layouts {
+		// wall and layouts without a zone are in local time, like caddy
+		// writes them
+		if parsed, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			*t = Timestamp(float64(parsed.UnixNano()) / float64(time.Second))
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown timestamp format %q, add its layout to timeLayouts", s)
+}
+
+// fromUnix guesses the unit from the magnitude, seconds are ~1e9 today
+func fromUnix(n float64) Timestamp {
+	switch {
+	case n > 1e17:
+		return Timestamp(n / 1e9)
+	case n > 1e14:
+		return Timestamp(n / 1e6)
+	case n > 1e11:
+		return Timestamp(n / 1e3)
+	}
+	return Timestamp(n)
+}
+
+func (t Timestamp) Time() time.Time {
+	return time.Unix(0, int64(float64(t)*float64(time.Second)))
+}
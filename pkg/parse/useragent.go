@@ -0,0 +1,119 @@
+package parse
+
+import (
+	"regexp"
+	"strings"
+)
+
+// UserAgent is what ParseUserAgent makes of a User-Agent header
+type UserAgent struct {
+	Browser string
+	Version string
+	OS      string
+	Device  string
+}
+
+// Summary is the short form shown in messages, "Chrome 120 / macOS / desktop"
+func (ua UserAgent) Summary() string {
+	browser := ua.Browser
+	if ua.Version != "" {
+		browser += " " + ua.Version
+	}
+	parts := []string{browser}
+	if ua.OS != "" {
+		parts = append(parts, ua.OS)
+	}
+	return strings.Join(append(parts, ua.Device), " / ")
+}
+
+var (
+	botPattern = regexp.MustCompile(`(?i)([a-z0-9_.-]*(bot|crawler|spider|scanner|slurp)[a-z0-9_.-]*|curl|wget|python-requests|go-http-client|okhttp|zgrab|masscan|nmap|nikto|sqlmap|httpx)(/([0-9.]+))?`)
+
+	// order matters, most chromium browsers also claim to be chrome and safari
+	browserPatterns = []struct {
+		name    string
+		pattern *regexp.Regexp
+	}{
+		{"Edge", regexp.MustCompile(`Edg(?:e|A|iOS)?/(\d+)`)},
+		{"Opera", regexp.MustCompile(`(?:OPR|Opera)/(\d+)`)},
+		{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/(\d+)`)},
+		{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/(\d+)`)},
+		{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/(\d+)`)},
+		{"Safari", regexp.MustCompile(`Version/(\d+)[.\d]* (?:Mobile/\S+ )?Safari/`)},
+		{"Internet Explorer", regexp.MustCompile(`(?:MSIE |Trident/.*rv:)(\d+)`)},
+	}
+
+	osPatterns = []struct {
+		name    string
+		pattern *regexp.Regexp
+	}{
+		{"iOS", regexp.MustCompile(`(?:iPhone|CPU) OS (\d+)`)},
+		{"iPadOS", regexp.MustCompile(`iPad`)},
+		{"Android", regexp.MustCompile(`Android (\d+)`)},
+		{"ChromeOS", regexp.MustCompile(`CrOS`)},
+		{"Windows", regexp.MustCompile(`Windows NT`)},
+		{"macOS", regexp.MustCompile(`Mac OS X`)},
+		{"Linux", regexp.MustCompile(`Linux`)},
+	}
+)
+
+// ParseUserAgent recognises the browser, OS and device of a User-Agent
+// header, bots and http libraries included
+func ParseUserAgent(raw string) UserAgent {
+	var ua UserAgent
+
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return UserAgent{Browser: "unknown", Device: "unknown"}
+	}
+
+	if m := botPattern.FindStringSubmatch(raw); m != nil {
+		ua.Browser = m[1]
+		ua.Version = m[4]
+		ua.Device = "bot"
+	} else {
+		for _, b := range browserPatterns {
+			if m := b.pattern.FindStringSubmatch(raw); m != nil {
+				ua.Browser = b.name
+				if len(m) > 1 {
+					ua.Version = m[1]
+				}
+				break
+			}
+		}
+	}
+
+	for _, o := range osPatterns {
+		if m := o.pattern.FindStringSubmatch(raw); m != nil {
+			ua.OS = o.name
+			if len(m) > 1 {
+				ua.OS += " " + m[1]
+			}
+			break
+		}
+	}
+
+	if ua.Device == "" {
+		switch {
+		case strings.Contains(raw, "iPad") || strings.Contains(raw, "Tablet") ||
+			(strings.Contains(raw, "Android") && !strings.Contains(raw, "Mobile")):
+			ua.Device = "tablet"
+		case strings.Contains(raw, "Mobi") || strings.Contains(raw, "iPhone"):
+			ua.Device = "mobile"
+		case ua.Browser == "":
+			// not a browser we know and not an obvious bot either
+			ua.Device = "unknown"
+		default:
+			ua.Device = "desktop"
+		}
+	}
+
+	if ua.Browser == "" {
+		// fall back to the first product token, "Foo/1.2 (...)" -> Foo 1.2
+		token := strings.Fields(raw)[0]
+		name, version, _ := strings.Cut(token, "/")
+		ua.Browser = name
+		ua.Version = version
+	}
+	return ua
+}